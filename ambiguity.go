@@ -0,0 +1,304 @@
+package goabnf
+
+import (
+	"fmt"
+	"iter"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ambiguity.go explains why a forest is ambiguous. Every packed node with more
+// than one pack is a point where the input can be derived in several ways; the
+// report picks the one whose symbol spans the fewest bytes, as the smaller the
+// span, the easier it is to see which rules overlap, then extracts the tree of
+// the enclosing rule once per packing.
+
+// AmbiguityReport is a witness that an input is ambiguous: the smallest span of
+// it a symbol derives in more than one way, and two of these derivations.
+type AmbiguityReport struct {
+	// Rule is the innermost rule whose derivation contains the ambiguity.
+	Rule string
+	// Symbol is the ambiguous symbol: Rule itself, or one of the groups,
+	// options or repetitions of its definition as written in the grammar, such
+	// as `*c-wsp`.
+	Symbol string
+	// Start and End delimit the span of the input Symbol derives ambiguously.
+	// No smaller span of the input is derived ambiguously.
+	Start, End int
+	// Witnesses are two distinct derivations of the span.
+	Witnesses [2]AmbiguityWitness
+
+	input []byte
+}
+
+// AmbiguityWitness is one derivation of an ambiguous span.
+type AmbiguityWitness struct {
+	// Alternative is the 0-based index of the alternative of Symbol the
+	// derivation takes, or -1 for a repetition or an absent option. Both
+	// witnesses have the same alternative when they only differ in how it
+	// splits the span.
+	Alternative int
+	// Tree is the parse tree of Rule under this derivation. As parse trees only
+	// keep rule nodes, two derivations that differ inside the groups or
+	// repetitions of a single rule have equal trees.
+	Tree *ParseTree
+}
+
+// AmbiguityReport returns a witness of the ambiguity of the forest, or nil if
+// the input is invalid or has a single parse tree.
+func (f *Forest) AmbiguityReport() *AmbiguityReport {
+	if f.root == nil {
+		return nil
+	}
+	type step struct {
+		n *gnode
+		k int
+	}
+	var (
+		best        []step
+		owner, rule *gnode
+		path        []step
+	)
+	seen := map[*gnode]bool{}
+	var walk func(n, sym, rl *gnode)
+	walk = func(n, sym, rl *gnode) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		if n.kind == gSymbol {
+			sym = n
+			if f.sg.nts[n.nt].isRule {
+				rl = n
+			}
+		}
+		if len(n.packs) > 1 && (owner == nil || sym.End-sym.Start < owner.End-owner.Start) {
+			best = append([]step{}, path...)
+			best = append(best, step{n: n})
+			owner, rule = sym, rl
+		}
+		for k, pk := range n.packs {
+			path = append(path, step{n, k})
+			for _, c := range pk {
+				walk(c, sym, rl)
+			}
+			path = path[:len(path)-1]
+		}
+	}
+	walk(f.root, f.root, f.root)
+	if owner == nil {
+		return nil
+	}
+
+	r := &AmbiguityReport{
+		Rule:   f.sg.nts[rule.nt].ruleName,
+		Symbol: f.sg.symbolName(owner.nt),
+		Start:  owner.Start,
+		End:    owner.End,
+		input:  f.input,
+	}
+	// Follow the path down to the ambiguous node, then take its first two
+	// packings in turn.
+	pick := map[*gnode]int{}
+	for _, s := range best {
+		pick[s.n] = s.k
+	}
+	at := best[len(best)-1].n
+	for k := range r.Witnesses {
+		pick[at] = k
+		r.Witnesses[k] = AmbiguityWitness{
+			Alternative: f.sg.alternative(owner.nt, at.alts[k]),
+			Tree:        f.emitSymbol(rule, pick, map[*gnode]bool{}),
+		}
+	}
+	return r
+}
+
+// alternative maps the alternate alt of nt to the index of the ABNF alternative
+// it stands for, or -1 for a repetition, an absent option or a recovered span.
+func (sg *slotGrammar) alternative(nt, alt int) int {
+	switch info := sg.nts[nt]; {
+	case info.isRep, len(info.alts[alt]) == 1 && info.alts[alt][0].kind == symErr:
+		return -1
+	case info.isOpt:
+		return alt - 1
+	}
+	return alt
+}
+
+// String renders the report with its two witnesses side by side:
+//
+//	`a` derives "xxy" (bytes 0 to 3) in more than one way
+//	alternative 0 | alternative 0
+//	a [0,3)       | a [0,3)
+//	  b [0,1)     |   b [0,2)
+//	    "x"       |     "x"
+//	  c [1,3)     |     "x"
+//	    "x"       |   c [2,3)
+//	    "y"       |     "y"
+func (r *AmbiguityReport) String() string {
+	var b strings.Builder
+	symbol := "`" + r.Symbol + "`"
+	if r.Symbol != r.Rule {
+		symbol += " in `" + r.Rule + "`"
+	}
+	fmt.Fprintf(&b, "%s derives %q (bytes %d to %d) in more than one way\n", symbol, r.input[r.Start:r.End], r.Start, r.End)
+
+	var cols [2][]string
+	width := 0
+	for k, w := range r.Witnesses {
+		head := "derivation " + strconv.Itoa(k+1)
+		if w.Alternative >= 0 {
+			head = "alternative " + strconv.Itoa(w.Alternative)
+		}
+		cols[k] = append([]string{head}, treeLines(w.Tree, r.input, "")...)
+	}
+	for _, l := range cols[0] {
+		width = max(width, utf8.RuneCountInString(l))
+	}
+	for i := range max(len(cols[0]), len(cols[1])) {
+		var left, right string
+		if i < len(cols[0]) {
+			left = cols[0][i]
+		}
+		if i < len(cols[1]) {
+			right = cols[1][i]
+		}
+		pad := strings.Repeat(" ", width-utf8.RuneCountInString(left))
+		fmt.Fprintf(&b, "%s%s | %s\n", left, pad, right)
+	}
+	return b.String()
+}
+
+// treeLines renders t one node per line, children indented under their parent:
+// rule nodes by name and extent, terminals by the input they match.
+func treeLines(t *ParseTree, input []byte, indent string) []string {
+	if t.Rule == "" {
+		return []string{indent + strconv.Quote(string(input[t.Start:t.End]))}
+	}
+	lines := []string{fmt.Sprintf("%s%s [%d,%d)", indent, t.Rule, t.Start, t.End)}
+	for _, c := range t.Children {
+		lines = append(lines, treeLines(c, input, indent+"  ")...)
+	}
+	return lines
+}
+
+// AmbiguitySearch is the outcome of a search for an ambiguous sentence of a
+// rule (see FindAmbiguity).
+type AmbiguitySearch struct {
+	// Input is the first ambiguous sentence found, or nil if there is none.
+	Input []byte
+	// NumTrees is the number of parse trees of Input, -1 if infinitely many.
+	NumTrees *big.Int
+	// Report explains the ambiguity of Input.
+	Report *AmbiguityReport
+	// Checked is the number of sentences parsed.
+	Checked int
+	// UnambiguousUpTo certifies, when no ambiguous sentence was found by an
+	// exhaustive search, that every sentence of the rule of at most this many
+	// characters has a single parse tree. It is -1 otherwise.
+	UnambiguousUpTo int
+}
+
+// Ambiguous reports whether an ambiguous sentence was found.
+func (s *AmbiguitySearch) Ambiguous() bool { return s.Input != nil }
+
+// String summarizes the search: the ambiguous sentence and its report, or the
+// certificate.
+func (s *AmbiguitySearch) String() string {
+	switch {
+	case s.Input != nil:
+		trees := s.NumTrees.String()
+		if s.NumTrees.Sign() < 0 {
+			trees = "infinitely many"
+		}
+		return fmt.Sprintf("%q has %s parse trees\n%s", s.Input, trees, s.Report)
+	case s.UnambiguousUpTo >= 0:
+		return fmt.Sprintf("unambiguous up to %d characters (%d sentences)\n", s.UnambiguousUpTo, s.Checked)
+	}
+	return fmt.Sprintf("no ambiguity in %d sentences\n", s.Checked)
+}
+
+// AmbiguityOption configures FindAmbiguity and DiffPEG.
+type AmbiguityOption interface {
+	apply(*ambiguityConfig)
+}
+
+type ambiguityConfig struct {
+	maxSentences int
+}
+
+// WithMaxSentences bounds the number of sentences FindAmbiguity (or DiffPEG)
+// enumerates, as there may be exponentially many in the length bound. 0 means
+// unbounded; the default is 100000.
+func WithMaxSentences(n int) AmbiguityOption {
+	return maxSentencesOption(n)
+}
+
+type maxSentencesOption int
+
+var _ AmbiguityOption = (*maxSentencesOption)(nil)
+
+func (o maxSentencesOption) apply(c *ambiguityConfig) {
+	c.maxSentences = int(o)
+}
+
+// FindAmbiguity searches the sentences of rule of at most maxLen characters,
+// shortest first, for one with more than one parse tree. Characters no terminal
+// tells apart are only tried once, so the search is exhaustive: when it finds
+// nothing, the result certifies the rule is unambiguous up to maxLen.
+//
+// The sentences of a rule may be exponentially many in maxLen; past the
+// WithMaxSentences budget, it fails with ErrTooManySentences. Longer sentences
+// can still be checked with FindAmbiguityIn, e.g. samples of an ASTGenerator.
+func (g *Grammar) FindAmbiguity(rule string, maxLen int, opts ...AmbiguityOption) (*AmbiguitySearch, error) {
+	cfg := ambiguityConfig{maxSentences: 100000}
+	for _, o := range opts {
+		o.apply(&cfg)
+	}
+	if GetRule(rule, g.Rulemap) == nil {
+		return nil, &ErrRuleNotFound{Rulename: rule}
+	}
+	sentences, ok := newSentenceEnumerator(g, rule, maxLen, cfg.maxSentences).enumerate(rule)
+	if !ok {
+		return nil, &ErrTooManySentences{Max: cfg.maxSentences}
+	}
+	s, err := g.FindAmbiguityIn(rule, func(yield func([]byte) bool) {
+		for _, sn := range sentences {
+			if !yield([]byte(sn)) {
+				return
+			}
+		}
+	})
+	if err != nil || s.Input != nil {
+		return s, err
+	}
+	s.UnambiguousUpTo = maxLen
+	return s, nil
+}
+
+// FindAmbiguityIn parses the given sentences of rule in turn and returns the
+// first ambiguous one. Inputs rule does not derive are skipped. Unlike
+// FindAmbiguity, finding nothing certifies nothing beyond those sentences.
+func (g *Grammar) FindAmbiguityIn(rule string, sentences iter.Seq[[]byte]) (*AmbiguitySearch, error) {
+	s := &AmbiguitySearch{UnambiguousUpTo: -1}
+	for in := range sentences {
+		f, err := ParseForest(in, g, rule)
+		if err != nil {
+			return nil, err
+		}
+		if !f.Valid() {
+			continue
+		}
+		s.Checked++
+		if f.Ambiguous() {
+			s.Input = append([]byte{}, in...)
+			s.NumTrees = f.NumTrees()
+			s.Report = f.AmbiguityReport()
+			return s, nil
+		}
+	}
+	return s, nil
+}
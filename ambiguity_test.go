@@ -0,0 +1,261 @@
+package goabnf
+
+import (
+	"iter"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_AmbiguityReport(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Grammar      []byte
+		Rulename     string
+		Input        string
+		ExpectedNil  bool
+		ExpectedRule string
+		ExpectedSym  string
+		ExpectedSpan [2]int
+		ExpectedAlts [2]int
+	}{
+		"unambiguous": {
+			Grammar:     []byte("a = \"x\" / \"y\"\r\n"),
+			Rulename:    "a",
+			Input:       "x",
+			ExpectedNil: true,
+		},
+		"invalid": {
+			Grammar:     []byte("a = \"x\"\r\n"),
+			Rulename:    "a",
+			Input:       "y",
+			ExpectedNil: true,
+		},
+		"duplicate-alternative": {
+			Grammar:      []byte("a = \"x\" / \"x\"\r\n"),
+			Rulename:     "a",
+			Input:        "x",
+			ExpectedRule: "a",
+			ExpectedSym:  "a",
+			ExpectedSpan: [2]int{0, 1},
+			ExpectedAlts: [2]int{0, 1},
+		},
+		"split": {
+			// The middle "x" belongs either to b or to c.
+			Grammar:      []byte("a = b c\r\nb = \"x\" [\"x\"]\r\nc = [\"x\"] \"y\"\r\n"),
+			Rulename:     "a",
+			Input:        "xxy",
+			ExpectedRule: "a",
+			ExpectedSym:  "a",
+			ExpectedSpan: [2]int{0, 3},
+			ExpectedAlts: [2]int{0, 0},
+		},
+		"smallest-span": {
+			// The whole input is ambiguous only because its last "xx" is.
+			Grammar:      []byte("a = \"y\" b\r\nb = 1*c\r\nc = \"x\" / \"xx\"\r\n"),
+			Rulename:     "a",
+			Input:        "yxx",
+			ExpectedRule: "b",
+			ExpectedSym:  "1*c",
+			ExpectedSpan: [2]int{1, 3},
+			ExpectedAlts: [2]int{-1, -1},
+		},
+		"option": {
+			Grammar:      []byte("a = [\"x\" / b]\r\nb = \"x\"\r\n"),
+			Rulename:     "a",
+			Input:        "x",
+			ExpectedRule: "a",
+			ExpectedSym:  "[\"x\" / b]",
+			ExpectedSpan: [2]int{0, 1},
+			ExpectedAlts: [2]int{1, 0},
+		},
+		"raw-rfc5234": {
+			// Without Erratum 2968, a line holding only white space after a
+			// rule either continues the rule (as a comment-free c-wsp) or
+			// stands alone in the rule list.
+			Grammar:      abnfAbnf,
+			Rulename:     "rulelist",
+			Input:        "a = b\r\n \r\n",
+			ExpectedRule: "rulelist",
+			ExpectedSym:  "1*(rule / (*c-wsp c-nl))",
+			ExpectedSpan: [2]int{0, 10},
+			ExpectedAlts: [2]int{-1, -1},
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			g, err := ParseABNF(tt.Grammar)
+			require.NoError(t, err)
+			f, err := ParseForest([]byte(tt.Input), g, tt.Rulename)
+			require.NoError(t, err)
+
+			r := f.AmbiguityReport()
+			if tt.ExpectedNil {
+				assert.Nil(t, r)
+				return
+			}
+			require.NotNil(t, r)
+			assert.Equal(t, tt.ExpectedRule, r.Rule)
+			assert.Equal(t, tt.ExpectedSym, r.Symbol)
+			assert.Equal(t, tt.ExpectedSpan, [2]int{r.Start, r.End})
+			assert.Equal(t, tt.ExpectedAlts, [2]int{r.Witnesses[0].Alternative, r.Witnesses[1].Alternative})
+			for _, w := range r.Witnesses {
+				assert.Equal(t, tt.ExpectedRule, w.Tree.Rule)
+			}
+		})
+	}
+}
+
+func Test_U_AmbiguityReport_String(t *testing.T) {
+	t.Parallel()
+
+	g := mustGrammar("a = b c\r\nb = \"x\" [\"x\"]\r\nc = [\"x\"] \"y\"\r\n")
+	f, err := ParseForest([]byte("xxy"), g, "a")
+	require.NoError(t, err)
+
+	assert.Equal(t, "`a` derives \"xxy\" (bytes 0 to 3) in more than one way\n"+
+		"alternative 0 | alternative 0\n"+
+		"a [0,3)       | a [0,3)\n"+
+		"  b [0,1)     |   b [0,2)\n"+
+		"    \"x\"       |     \"x\"\n"+
+		"  c [1,3)     |     \"x\"\n"+
+		"    \"x\"       |   c [2,3)\n"+
+		"    \"y\"       |     \"y\"\n", f.AmbiguityReport().String())
+}
+
+func Test_U_FindAmbiguity(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Grammar         *Grammar
+		Rulename        string
+		MaxLen          int
+		Options         []AmbiguityOption
+		ExpectedInput   []byte
+		ExpectedTrees   int64
+		ExpectedUpTo    int
+		ExpectedChecked int
+		ExpectedErr     error
+	}{
+		"unambiguous": {
+			Grammar:         mustGrammar("a = \"x\" *(\"+\" \"x\")\r\n"),
+			Rulename:        "a",
+			MaxLen:          7,
+			ExpectedUpTo:    7,
+			ExpectedChecked: 4,
+		},
+		"catalan": {
+			Grammar:         mustGrammar("a = a \"+\" a / \"x\"\r\n"),
+			Rulename:        "a",
+			MaxLen:          7,
+			ExpectedInput:   []byte("X+X+X"),
+			ExpectedTrees:   2,
+			ExpectedUpTo:    -1,
+			ExpectedChecked: 3,
+		},
+		"infinitely-ambiguous": {
+			Grammar:         mustGrammar("a = a / \"x\"\r\n"),
+			Rulename:        "a",
+			MaxLen:          3,
+			ExpectedInput:   []byte("X"),
+			ExpectedTrees:   -1,
+			ExpectedUpTo:    -1,
+			ExpectedChecked: 1,
+		},
+		"raw-rfc5234": {
+			// A line holding only white space after an empty line either
+			// continues it, or stands alone in the rule list.
+			Grammar:         mustGrammar(string(abnfAbnf)),
+			Rulename:        "rulelist",
+			MaxLen:          5,
+			ExpectedInput:   []byte("\r\n\t\r\n"),
+			ExpectedTrees:   2,
+			ExpectedUpTo:    -1,
+			ExpectedChecked: 95,
+		},
+		"rfc5234-erratum-2968": {
+			Grammar:         ABNF,
+			Rulename:        "rulelist",
+			MaxLen:          4,
+			ExpectedUpTo:    4,
+			ExpectedChecked: 53,
+		},
+		"unknown-rule": {
+			Grammar:     mustGrammar("a = \"x\"\r\n"),
+			Rulename:    "b",
+			MaxLen:      1,
+			ExpectedErr: &ErrRuleNotFound{Rulename: "b"},
+		},
+		"too-many-sentences": {
+			Grammar:     mustGrammar("a = *(%x00-FF / \"x\" / \"y\")\r\n"),
+			Rulename:    "a",
+			MaxLen:      2,
+			Options:     []AmbiguityOption{WithMaxSentences(10)},
+			ExpectedErr: &ErrTooManySentences{Max: 10},
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := tt.Grammar.FindAmbiguity(tt.Rulename, tt.MaxLen, tt.Options...)
+			assert.Equal(t, tt.ExpectedErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.ExpectedInput, s.Input)
+			assert.Equal(t, tt.ExpectedInput != nil, s.Ambiguous())
+			assert.Equal(t, tt.ExpectedUpTo, s.UnambiguousUpTo)
+			assert.Equal(t, tt.ExpectedChecked, s.Checked)
+			if s.Ambiguous() {
+				assert.Equal(t, tt.ExpectedTrees, s.NumTrees.Int64())
+				assert.NotNil(t, s.Report)
+			}
+		})
+	}
+}
+
+func Test_U_FindAmbiguityIn(t *testing.T) {
+	t.Parallel()
+
+	// Longer sentences than an exhaustive search could reach, drawn by an
+	// ASTGenerator.
+	samples := func(g *Grammar) iter.Seq[[]byte] {
+		ag, err := NewASTGenerator(g, "expr", WithMaxDepth(6))
+		require.NoError(t, err)
+		r := rand.New(rand.NewSource(1))
+		return func(yield func([]byte) bool) {
+			for range 200 {
+				if !yield(ag.GenerateRand(r)) {
+					return
+				}
+			}
+		}
+	}
+
+	left := mustGrammar("expr = expr \"-\" num / num\r\nnum = 1*DIGIT\r\n")
+	s, err := left.FindAmbiguityIn("expr", samples(left))
+	require.NoError(t, err)
+	assert.False(t, s.Ambiguous())
+	assert.Equal(t, 200, s.Checked)
+	assert.Equal(t, -1, s.UnambiguousUpTo)
+
+	both := mustGrammar("expr = expr \"-\" expr / num\r\nnum = 1*DIGIT\r\n")
+	s, err = both.FindAmbiguityIn("expr", samples(both))
+	require.NoError(t, err)
+	require.True(t, s.Ambiguous())
+	assert.Equal(t, "expr", s.Report.Rule)
+
+	// Inputs the rule does not derive are skipped.
+	s, err = left.FindAmbiguityIn("expr", slices.Values([][]byte{[]byte("1-"), []byte("1-2")}))
+	require.NoError(t, err)
+	assert.Equal(t, 1, s.Checked)
+}
@@ -3,7 +3,6 @@ package goabnf
 import (
 	"fmt"
 	"math/rand"
-	"unicode/utf8"
 )
 
 // Generates strings by recursive descent over the grammar AST, so unlike the*
@@ -245,7 +244,12 @@ func (ag *ASTGenerator) genElem(src source, out *[]byte, elem ElemItf, depth int
 
 	case ElemCharVal:
 		for _, r := range e.Values {
-			if !e.Sensitive && isASCIILetter(r) && src.intn(2) == 1 {
+			switch {
+			case !e.Sensitive && ag.g.Folding == FoldUnicode:
+				if vs := ag.g.Folding.variants(r); len(vs) > 1 {
+					r = vs[src.intn(len(vs))]
+				}
+			case !e.Sensitive && isASCIILetter(r) && src.intn(2) == 1:
 				r = flipASCIICase(r)
 			}
 			*out = append(*out, []byte(string(r))...)
@@ -257,25 +261,25 @@ func (ag *ASTGenerator) genElem(src source, out *[]byte, elem ElemItf, depth int
 			for _, v := range e.Elems {
 				r := numvalToRune(v, e.Base)
 				// Emit only real characters; an out-of-range value cannot appear
-				// in UTF-8 input, so the series would never match anyway.
-				if utf8.ValidRune(r) {
-					*out = append(*out, []byte(string(r))...)
+				// in input, so the series would never match anyway.
+				if ag.g.Mode.validChar(r) {
+					*out = ag.g.Mode.appendChar(*out, r)
 				}
 			}
 		case StatRange:
 			min, max := numvalToRune(e.Elems[0], e.Base), numvalToRune(e.Elems[1], e.Base)
-			// Emit within the representable (Unicode) subset of the range.
-			if max > utf8.MaxRune {
-				max = utf8.MaxRune
+			// Emit within the representable subset of the range.
+			if mc := ag.g.Mode.maxChar(); max > mc {
+				max = mc
 			}
-			if min > utf8.MaxRune || min > max {
+			if min > ag.g.Mode.maxChar() || min > max {
 				break // nothing representable to emit
 			}
 			span := int(max-min) + 1
 			if span < 1 {
 				span = 1
 			}
-			*out = append(*out, []byte(string(min+rune(src.intn(span))))...)
+			*out = ag.g.Mode.appendChar(*out, min+rune(src.intn(span)))
 		}
 
 	case ElemProseVal:
@@ -344,11 +348,16 @@ func (ag *ASTGenerator) costElem(elem ElemItf) int {
 		case StatSeries:
 			n := 0
 			for _, v := range e.Elems {
-				n += len(string(numvalToRune(v, e.Base)))
+				if r := numvalToRune(v, e.Base); ag.g.Mode.validChar(r) {
+					n += ag.g.Mode.charLen(r)
+				}
 			}
 			return n
-		default: // StatRange: one rune, take the lower bound's encoded length
-			return len(string(numvalToRune(e.Elems[0], e.Base)))
+		default: // StatRange: one character, take the lower bound's encoded length
+			if r := numvalToRune(e.Elems[0], e.Base); ag.g.Mode.validChar(r) {
+				return ag.g.Mode.charLen(r)
+			}
+			return 1
 		}
 	case ElemProseVal:
 		return 0
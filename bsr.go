@@ -2,7 +2,7 @@ package goabnf
 
 import (
 	"math/big"
-	"unicode/utf8"
+	"slices"
 )
 
 // Binary Subtree Representation (BSR).
@@ -27,9 +27,13 @@ import (
 // bsrElem is one binary subtree element. The slot's dot is "just past" the most
 // recently consumed symbol; that symbol spans [k,r] and the earlier prefix spans
 // [l,k]. A complete production (dot == len) records the nonterminal's extent.
+// In a counted repetition, rc is the count of elements before the production,
+// which tells apart derivations of a same extent with more or fewer elements
+// left to match; it is 0 elsewhere.
 type bsrElem struct {
 	slot    slot
 	l, k, r int
+	rc      int
 }
 
 // bsrGNode is a GSS node for the BSR engine. Unlike the SPPF GSS, its edges are
@@ -63,6 +67,8 @@ type bsrParser struct {
 
 	maxElems int
 	aborted  bool
+
+	furthest
 }
 
 func (p *bsrParser) add(L slot, u *bsrGNode, i, rc int) {
@@ -89,14 +95,14 @@ func (p *bsrParser) gssNodeFor(ret slot, pos, rc int) *bsrGNode {
 // when the consumed prefix has length >= 2 (an intermediate extent) or the
 // production is complete (dot == len). A length-1 prefix is degenerate (l == k)
 // and is reconstructed directly from the single symbol, so it is not stored.
-func (p *bsrParser) record(L slot, l, k, r int) {
+func (p *bsrParser) record(L slot, l, k, r, rc int) {
 	prod := p.sg.nts[L.nt].alts[L.alt]
 	if L.dot >= 2 || L.dot == len(prod) {
 		if p.maxElems > 0 && len(p.set) >= p.maxElems {
 			p.aborted = true
 			return
 		}
-		p.set[bsrElem{L, l, k, r}] = true
+		p.set[bsrElem{L, l, k, r, rc}] = true
 	}
 }
 
@@ -106,7 +112,7 @@ func (p *bsrParser) create(ret slot, u *bsrGNode, i, rc int) *bsrGNode {
 		v.edgeSet[u] = true
 		v.edges = append(v.edges, u)
 		for j := range p.popped[v] {
-			p.record(ret, u.pos, i, j)
+			p.record(ret, u.pos, i, j, rc)
 			p.add(ret, u, j, rc)
 		}
 	}
@@ -122,7 +128,7 @@ func (p *bsrParser) pop(u *bsrGNode, j int) {
 	}
 	p.popped[u][j] = true
 	for _, v := range u.edges {
-		p.record(u.ret, v.pos, u.pos, j)
+		p.record(u.ret, v.pos, u.pos, j, u.rc)
 		p.add(u.ret, v, j, u.rc)
 	}
 }
@@ -137,61 +143,29 @@ func (p *bsrParser) addRepAlts(ntID int, v *bsrGNode, i, rc int) {
 	}
 }
 
-func (p *bsrParser) matchTerm(s ssym, i int) int {
-	if s.kind == symEps {
-		return i
+func (p *bsrParser) matchTerm(s ssym, i int) int { return termEnd(p.sg.mode, p.sg.fold, p.input, s, i) }
+
+// noteFail is gllParser.noteFail over the BSR engine's GSS.
+func (p *bsrParser) noteFail(L slot, u *bsrGNode, i int, s ssym) {
+	if !p.at(i) {
+		return
 	}
-	switch v := s.term.(type) {
-	case ElemCharVal:
-		idx := i
-		for k := 0; k < len(v.Values); k++ {
-			if idx >= len(p.input) {
-				return -1
-			}
-			r, size := utf8.DecodeRune(p.input[idx:])
-			if r == utf8.RuneError && size == 1 {
-				return -1
-			}
-			if !sensequal(v.Values[k], r, v.Sensitive) {
-				return -1
-			}
-			idx += size
+	desc := termDesc(s.term)
+	p.note(i, desc)
+	var frames []ruleFrame
+	seen := map[*bsrGNode]bool{}
+	for nt, start := L.nt, u.pos; ; {
+		if info := p.sg.nts[nt]; info.isRule {
+			frames = append(frames, ruleFrame{info.ruleName, start})
 		}
-		return idx
-	case ElemNumVal:
-		switch v.Status {
-		case StatRange:
-			if i >= len(p.input) {
-				return -1
-			}
-			min := numvalToRune(v.Elems[0], v.Base)
-			max := numvalToRune(v.Elems[1], v.Base)
-			r, size := utf8.DecodeRune(p.input[i:])
-			if r == utf8.RuneError && size == 1 {
-				return -1
-			}
-			if min <= r && r <= max {
-				return i + size
-			}
-			return -1
-		case StatSeries:
-			idx := i
-			for k := 0; k < len(v.Elems); k++ {
-				ru := numvalToRune(v.Elems[k], v.Base)
-				if !utf8.ValidRune(ru) {
-					return -1
-				}
-				str := string(ru)
-				sz := len(str)
-				if idx+sz > len(p.input) || str != string(p.input[idx:idx+sz]) {
-					return -1
-				}
-				idx += sz
-			}
-			return idx
+		if u == p.u0 || len(u.edges) == 0 || seen[u] {
+			break
 		}
+		seen[u] = true
+		nt, u = u.ret.nt, u.edges[0]
+		start = u.pos
 	}
-	return -1
+	p.noteIn(desc, frames, i)
 }
 
 func (p *bsrParser) parse() {
@@ -223,11 +197,7 @@ func (p *bsrParser) process(d bsrDesc) {
 			ret := slot{L.nt, L.alt, L.dot + 1}
 			v := p.create(ret, u, i, rc)
 			if p.sg.nts[s.nt].isRep {
-				childRC := 0
-				if s.nt == L.nt {
-					childRC = capRC(rc, p.sg.nts[s.nt])
-				}
-				p.addRepAlts(s.nt, v, i, childRC)
+				p.addRepAlts(s.nt, v, i, p.sg.childRC(L, rc, s))
 			} else {
 				for ai := range p.sg.nts[s.nt].alts {
 					p.add(slot{s.nt, ai, 0}, v, i, 0)
@@ -237,10 +207,11 @@ func (p *bsrParser) process(d bsrDesc) {
 		}
 		j := p.matchTerm(s, i)
 		if j < 0 {
+			p.noteFail(L, u, i, s)
 			return
 		}
 		next := slot{L.nt, L.alt, L.dot + 1}
-		p.record(next, u.pos, i, j)
+		p.record(next, u.pos, i, j, rc)
 		i = j
 		L = next
 	}
@@ -257,22 +228,31 @@ type BSRForest struct {
 	input    []byte
 	rulename string
 	set      map[bsrElem]bool
-	index    map[bsrKey][]int // (slot,l,r) -> pivots k
+	index    map[bsrKey][]int // (slot,l,r,rc) -> pivots k
 	start    int
 	n        int
+
+	// diag carries the furthest-failure diagnostics from the parse, surfaced
+	// through ParseError when the forest is invalid.
+	diag furthest
 }
 
 type bsrKey struct {
 	slot slot
 	l, r int
+	rc   int
 }
 
 func (f *BSRForest) buildIndex() {
 	f.index = make(map[bsrKey][]int, len(f.set))
 	for e := range f.set {
-		k := bsrKey{e.slot, e.l, e.r}
+		k := bsrKey{e.slot, e.l, e.r, e.rc}
 		f.index[k] = append(f.index[k], e.k)
 	}
+	// The set is a map: order pivots so that extraction is deterministic.
+	for _, ks := range f.index {
+		slices.Sort(ks)
+	}
 }
 
 // startElems returns, for each production of the start rule, the pivots of the
@@ -280,7 +260,7 @@ func (f *BSRForest) buildIndex() {
 func (f *BSRForest) startElems() (slot, []int, bool) {
 	for ai, prod := range f.sg.nts[f.start].alts {
 		s := slot{f.start, ai, len(prod)}
-		if ks := f.index[bsrKey{s, 0, f.n}]; len(ks) > 0 {
+		if ks := f.index[bsrKey{s, 0, f.n, 0}]; len(ks) > 0 {
 			return s, ks, true
 		}
 	}
@@ -291,13 +271,22 @@ func (f *BSRForest) startElems() (slot, []int, bool) {
 func (f *BSRForest) Valid() bool {
 	for ai, prod := range f.sg.nts[f.start].alts {
 		s := slot{f.start, ai, len(prod)}
-		if len(f.index[bsrKey{s, 0, f.n}]) > 0 {
+		if len(f.index[bsrKey{s, 0, f.n, 0}]) > 0 {
 			return true
 		}
 	}
 	return false
 }
 
+// ParseError returns the furthest-failure diagnostic for an invalid forest, as
+// Forest.ParseError does. It returns nil when the forest is valid.
+func (f *BSRForest) ParseError() *ParseError {
+	if f.Valid() {
+		return nil
+	}
+	return f.diag.parseError(f.input, 0)
+}
+
 // Nodes returns the number of BSR elements (the representation's size).
 func (f *BSRForest) Nodes() int { return len(f.set) }
 
@@ -307,6 +296,7 @@ type bsrNodeID struct {
 	slot  slot // for intermediate nodes
 	nt    int  // for symbol nodes
 	l, r  int
+	rc    int
 }
 
 // NumTrees returns the number of distinct parse trees represented, or -1 for
@@ -316,83 +306,88 @@ func (f *BSRForest) NumTrees() *big.Int {
 	if !f.Valid() {
 		return big.NewInt(0)
 	}
-	memo := map[bsrNodeID]*big.Int{}
-	onStack := map[bsrNodeID]bool{}
-	infinite := false
+	c := f.newCounter()
+	res := c.sym(f.start, 0, f.n, 0)
+	if c.infinite {
+		return big.NewInt(-1)
+	}
+	return res
+}
 
-	var symWays func(s ssym, a, b int) *big.Int
-	var countSym func(nt, l, r int) *big.Int
-	var countInter func(sl slot, l, r int) *big.Int
-	var countElem func(sl slot, l, k, r int) *big.Int
+// bsrCounter counts the trees below BSR extents, memoized per symbol and
+// intermediate node. A cycle sets infinite and counts as 1 tree.
+type bsrCounter struct {
+	f        *BSRForest
+	memo     map[bsrNodeID]*big.Int
+	onStack  map[bsrNodeID]bool
+	infinite bool
+}
 
-	symWays = func(s ssym, a, b int) *big.Int {
-		if s.kind == symNonterm {
-			return countSym(s.nt, a, b)
-		}
-		return big.NewInt(1) // terminal / eps leaf
-	}
+func (f *BSRForest) newCounter() *bsrCounter {
+	return &bsrCounter{f: f, memo: map[bsrNodeID]*big.Int{}, onStack: map[bsrNodeID]bool{}}
+}
 
-	countElem = func(sl slot, l, k, r int) *big.Int {
-		prod := f.sg.nts[sl.nt].alts[sl.alt]
-		last := symWays(prod[sl.dot-1], k, r)
-		var pre *big.Int
-		switch sl.dot {
-		case 1: // empty prefix (l == k)
-			pre = big.NewInt(1)
-		case 2: // single-symbol prefix
-			pre = symWays(prod[0], l, k)
-		default: // intermediate prefix
-			pre = countInter(slot{sl.nt, sl.alt, sl.dot - 1}, l, k)
-		}
-		return new(big.Int).Mul(pre, last)
+func (c *bsrCounter) ways(s ssym, a, b, rc int) *big.Int {
+	if s.kind == symNonterm {
+		return c.sym(s.nt, a, b, rc)
 	}
+	return big.NewInt(1) // terminal / eps leaf
+}
 
-	countInter = func(sl slot, l, r int) *big.Int {
-		id := bsrNodeID{inter: true, slot: sl, l: l, r: r}
-		if v, ok := memo[id]; ok {
-			return v
-		}
-		if onStack[id] {
-			infinite = true
-			return big.NewInt(1)
-		}
-		onStack[id] = true
-		total := big.NewInt(0)
-		for _, k := range f.index[bsrKey{sl, l, r}] {
-			total.Add(total, countElem(sl, l, k, r))
-		}
-		onStack[id] = false
-		memo[id] = total
-		return total
-	}
+func (c *bsrCounter) elem(sl slot, l, k, r, rc int) *big.Int {
+	prod := c.f.sg.nts[sl.nt].alts[sl.alt]
+	last := c.ways(prod[sl.dot-1], k, r, c.f.sg.childRC(sl, rc, prod[sl.dot-1]))
+	var pre *big.Int
+	switch sl.dot {
+	case 1: // empty prefix (l == k)
+		pre = big.NewInt(1)
+	case 2: // single-symbol prefix
+		pre = c.ways(prod[0], l, k, c.f.sg.childRC(sl, rc, prod[0]))
+	default: // intermediate prefix
+		pre = c.inter(slot{sl.nt, sl.alt, sl.dot - 1}, l, k, rc)
+	}
+	return new(big.Int).Mul(pre, last)
+}
 
-	countSym = func(nt, l, r int) *big.Int {
-		id := bsrNodeID{nt: nt, l: l, r: r}
-		if v, ok := memo[id]; ok {
-			return v
-		}
-		if onStack[id] {
-			infinite = true
-			return big.NewInt(1)
-		}
-		onStack[id] = true
-		total := big.NewInt(0)
-		for ai, prod := range f.sg.nts[nt].alts {
-			sl := slot{nt, ai, len(prod)}
-			for _, k := range f.index[bsrKey{sl, l, r}] {
-				total.Add(total, countElem(sl, l, k, r))
-			}
-		}
-		onStack[id] = false
-		memo[id] = total
-		return total
+func (c *bsrCounter) inter(sl slot, l, r, rc int) *big.Int {
+	id := bsrNodeID{inter: true, slot: sl, l: l, r: r, rc: rc}
+	if v, ok := c.memo[id]; ok {
+		return v
 	}
+	if c.onStack[id] {
+		c.infinite = true
+		return big.NewInt(1)
+	}
+	c.onStack[id] = true
+	total := big.NewInt(0)
+	for _, k := range c.f.index[bsrKey{sl, l, r, rc}] {
+		total.Add(total, c.elem(sl, l, k, r, rc))
+	}
+	c.onStack[id] = false
+	c.memo[id] = total
+	return total
+}
 
-	res := countSym(f.start, 0, f.n)
-	if infinite {
-		return big.NewInt(-1)
+func (c *bsrCounter) sym(nt, l, r, rc int) *big.Int {
+	id := bsrNodeID{nt: nt, l: l, r: r, rc: rc}
+	if v, ok := c.memo[id]; ok {
+		return v
 	}
-	return res
+	if c.onStack[id] {
+		c.infinite = true
+		return big.NewInt(1)
+	}
+	c.onStack[id] = true
+	total := big.NewInt(0)
+	for ai, prod := range c.f.sg.nts[nt].alts {
+		sl := slot{nt, ai, len(prod)}
+		for _, k := range c.f.index[bsrKey{sl, l, r, rc}] {
+			total.Add(total, c.elem(sl, l, k, r, rc))
+		}
+	}
+	c.onStack[id] = false
+	c.memo[id] = total
+	return total
 }
 
 // Ambiguous reports whether the input has more than one distinct parse tree.
@@ -402,49 +397,49 @@ func (f *BSRForest) Ambiguous() bool {
 	}
 	visited := map[bsrNodeID]bool{}
 
-	var symAmb func(nt, l, r int) bool
-	var interAmb func(sl slot, l, r int) bool
-	var elemAmb func(sl slot, l, k, r int) bool
+	var symAmb func(nt, l, r, rc int) bool
+	var interAmb func(sl slot, l, r, rc int) bool
+	var elemAmb func(sl slot, l, k, r, rc int) bool
 
-	symOf := func(s ssym, a, b int) bool {
+	symOf := func(sl slot, s ssym, a, b, rc int) bool {
 		if s.kind == symNonterm {
-			return symAmb(s.nt, a, b)
+			return symAmb(s.nt, a, b, f.sg.childRC(sl, rc, s))
 		}
 		return false
 	}
-	elemAmb = func(sl slot, l, k, r int) bool {
+	elemAmb = func(sl slot, l, k, r, rc int) bool {
 		prod := f.sg.nts[sl.nt].alts[sl.alt]
-		if symOf(prod[sl.dot-1], k, r) {
+		if symOf(sl, prod[sl.dot-1], k, r, rc) {
 			return true
 		}
 		switch sl.dot {
 		case 1:
 			return false
 		case 2:
-			return symOf(prod[0], l, k)
+			return symOf(sl, prod[0], l, k, rc)
 		default:
-			return interAmb(slot{sl.nt, sl.alt, sl.dot - 1}, l, k)
+			return interAmb(slot{sl.nt, sl.alt, sl.dot - 1}, l, k, rc)
 		}
 	}
-	interAmb = func(sl slot, l, r int) bool {
-		id := bsrNodeID{inter: true, slot: sl, l: l, r: r}
+	interAmb = func(sl slot, l, r, rc int) bool {
+		id := bsrNodeID{inter: true, slot: sl, l: l, r: r, rc: rc}
 		if visited[id] {
 			return false
 		}
 		visited[id] = true
-		ks := f.index[bsrKey{sl, l, r}]
+		ks := f.index[bsrKey{sl, l, r, rc}]
 		if len(ks) > 1 {
 			return true
 		}
 		for _, k := range ks {
-			if elemAmb(sl, l, k, r) {
+			if elemAmb(sl, l, k, r, rc) {
 				return true
 			}
 		}
 		return false
 	}
-	symAmb = func(nt, l, r int) bool {
-		id := bsrNodeID{nt: nt, l: l, r: r}
+	symAmb = func(nt, l, r, rc int) bool {
+		id := bsrNodeID{nt: nt, l: l, r: r, rc: rc}
 		if visited[id] {
 			return false
 		}
@@ -452,7 +447,7 @@ func (f *BSRForest) Ambiguous() bool {
 		packs := 0
 		for ai, prod := range f.sg.nts[nt].alts {
 			sl := slot{nt, ai, len(prod)}
-			ks := f.index[bsrKey{sl, l, r}]
+			ks := f.index[bsrKey{sl, l, r, rc}]
 			packs += len(ks)
 		}
 		if packs > 1 {
@@ -460,15 +455,15 @@ func (f *BSRForest) Ambiguous() bool {
 		}
 		for ai, prod := range f.sg.nts[nt].alts {
 			sl := slot{nt, ai, len(prod)}
-			for _, k := range f.index[bsrKey{sl, l, r}] {
-				if elemAmb(sl, l, k, r) {
+			for _, k := range f.index[bsrKey{sl, l, r, rc}] {
+				if elemAmb(sl, l, k, r, rc) {
 					return true
 				}
 			}
 		}
 		return false
 	}
-	return symAmb(f.start, 0, f.n)
+	return symAmb(f.start, 0, f.n, 0)
 }
 
 // Tree extracts a single parse tree (first element at each extent), or nil if
@@ -483,43 +478,43 @@ func (f *BSRForest) Tree() *ParseTree {
 		return nil
 	}
 	visited := map[bsrNodeID]bool{}
-	return f.emitSym(f.start, sl, ks[0], 0, f.n, visited)
+	return f.emitSym(f.start, sl, ks[0], 0, f.n, 0, visited)
 }
 
 // emitSym builds a ParseTree node for nonterminal nt over [l,r], chosen via the
 // complete-production slot sl with pivot k.
-func (f *BSRForest) emitSym(nt int, sl slot, k, l, r int, visited map[bsrNodeID]bool) *ParseTree {
+func (f *BSRForest) emitSym(nt int, sl slot, k, l, r, rc int, visited map[bsrNodeID]bool) *ParseTree {
 	var kids []*ParseTree
-	f.collectElem(sl, l, k, r, &kids, visited)
+	f.collectElem(sl, l, k, r, rc, &kids, visited)
 	info := f.sg.nts[nt]
 	if !info.isRule {
 		// synthetic (group/option/rep): splice children into the parent
 		return &ParseTree{Start: l, End: r, Children: kids} // caller flattens
 	}
-	return &ParseTree{Rule: info.ruleName, Start: l, End: r, Children: kids}
+	return &ParseTree{Rule: info.ruleName, Alternative: sl.alt, Start: l, End: r, Children: kids}
 }
 
 // collectElem appends the children of element (sl,l,k,r) into out, flattening
 // intermediate extents and synthetic nonterminals.
-func (f *BSRForest) collectElem(sl slot, l, k, r int, out *[]*ParseTree, visited map[bsrNodeID]bool) {
+func (f *BSRForest) collectElem(sl slot, l, k, r, rc int, out *[]*ParseTree, visited map[bsrNodeID]bool) {
 	prod := f.sg.nts[sl.nt].alts[sl.alt]
 	// prefix x_1..x_{dot-1} over [l,k]
 	switch sl.dot {
 	case 1:
 		// empty
 	case 2:
-		f.collectChild(prod[0], l, k, out, visited)
+		f.collectChild(prod[0], l, k, f.sg.childRC(sl, rc, prod[0]), out, visited)
 	default:
 		isl := slot{sl.nt, sl.alt, sl.dot - 1}
-		if ks := f.index[bsrKey{isl, l, k}]; len(ks) > 0 {
-			f.collectElem(isl, l, ks[0], k, out, visited)
+		if ks := f.index[bsrKey{isl, l, k, rc}]; len(ks) > 0 {
+			f.collectElem(isl, l, ks[0], k, rc, out, visited)
 		}
 	}
 	// last symbol x_{dot} over [k,r]
-	f.collectChild(prod[sl.dot-1], k, r, out, visited)
+	f.collectChild(prod[sl.dot-1], k, r, f.sg.childRC(sl, rc, prod[sl.dot-1]), out, visited)
 }
 
-func (f *BSRForest) collectChild(s ssym, a, b int, out *[]*ParseTree, visited map[bsrNodeID]bool) {
+func (f *BSRForest) collectChild(s ssym, a, b, rc int, out *[]*ParseTree, visited map[bsrNodeID]bool) {
 	if s.kind != symNonterm {
 		if s.kind == symEps {
 			return // epsilon contributes no leaf
@@ -527,7 +522,7 @@ func (f *BSRForest) collectChild(s ssym, a, b int, out *[]*ParseTree, visited ma
 		*out = append(*out, &ParseTree{Start: a, End: b})
 		return
 	}
-	id := bsrNodeID{nt: s.nt, l: a, r: b}
+	id := bsrNodeID{nt: s.nt, l: a, r: b, rc: rc}
 	if visited[id] {
 		return
 	}
@@ -535,15 +530,15 @@ func (f *BSRForest) collectChild(s ssym, a, b int, out *[]*ParseTree, visited ma
 	// pick the first complete-production element for this nonterminal extent
 	for ai, prod := range f.sg.nts[s.nt].alts {
 		csl := slot{s.nt, ai, len(prod)}
-		if ks := f.index[bsrKey{csl, a, b}]; len(ks) > 0 {
+		if ks := f.index[bsrKey{csl, a, b, rc}]; len(ks) > 0 {
 			info := f.sg.nts[s.nt]
 			if info.isRule {
 				var kids []*ParseTree
-				f.collectElem(csl, a, ks[0], b, &kids, visited)
-				*out = append(*out, &ParseTree{Rule: info.ruleName, Start: a, End: b, Children: kids})
+				f.collectElem(csl, a, ks[0], b, rc, &kids, visited)
+				*out = append(*out, &ParseTree{Rule: info.ruleName, Alternative: ai, Start: a, End: b, Children: kids})
 			} else {
 				// synthetic: splice its children directly into the parent
-				f.collectElem(csl, a, ks[0], b, out, visited)
+				f.collectElem(csl, a, ks[0], b, rc, out, visited)
 			}
 			visited[id] = false
 			return
@@ -583,6 +578,7 @@ func ParseBSR(input []byte, grammar *Grammar, rootRulename string, opts ...Fores
 		set:      p.set,
 		start:    sg.start,
 		n:        len(input),
+		diag:     p.furthest,
 	}
 	f.buildIndex()
 	return f, nil
@@ -0,0 +1,239 @@
+package goabnf
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// canonical.go rewrites an input into a canonical spelling of the same
+// derivation, writing each rule node from the match of its alternative against
+// its children (see rematch.go): which char-val, repetition or option every
+// child comes from.
+
+// LetterCase is the casing Canonicalize writes the letters of case-insensitive
+// char-vals in.
+type LetterCase int
+
+const (
+	// KeepCase leaves letters as they are in the input. It is the default.
+	KeepCase LetterCase = iota
+	// LowerCase writes letters in lower case.
+	LowerCase
+	// UpperCase writes letters in upper case.
+	UpperCase
+)
+
+// CanonicalPolicy tells Canonicalize which spellings of an input to unify.
+type CanonicalPolicy struct {
+	// Case is the casing of the letters matched by case-insensitive char-vals,
+	// e.g. of the hex digits of HEXDIG. A letter only changes to a character
+	// the grammar's CaseFolding folds it with.
+	Case LetterCase
+	// MinimalWhitespace drops the occurrences of repetitions beyond their
+	// minimum, and the content of options, whose element derives nothing but
+	// whitespace (SP, HTAB, CR and LF) in the grammar, as WSP, LWSP or a rule
+	// made of those: *WSP is written empty and 1*WSP as its first character,
+	// while *(ALPHA / SP) is kept whole.
+	MinimalWhitespace bool
+	// Rewrite maps rule names to the text their nodes are written as, e.g.
+	// "OWS" to "" and "RWS" to " ". The text should derive the rule.
+	Rewrite map[string]string
+	// Derivation are the ParseForest options choosing the derivation of an
+	// ambiguous input to write. It defaults to WithAlternativePriority: the
+	// earliest alternatives are preferred.
+	Derivation []ForestOption
+}
+
+// Canonicalize parses input as rule and writes it back following policy, so
+// that inputs only differing in the spellings the policy unifies get the same
+// canonical form. The result is parsed again: a policy breaking it, e.g. by
+// rewriting a rule to a text it does not derive, is reported with the
+// *ParseError of the result. An invalid input is reported with its own.
+func Canonicalize(g *Grammar, rule string, input []byte, policy CanonicalPolicy) ([]byte, error) {
+	opts := policy.Derivation
+	if opts == nil {
+		opts = []ForestOption{WithAlternativePriority()}
+	}
+	f, err := ParseForest(input, g, rule, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if pe := f.ParseError(); pe != nil {
+		return nil, pe
+	}
+	c := &canonicalizer{g: g, input: input, policy: policy, rewrite: map[string]string{}}
+	if policy.MinimalWhitespace {
+		c.blank = newBlankRules(g)
+	}
+	for name, text := range policy.Rewrite {
+		c.rewrite[canon(name)] = text
+	}
+	c.node(f.Tree())
+	out := c.out
+
+	check, err := ParseForest(out, g, rule)
+	if err != nil {
+		return nil, err
+	}
+	if pe := check.ParseError(); pe != nil {
+		return out, pe
+	}
+	return out, nil
+}
+
+type canonicalizer struct {
+	g       *Grammar
+	input   []byte
+	policy  CanonicalPolicy
+	rewrite map[string]string // canon(rule) -> text
+	blank   *blankRules       // with MinimalWhitespace only
+	out     []byte
+}
+
+// node writes the canonical form of the rule node t.
+func (c *canonicalizer) node(t *ParseTree) {
+	if text, ok := c.rewrite[canon(t.Rule)]; ok {
+		c.out = append(c.out, text...)
+		return
+	}
+	events, ok := rematch(c.g, c.input, t)
+	if !ok {
+		// A node cut short on a cycle has no children to match: keep it.
+		c.out = append(c.out, c.input[t.Start:t.End]...)
+		return
+	}
+	dropped := 0 // depth of the dropped occurrences being matched
+	for _, e := range events {
+		switch e.kind {
+		case rematchEnter:
+			if dropped > 0 || c.droppable(e.rep, e.n) {
+				dropped++
+			}
+		case rematchLeave:
+			if dropped > 0 {
+				dropped--
+			}
+		case rematchRule:
+			if dropped == 0 {
+				c.node(t.Children[e.kid])
+			}
+		case rematchTerm:
+			if dropped == 0 {
+				c.terminal(e.rep.Element, t.Children[e.kid])
+			}
+		}
+	}
+}
+
+// terminal writes the leaf t matched by the terminal e.
+func (c *canonicalizer) terminal(e ElemItf, t *ParseTree) {
+	text := c.input[t.Start:t.End]
+	v, ok := e.(ElemCharVal)
+	if !ok || v.Sensitive || c.policy.Case == KeepCase {
+		c.out = append(c.out, text...)
+		return
+	}
+	to := unicode.ToLower
+	if c.policy.Case == UpperCase {
+		to = unicode.ToUpper
+	}
+	for len(text) > 0 {
+		r, size := utf8.DecodeRune(text)
+		if cr := to(r); c.g.Folding.equal(r, cr) {
+			r = cr
+		}
+		c.out = utf8.AppendRune(c.out, r)
+		text = text[size:]
+	}
+}
+
+// droppable reports whether MinimalWhitespace drops occurrence n of r: an
+// occurrence beyond the minimum, or the content of an option, whose element
+// derives nothing but whitespace.
+func (c *canonicalizer) droppable(r *Repetition, n int) bool {
+	if !c.policy.MinimalWhitespace {
+		return false
+	}
+	_, opt := r.Element.(ElemOption)
+	return (n >= r.Min || opt) && c.blank.elem(r.Element)
+}
+
+// blankRules tells the elements of a grammar deriving nothing but whitespace.
+type blankRules struct {
+	g     *Grammar
+	rules map[string]bool // canon(rule) -> derives nothing but whitespace
+}
+
+// newBlankRules finds the blank rules of g, core rules included, as a greatest
+// fixed point: every rule is assumed blank, until one of its elements is not.
+func newBlankRules(g *Grammar) *blankRules {
+	b := &blankRules{g: g, rules: map[string]bool{}}
+	var all []*Rule
+	for _, rm := range []map[string]*Rule{g.Rulemap, coreRules} {
+		for _, r := range rm {
+			if _, ok := b.rules[canon(r.Name)]; !ok {
+				b.rules[canon(r.Name)] = true
+				all = append(all, r)
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, r := range all {
+			if b.rules[canon(r.Name)] && !b.alt(r.Alternation) {
+				b.rules[canon(r.Name)] = false
+				changed = true
+			}
+		}
+	}
+	return b
+}
+
+func (b *blankRules) alt(a Alternation) bool {
+	for _, cc := range a.Concatenations {
+		for _, r := range cc.Repetitions {
+			if r.Max != 0 && !b.elem(r.Element) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (b *blankRules) elem(e ElemItf) bool {
+	switch v := e.(type) {
+	case ElemRulename:
+		return b.rules[canon(v.Name)]
+	case ElemGroup:
+		return b.alt(v.Alternation)
+	case ElemOption:
+		return b.alt(v.Alternation)
+	case ElemCharVal:
+		for _, r := range v.Values {
+			if !isBlank(r) {
+				return false
+			}
+		}
+		return true
+	case ElemNumVal:
+		if v.Status == StatRange {
+			for r := numvalToRune(v.Elems[0], v.Base); r <= numvalToRune(v.Elems[1], v.Base); r++ {
+				if !isBlank(r) {
+					return false
+				}
+			}
+			return true
+		}
+		for _, el := range v.Elems {
+			if !isBlank(numvalToRune(el, v.Base)) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func isBlank(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r' || r == '\n'
+}
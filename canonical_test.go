@@ -0,0 +1,171 @@
+package goabnf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const headerAbnf = "header = name \":\" OWS value OWS\r\n" +
+	"name = \"Host\" / \"Accept\"\r\n" +
+	"value = word *(RWS word)\r\n" +
+	"word = 1*(ALPHA / \".\")\r\n" +
+	"OWS = *(SP / HTAB)\r\n" +
+	"RWS = 1*(SP / HTAB)\r\n"
+
+func Test_U_Canonicalize(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Grammar        string
+		Rule           string
+		Folding        CaseFolding
+		Input          string
+		Policy         CanonicalPolicy
+		ExpectedOutput string
+	}{
+		"keep": {
+			Grammar:        "a = 1*HEXDIG\r\n",
+			Rule:           "a",
+			Input:          "dEaD09",
+			ExpectedOutput: "dEaD09",
+		},
+		"hexdig-lower": {
+			Grammar:        "a = 1*HEXDIG\r\n",
+			Rule:           "a",
+			Input:          "dEaD09",
+			Policy:         CanonicalPolicy{Case: LowerCase},
+			ExpectedOutput: "dead09",
+		},
+		"hexdig-upper": {
+			Grammar:        "a = 1*HEXDIG\r\n",
+			Rule:           "a",
+			Input:          "dEaD09",
+			Policy:         CanonicalPolicy{Case: UpperCase},
+			ExpectedOutput: "DEAD09",
+		},
+		"num-val-untouched": {
+			Grammar:        "a = %x41 \"b\"\r\n",
+			Rule:           "a",
+			Input:          "AB",
+			Policy:         CanonicalPolicy{Case: LowerCase},
+			ExpectedOutput: "Ab",
+		},
+		"sensitive-untouched": {
+			Grammar:        "a = %s\"Ab\" \"cd\"\r\n",
+			Rule:           "a",
+			Input:          "AbCD",
+			Policy:         CanonicalPolicy{Case: LowerCase},
+			ExpectedOutput: "Abcd",
+		},
+		"unicode-folding": {
+			Grammar:        "a = \"k\" \"é\"\r\n",
+			Rule:           "a",
+			Folding:        FoldUnicode,
+			Input:          "KÉ",
+			Policy:         CanonicalPolicy{Case: LowerCase},
+			ExpectedOutput: "ké",
+		},
+		"minimal-whitespace": {
+			Grammar:        headerAbnf,
+			Rule:           "header",
+			Input:          "HOST: \t example.org \t  other  ",
+			Policy:         CanonicalPolicy{Case: LowerCase, MinimalWhitespace: true},
+			ExpectedOutput: "host:example.org other",
+		},
+		"minimal-whitespace-first": {
+			// 1*WSP keeps its first character.
+			Grammar:        headerAbnf,
+			Rule:           "header",
+			Input:          "Host:a\t b",
+			Policy:         CanonicalPolicy{MinimalWhitespace: true},
+			ExpectedOutput: "Host:a\tb",
+		},
+		"minimal-whitespace-significant": {
+			// The spaces are words of the grammar, not whitespace around them.
+			Grammar:        "a = *(ALPHA / SP)\r\n",
+			Rule:           "a",
+			Input:          "hello world",
+			Policy:         CanonicalPolicy{MinimalWhitespace: true},
+			ExpectedOutput: "hello world",
+		},
+		"minimal-whitespace-rules": {
+			// sep is made of whitespace rules only, and so is the option.
+			Grammar:        "a = \"x\" sep \"y\" [LWSP] \"z\"\r\nsep = *blank\r\nblank = WSP / %x0D.0A\r\n",
+			Rule:           "a",
+			Input:          "x \t\r\n y \r\n z",
+			Policy:         CanonicalPolicy{MinimalWhitespace: true},
+			ExpectedOutput: "xyz",
+		},
+		"minimal-whitespace-greedy-split": {
+			// The spaces are attributed to *SP, which takes all it can,
+			// rather than to the repetition also matching words.
+			Grammar:        "a = *SP *(SP / ALPHA)\r\n",
+			Rule:           "a",
+			Input:          "  x",
+			Policy:         CanonicalPolicy{MinimalWhitespace: true},
+			ExpectedOutput: "x",
+		},
+		"rewrite": {
+			Grammar:        headerAbnf,
+			Rule:           "header",
+			Input:          "Accept:  a\t b  ",
+			Policy:         CanonicalPolicy{Rewrite: map[string]string{"ows": "", "RWS": " "}},
+			ExpectedOutput: "Accept:a b",
+		},
+		"derivation-priority": {
+			// "a" is both an x and a y: the first alternative is written.
+			Grammar:        "a = x / y\r\nx = \"A\"\r\ny = %x61\r\n",
+			Rule:           "a",
+			Input:          "a",
+			Policy:         CanonicalPolicy{Case: UpperCase},
+			ExpectedOutput: "A",
+		},
+		"derivation-filter": {
+			Grammar: "a = x / y\r\nx = \"A\"\r\ny = %x61\r\n",
+			Rule:    "a",
+			Input:   "a",
+			Policy: CanonicalPolicy{Case: UpperCase, Derivation: []ForestOption{
+				WithFilter(func(p PackedNode, _ []byte) bool { return p.Symbol != "x" }),
+			}},
+			ExpectedOutput: "a",
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			g, err := ParseABNF([]byte(tt.Grammar), WithCaseFolding(tt.Folding))
+			require.NoError(t, err)
+			out, err := Canonicalize(g, tt.Rule, []byte(tt.Input), tt.Policy)
+			require.NoError(t, err)
+			assert.Equal(t, tt.ExpectedOutput, string(out))
+
+			// A canonical form is its own canonical form.
+			again, err := Canonicalize(g, tt.Rule, out, tt.Policy)
+			require.NoError(t, err)
+			assert.Equal(t, string(out), string(again))
+		})
+	}
+}
+
+func Test_U_Canonicalize_Errors(t *testing.T) {
+	g := mustGrammar(headerAbnf)
+
+	// The input is invalid.
+	out, err := Canonicalize(g, "header", []byte("Host example.org"), CanonicalPolicy{})
+	var pe *ParseError
+	require.ErrorAs(t, err, &pe)
+	assert.Nil(t, out)
+
+	// The policy breaks the input: name does not derive "Date".
+	out, err = Canonicalize(g, "header", []byte("Host:a b"), CanonicalPolicy{Rewrite: map[string]string{"name": "Date"}})
+	require.ErrorAs(t, err, &pe)
+	assert.Equal(t, "Date:a b", string(out))
+
+	_, err = Canonicalize(g, "unknown", []byte("Host:a"), CanonicalPolicy{})
+	var notFound *ErrRuleNotFound
+	assert.ErrorAs(t, err, &notFound)
+}
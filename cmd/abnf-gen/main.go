@@ -31,6 +31,8 @@ func run() error {
 	root := flag.String("root", "", "root rule the parser starts from (required)")
 	pkg := flag.String("pkg", "", "package name for the generated file (required)")
 	noValidate := flag.Bool("no-validate", false, "skip semantic validation of the grammar")
+	bytesMode := flag.Bool("bytes", false, "match num-vals against bytes rather than UTF-8 code points")
+	unicodeFold := flag.Bool("unicode-fold", false, "fold case-insensitive char-vals with Unicode simple case folding")
 	flag.Parse()
 
 	missing := func(name, val string) error {
@@ -55,6 +57,12 @@ func run() error {
 	if *noValidate {
 		opts = append(opts, goabnf.WithValidation(false))
 	}
+	if *bytesMode {
+		opts = append(opts, goabnf.WithMatchMode(goabnf.ModeBytes))
+	}
+	if *unicodeFold {
+		opts = append(opts, goabnf.WithCaseFolding(goabnf.FoldUnicode))
+	}
 	code, err := goabnf.GenerateGoParserFromABNF(src, *root, *pkg, opts...)
 	if err != nil {
 		return err
@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	goabnf "github.com/pandatix/go-abnf"
+	"github.com/urfave/cli/v2"
+)
+
+var Grep = &cli.Command{
+	Name:        "grep",
+	Usage:       "search files for strings derivable from an ABNF rule.",
+	Description: "search files for strings derivable from an ABNF rule, using the exact grammar instead of a hand-written regex. It first validate the grammar (see `validate` command), then write every match to stdout as `file:line:column: match`.",
+	ArgsUsage:   "file...",
+	Flags: []cli.Flag{
+		cli.HelpFlag,
+		&cli.StringFlag{
+			Name:  "input",
+			Usage: "set the input to get the ABNF grammar from. Set a file or let empty to read from stdin.",
+			Value: "-",
+		},
+		&cli.StringFlag{
+			Name:     "rulename",
+			Aliases:  []string{"rule"},
+			Usage:    "rulename to search for.",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "overlapping",
+			Usage: "report every match, including those overlapping or nested in others, instead of the leftmost-longest ones.",
+		},
+		&cli.StringFlag{
+			Name:  "color",
+			Usage: "colorize errors: auto (when stderr is a terminal), always or never.",
+			Value: "auto",
+		},
+	},
+	Action: grep,
+}
+
+func grep(ctx *cli.Context) error {
+	if ctx.Args().Len() == 0 {
+		return errors.New("no file to search")
+	}
+	b, err := readInput(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Build grammar
+	g, err := goabnf.ParseABNF(b)
+	if err != nil {
+		return diagnose(ctx, err, b)
+	}
+
+	mode := goabnf.FindLeftmostLongest
+	if ctx.Bool("overlapping") {
+		mode = goabnf.FindOverlapping
+	}
+	for _, file := range ctx.Args().Slice() {
+		text, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		spans, err := g.FindAll(ctx.String("rulename"), text, goabnf.WithFindMode(mode))
+		if err != nil {
+			return err
+		}
+
+		// Spans are ordered by start, so line and column are tracked incrementally.
+		line, col, off := 1, 1, 0
+		for _, s := range spans {
+			for ; off < s.Start; off++ {
+				if text[off] == '\n' {
+					line++
+					col = 1
+				} else {
+					col++
+				}
+			}
+			fmt.Printf("%s:%d:%d: %s\n", file, line, col, text[s.Start:s.End])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	goabnf "github.com/pandatix/go-abnf"
+	"github.com/urfave/cli/v2"
+)
+
+var Parse = &cli.Command{
+	Name:        "parse",
+	Usage:       "parse a file against an ABNF rule and export its parse forest.",
+	Description: "parse a file (or stdin with `-`) against a rule of an ABNF grammar, and write its parse forest to stdout as DOT (Graphviz), mermaid or JSON. It first validate the grammar (see `validate` command). Syntax errors of the file are rendered as `validate` does.",
+	ArgsUsage:   "file",
+	Flags: []cli.Flag{
+		cli.HelpFlag,
+		&cli.StringFlag{
+			Name:  "input",
+			Usage: "set the input to get the ABNF grammar from. Set a file or let empty to read from stdin.",
+			Value: "-",
+		},
+		&cli.StringFlag{
+			Name:     "rulename",
+			Aliases:  []string{"rule"},
+			Usage:    "rulename to parse the file as.",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "output format: dot, mermaid or json.",
+			Value: "json",
+		},
+		&cli.BoolFlag{
+			Name:  "bsr",
+			Usage: "export the BSR set, grouped by slot, instead of the SPPF.",
+		},
+		&cli.StringFlag{
+			Name:  "color",
+			Usage: "colorize errors: auto (when stderr is a terminal), always or never.",
+			Value: "auto",
+		},
+	},
+	Action: parse,
+}
+
+// forestExporter is implemented by both goabnf.Forest and goabnf.BSRForest.
+type forestExporter interface {
+	ToDOT() string
+	ToMermaid() string
+	MarshalJSON() ([]byte, error)
+	ParseError() *goabnf.ParseError
+}
+
+func parse(ctx *cli.Context) error {
+	if ctx.Args().Len() != 1 {
+		return errors.New("expected a single file to parse")
+	}
+	b, err := readInput(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Build grammar
+	g, err := goabnf.ParseABNF(b)
+	if err != nil {
+		return diagnose(ctx, err, b)
+	}
+
+	file := ctx.Args().First()
+	var text []byte
+	if file == "-" {
+		if ctx.String("input") == "-" {
+			return errors.New("the grammar and the file can't both be read from stdin")
+		}
+		file = "<stdin>"
+		text, err = io.ReadAll(os.Stdin)
+	} else {
+		text, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+
+	var f forestExporter
+	if ctx.Bool("bsr") {
+		f, err = goabnf.ParseBSR(text, g, ctx.String("rulename"))
+	} else {
+		f, err = goabnf.ParseForest(text, g, ctx.String("rulename"))
+	}
+	if err != nil {
+		return err
+	}
+	if pe := f.ParseError(); pe != nil {
+		return diagnoseFile(ctx, pe, file, text)
+	}
+
+	switch ctx.String("format") {
+	case "dot":
+		fmt.Print(f.ToDOT())
+	case "mermaid":
+		fmt.Print(f.ToMermaid())
+	case "json":
+		out, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	default:
+		return fmt.Errorf("unknown format %q, expected dot, mermaid or json", ctx.String("format"))
+	}
+	return nil
+}
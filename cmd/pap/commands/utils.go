@@ -4,7 +4,9 @@ import (
 	"crypto/rand"
 	"io"
 	"os"
+	"strings"
 
+	goabnf "github.com/pandatix/go-abnf"
 	"github.com/urfave/cli/v2"
 )
 
@@ -27,3 +29,28 @@ func findSeed(ctx *cli.Context) int64 {
 
 	return int64(b[0])
 }
+
+// diagnose turns err into a rendered diagnostic located in src, the content
+// read from the "input" flag, colored when stderr is a terminal unless the
+// "color" flag says otherwise.
+func diagnose(ctx *cli.Context, err error, src []byte) error {
+	name := ctx.String("input")
+	if name == "-" {
+		name = "<stdin>"
+	}
+	return diagnoseFile(ctx, err, name, src)
+}
+
+// diagnoseFile is diagnose for a source read from file name.
+func diagnoseFile(ctx *cli.Context, err error, name string, src []byte) error {
+	color := false
+	switch ctx.String("color") {
+	case "always":
+		color = true
+	case "auto":
+		if fi, err := os.Stderr.Stat(); err == nil {
+			color = fi.Mode()&os.ModeCharDevice != 0
+		}
+	}
+	return cli.Exit(strings.TrimSuffix(goabnf.RenderError(err, name, src, color), "\n"), 1)
+}
@@ -10,7 +10,7 @@ import (
 var Validate = &cli.Command{
 	Name:        "validate",
 	Usage:       "validate an ABNF grammar.",
-	Description: "validate an ABNF grammar from a file or stdin, and return its pretty print to stdout (exit code 0) or the error to stderr (exit code 1).",
+	Description: "validate an ABNF grammar from a file or stdin, and return its pretty print to stdout (exit code 0) or the error to stderr (exit code 1). Syntax errors are rendered with the offending line and a caret under the failing column.",
 	Flags: []cli.Flag{
 		cli.HelpFlag,
 		&cli.StringFlag{
@@ -23,6 +23,11 @@ var Validate = &cli.Command{
 			Usage: "set if proceed to semantic validation, see https://pkg.go.dev/github.com/pandatix/go-abnf#SemvalABNF for more info.",
 			Value: true,
 		},
+		&cli.StringFlag{
+			Name:  "color",
+			Usage: "colorize errors: auto (when stderr is a terminal), always or never.",
+			Value: "auto",
+		},
 	},
 	Action: validate,
 }
@@ -40,9 +45,9 @@ func validate(ctx *cli.Context) error {
 	}
 	g, err := goabnf.ParseABNF(b, opts...)
 	if err != nil {
-		return err
+		return diagnose(ctx, err, b)
 	}
 	fmt.Println(g.PrettyPrint())
 
-	return err
+	return nil
 }
@@ -27,6 +27,8 @@ func main() {
 			commands.Generate,
 			commands.TransitionGraph,
 			commands.Regex,
+			commands.Grep,
+			commands.Parse,
 		},
 		Flags: []cli.Flag{
 			cli.VersionFlag,
@@ -5,7 +5,6 @@ import (
 	"go/format"
 	"strconv"
 	"strings"
-	"unicode/utf8"
 )
 
 // GenerateGoParserFromABNF is a convenience wrapper that parses ABNF grammar
@@ -71,6 +70,7 @@ type codegen struct {
 	series    []string
 	seriesIdx map[string]int
 	usesUTF8  bool // whether any emitted matcher references unicode/utf8
+	usesFold  bool // whether any emitted matcher folds with unicode.SimpleFold
 }
 
 func (c *codegen) startID(nt, alt int) int { return c.idOf[nt][alt] }
@@ -103,12 +103,16 @@ func (c *codegen) emit() string {
 	c.emitSeed(&b)
 	c.emitMetadata(&b)
 	b.WriteString(queryBoilerplate)
+	if c.usesFold {
+		b.WriteString(foldBoilerplate)
+	}
 	c.emitSeriesVars(&b)
 	return b.String()
 }
 
 // scanUTF8 sets usesUTF8 if any terminal compiles to code that decodes runes
-// (a num-val range, or a char-val with a non-ASCII rune).
+// (a num-val range of a ModeUTF8 grammar, or a char-val with a non-ASCII rune
+// or folded with FoldUnicode), and usesFold if one folds with FoldUnicode.
 func (c *codegen) scanUTF8() {
 	for _, nt := range c.sg.nts {
 		for _, prod := range nt.alts {
@@ -118,13 +122,14 @@ func (c *codegen) scanUTF8() {
 				}
 				switch v := s.term.(type) {
 				case ElemNumVal:
-					if v.Status == StatRange {
+					if v.Status == StatRange && c.sg.mode == ModeUTF8 {
 						c.usesUTF8 = true
 					}
 				case ElemCharVal:
-					for _, r := range v.Values {
-						if r > 0x7f {
-							c.usesUTF8 = true
+					if c.decodesCharVal(v) {
+						c.usesUTF8 = true
+						if !v.Sensitive && c.sg.fold == FoldUnicode {
+							c.usesFold = true
 						}
 					}
 				}
@@ -135,6 +140,9 @@ func (c *codegen) scanUTF8() {
 
 func (c *codegen) emitImports(b *strings.Builder) {
 	b.WriteString("import (\n\t\"errors\"\n\t\"math/big\"\n")
+	if c.usesFold {
+		b.WriteString("\t\"unicode\"\n")
+	}
 	if c.usesUTF8 {
 		b.WriteString("\t\"unicode/utf8\"\n")
 	}
@@ -204,12 +212,12 @@ func (c *codegen) emitCall(b *strings.Builder, s sInfo, callee, retID int) {
 
 func (c *codegen) emitTerminal(b *strings.Builder, sym ssym, nextID int) {
 	if sym.kind == symEps {
-		fmt.Fprintf(b, "\t\t\tp.record(%d, u.pos, i, i)\n", nextID)
+		fmt.Fprintf(b, "\t\t\tp.record(%d, u.pos, i, i, rc)\n", nextID)
 		fmt.Fprintf(b, "\t\t\tL = %d\n\t\t\tcontinue\n", nextID)
 		return
 	}
 	c.emitMatch(b, sym) // sets j or returns on failure
-	fmt.Fprintf(b, "\t\t\tp.record(%d, u.pos, i, j)\n", nextID)
+	fmt.Fprintf(b, "\t\t\tp.record(%d, u.pos, i, j, rc)\n", nextID)
 	fmt.Fprintf(b, "\t\t\ti = j\n\t\t\tL = %d\n\t\t\tcontinue\n", nextID)
 }
 
@@ -224,6 +232,10 @@ func (c *codegen) emitMatch(b *strings.Builder, s ssym) {
 		case StatRange:
 			lo := numvalToRune(v.Elems[0], v.Base)
 			hi := numvalToRune(v.Elems[1], v.Base)
+			if c.sg.mode == ModeBytes {
+				c.emitByteRange(b, lo, hi)
+				return
+			}
 			b.WriteString("\t\t\tif i >= len(p.input) {\n\t\t\t\treturn\n\t\t\t}\n")
 			b.WriteString("\t\t\trv, rs := utf8.DecodeRune(p.input[i:])\n")
 			b.WriteString("\t\t\tif rv == utf8.RuneError && rs == 1 {\n\t\t\t\treturn\n\t\t\t}\n")
@@ -239,15 +251,32 @@ func (c *codegen) emitMatch(b *strings.Builder, s ssym) {
 	}
 }
 
-func (c *codegen) emitCharVal(b *strings.Builder, v ElemCharVal) {
-	allASCII := true
+// emitByteRange emits the match of one byte of [lo,hi], for ModeBytes.
+func (c *codegen) emitByteRange(b *strings.Builder, lo, hi rune) {
+	hi = min(hi, 0xFF)
+	if lo > hi {
+		b.WriteString("\t\t\treturn // range matches no byte\n")
+		return
+	}
+	b.WriteString("\t\t\tif i >= len(p.input) {\n\t\t\t\treturn\n\t\t\t}\n")
+	fmt.Fprintf(b, "\t\t\tif p.input[i] < %d || p.input[i] > %d {\n\t\t\t\treturn\n\t\t\t}\n", lo, hi)
+	b.WriteString("\t\t\tj := i + 1\n")
+}
+
+// decodesCharVal reports whether the char-val v is matched rune by rune rather
+// than byte by byte: when it holds a non-ASCII rune, or a rune FoldUnicode
+// folds with a non-ASCII one, as "k" with the Kelvin sign.
+func (c *codegen) decodesCharVal(v ElemCharVal) bool {
 	for _, r := range v.Values {
-		if r > 0x7f {
-			allASCII = false
-			break
+		if r > 0x7f || (!v.Sensitive && !foldsAsASCII(r, c.sg.fold)) {
+			return true
 		}
 	}
-	if allASCII {
+	return false
+}
+
+func (c *codegen) emitCharVal(b *strings.Builder, v ElemCharVal) {
+	if !c.decodesCharVal(v) {
 		fmt.Fprintf(b, "\t\t\tif i+%d > len(p.input) {\n\t\t\t\treturn\n\t\t\t}\n", len(v.Values))
 		for off, r := range v.Values {
 			bb := byte(r)
@@ -267,9 +296,12 @@ func (c *codegen) emitCharVal(b *strings.Builder, v ElemCharVal) {
 		b.WriteString("\t\t\t\tif j >= len(p.input) {\n\t\t\t\t\treturn\n\t\t\t\t}\n")
 		b.WriteString("\t\t\t\trv, rs := utf8.DecodeRune(p.input[j:])\n")
 		b.WriteString("\t\t\t\tif rv == utf8.RuneError && rs == 1 {\n\t\t\t\t\treturn\n\t\t\t\t}\n")
-		if v.Sensitive {
+		switch {
+		case v.Sensitive:
 			fmt.Fprintf(b, "\t\t\t\tif rv != %d {\n\t\t\t\t\treturn\n\t\t\t\t}\n", r)
-		} else {
+		case c.sg.fold == FoldUnicode:
+			fmt.Fprintf(b, "\t\t\t\tif foldU(rv) != %d {\n\t\t\t\t\treturn\n\t\t\t\t}\n", foldKey(r))
+		default:
 			fmt.Fprintf(b, "\t\t\t\tif foldR(rv) != %d {\n\t\t\t\t\treturn\n\t\t\t\t}\n", foldRune(r))
 		}
 		b.WriteString("\t\t\t\tj += rs\n\t\t\t}\n")
@@ -277,16 +309,16 @@ func (c *codegen) emitCharVal(b *strings.Builder, v ElemCharVal) {
 }
 
 func (c *codegen) emitSeries(b *strings.Builder, v ElemNumVal) {
-	var sb strings.Builder
+	var bs []byte
 	for _, e := range v.Elems {
 		r := numvalToRune(e, v.Base)
-		if !utf8.ValidRune(r) {
-			b.WriteString("\t\t\treturn // series contains a non-Unicode value\n")
+		if !c.sg.mode.validChar(r) {
+			b.WriteString("\t\t\treturn // series contains a value that is no character\n")
 			return
 		}
-		sb.WriteRune(r)
+		bs = c.sg.mode.appendChar(bs, r)
 	}
-	idx := c.seriesVar([]byte(sb.String()))
+	idx := c.seriesVar(bs)
 	fmt.Fprintf(b, "\t\t\tif i+len(series%d) > len(p.input) || !bytesEqual(p.input[i:i+len(series%d)], series%d) {\n\t\t\t\treturn\n\t\t\t}\n", idx, idx, idx)
 	fmt.Fprintf(b, "\t\t\tj := i + len(series%d)\n", idx)
 }
@@ -340,7 +372,11 @@ func (c *codegen) emitMetadata(b *strings.Builder) {
 
 	b.WriteString("var qNTs = []qNT{\n")
 	for _, nt := range c.sg.nts {
-		fmt.Fprintf(b, "\t{isRule: %v, ruleName: %q, alts: ", nt.isRule, nt.ruleName)
+		fmt.Fprintf(b, "\t{isRule: %v, ruleName: %q, ", nt.isRule, nt.ruleName)
+		if nt.isRep {
+			fmt.Fprintf(b, "isRep: true, repMin: %d, repMax: %d, ", nt.repMin, nt.repMax)
+		}
+		b.WriteString("alts: ")
 		if len(nt.alts) == 0 {
 			b.WriteString("nil},\n")
 			continue
@@ -444,6 +480,21 @@ func foldRune(r rune) rune {
 	return r
 }
 
+// foldBoilerplate is the Unicode simple case folding of FoldUnicode grammars,
+// emitted only when a char-val uses it.
+const foldBoilerplate = `
+// foldU returns the smallest rune r folds with.
+func foldU(r rune) rune {
+	k := r
+	for s := unicode.SimpleFold(r); s != r; s = unicode.SimpleFold(s) {
+		if s < k {
+			k = s
+		}
+	}
+	return k
+}
+`
+
 // headerBoilerplate: runtime types, GSS/BSR engine state. Grammar-independent.
 // (Imports are emitted separately so unicode/utf8 is only pulled in when a
 // terminal actually decodes runes.) The generated process()/seed() and metadata
@@ -474,12 +525,18 @@ type qSym struct{ kind, nt int }
 type qNT struct {
 	isRule   bool
 	ruleName string
+	isRep    bool
+	repMin   int
+	repMax   int
 	alts     [][]qSym
 }
 
+// bsrElem is a BSR element; rc is the repetition count of its frame, which
+// tells apart the derivations of a counted repetition (0 elsewhere).
 type bsrElem struct {
 	sl      int
 	l, k, r int
+	rc      int
 }
 
 type gssKey struct {
@@ -559,14 +616,14 @@ func (p *parser) gssNodeFor(ret, pos, rc int) *gnode {
 
 // record inserts a BSR element, keeping only intermediate (prefix length >= 2)
 // or complete (dot == len) extents.
-func (p *parser) record(L, l, k, r int) {
+func (p *parser) record(L, l, k, r, rc int) {
 	si := slotInfo[L]
 	if si.dot >= 2 || si.dot == si.plen {
 		if MaxElements > 0 && len(p.set) >= MaxElements {
 			p.aborted = true
 			return
 		}
-		p.set[bsrElem{L, l, k, r}] = true
+		p.set[bsrElem{L, l, k, r, rc}] = true
 	}
 }
 
@@ -576,7 +633,7 @@ func (p *parser) create(ret int, u *gnode, i, rc int) *gnode {
 		v.edgeSet[u] = true
 		v.edges = append(v.edges, u)
 		for j := range p.popped[v] {
-			p.record(ret, u.pos, i, j)
+			p.record(ret, u.pos, i, j, rc)
 			p.add(ret, u, j, rc)
 		}
 	}
@@ -592,7 +649,7 @@ func (p *parser) pop(u *gnode, j int) {
 	}
 	p.popped[u][j] = true
 	for _, v := range u.edges {
-		p.record(u.ret, v.pos, u.pos, j)
+		p.record(u.ret, v.pos, u.pos, j, u.rc)
 		p.add(u.ret, v, j, u.rc)
 	}
 }
@@ -612,12 +669,28 @@ type ParseTree struct {
 type bsrKey struct {
 	sl   int
 	l, r int
+	rc   int
 }
 
 type nodeID struct {
 	sl   int // slot id for intermediate nodes; -1 for symbol nodes
 	nt   int // nonterminal id for symbol nodes
 	l, r int
+	rc   int
+}
+
+// childRC returns the repetition count of the frame of s called from slot sl at
+// count rc: a repetition's self-reference continues its chain, other calls
+// start at 0.
+func childRC(sl, rc int, s qSym) int {
+	nt := qNTs[slotInfo[sl].nt]
+	if s.kind != kNT || s.nt != slotInfo[sl].nt || !nt.isRep {
+		return 0
+	}
+	if rc+1 > nt.repMin && nt.repMax == inf {
+		return nt.repMin
+	}
+	return rc + 1
 }
 
 // Result holds the BSR set for a parse and answers queries over it.
@@ -634,7 +707,7 @@ func (r *Result) buildIndex() {
 	}
 	r.index = make(map[bsrKey][]int, len(r.set))
 	for e := range r.set {
-		bk := bsrKey{e.sl, e.l, e.r}
+		bk := bsrKey{e.sl, e.l, e.r, e.rc}
 		r.index[bk] = append(r.index[bk], e.k)
 	}
 }
@@ -643,7 +716,7 @@ func (r *Result) buildIndex() {
 func (r *Result) Valid() bool {
 	r.buildIndex()
 	for _, sl := range ntComplete[gStart] {
-		if len(r.index[bsrKey{sl, 0, r.n}]) > 0 {
+		if len(r.index[bsrKey{sl, 0, r.n, 0}]) > 0 {
 			return true
 		}
 	}
@@ -662,34 +735,34 @@ func (r *Result) NumTrees() *big.Int {
 	onStack := map[nodeID]bool{}
 	infinite := false
 
-	var symWays func(s qSym, a, b int) *big.Int
-	var countSym func(nt, l, rr int) *big.Int
-	var countInter func(sl, l, rr int) *big.Int
-	var countElem func(sl, l, k, rr int) *big.Int
+	var symWays func(sl int, s qSym, a, b, rc int) *big.Int
+	var countSym func(nt, l, rr, rc int) *big.Int
+	var countInter func(sl, l, rr, rc int) *big.Int
+	var countElem func(sl, l, k, rr, rc int) *big.Int
 
-	symWays = func(s qSym, a, b int) *big.Int {
+	symWays = func(sl int, s qSym, a, b, rc int) *big.Int {
 		if s.kind == kNT {
-			return countSym(s.nt, a, b)
+			return countSym(s.nt, a, b, childRC(sl, rc, s))
 		}
 		return big.NewInt(1)
 	}
-	countElem = func(sl, l, k, rr int) *big.Int {
+	countElem = func(sl, l, k, rr, rc int) *big.Int {
 		si := slotInfo[sl]
 		prod := qNTs[si.nt].alts[si.alt]
-		last := symWays(prod[si.dot-1], k, rr)
+		last := symWays(sl, prod[si.dot-1], k, rr, rc)
 		var pre *big.Int
 		switch {
 		case si.dot == 1:
 			pre = big.NewInt(1)
 		case si.dot == 2:
-			pre = symWays(prod[0], l, k)
+			pre = symWays(sl, prod[0], l, k, rc)
 		default:
-			pre = countInter(sl-1, l, k)
+			pre = countInter(sl-1, l, k, rc)
 		}
 		return new(big.Int).Mul(pre, last)
 	}
-	countInter = func(sl, l, rr int) *big.Int {
-		id := nodeID{sl: sl, nt: -1, l: l, r: rr}
+	countInter = func(sl, l, rr, rc int) *big.Int {
+		id := nodeID{sl: sl, nt: -1, l: l, r: rr, rc: rc}
 		if v, ok := memo[id]; ok {
 			return v
 		}
@@ -699,15 +772,15 @@ func (r *Result) NumTrees() *big.Int {
 		}
 		onStack[id] = true
 		total := big.NewInt(0)
-		for _, k := range r.index[bsrKey{sl, l, rr}] {
-			total.Add(total, countElem(sl, l, k, rr))
+		for _, k := range r.index[bsrKey{sl, l, rr, rc}] {
+			total.Add(total, countElem(sl, l, k, rr, rc))
 		}
 		onStack[id] = false
 		memo[id] = total
 		return total
 	}
-	countSym = func(nt, l, rr int) *big.Int {
-		id := nodeID{sl: -1, nt: nt, l: l, r: rr}
+	countSym = func(nt, l, rr, rc int) *big.Int {
+		id := nodeID{sl: -1, nt: nt, l: l, r: rr, rc: rc}
 		if v, ok := memo[id]; ok {
 			return v
 		}
@@ -718,15 +791,15 @@ func (r *Result) NumTrees() *big.Int {
 		onStack[id] = true
 		total := big.NewInt(0)
 		for _, sl := range ntComplete[nt] {
-			for _, k := range r.index[bsrKey{sl, l, rr}] {
-				total.Add(total, countElem(sl, l, k, rr))
+			for _, k := range r.index[bsrKey{sl, l, rr, rc}] {
+				total.Add(total, countElem(sl, l, k, rr, rc))
 			}
 		}
 		onStack[id] = false
 		memo[id] = total
 		return total
 	}
-	res := countSym(gStart, 0, r.n)
+	res := countSym(gStart, 0, r.n, 0)
 	if infinite {
 		return big.NewInt(-1)
 	}
@@ -739,71 +812,71 @@ func (r *Result) Ambiguous() bool {
 		return false
 	}
 	visited := map[nodeID]bool{}
-	var symAmb func(nt, l, rr int) bool
-	var interAmb func(sl, l, rr int) bool
-	var elemAmb func(sl, l, k, rr int) bool
-	var symOf func(s qSym, a, b int) bool
-	symOf = func(s qSym, a, b int) bool {
+	var symAmb func(nt, l, rr, rc int) bool
+	var interAmb func(sl, l, rr, rc int) bool
+	var elemAmb func(sl, l, k, rr, rc int) bool
+	var symOf func(sl int, s qSym, a, b, rc int) bool
+	symOf = func(sl int, s qSym, a, b, rc int) bool {
 		if s.kind == kNT {
-			return symAmb(s.nt, a, b)
+			return symAmb(s.nt, a, b, childRC(sl, rc, s))
 		}
 		return false
 	}
-	elemAmb = func(sl, l, k, rr int) bool {
+	elemAmb = func(sl, l, k, rr, rc int) bool {
 		si := slotInfo[sl]
 		prod := qNTs[si.nt].alts[si.alt]
-		if symOf(prod[si.dot-1], k, rr) {
+		if symOf(sl, prod[si.dot-1], k, rr, rc) {
 			return true
 		}
 		switch {
 		case si.dot == 1:
 			return false
 		case si.dot == 2:
-			return symOf(prod[0], l, k)
+			return symOf(sl, prod[0], l, k, rc)
 		default:
-			return interAmb(sl-1, l, k)
+			return interAmb(sl-1, l, k, rc)
 		}
 	}
-	interAmb = func(sl, l, rr int) bool {
-		id := nodeID{sl: sl, nt: -1, l: l, r: rr}
+	interAmb = func(sl, l, rr, rc int) bool {
+		id := nodeID{sl: sl, nt: -1, l: l, r: rr, rc: rc}
 		if visited[id] {
 			return false
 		}
 		visited[id] = true
-		ks := r.index[bsrKey{sl, l, rr}]
+		ks := r.index[bsrKey{sl, l, rr, rc}]
 		if len(ks) > 1 {
 			return true
 		}
 		for _, k := range ks {
-			if elemAmb(sl, l, k, rr) {
+			if elemAmb(sl, l, k, rr, rc) {
 				return true
 			}
 		}
 		return false
 	}
-	symAmb = func(nt, l, rr int) bool {
-		id := nodeID{sl: -1, nt: nt, l: l, r: rr}
+	symAmb = func(nt, l, rr, rc int) bool {
+		id := nodeID{sl: -1, nt: nt, l: l, r: rr, rc: rc}
 		if visited[id] {
 			return false
 		}
 		visited[id] = true
 		packs := 0
 		for _, sl := range ntComplete[nt] {
-			packs += len(r.index[bsrKey{sl, l, rr}])
+			packs += len(r.index[bsrKey{sl, l, rr, rc}])
 		}
 		if packs > 1 {
 			return true
 		}
 		for _, sl := range ntComplete[nt] {
-			for _, k := range r.index[bsrKey{sl, l, rr}] {
-				if elemAmb(sl, l, k, rr) {
+			for _, k := range r.index[bsrKey{sl, l, rr, rc}] {
+				if elemAmb(sl, l, k, rr, rc) {
 					return true
 				}
 			}
 		}
 		return false
 	}
-	return symAmb(gStart, 0, r.n)
+	return symAmb(gStart, 0, r.n, 0)
 }
 
 // Tree extracts a single parse tree (first element at each extent), or nil if
@@ -813,31 +886,31 @@ func (r *Result) Tree() *ParseTree {
 		return nil
 	}
 	for _, sl := range ntComplete[gStart] {
-		if ks := r.index[bsrKey{sl, 0, r.n}]; len(ks) > 0 {
+		if ks := r.index[bsrKey{sl, 0, r.n, 0}]; len(ks) > 0 {
 			var kids []*ParseTree
-			r.collectElem(sl, 0, ks[0], r.n, &kids, map[nodeID]bool{})
+			r.collectElem(sl, 0, ks[0], r.n, 0, &kids, map[nodeID]bool{})
 			return &ParseTree{Rule: qNTs[gStart].ruleName, Start: 0, End: r.n, Children: kids}
 		}
 	}
 	return nil
 }
 
-func (r *Result) collectElem(sl, l, k, rr int, out *[]*ParseTree, visited map[nodeID]bool) {
+func (r *Result) collectElem(sl, l, k, rr, rc int, out *[]*ParseTree, visited map[nodeID]bool) {
 	si := slotInfo[sl]
 	prod := qNTs[si.nt].alts[si.alt]
 	switch {
 	case si.dot == 1:
 	case si.dot == 2:
-		r.collectChild(prod[0], l, k, out, visited)
+		r.collectChild(prod[0], l, k, childRC(sl, rc, prod[0]), out, visited)
 	default:
-		if ks := r.index[bsrKey{sl - 1, l, k}]; len(ks) > 0 {
-			r.collectElem(sl-1, l, ks[0], k, out, visited)
+		if ks := r.index[bsrKey{sl - 1, l, k, rc}]; len(ks) > 0 {
+			r.collectElem(sl-1, l, ks[0], k, rc, out, visited)
 		}
 	}
-	r.collectChild(prod[si.dot-1], k, rr, out, visited)
+	r.collectChild(prod[si.dot-1], k, rr, childRC(sl, rc, prod[si.dot-1]), out, visited)
 }
 
-func (r *Result) collectChild(s qSym, a, b int, out *[]*ParseTree, visited map[nodeID]bool) {
+func (r *Result) collectChild(s qSym, a, b, rc int, out *[]*ParseTree, visited map[nodeID]bool) {
 	if s.kind != kNT {
 		if s.kind == kEps {
 			return
@@ -845,19 +918,19 @@ func (r *Result) collectChild(s qSym, a, b int, out *[]*ParseTree, visited map[n
 		*out = append(*out, &ParseTree{Start: a, End: b})
 		return
 	}
-	id := nodeID{sl: -1, nt: s.nt, l: a, r: b}
+	id := nodeID{sl: -1, nt: s.nt, l: a, r: b, rc: rc}
 	if visited[id] {
 		return
 	}
 	visited[id] = true
 	for _, sl := range ntComplete[s.nt] {
-		if ks := r.index[bsrKey{sl, a, b}]; len(ks) > 0 {
+		if ks := r.index[bsrKey{sl, a, b, rc}]; len(ks) > 0 {
 			if qNTs[s.nt].isRule {
 				var kids []*ParseTree
-				r.collectElem(sl, a, ks[0], b, &kids, visited)
+				r.collectElem(sl, a, ks[0], b, rc, &kids, visited)
 				*out = append(*out, &ParseTree{Rule: qNTs[s.nt].ruleName, Start: a, End: b, Children: kids})
 			} else {
-				r.collectElem(sl, a, ks[0], b, out, visited)
+				r.collectElem(sl, a, ks[0], b, rc, out, visited)
 			}
 			visited[id] = false
 			return
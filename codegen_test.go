@@ -157,3 +157,43 @@ func Test_F_GeneratedABNFParser_ParsesABNFGrammar(t *testing.T) {
 			"a malformed grammar must be rejected; got %q", got)
 	}
 }
+
+// The generated parser keys the elements of a counted repetition by count, as
+// ParseForest does: over "aaaa", "a"+"a" ends a 2*3 repetition started by "aa"
+// but not one started by "a"+"a".
+func Test_F_GeneratedParser_RepetitionCount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping functional codegen test in -short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not found in PATH; skipping functional codegen test")
+	}
+
+	g := mustGrammar("a = 2*3(\"a\" / \"aa\")\r\n")
+	src, err := GenerateGoParser(g, "a", "main")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	mustWrite := func(name string, content []byte) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), content, 0o644))
+	}
+	mustWrite("parser.go", src)
+	mustWrite("main.go", []byte(genABNFMain))
+	mustWrite("go.mod", []byte("module genrep\n\ngo 1.18\n"))
+
+	for _, in := range []string{"a", "aa", "aaa", "aaaa", "aaaaaa", "aaaaaaa"} {
+		mustWrite("input", []byte(in))
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		cmd := exec.CommandContext(ctx, goBin, "run", ".", "input")
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		cancel()
+		require.NoErrorf(t, err, "go run . on %q failed:\n%s", in, out)
+
+		f, err := ParseForest([]byte(in), g, "a")
+		require.NoError(t, err)
+		want := fmt.Sprintf("%v\t%s\t%v", f.Valid(), f.NumTrees().String(), f.Ambiguous())
+		assert.Equalf(t, want, strings.TrimSpace(string(out)), "generated parser on %q", in)
+	}
+}
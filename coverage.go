@@ -0,0 +1,407 @@
+package goabnf
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// coverage.go measures how much of a grammar a corpus of inputs exercises. It
+// is the converse of the transition graph's CoverageCompact: rather than
+// emitting inputs covering the grammar, it takes inputs from elsewhere and
+// reports what they leave uncovered, through any grammar the parser accepts,
+// recursive ones included.
+//
+// A parse tree keeps the alternative of each rule node but not the repetitions
+// nor the num-vals its children come from: they are read off the match of the
+// alternative against the children (see rematch.go).
+
+// CoverageReport is what a corpus of inputs covers of the rules reachable from
+// a rule. It encodes to JSON as is, and String lists it as an annotated
+// grammar.
+type CoverageReport struct {
+	// Rule is the rule the inputs were parsed as.
+	Rule string `json:"rule"`
+	// Inputs is the number of inputs, and Invalid the number of those that
+	// are not derived by Rule, hence cover nothing.
+	Inputs  int `json:"inputs"`
+	Invalid int `json:"invalid"`
+	// Rules are Rule then the rules it depends on, core rules included, in
+	// the order they are reached.
+	Rules []*RuleCoverage `json:"rules"`
+}
+
+// RuleCoverage is the coverage of a rule.
+type RuleCoverage struct {
+	Rule string `json:"rule"`
+	// Hits is the number of nodes of the rule in the parse trees.
+	Hits         int                    `json:"hits"`
+	Alternatives []*AlternativeCoverage `json:"alternatives"`
+	// Repetitions are the repetitions and options of the rule, nested ones
+	// included, in the order they are written. Elements without a repetition
+	// count are left out.
+	Repetitions []*RepetitionCoverage `json:"repetitions,omitempty"`
+	// Ranges are the num-val ranges of the rule, in the order they are
+	// written.
+	Ranges []*RangeCoverage `json:"ranges,omitempty"`
+}
+
+// AlternativeCoverage is the coverage of an alternative of a rule.
+type AlternativeCoverage struct {
+	// Index is the 0-based index of the alternative, as ParseTree.Alternative.
+	Index int    `json:"index"`
+	Text  string `json:"text"`
+	Hits  int    `json:"hits"`
+}
+
+// RepetitionCoverage is the coverage of a repetition, or of an option as a
+// repetition of 0 to 1 occurrence.
+type RepetitionCoverage struct {
+	Text string `json:"text"`
+	// Min and Max are the bounds of the repetition, Max -1 when unbounded.
+	Min int `json:"min"`
+	Max int `json:"max"`
+	// Seen are the counts of occurrences that were hit, in increasing order.
+	Seen []int `json:"seen"`
+	// Missing are the counts among Min, Min+1 and Max that were not hit.
+	Missing []int `json:"missing"`
+}
+
+// RangeCoverage is the coverage of the boundaries of a num-val range.
+type RangeCoverage struct {
+	Text string     `json:"text"`
+	Low  RangeBound `json:"low"`
+	High RangeBound `json:"high"`
+	// Hits is the number of characters matched by the range.
+	Hits int `json:"hits"`
+}
+
+// RangeBound is a boundary of a num-val range, and whether a character
+// matched it.
+type RangeBound struct {
+	Value int  `json:"value"`
+	Hit   bool `json:"hit"`
+}
+
+// Coverage parses each input as rule and reports which alternatives,
+// repetition counts (minimum, minimum plus one and maximum) and num-val range
+// boundaries of the rules reachable from rule the valid ones exercise. An
+// ambiguous input covers the tree Forest.Tree extracts.
+func Coverage(g *Grammar, rule string, inputs [][]byte) (*CoverageReport, error) {
+	root := GetRule(rule, g.Rulemap)
+	if root == nil {
+		return nil, &ErrRuleNotFound{Rulename: rule}
+	}
+	cv := &coverage{
+		g:      g,
+		report: &CoverageReport{Rule: root.Name, Inputs: len(inputs)},
+		rules:  map[string]*RuleCoverage{},
+		reps:   map[*Repetition]*repCounts{},
+		opts:   map[*Repetition]*repCounts{},
+		ranges: map[*Repetition]*RangeCoverage{},
+	}
+	cv.sites(root)
+
+	for _, input := range inputs {
+		f, err := ParseForest(input, g, rule)
+		if err != nil {
+			return nil, err
+		}
+		if !f.Valid() {
+			cv.report.Invalid++
+			continue
+		}
+		cv.input = input
+		cv.node(f.Tree())
+	}
+
+	for _, c := range cv.counts {
+		c.finish()
+	}
+	return cv.report, nil
+}
+
+type coverage struct {
+	g      *Grammar
+	report *CoverageReport
+	rules  map[string]*RuleCoverage // canon(rule) -> coverage
+	reps   map[*Repetition]*repCounts
+	opts   map[*Repetition]*repCounts
+	ranges map[*Repetition]*RangeCoverage
+	counts []*repCounts // reps and opts, to finish
+
+	input []byte
+}
+
+// repCounts tallies the counts of a repetition until they are written to its
+// RepetitionCoverage.
+type repCounts struct {
+	cov  *RepetitionCoverage
+	seen map[int]bool
+}
+
+func (c *repCounts) finish() {
+	for n := range c.seen {
+		c.cov.Seen = append(c.cov.Seen, n)
+	}
+	slices.Sort(c.cov.Seen)
+	for _, n := range repTargets(c.cov.Min, c.cov.Max) {
+		if !c.seen[n] {
+			c.cov.Missing = append(c.cov.Missing, n)
+		}
+	}
+}
+
+// repTargets returns the counts a repetition of min to max occurrences should
+// be exercised with: min, min+1 and max.
+func repTargets(min, max int) []int {
+	out := []int{min}
+	if max == inf || min+1 <= max {
+		out = append(out, min+1)
+	}
+	if max != inf && max > min+1 {
+		out = append(out, max)
+	}
+	return out
+}
+
+// sites lists the rules reachable from root breadth-first, with their
+// alternatives, repetitions and ranges.
+func (cv *coverage) sites(root *Rule) {
+	queue := []*Rule{root}
+	cv.rules[canon(root.Name)] = &RuleCoverage{}
+	for len(queue) > 0 {
+		r := queue[0]
+		queue = queue[1:]
+		rc := cv.rules[canon(r.Name)]
+		rc.Rule = r.Name
+		rc.Alternatives = []*AlternativeCoverage{}
+		for i, cc := range r.Alternation.Concatenations {
+			rc.Alternatives = append(rc.Alternatives, &AlternativeCoverage{Index: i, Text: cc.String()})
+		}
+		cv.alternation(rc, r.Alternation)
+		cv.report.Rules = append(cv.report.Rules, rc)
+
+		for _, dep := range getDependencies(r.Alternation) {
+			if _, ok := cv.rules[canon(dep)]; ok {
+				continue
+			}
+			if d := GetRule(dep, cv.g.Rulemap); d != nil {
+				cv.rules[canon(dep)] = &RuleCoverage{}
+				queue = append(queue, d)
+			}
+		}
+	}
+}
+
+func (cv *coverage) alternation(rc *RuleCoverage, a Alternation) {
+	for _, cc := range a.Concatenations {
+		for i := range cc.Repetitions {
+			r := &cc.Repetitions[i]
+			if r.Min != 1 || r.Max != 1 {
+				cv.reps[r] = cv.repetition(rc, r.String(), r.Min, r.Max)
+			}
+			switch v := r.Element.(type) {
+			case ElemGroup:
+				cv.alternation(rc, v.Alternation)
+			case ElemOption:
+				cv.opts[r] = cv.repetition(rc, v.String(), 0, 1)
+				cv.alternation(rc, v.Alternation)
+			case ElemNumVal:
+				if v.Status == StatRange {
+					rg := &RangeCoverage{
+						Text: v.String(),
+						Low:  RangeBound{Value: int(numvalToInt32(v.Elems[0], v.Base))},
+						High: RangeBound{Value: int(numvalToInt32(v.Elems[1], v.Base))},
+					}
+					cv.ranges[r] = rg
+					rc.Ranges = append(rc.Ranges, rg)
+				}
+			}
+		}
+	}
+}
+
+func (cv *coverage) repetition(rc *RuleCoverage, text string, min, max int) *repCounts {
+	c := &repCounts{
+		cov:  &RepetitionCoverage{Text: text, Min: min, Max: max, Seen: []int{}, Missing: []int{}},
+		seen: map[int]bool{},
+	}
+	rc.Repetitions = append(rc.Repetitions, c.cov)
+	cv.counts = append(cv.counts, c)
+	return c
+}
+
+// node records the rule node t, then its rule descendants.
+func (cv *coverage) node(t *ParseTree) {
+	rc := cv.rules[canon(t.Rule)]
+	if rc == nil {
+		return
+	}
+	rc.Hits++
+	r := GetRule(t.Rule, cv.g.Rulemap)
+	if t.Alternative >= 0 && t.Alternative < len(r.Alternation.Concatenations) {
+		rc.Alternatives[t.Alternative].Hits++
+		// A node cut short on a cycle has no children to match: it only
+		// covers its alternative.
+		if events, ok := rematch(cv.g, cv.input, t); ok {
+			cv.record(t, events)
+		}
+	}
+	for _, c := range t.Children {
+		if c.Rule != "" {
+			cv.node(c)
+		}
+	}
+}
+
+func (cv *coverage) record(t *ParseTree, events []rematchEvent) {
+	for _, e := range events {
+		switch e.kind {
+		case rematchStop:
+			if c, ok := cv.reps[e.rep]; ok {
+				c.seen[e.n] = true
+			}
+		case rematchOption:
+			if c, ok := cv.opts[e.rep]; ok {
+				c.seen[e.n] = true
+			}
+		case rematchTerm:
+			if rg, ok := cv.ranges[e.rep]; ok {
+				kid := t.Children[e.kid]
+				c, _ := cv.g.Mode.decode(cv.input[kid.Start:kid.End])
+				rg.Hits++
+				rg.Low.Hit = rg.Low.Hit || int(c) == rg.Low.Value
+				rg.High.Hit = rg.High.Hit || int(c) == rg.High.Value
+			}
+		}
+	}
+}
+
+// Targets returns how many of the coverage targets of the report were hit,
+// out of how many: the alternatives, the counts among the minimum, minimum
+// plus one and maximum of each repetition, and the boundaries of each range.
+func (r *CoverageReport) Targets() (hit, total int) {
+	for _, rc := range r.Rules {
+		for _, a := range rc.Alternatives {
+			total++
+			if a.Hits > 0 {
+				hit++
+			}
+		}
+		for _, rep := range rc.Repetitions {
+			n := len(repTargets(rep.Min, rep.Max))
+			total += n
+			hit += n - len(rep.Missing)
+		}
+		for _, rg := range rc.Ranges {
+			for _, b := range rg.bounds() {
+				total++
+				if b.Hit {
+					hit++
+				}
+			}
+		}
+	}
+	return hit, total
+}
+
+// bounds returns the boundaries of the range, once when it is one value.
+func (rg *RangeCoverage) bounds() []RangeBound {
+	if rg.Low.Value == rg.High.Value {
+		return []RangeBound{{Value: rg.Low.Value, Hit: rg.Low.Hit || rg.High.Hit}}
+	}
+	return []RangeBound{rg.Low, rg.High}
+}
+
+// String lists the rules of the report as a grammar, each annotated with
+// comments of what the inputs covered of it:
+//
+//	; uri: 4 inputs, 1 invalid, 19/27 targets hit
+//	uri = scheme ":" hier
+//	    ; 3 hits
+//	...
+//	hier = "//" authority path / path
+//	    ; 3 hits
+//	    ; alternative 0 "//" authority path: 2 hits
+//	    ; alternative 1 path: 1 hit
+//	ALPHA = %x41-5A / %x61-7A
+//	    ; 30 hits
+//	    ; alternative 0 %x41-5A: 1 hit
+//	    ; alternative 1 %x61-7A: 29 hits
+//	    ; %x41-5A: bounds %x41 missing, %x5A hit
+//	    ; %x61-7A: bounds %x61 hit, %x7A missing
+//	authority = [userinfo "@"] host
+//	    ; 2 hits
+//	    ; [userinfo "@"]: seen 0 1
+//	...
+//	host = 1*(ALPHA / ".")
+//	    ; 2 hits
+//	    ; 1*(ALPHA / "."): seen 5 11, missing 1 2
+func (r *CoverageReport) String() string {
+	var b strings.Builder
+	hit, total := r.Targets()
+	fmt.Fprintf(&b, "; %s: %d inputs, %d invalid, %d/%d targets hit\n", r.Rule, r.Inputs, r.Invalid, hit, total)
+	for _, rc := range r.Rules {
+		texts := make([]string, len(rc.Alternatives))
+		for i, a := range rc.Alternatives {
+			texts[i] = a.Text
+		}
+		fmt.Fprintf(&b, "%s = %s\n", rc.Rule, strings.Join(texts, " / "))
+		if rc.Hits == 0 {
+			b.WriteString("    ; not covered\n")
+			continue
+		}
+		fmt.Fprintf(&b, "    ; %s\n", hits(rc.Hits))
+		if len(rc.Alternatives) > 1 {
+			for _, a := range rc.Alternatives {
+				fmt.Fprintf(&b, "    ; alternative %d %s: %s\n", a.Index, a.Text, hits(a.Hits))
+			}
+		}
+		for _, rep := range rc.Repetitions {
+			fmt.Fprintf(&b, "    ; %s: %s\n", rep.Text, rep.summary())
+		}
+		for _, rg := range rc.Ranges {
+			var parts []string
+			for _, bd := range rg.bounds() {
+				state := "hit"
+				if !bd.Hit {
+					state = "missing"
+				}
+				parts = append(parts, fmt.Sprintf("%%x%X %s", bd.Value, state))
+			}
+			fmt.Fprintf(&b, "    ; %s: bounds %s\n", rg.Text, strings.Join(parts, ", "))
+		}
+	}
+	return b.String()
+}
+
+func (rep *RepetitionCoverage) summary() string {
+	if len(rep.Seen) == 0 {
+		return "not covered"
+	}
+	s := "seen " + joinInts(rep.Seen)
+	if len(rep.Missing) > 0 {
+		s += ", missing " + joinInts(rep.Missing)
+	}
+	return s
+}
+
+func hits(n int) string {
+	switch n {
+	case 0:
+		return "not covered"
+	case 1:
+		return "1 hit"
+	}
+	return strconv.Itoa(n) + " hits"
+}
+
+func joinInts(ns []int) string {
+	s := make([]string, len(ns))
+	for i, n := range ns {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,155 @@
+package goabnf
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ruleCoverage(t *testing.T, r *CoverageReport, rule string) *RuleCoverage {
+	t.Helper()
+	for _, rc := range r.Rules {
+		if rc.Rule == rule {
+			return rc
+		}
+	}
+	require.Failf(t, "rule not reported", "%s", rule)
+	return nil
+}
+
+func Test_U_Coverage(t *testing.T) {
+	g := mustGrammar(uriLikeAbnf)
+	r, err := Coverage(g, "uri", [][]byte{
+		[]byte("http://me@example.org/a/b"),
+		[]byte("file:/x"),
+		[]byte("nope"),
+		[]byte("ftp://Z.org"),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, r.Inputs)
+	assert.Equal(t, 1, r.Invalid)
+	var names []string
+	for _, rc := range r.Rules {
+		names = append(names, rc.Rule)
+	}
+	assert.Equal(t, []string{"uri", "scheme", "hier", "ALPHA", "authority", "path", "userinfo", "host", "segment"}, names)
+
+	hier := ruleCoverage(t, r, "hier")
+	assert.Equal(t, 3, hier.Hits)
+	assert.Equal(t, []*AlternativeCoverage{
+		{Index: 0, Text: `"//" authority path`, Hits: 2},
+		{Index: 1, Text: "path", Hits: 1},
+	}, hier.Alternatives)
+
+	assert.Equal(t, []*RepetitionCoverage{
+		{Text: `[userinfo "@"]`, Min: 0, Max: 1, Seen: []int{0, 1}, Missing: []int{}},
+	}, ruleCoverage(t, r, "authority").Repetitions)
+	assert.Equal(t, []*RepetitionCoverage{
+		{Text: `*("/" segment)`, Min: 0, Max: -1, Seen: []int{0, 1, 2}, Missing: []int{}},
+	}, ruleCoverage(t, r, "path").Repetitions)
+	assert.Equal(t, []*RepetitionCoverage{
+		{Text: `1*(ALPHA / ".")`, Min: 1, Max: -1, Seen: []int{5, 11}, Missing: []int{1, 2}},
+	}, ruleCoverage(t, r, "host").Repetitions)
+
+	assert.Equal(t, []*RangeCoverage{
+		{Text: "%x41-5A", Low: RangeBound{Value: 0x41}, High: RangeBound{Value: 0x5A, Hit: true}, Hits: 1},
+		{Text: "%x61-7A", Low: RangeBound{Value: 0x61, Hit: true}, High: RangeBound{Value: 0x7A}, Hits: 29},
+	}, ruleCoverage(t, r, "ALPHA").Ranges)
+
+	hit, total := r.Targets()
+	assert.Equal(t, 19, hit)
+	assert.Equal(t, 27, total)
+
+	// JSON round-trips.
+	b, err := json.Marshal(r)
+	require.NoError(t, err)
+	var back CoverageReport
+	require.NoError(t, json.Unmarshal(b, &back))
+	assert.Equal(t, r, &back)
+
+	listing := r.String()
+	assert.Contains(t, listing, "; uri: 4 inputs, 1 invalid, 19/27 targets hit\n")
+	assert.Contains(t, listing, "hier = \"//\" authority path / path\n    ; 3 hits\n    ; alternative 0 \"//\" authority path: 2 hits\n    ; alternative 1 path: 1 hit\n")
+	assert.Contains(t, listing, "    ; %x41-5A: bounds %x41 missing, %x5A hit\n")
+	assert.Contains(t, listing, "    ; 1*(ALPHA / \".\"): seen 5 11, missing 1 2\n")
+}
+
+func Test_U_Coverage_Recursive(t *testing.T) {
+	g := mustGrammar("a = \"(\" [a] \")\" / 2*3%x30-39\r\n")
+	r, err := Coverage(g, "a", [][]byte{[]byte("()"), []byte("((09))"), []byte("((()))")})
+	require.NoError(t, err)
+
+	a := ruleCoverage(t, r, "a")
+	assert.Equal(t, 7, a.Hits)
+	assert.Equal(t, 6, a.Alternatives[0].Hits)
+	assert.Equal(t, 1, a.Alternatives[1].Hits)
+	assert.Equal(t, []*RepetitionCoverage{
+		{Text: "[a]", Min: 0, Max: 1, Seen: []int{0, 1}, Missing: []int{}},
+		{Text: "2*3%x30-39", Min: 2, Max: 3, Seen: []int{2}, Missing: []int{3}},
+	}, a.Repetitions)
+	assert.Equal(t, []*RangeCoverage{
+		{Text: "%x30-39", Low: RangeBound{Value: 0x30, Hit: true}, High: RangeBound{Value: 0x39, Hit: true}, Hits: 2},
+	}, a.Ranges)
+}
+
+func Test_U_Coverage_GreedySplit(t *testing.T) {
+	// The tree does not tell which repetition matched which "x": the first
+	// one is credited with as many as it can take.
+	g := mustGrammar("a = *\"x\" *\"x\"\r\n")
+	r, err := Coverage(g, "a", [][]byte{[]byte("xx")})
+	require.NoError(t, err)
+
+	assert.Equal(t, []*RepetitionCoverage{
+		{Text: `*"x"`, Min: 0, Max: -1, Seen: []int{2}, Missing: []int{0, 1}},
+		{Text: `*"x"`, Min: 0, Max: -1, Seen: []int{0}, Missing: []int{1}},
+	}, ruleCoverage(t, r, "a").Repetitions)
+}
+
+func Test_U_Coverage_NotCovered(t *testing.T) {
+	g := mustGrammar("a = b / c\r\nb = \"b\"\r\nc = \"c\"\r\n")
+	r, err := Coverage(g, "a", [][]byte{[]byte("b"), []byte("x")})
+	require.NoError(t, err)
+
+	assert.Equal(t, "; a: 2 inputs, 1 invalid, 2/4 targets hit\n"+
+		"a = b / c\n"+
+		"    ; 1 hit\n"+
+		"    ; alternative 0 b: 1 hit\n"+
+		"    ; alternative 1 c: not covered\n"+
+		"b = \"b\"\n"+
+		"    ; 1 hit\n"+
+		"c = \"c\"\n"+
+		"    ; not covered\n", r.String())
+}
+
+// The CoverageCompact set of the transition graph covers every alternative and
+// range boundary.
+func Test_U_Coverage_CoverageCompact(t *testing.T) {
+	src := "s = x / y \"-\" z\r\nx = %x30-39\r\ny = \"k\" / %x41-43\r\nz = [\"q\"] 1*2%x61-62\r\n"
+	graph := tg(t, src, WithDeflateRules(true))
+	var corpus [][]byte
+	r := graph.Reader(WithCoverageMode(CoverageCompact))
+	for r.Next() {
+		corpus = append(corpus, r.Scan())
+	}
+
+	report, err := Coverage(mustGrammar(src), "s", corpus)
+	require.NoError(t, err)
+	assert.Zero(t, report.Invalid)
+	for _, rc := range report.Rules {
+		for _, a := range rc.Alternatives {
+			assert.NotZerof(t, a.Hits, "%s alternative %d", rc.Rule, a.Index)
+		}
+		for _, rg := range rc.Ranges {
+			assert.Truef(t, rg.Low.Hit && rg.High.Hit, "%s %s", rc.Rule, rg.Text)
+		}
+	}
+}
+
+func Test_U_Coverage_Errors(t *testing.T) {
+	_, err := Coverage(mustGrammar(uriLikeAbnf), "unknown", nil)
+	var notFound *ErrRuleNotFound
+	assert.ErrorAs(t, err, &notFound)
+}
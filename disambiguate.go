@@ -0,0 +1,181 @@
+package goabnf
+
+import "math"
+
+// disambiguate.go filters the derivations of a forest once it is built, so that
+// Tree, Ambiguous and NumTrees only see the intended ones. A filter removes
+// packs: the predicates of WithFilter may reject any of them, which in turn
+// removes the packs using a symbol left without derivation; the priority and
+// longest-match selections then keep, at each ambiguous node, the best of the
+// remaining packs, and never leave a node without one.
+
+// PackedNode describes a packed node of a forest, i.e. one way of deriving a
+// span of the input by an alternative of a symbol.
+type PackedNode struct {
+	// Symbol is the nonterminal being derived: a rule name, or a group, option
+	// or repetition as written in the grammar.
+	Symbol string
+	// IsRule reports whether Symbol is a rule.
+	IsRule bool
+	// Alternative is the index of the alternative of Symbol, as in
+	// AmbiguityWitness.
+	Alternative int
+	// Complete is false when the packed node only derives a prefix of the
+	// alternative, as the forest is binarized: the prefix of n symbols is
+	// derived from the prefix of n-1 and the n-th symbol.
+	Complete bool
+	// The packed node derives the span [Start,End) of the input, and its last
+	// symbol starts at Pivot.
+	Start, Pivot, End int
+}
+
+// WithFilter makes ParseForest drop the derivations going through a packed
+// node for which keep returns false; input is the parsed input. A symbol left
+// without derivation is dropped in turn, so rejecting every derivation of the
+// input makes the forest invalid. It is typically used to reject keywords as
+// identifiers. Predicates apply before WithAlternativePriority and
+// WithLongestMatch. It is ignored by ParseBSR.
+func WithFilter(keep func(p PackedNode, input []byte) bool) ForestOption {
+	return func(c *forestConfig) { c.keep = append(c.keep, keep) }
+}
+
+// WithAlternativePriority makes ParseForest prefer, where a symbol derives the
+// same span by several of its alternatives, the first one in grammar order, as
+// a PEG ordered choice does. Groups and options rank their alternatives
+// too. An option prefers being present, and a repetition stopping. It is ignored by ParseBSR.
+func WithAlternativePriority() ForestOption {
+	return func(c *forestConfig) { c.priority = true }
+}
+
+// WithLongestMatch makes ParseForest prefer, where a repetition or option may
+// end at several positions, the derivations in which it matches the most input,
+// leftmost first, so that `*pchar` consumes every pchar it can. Inside a
+// repetition, the longest first element is preferred. It applies after
+// WithAlternativePriority. It is ignored by ParseBSR.
+func WithLongestMatch() ForestOption {
+	return func(c *forestConfig) { c.longest = true }
+}
+
+// disambiguate applies the filters of cfg to the forest.
+func (f *Forest) disambiguate(cfg forestConfig) {
+	if len(cfg.keep) == 0 && !cfg.priority && !cfg.longest {
+		return
+	}
+	order := postOrder(f.root)
+	if len(cfg.keep) > 0 {
+		dead := map[*gnode]bool{}
+		for changed := true; changed; {
+			changed = false
+			for _, n := range order {
+				if len(n.packs) == 0 {
+					continue
+				}
+				before := len(n.packs)
+				n.retainPacks(func(pk []*gnode, alt int) bool {
+					for _, c := range pk {
+						if dead[c] {
+							return false
+						}
+					}
+					p := f.packed(n, pk, alt)
+					for _, keep := range cfg.keep {
+						if !keep(p, f.input) {
+							return false
+						}
+					}
+					return true
+				})
+				if len(n.packs) != before {
+					changed = true
+				}
+				if len(n.packs) == 0 {
+					dead[n] = true
+				}
+			}
+		}
+		if dead[f.root] {
+			f.root = nil
+			return
+		}
+	}
+	for _, n := range order {
+		if cfg.priority && len(n.packs) > 1 {
+			f.preferFirst(n)
+		}
+		if cfg.longest && len(n.packs) > 1 {
+			f.preferLongest(n)
+		}
+	}
+}
+
+// packSlot returns the slot a pack of n derives, with alternate alt.
+func (f *Forest) packSlot(n *gnode, alt int) slot {
+	if n.kind == gInter {
+		return n.L
+	}
+	return slot{n.nt, alt, len(f.sg.nts[n.nt].alts[alt])}
+}
+
+func (f *Forest) packed(n *gnode, pk []*gnode, alt int) PackedNode {
+	sl := f.packSlot(n, alt)
+	info := f.sg.nts[sl.nt]
+	return PackedNode{
+		Symbol:      f.sg.symbolName(sl.nt),
+		IsRule:      info.isRule,
+		Alternative: f.sg.alternative(sl.nt, alt),
+		Complete:    n.kind == gSymbol,
+		Start:       n.Start,
+		Pivot:       pk[len(pk)-1].Start,
+		End:         n.End,
+	}
+}
+
+// preferFirst keeps the packs of n deriving its best ranked alternate. Groups,
+// options and repetitions are symbol nodes of their own, so their alternates
+// are ranked here too; the packs of an intermediate node all derive the same
+// prefix of one alternate and only differ by their split, which priority does
+// not choose.
+func (f *Forest) preferFirst(n *gnode) {
+	if n.kind != gSymbol {
+		return
+	}
+	info := f.sg.nts[n.nt]
+	rank := func(alt int) int {
+		if info.isOpt && alt == 0 {
+			return math.MaxInt
+		}
+		return alt
+	}
+	best := math.MaxInt
+	for _, alt := range n.alts {
+		best = min(best, rank(alt))
+	}
+	n.retainPacks(func(_ []*gnode, alt int) bool { return rank(alt) == best })
+}
+
+// preferLongest keeps, among the packs of n of the same alternate splitting the
+// span after a repetition or an option, those splitting it the furthest.
+func (f *Forest) preferLongest(n *gnode) {
+	greedy := func(alt int) bool {
+		sl := f.packSlot(n, alt)
+		if sl.dot < 2 {
+			return false
+		}
+		if left := f.sg.nts[sl.nt].alts[sl.alt][sl.dot-2]; left.kind == symNonterm {
+			if info := f.sg.nts[left.nt]; info.isRep || info.isOpt {
+				return true
+			}
+		}
+		return f.sg.nts[sl.nt].isRep
+	}
+	furthest := map[int]int{}
+	for k, pk := range n.packs {
+		if alt := n.alts[k]; greedy(alt) {
+			furthest[alt] = max(furthest[alt], pk[len(pk)-1].Start)
+		}
+	}
+	n.retainPacks(func(pk []*gnode, alt int) bool {
+		pivot, ok := furthest[alt]
+		return !ok || pk[len(pk)-1].Start == pivot
+	})
+}
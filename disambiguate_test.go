@@ -0,0 +1,193 @@
+package goabnf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_ParseForestDisambiguate(t *testing.T) {
+	t.Parallel()
+
+	keyword := func(p PackedNode, input []byte) bool {
+		return !(p.Symbol == "id" && string(input[p.Start:p.End]) == "if")
+	}
+
+	var tests = map[string]struct {
+		Grammar       string
+		Input         string
+		Options       []ForestOption
+		ExpectedValid bool
+		ExpectedTrees int64
+		ExpectedTree  *ParseTree
+	}{
+		"unfiltered": {
+			Grammar:       "a = *\"x\" *\"x\"\r\n",
+			Input:         "xx",
+			ExpectedValid: true,
+			ExpectedTrees: 3,
+		},
+		"longest-match": {
+			Grammar:       "a = *\"x\" *\"x\"\r\n",
+			Input:         "xx",
+			Options:       []ForestOption{WithLongestMatch()},
+			ExpectedValid: true,
+			ExpectedTrees: 1,
+		},
+		"longest-element": {
+			Grammar:       "a = *(\"x\" / \"xx\")\r\n",
+			Input:         "xxx",
+			Options:       []ForestOption{WithLongestMatch()},
+			ExpectedValid: true,
+			ExpectedTrees: 1,
+			ExpectedTree: &ParseTree{Rule: "a", Start: 0, End: 3, Children: []*ParseTree{
+				{Start: 0, End: 2},
+				{Start: 2, End: 3},
+			}},
+		},
+		"longest-option": {
+			Grammar:       "a = [b] b\r\nb = *\"x\"\r\n",
+			Input:         "xx",
+			Options:       []ForestOption{WithLongestMatch()},
+			ExpectedValid: true,
+			ExpectedTrees: 1,
+			ExpectedTree: &ParseTree{Rule: "a", Start: 0, End: 2, Children: []*ParseTree{
+				{Rule: "b", Start: 0, End: 2, Children: []*ParseTree{{Start: 0, End: 1}, {Start: 1, End: 2}}},
+				{Rule: "b", Start: 2, End: 2},
+			}},
+		},
+		"priority": {
+			Grammar:       "a = b / c\r\nb = \"x\"\r\nc = \"x\"\r\n",
+			Input:         "x",
+			Options:       []ForestOption{WithAlternativePriority()},
+			ExpectedValid: true,
+			ExpectedTrees: 1,
+			ExpectedTree: &ParseTree{Rule: "a", Start: 0, End: 1, Children: []*ParseTree{
+				{Rule: "b", Start: 0, End: 1, Children: []*ParseTree{{Start: 0, End: 1}}},
+			}},
+		},
+		"priority-option": {
+			// Being present is preferred, even when deriving nothing.
+			Grammar:       "a = [b] \"x\"\r\nb = \"\"\r\n",
+			Input:         "x",
+			Options:       []ForestOption{WithAlternativePriority()},
+			ExpectedValid: true,
+			ExpectedTrees: 1,
+			ExpectedTree: &ParseTree{Rule: "a", Start: 0, End: 1, Children: []*ParseTree{
+				{Rule: "b", Start: 0, End: 0},
+				{Start: 0, End: 1},
+			}},
+		},
+		"priority-group": {
+			// Groups rank their alternatives as rules do.
+			Grammar:       "a = (c / b) \"y\"\r\nb = \"x\"\r\nc = \"x\"\r\n",
+			Input:         "xy",
+			Options:       []ForestOption{WithAlternativePriority()},
+			ExpectedValid: true,
+			ExpectedTrees: 1,
+			ExpectedTree: &ParseTree{Rule: "a", Start: 0, End: 2, Children: []*ParseTree{
+				{Rule: "c", Start: 0, End: 1, Children: []*ParseTree{{Start: 0, End: 1}}},
+				{Start: 1, End: 2},
+			}},
+		},
+		"priority-repeated-group": {
+			Grammar:       "a = *(c / b)\r\nb = \"x\"\r\nc = \"x\"\r\n",
+			Input:         "xx",
+			Options:       []ForestOption{WithAlternativePriority()},
+			ExpectedValid: true,
+			ExpectedTrees: 1,
+			ExpectedTree: &ParseTree{Rule: "a", Start: 0, End: 2, Children: []*ParseTree{
+				{Rule: "c", Start: 0, End: 1, Children: []*ParseTree{{Start: 0, End: 1}}},
+				{Rule: "c", Start: 1, End: 2, Children: []*ParseTree{{Start: 1, End: 2}}},
+			}},
+		},
+		"priority-option-group": {
+			Grammar:       "a = [c / b] \"y\"\r\nb = \"x\"\r\nc = \"x\"\r\n",
+			Input:         "xy",
+			Options:       []ForestOption{WithAlternativePriority()},
+			ExpectedValid: true,
+			ExpectedTrees: 1,
+			ExpectedTree: &ParseTree{Rule: "a", Start: 0, End: 2, Children: []*ParseTree{
+				{Rule: "c", Start: 0, End: 1, Children: []*ParseTree{{Start: 0, End: 1}}},
+				{Start: 1, End: 2},
+			}},
+		},
+		"priority-splits": {
+			// Priority does not choose between splits of one alternative.
+			Grammar:       "a = a a / \"x\"\r\n",
+			Input:         "xxx",
+			Options:       []ForestOption{WithAlternativePriority()},
+			ExpectedValid: true,
+			ExpectedTrees: 2,
+		},
+		"filter": {
+			Grammar:       "a = kw / id\r\nkw = \"if\"\r\nid = 1*ALPHA\r\n",
+			Input:         "if",
+			Options:       []ForestOption{WithFilter(keyword)},
+			ExpectedValid: true,
+			ExpectedTrees: 1,
+			ExpectedTree: &ParseTree{Rule: "a", Start: 0, End: 2, Children: []*ParseTree{
+				{Rule: "kw", Start: 0, End: 2, Children: []*ParseTree{{Start: 0, End: 2}}},
+			}},
+		},
+		"filter-propagates": {
+			// Rejecting the only derivation of id rejects the ones using it.
+			Grammar:       "a = id\r\nid = 1*ALPHA\r\n",
+			Input:         "if",
+			Options:       []ForestOption{WithFilter(keyword)},
+			ExpectedValid: false,
+		},
+		"filter-before-priority": {
+			Grammar:       "a = id / kw\r\nkw = \"if\"\r\nid = 1*ALPHA\r\n",
+			Input:         "if",
+			Options:       []ForestOption{WithAlternativePriority(), WithFilter(keyword)},
+			ExpectedValid: true,
+			ExpectedTrees: 1,
+			ExpectedTree: &ParseTree{Rule: "a", Alternative: 1, Start: 0, End: 2, Children: []*ParseTree{
+				{Rule: "kw", Start: 0, End: 2, Children: []*ParseTree{{Start: 0, End: 2}}},
+			}},
+		},
+		"filter-partial": {
+			// Left associativity: the right operand of an addition is no
+			// addition itself.
+			Grammar: "a = a \"+\" a / \"x\"\r\n",
+			Input:   "x+x+x",
+			Options: []ForestOption{WithFilter(func(p PackedNode, input []byte) bool {
+				return !p.Complete || p.Alternative != 0 || p.Pivot == p.End-1
+			})},
+			ExpectedValid: true,
+			ExpectedTrees: 1,
+			ExpectedTree: &ParseTree{Rule: "a", Start: 0, End: 5, Children: []*ParseTree{
+				{Rule: "a", Start: 0, End: 3, Children: []*ParseTree{
+					{Rule: "a", Alternative: 1, Start: 0, End: 1, Children: []*ParseTree{{Start: 0, End: 1}}},
+					{Start: 1, End: 2},
+					{Rule: "a", Alternative: 1, Start: 2, End: 3, Children: []*ParseTree{{Start: 2, End: 3}}},
+				}},
+				{Start: 3, End: 4},
+				{Rule: "a", Alternative: 1, Start: 4, End: 5, Children: []*ParseTree{{Start: 4, End: 5}}},
+			}},
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			g := mustGrammar(tt.Grammar)
+			f, err := ParseForest([]byte(tt.Input), g, "a", tt.Options...)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.ExpectedValid, f.Valid())
+			if !tt.ExpectedValid {
+				assert.Nil(t, f.Tree())
+				return
+			}
+			assert.Equal(t, tt.ExpectedTrees, f.NumTrees().Int64())
+			assert.Equal(t, tt.ExpectedTrees > 1, f.Ambiguous())
+			if tt.ExpectedTree != nil {
+				assert.Equal(t, tt.ExpectedTree, f.Tree())
+			}
+		})
+	}
+}
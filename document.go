@@ -0,0 +1,174 @@
+package goabnf
+
+// document.go keeps an input parsed across edits, for editors that reparse on
+// every keystroke. The grammar is context-free: a rule node of the tree derives
+// its span whatever surrounds it, so after an edit falling inside a rule node,
+// parsing the new text of that node alone as its rule gives a tree of the whole
+// new input, the rest of the previous tree kept as is. The deepest rule node
+// containing the edit is tried first, then its ancestors; the root is the full
+// reparse, the fallback when no node short of it still derives its new text.
+
+// Edit replaces the bytes [Start, End) of an input with NewText. Start == End
+// inserts, and an empty NewText deletes.
+type Edit struct {
+	Start, End int
+	NewText    []byte
+}
+
+// Reparse describes what Document.Apply parsed again after an edit.
+type Reparse struct {
+	// Rule is the rule of the node that was parsed again.
+	Rule string
+	// Start and End delimit its span in the edited input.
+	Start, End int
+	// Full is true when the whole input was parsed again, be it because no
+	// node short of the root derived its new text or because the document was
+	// invalid before the edit.
+	Full bool
+}
+
+// Document is an input kept parsed as a rule of a grammar across edits. It is
+// not safe for concurrent use.
+type Document struct {
+	g     *Grammar
+	rule  string
+	input []byte
+	tree  *ParseTree
+	err   *ParseError
+}
+
+// NewDocument parses input as rulename and returns the document to edit.
+func NewDocument(g *Grammar, rulename string, input []byte) (*Document, error) {
+	if GetRule(rulename, g.Rulemap) == nil {
+		return nil, &ErrRuleNotFound{Rulename: rulename}
+	}
+	d := &Document{g: g, rule: rulename, input: append([]byte{}, input...)}
+	if err := d.parse(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Input returns the current input. It must not be modified.
+func (d *Document) Input() []byte { return d.input }
+
+// Tree returns the parse tree of the current input, or nil if it is invalid.
+// Its nodes are shared with the trees of the previous versions of the input
+// where the edits left them untouched, and must not be modified.
+func (d *Document) Tree() *ParseTree { return d.tree }
+
+// Valid reports whether the current input derives from the rule.
+func (d *Document) Valid() bool { return d.tree != nil }
+
+// ParseError returns the diagnostic of the current input when it is invalid,
+// as Forest.ParseError does, or nil.
+func (d *Document) ParseError() *ParseError { return d.err }
+
+// Apply edits the input and parses it again, reusing the nodes of the previous
+// tree the edit leaves untouched. The nodes before the edit are kept, those
+// after it are shifted by the change of length, and the smallest rule node
+// containing the edit whose new text still derives from its rule is parsed
+// again. The tree is the one ParseForest would extract when the edited input
+// has only one; otherwise it is one of its trees.
+func (d *Document) Apply(e Edit) (Reparse, error) {
+	if e.Start < 0 || e.Start > e.End || e.End > len(d.input) {
+		return Reparse{}, &ErrInvalidEdit{Start: e.Start, End: e.End, Len: len(d.input)}
+	}
+	input := make([]byte, 0, len(d.input)-(e.End-e.Start)+len(e.NewText))
+	input = append(input, d.input[:e.Start]...)
+	input = append(input, e.NewText...)
+	input = append(input, d.input[e.End:]...)
+	delta := len(e.NewText) - (e.End - e.Start)
+
+	// The path to the deepest rule node containing the edit, root first.
+	var path []*ParseTree
+	for n := d.tree; n != nil; {
+		path = append(path, n)
+		var next *ParseTree
+		for _, c := range n.Children {
+			if c.Start <= e.Start && e.End <= c.End && c.Rule != "" {
+				next = c
+				break
+			}
+			if c.Start > e.Start {
+				break
+			}
+		}
+		n = next
+	}
+
+	// The root is left to the full reparse.
+	for k := len(path) - 1; k > 0; k-- {
+		n := path[k]
+		start, end := n.Start, n.End+delta
+		f, err := ParseForest(input[start:end], d.g, n.Rule)
+		if err != nil {
+			return Reparse{}, err
+		}
+		if !f.Valid() {
+			continue
+		}
+		sub := shiftTree(f.Tree(), start)
+		d.input = input
+		d.tree = splice(path[:k+1], 0, sub, delta)
+		return Reparse{Rule: n.Rule, Start: start, End: end}, nil
+	}
+
+	old := d.input
+	d.input = input
+	if err := d.parse(); err != nil {
+		d.input = old
+		return Reparse{}, err
+	}
+	return Reparse{Rule: d.rule, Start: 0, End: len(input), Full: true}, nil
+}
+
+func (d *Document) parse() error {
+	f, err := ParseForest(d.input, d.g, d.rule)
+	if err != nil {
+		return err
+	}
+	d.tree, d.err = f.Tree(), f.ParseError()
+	return nil
+}
+
+// splice returns a copy of path[k] in which path[len(path)-1] is replaced by
+// sub, the nodes before it kept and those after it shifted by delta.
+func splice(path []*ParseTree, k int, sub *ParseTree, delta int) *ParseTree {
+	if k == len(path)-1 {
+		return sub
+	}
+	n := *path[k]
+	n.End += delta
+	n.Children = make([]*ParseTree, len(path[k].Children))
+	after := false
+	for i, c := range path[k].Children {
+		switch {
+		case c == path[k+1]:
+			n.Children[i] = splice(path, k+1, sub, delta)
+			after = true
+		case after:
+			n.Children[i] = shiftTree(c, delta)
+		default:
+			n.Children[i] = c
+		}
+	}
+	return &n
+}
+
+// shiftTree returns t with all its spans moved by delta, t itself if delta is 0.
+func shiftTree(t *ParseTree, delta int) *ParseTree {
+	if delta == 0 {
+		return t
+	}
+	n := *t
+	n.Start += delta
+	n.End += delta
+	if t.Children != nil {
+		n.Children = make([]*ParseTree, len(t.Children))
+		for i, c := range t.Children {
+			n.Children[i] = shiftTree(c, delta)
+		}
+	}
+	return &n
+}
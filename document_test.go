@@ -0,0 +1,99 @@
+package goabnf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_Document(t *testing.T) {
+	g := mustGrammar(uriLikeAbnf)
+	d, err := NewDocument(g, "uri", []byte("http://me@example.org/a/b"))
+	require.NoError(t, err)
+	require.True(t, d.Valid())
+
+	// Each edit applies to the input left by the previous ones.
+	var steps = []struct {
+		Edit            Edit
+		ExpectedInput   string
+		ExpectedReparse Reparse
+		ExpectedValid   bool
+	}{
+		{
+			// The ALPHA of the edited letter still derives the new one.
+			Edit:            Edit{Start: 11, End: 12, NewText: []byte("y")},
+			ExpectedInput:   "http://me@eyample.org/a/b",
+			ExpectedReparse: Reparse{Rule: "ALPHA", Start: 11, End: 12},
+			ExpectedValid:   true,
+		},
+		{
+			Edit:            Edit{Start: 10, End: 17, NewText: []byte("other")},
+			ExpectedInput:   "http://me@other.org/a/b",
+			ExpectedReparse: Reparse{Rule: "host", Start: 10, End: 19},
+			ExpectedValid:   true,
+		},
+		{
+			// Neither the last ALPHA nor the last segment derive "b/c".
+			Edit:            Edit{Start: 23, End: 23, NewText: []byte("/c")},
+			ExpectedInput:   "http://me@other.org/a/b/c",
+			ExpectedReparse: Reparse{Rule: "path", Start: 19, End: 25},
+			ExpectedValid:   true,
+		},
+		{
+			Edit:            Edit{Start: 7, End: 10},
+			ExpectedInput:   "http://other.org/a/b/c",
+			ExpectedReparse: Reparse{Rule: "authority", Start: 7, End: 16},
+			ExpectedValid:   true,
+		},
+		{
+			Edit:            Edit{Start: 4, End: 5, NewText: []byte("!")},
+			ExpectedInput:   "http!//other.org/a/b/c",
+			ExpectedReparse: Reparse{Rule: "uri", Start: 0, End: 22, Full: true},
+			ExpectedValid:   false,
+		},
+		{
+			// An invalid document has no tree to reuse.
+			Edit:            Edit{Start: 4, End: 5, NewText: []byte(":")},
+			ExpectedInput:   "http://other.org/a/b/c",
+			ExpectedReparse: Reparse{Rule: "uri", Start: 0, End: 22, Full: true},
+			ExpectedValid:   true,
+		},
+	}
+
+	for _, st := range steps {
+		before := d.Tree()
+		r, err := d.Apply(st.Edit)
+		require.NoError(t, err)
+		assert.Equal(t, st.ExpectedInput, string(d.Input()))
+		assert.Equal(t, st.ExpectedReparse, r)
+		assert.Equal(t, st.ExpectedValid, d.Valid())
+
+		f, err := ParseForest(d.Input(), g, "uri")
+		require.NoError(t, err)
+		assert.Equal(t, f.Tree(), d.Tree())
+		assert.Equal(t, f.ParseError(), d.ParseError())
+
+		// The nodes before the edit are shared with the previous tree.
+		if before != nil && d.Tree() != nil && !r.Full && st.Edit.Start > before.Children[0].End {
+			assert.Same(t, before.Children[0], d.Tree().Children[0])
+		}
+	}
+}
+
+func Test_U_Document_Errors(t *testing.T) {
+	g := mustGrammar(uriLikeAbnf)
+
+	_, err := NewDocument(g, "unknown", nil)
+	var notFound *ErrRuleNotFound
+	assert.ErrorAs(t, err, &notFound)
+
+	d, err := NewDocument(g, "uri", []byte("a:b"))
+	require.NoError(t, err)
+	for _, e := range []Edit{{Start: -1, End: 0}, {Start: 2, End: 1}, {Start: 0, End: 4}} {
+		_, err := d.Apply(e)
+		var invalid *ErrInvalidEdit
+		assert.ErrorAs(t, err, &invalid)
+		assert.Equal(t, "a:b", string(d.Input()))
+	}
+}
@@ -0,0 +1,632 @@
+package goabnf
+
+import "math/big"
+
+// Earley parsing, with Leo's optimization of right recursion.
+//
+// This follows Earley, "An efficient context-free parsing algorithm"
+// (Communications of the ACM 13, 1970) and Leo, "A general context-free parsing
+// algorithm running in linear time on every LR(k) grammar without using
+// lookahead" (Theoretical Computer Science 82, 1991).
+//
+// This is a third engine, independent of the GLL ones in sppf.go and bsr.go:
+// it lowers the grammar on its own, lazily as nonterminals get predicted, and
+// records its derivations its own way, so that the three can be checked
+// against each other. Only the terminal matcher (termEnd) is shared, as the
+// engines must agree on what a char-val or num-val accepts.
+//
+// An Earley item (production, dot, origin) in set j states that the part of
+// the production before the dot derives input[origin:j]. Each item keeps the
+// links it was reached by: the item before the dot was advanced, and the node
+// it was advanced over, a terminal or a nonterminal spanning [k,j] whose
+// derivations are the complete items of that nonterminal with origin k in set
+// j. Items, links and nodes thus form a forest.
+//
+// A repetition m*nE is lowered as RFC 5234 reads it, with one nonterminal per
+// count of elements matched so far, created when first predicted: R_c = E R_c+1
+// below m, R_c = "" / E R_c+1 from m to n, and R_m = "" / E R_m when there is
+// no n.
+//
+// Leo's optimization avoids the quadratic number of items a right
+// recursion produces: when an item waits on a nonterminal as its last symbol
+// and is the only one waiting on it in its set, completing that nonterminal
+// completes the item in turn, and so on up a chain. Completion jumps to the
+// top of the chain directly, and leaves a Leo link on it. The items skipped
+// over are materialized from these links only when the forest is read.
+
+// earleySym is a symbol of a production: a nonterminal, or a terminal when nt
+// is -1.
+type earleySym struct {
+	nt   int
+	term ElemItf
+}
+
+type earleyProd struct {
+	lhs int
+	alt int // alternate of lhs, as in sgNT: 0 is the absent option
+	rhs []earleySym
+}
+
+type earleyNT struct {
+	label    string
+	isRule   bool
+	ruleName string
+	prods    []int
+	built    bool
+
+	// What the nonterminal stands for, to build its productions when first
+	// predicted: a rule, group or option alternation, or the count-th step
+	// of a repetition.
+	alt   *Alternation
+	opt   bool
+	rep   *earleyRep
+	count int
+}
+
+type earleyRep struct {
+	min, max int
+	label    string
+	elem     earleySym
+	counts   map[int]int // count -> nonterminal
+}
+
+type earleyGrammar struct {
+	g     *Grammar
+	nts   []*earleyNT
+	prods []earleyProd
+	rules map[string]int
+	max   int
+	over  bool
+}
+
+func (eg *earleyGrammar) newNT(nt *earleyNT) int {
+	if eg.max > 0 && len(eg.nts) >= eg.max {
+		eg.over = true
+	}
+	eg.nts = append(eg.nts, nt)
+	return len(eg.nts) - 1
+}
+
+func (eg *earleyGrammar) rule(name string) int {
+	if id, ok := eg.rules[canon(name)]; ok {
+		return id
+	}
+	nt := &earleyNT{label: name}
+	if r := GetRule(name, eg.g.Rulemap); r != nil {
+		nt.isRule, nt.ruleName, nt.alt = true, r.Name, &r.Alternation
+	}
+	id := eg.newNT(nt)
+	eg.rules[canon(name)] = id
+	return id
+}
+
+func (eg *earleyGrammar) elem(e ElemItf) earleySym {
+	switch v := e.(type) {
+	case ElemRulename:
+		return earleySym{nt: eg.rule(v.Name)}
+	case ElemGroup:
+		return earleySym{nt: eg.newNT(&earleyNT{label: v.String(), alt: &v.Alternation})}
+	case ElemOption:
+		return earleySym{nt: eg.newNT(&earleyNT{label: v.String(), alt: &v.Alternation, opt: true})}
+	}
+	return earleySym{nt: -1, term: e}
+}
+
+func (eg *earleyGrammar) repetition(r Repetition) earleySym {
+	if r.Min == 1 && r.Max == 1 {
+		return eg.elem(r.Element)
+	}
+	rep := &earleyRep{min: r.Min, max: r.Max, label: r.String(), elem: eg.elem(r.Element), counts: map[int]int{}}
+	return earleySym{nt: eg.repCount(rep, 0)}
+}
+
+func (eg *earleyGrammar) repCount(rep *earleyRep, c int) int {
+	if id, ok := rep.counts[c]; ok {
+		return id
+	}
+	id := eg.newNT(&earleyNT{label: rep.label, rep: rep, count: c})
+	rep.counts[c] = id
+	return id
+}
+
+// prodsOf returns the productions of nt, building them on first use.
+func (eg *earleyGrammar) prodsOf(nt int) []int {
+	n := eg.nts[nt]
+	if n.built {
+		return n.prods
+	}
+	n.built = true
+	add := func(alt int, rhs []earleySym) {
+		eg.prods = append(eg.prods, earleyProd{lhs: nt, alt: alt, rhs: rhs})
+		n.prods = append(n.prods, len(eg.prods)-1)
+	}
+	switch {
+	case n.rep != nil:
+		rep, c := n.rep, n.count
+		if rep.max != inf && rep.max < rep.min {
+			break // derives nothing, as in the recognizer
+		}
+		// Read off m*nE: m mandatory elements, then either n-m optional
+		// ones or, unbounded, *E.
+		switch {
+		case c < rep.min:
+			add(1, []earleySym{rep.elem, {nt: eg.repCount(rep, c+1)}})
+		case rep.max == inf:
+			add(0, nil)
+			add(1, []earleySym{rep.elem, {nt: nt}})
+		case c < rep.max:
+			add(0, nil)
+			add(1, []earleySym{rep.elem, {nt: eg.repCount(rep, c+1)}})
+		default:
+			add(0, nil)
+		}
+	case n.alt != nil:
+		off := 0
+		if n.opt {
+			add(0, nil)
+			off = 1
+		}
+		for k, c := range n.alt.Concatenations {
+			var rhs []earleySym
+			for _, r := range c.Repetitions {
+				rhs = append(rhs, eg.repetition(r))
+			}
+			add(k+off, rhs)
+		}
+	}
+	return n.prods
+}
+
+// ---------------------------------------------------------------------------
+// Recognition
+// ---------------------------------------------------------------------------
+
+type earleyKey struct{ prod, dot, origin int }
+
+type earleyItem struct {
+	earleyKey
+	end      int
+	links    []earleyLink
+	linkSet  map[earleyLink]bool
+	expanded bool // Leo links replaced by the chains they stand for
+}
+
+// earleyNode is a terminal (nt == -1) or nonterminal spanning [start,end).
+type earleyNode struct{ nt, start, end int }
+
+// earleyLink is a way an item was reached: by advancing pred over child, or,
+// when leo is set, by completing child at the bottom of a Leo chain.
+type earleyLink struct {
+	pred  *earleyItem
+	child earleyNode
+	leo   *leoItem
+}
+
+// leoItem is the transitive item of a nonterminal in a set: wait is the only
+// item of the set waiting on it, as its last symbol, and next the transitive
+// item of the nonterminal wait completes, if any.
+type leoItem struct {
+	wait *earleyItem
+	next *leoItem
+	top  earleyKey
+}
+
+type earleySet struct {
+	items     map[earleyKey]*earleyItem
+	work      []*earleyItem
+	waiting   map[int][]*earleyItem
+	predicted map[int]bool
+	empty     map[int]bool             // nonterminals deriving "" here
+	done      map[[2]int][]*earleyItem // (nt, origin) -> complete items
+	leo       map[int]*leoItem         // memoized transitive items
+}
+
+func newEarleySet() *earleySet {
+	return &earleySet{
+		items:     map[earleyKey]*earleyItem{},
+		waiting:   map[int][]*earleyItem{},
+		predicted: map[int]bool{},
+		empty:     map[int]bool{},
+		done:      map[[2]int][]*earleyItem{},
+		leo:       map[int]*leoItem{},
+	}
+}
+
+func (it *earleyItem) addLink(l earleyLink) {
+	if it.linkSet == nil {
+		it.linkSet = map[earleyLink]bool{}
+	}
+	if !it.linkSet[l] {
+		it.linkSet[l] = true
+		it.links = append(it.links, l)
+	}
+}
+
+// EarleyForest is the outcome of ParseEarley: the Earley sets of the input,
+// read as a parse forest.
+type EarleyForest struct {
+	eg       *earleyGrammar
+	input    []byte
+	rulename string
+	start    int
+	sets     []*earleySet
+	size     int
+
+	maxItems int
+	aborted  bool
+
+	// Items of Leo chains, materialized when the forest is read.
+	chain     map[earleyChainKey]*earleyItem
+	chainDone map[earleyNode][]*earleyItem
+}
+
+type earleyChainKey struct {
+	earleyKey
+	end int
+}
+
+// ParseEarley parses input with grammar starting at rootRulename using an
+// Earley parser with Leo's optimization. It accepts any grammar, as ParseForest
+// does, and is meant as an independent oracle for it: both answer Valid,
+// NumTrees, Ambiguous and Tree the same. WithMaxForestNodes bounds the number
+// of Earley items and WithMaxSlots the number of nonterminals; the other
+// options are ignored.
+func ParseEarley(input []byte, grammar *Grammar, rootRulename string, opts ...ForestOption) (*EarleyForest, error) {
+	cfg := forestConfig{maxSlots: defaultMaxSlots}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if GetRule(rootRulename, grammar.Rulemap) == nil {
+		return nil, &ErrRuleNotFound{Rulename: rootRulename}
+	}
+	eg := &earleyGrammar{g: grammar, rules: map[string]int{}, max: cfg.maxSlots}
+	f := &EarleyForest{
+		eg:        eg,
+		input:     input,
+		rulename:  rootRulename,
+		start:     eg.rule(rootRulename),
+		sets:      make([]*earleySet, len(input)+1),
+		maxItems:  cfg.maxNodes,
+		chain:     map[earleyChainKey]*earleyItem{},
+		chainDone: map[earleyNode][]*earleyItem{},
+	}
+	f.parse()
+	if eg.over {
+		return nil, &ErrGrammarTooLarge{Max: cfg.maxSlots}
+	}
+	if f.aborted {
+		return nil, &ErrForestTooLarge{Max: cfg.maxNodes}
+	}
+	return f, nil
+}
+
+func (f *EarleyForest) set(j int) *earleySet {
+	if f.sets[j] == nil {
+		f.sets[j] = newEarleySet()
+	}
+	return f.sets[j]
+}
+
+func (f *EarleyForest) parse() {
+	for _, p := range f.eg.prodsOf(f.start) {
+		f.add(0, earleyKey{p, 0, 0}, nil)
+	}
+	for j := range f.sets {
+		s := f.sets[j]
+		if s == nil {
+			continue
+		}
+		for len(s.work) > 0 {
+			if f.aborted || f.eg.over {
+				return
+			}
+			it := s.work[len(s.work)-1]
+			s.work = s.work[:len(s.work)-1]
+			f.process(j, it)
+		}
+	}
+}
+
+// add adds the item k to set j, reached by link l if not nil.
+func (f *EarleyForest) add(j int, k earleyKey, l *earleyLink) {
+	s := f.set(j)
+	it, ok := s.items[k]
+	if !ok {
+		if f.maxItems > 0 && f.size >= f.maxItems {
+			f.aborted = true
+			return
+		}
+		f.size++
+		it = &earleyItem{earleyKey: k, end: j}
+		s.items[k] = it
+		s.work = append(s.work, it)
+	}
+	if l != nil {
+		it.addLink(*l)
+	}
+}
+
+func (f *EarleyForest) advance(w *earleyItem, j int, child earleyNode) {
+	f.add(j, earleyKey{w.prod, w.dot + 1, w.origin}, &earleyLink{pred: w, child: child})
+}
+
+func (f *EarleyForest) process(j int, it *earleyItem) {
+	s := f.sets[j]
+	p := f.eg.prods[it.prod]
+	if it.dot == len(p.rhs) {
+		x, k := p.lhs, it.origin
+		s.done[[2]int{x, k}] = append(s.done[[2]int{x, k}], it)
+		child := earleyNode{x, k, j}
+		if k == j {
+			// Items waiting on x later in this set advance when predicting it.
+			s.empty[x] = true
+			for _, w := range s.waiting[x] {
+				f.advance(w, j, child)
+			}
+			return
+		}
+		if l := f.leo(x, k); l != nil {
+			f.add(j, l.top, &earleyLink{child: child, leo: l})
+			return
+		}
+		for _, w := range f.sets[k].waiting[x] {
+			f.advance(w, j, child)
+		}
+		return
+	}
+	sym := p.rhs[it.dot]
+	if sym.nt >= 0 {
+		s.waiting[sym.nt] = append(s.waiting[sym.nt], it)
+		if !s.predicted[sym.nt] {
+			s.predicted[sym.nt] = true
+			for _, q := range f.eg.prodsOf(sym.nt) {
+				f.add(j, earleyKey{q, 0, j}, nil)
+			}
+		}
+		if s.empty[sym.nt] {
+			f.advance(it, j, earleyNode{sym.nt, j, j})
+		}
+		return
+	}
+	if e := termEnd(f.eg.g.Mode, f.eg.g.Folding, f.input, ssym{kind: symTerm, term: sym.term}, j); e >= 0 {
+		f.advance(it, e, earleyNode{-1, j, e})
+	}
+}
+
+// leo returns the transitive item of x in the complete set k, or nil.
+func (f *EarleyForest) leo(x, k int) *leoItem {
+	s := f.sets[k]
+	if l, ok := s.leo[x]; ok {
+		return l
+	}
+	var l *leoItem
+	if ws := s.waiting[x]; len(ws) == 1 {
+		w := ws[0]
+		p := f.eg.prods[w.prod]
+		// An item predicted in this very set would chain back into it.
+		if w.dot+1 == len(p.rhs) && w.origin < k {
+			l = &leoItem{wait: w, next: f.leo(p.lhs, w.origin), top: earleyKey{w.prod, w.dot + 1, w.origin}}
+			if l.next != nil {
+				l.top = l.next.top
+			}
+		}
+	}
+	s.leo[x] = l
+	return l
+}
+
+// ---------------------------------------------------------------------------
+// Forest
+// ---------------------------------------------------------------------------
+
+// links returns the links of it, with its Leo links replaced by the links of
+// the items of their chains.
+func (f *EarleyForest) links(it *earleyItem) []earleyLink {
+	if it.expanded {
+		return it.links
+	}
+	it.expanded = true
+	var out []earleyLink
+	seen := map[earleyLink]bool{}
+	for _, l := range it.links {
+		if l.leo != nil {
+			l = f.expand(l.leo, l.child, it.end)
+		}
+		if !seen[l] {
+			seen[l] = true
+			out = append(out, l)
+		}
+	}
+	it.links, it.linkSet = out, nil
+	return out
+}
+
+// expand materializes, in set j, the items of the chain of l completed by
+// child, and returns the link reaching the top of the chain.
+func (f *EarleyForest) expand(l *leoItem, child earleyNode, j int) earleyLink {
+	for ; l.next != nil; l = l.next {
+		w := l.wait
+		k := earleyKey{w.prod, w.dot + 1, w.origin}
+		m, ok := f.sets[j].items[k]
+		if !ok {
+			ck := earleyChainKey{k, j}
+			if m, ok = f.chain[ck]; !ok {
+				m = &earleyItem{earleyKey: k, end: j, expanded: true}
+				f.chain[ck] = m
+				n := earleyNode{f.eg.prods[w.prod].lhs, w.origin, j}
+				f.chainDone[n] = append(f.chainDone[n], m)
+			}
+		}
+		m.addLink(earleyLink{pred: w, child: child})
+		child = earleyNode{f.eg.prods[w.prod].lhs, w.origin, j}
+	}
+	return earleyLink{pred: l.wait, child: child}
+}
+
+// packs returns the complete items deriving the nonterminal node n.
+func (f *EarleyForest) packs(n earleyNode) []*earleyItem {
+	var out []*earleyItem
+	if s := f.sets[n.end]; s != nil {
+		out = append(out, s.done[[2]int{n.nt, n.start}]...)
+	}
+	return append(out, f.chainDone[n]...)
+}
+
+func (f *EarleyForest) root() earleyNode { return earleyNode{f.start, 0, len(f.input)} }
+
+// Valid reports whether the whole input is derivable by the root rule.
+func (f *EarleyForest) Valid() bool {
+	return len(f.packs(f.root())) > 0
+}
+
+// NumTrees returns the number of distinct parse trees of the input, -1 if
+// infinitely many, as Forest.NumTrees does.
+func (f *EarleyForest) NumTrees() *big.Int {
+	if !f.Valid() {
+		return big.NewInt(0)
+	}
+	nodes := map[earleyNode]*big.Int{}
+	items := map[*earleyItem]*big.Int{}
+	onStack := map[any]bool{}
+	infinite := false
+	var countNode func(n earleyNode) *big.Int
+	var countItem func(it *earleyItem) *big.Int
+	countNode = func(n earleyNode) *big.Int {
+		if n.nt < 0 {
+			return big.NewInt(1)
+		}
+		if v, ok := nodes[n]; ok {
+			return v
+		}
+		if onStack[n] {
+			infinite = true
+			return big.NewInt(1)
+		}
+		onStack[n] = true
+		total := big.NewInt(0)
+		for _, it := range f.packs(n) {
+			total.Add(total, countItem(it))
+		}
+		onStack[n] = false
+		nodes[n] = total
+		return total
+	}
+	countItem = func(it *earleyItem) *big.Int {
+		if it.dot == 0 {
+			return big.NewInt(1)
+		}
+		if v, ok := items[it]; ok {
+			return v
+		}
+		if onStack[it] {
+			infinite = true
+			return big.NewInt(1)
+		}
+		onStack[it] = true
+		total := big.NewInt(0)
+		for _, l := range f.links(it) {
+			total.Add(total, new(big.Int).Mul(countItem(l.pred), countNode(l.child)))
+		}
+		onStack[it] = false
+		items[it] = total
+		return total
+	}
+	res := countNode(f.root())
+	if infinite {
+		return big.NewInt(-1)
+	}
+	return res
+}
+
+// Ambiguous reports whether the input has more than one distinct parse tree.
+func (f *EarleyForest) Ambiguous() bool {
+	if !f.Valid() {
+		return false
+	}
+	seenNodes := map[earleyNode]bool{}
+	seenItems := map[*earleyItem]bool{}
+	var walkNode func(n earleyNode) bool
+	var walkItem func(it *earleyItem) bool
+	walkNode = func(n earleyNode) bool {
+		if n.nt < 0 || seenNodes[n] {
+			return false
+		}
+		seenNodes[n] = true
+		ps := f.packs(n)
+		if len(ps) > 1 {
+			return true
+		}
+		for _, it := range ps {
+			if walkItem(it) {
+				return true
+			}
+		}
+		return false
+	}
+	walkItem = func(it *earleyItem) bool {
+		if it.dot == 0 || seenItems[it] {
+			return false
+		}
+		seenItems[it] = true
+		ls := f.links(it)
+		if len(ls) > 1 {
+			return true
+		}
+		for _, l := range ls {
+			if walkItem(l.pred) || walkNode(l.child) {
+				return true
+			}
+		}
+		return false
+	}
+	return walkNode(f.root())
+}
+
+// Tree extracts a single parse tree (first derivation at each node), or nil if
+// the input is invalid, as Forest.Tree does.
+func (f *EarleyForest) Tree() *ParseTree {
+	if !f.Valid() {
+		return nil
+	}
+	var kids []*ParseTree
+	f.emitNode(f.root(), &kids, map[earleyNode]bool{})
+	return kids[0]
+}
+
+// emitNode appends to out the tree of n: a rule node, a terminal leaf, or the
+// spliced children of a synthetic nonterminal.
+func (f *EarleyForest) emitNode(n earleyNode, out *[]*ParseTree, onStack map[earleyNode]bool) {
+	if n.nt < 0 {
+		if n.end > n.start {
+			*out = append(*out, &ParseTree{Start: n.start, End: n.end})
+		}
+		return
+	}
+	nt := f.eg.nts[n.nt]
+	ps := f.packs(n)
+	if onStack[n] || len(ps) == 0 {
+		if nt.isRule {
+			*out = append(*out, &ParseTree{Rule: nt.ruleName, Start: n.start, End: n.end})
+		}
+		return
+	}
+	onStack[n] = true
+	defer delete(onStack, n)
+	it := ps[0]
+	if !nt.isRule {
+		f.emitItem(it, out, onStack)
+		return
+	}
+	t := &ParseTree{Rule: nt.ruleName, Alternative: f.eg.prods[it.prod].alt, Start: n.start, End: n.end}
+	f.emitItem(it, &t.Children, onStack)
+	*out = append(*out, t)
+}
+
+func (f *EarleyForest) emitItem(it *earleyItem, out *[]*ParseTree, onStack map[earleyNode]bool) {
+	if it.dot == 0 {
+		return
+	}
+	l := f.links(it)[0]
+	f.emitItem(l.pred, out, onStack)
+	f.emitNode(l.child, out, onStack)
+}
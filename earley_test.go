@@ -0,0 +1,142 @@
+package goabnf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_ParseEarley(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Grammar           string
+		Input             string
+		ExpectedValid     bool
+		ExpectedNumTrees  string
+		ExpectedAmbiguous bool
+	}{
+		"invalid": {
+			Grammar:           "a = \"x\"\r\n",
+			Input:             "y",
+			ExpectedValid:     false,
+			ExpectedNumTrees:  "0",
+			ExpectedAmbiguous: false,
+		},
+		"catalan": {
+			Grammar:           "a = a a / \"a\"\r\n",
+			Input:             "aaaa",
+			ExpectedValid:     true,
+			ExpectedNumTrees:  "5",
+			ExpectedAmbiguous: true,
+		},
+		"right-recursion": {
+			Grammar:           "a = \"a\" a / \"a\"\r\n",
+			Input:             "aaaaa",
+			ExpectedValid:     true,
+			ExpectedNumTrees:  "1",
+			ExpectedAmbiguous: false,
+		},
+		"right-recursion-ambiguous": {
+			// Leo chains through a and b both complete a.
+			Grammar:           "a = \"a\" a / \"a\" b / \"a\"\r\nb = a\r\n",
+			Input:             "aaa",
+			ExpectedValid:     true,
+			ExpectedNumTrees:  "4",
+			ExpectedAmbiguous: true,
+		},
+		"bounded-count": {
+			// [a,a,a,a] would be 4 elements, out of the bound.
+			Grammar:           "a = 2*3(\"a\" / \"aa\")\r\n",
+			Input:             "aaaa",
+			ExpectedValid:     true,
+			ExpectedNumTrees:  "4",
+			ExpectedAmbiguous: true,
+		},
+		"nullable-element": {
+			Grammar:           "a = 2*3[\"a\"]\r\n",
+			Input:             "aa",
+			ExpectedValid:     true,
+			ExpectedNumTrees:  "4",
+			ExpectedAmbiguous: true,
+		},
+		"infinitely-ambiguous": {
+			Grammar:           "a = a / \"a\"\r\n",
+			Input:             "a",
+			ExpectedValid:     true,
+			ExpectedNumTrees:  "-1",
+			ExpectedAmbiguous: true,
+		},
+		"undefined-rule": {
+			Grammar:           "a = b / \"a\"\r\n",
+			Input:             "a",
+			ExpectedValid:     true,
+			ExpectedNumTrees:  "1",
+			ExpectedAmbiguous: false,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			g, err := ParseABNF([]byte(tt.Grammar), WithValidation(false))
+			require.NoError(t, err)
+
+			f, err := ParseEarley([]byte(tt.Input), g, "a")
+			require.NoError(t, err)
+			assert.Equal(t, tt.ExpectedValid, f.Valid())
+			assert.Equal(t, tt.ExpectedNumTrees, f.NumTrees().String())
+			assert.Equal(t, tt.ExpectedAmbiguous, f.Ambiguous())
+			if tt.ExpectedValid {
+				require.NotNil(t, f.Tree())
+			} else {
+				assert.Nil(t, f.Tree())
+			}
+		})
+	}
+}
+
+func Test_U_ParseEarley_Tree(t *testing.T) {
+	g := mustGrammar(uriLikeAbnf)
+	input := []byte("http://me@example.org/a/b")
+
+	ef, err := ParseEarley(input, g, "uri")
+	require.NoError(t, err)
+	sf, err := ParseForest(input, g, "uri")
+	require.NoError(t, err)
+	require.False(t, sf.Ambiguous())
+	assert.Equal(t, sf.Tree(), ef.Tree())
+}
+
+func Test_U_ParseEarley_RightRecursion(t *testing.T) {
+	// Leo's optimization keeps the number of items linear in the input.
+	g := mustGrammar("a = \"a\" a / \"a\"\r\n")
+	in := []byte(strings.Repeat("a", 2000))
+	f, err := ParseEarley(in, g, "a", WithMaxForestNodes(6*len(in)))
+	require.NoError(t, err)
+	assert.True(t, f.Valid())
+	assert.Equal(t, "1", f.NumTrees().String())
+	assert.Len(t, f.Tree().Children, 2)
+}
+
+func Test_U_ParseEarley_Errors(t *testing.T) {
+	g := mustGrammar("list = num *(\",\" num)\r\nnum = 1*DIGIT\r\n")
+
+	_, err := ParseEarley([]byte("1"), g, "unknown")
+	var notFound *ErrRuleNotFound
+	assert.ErrorAs(t, err, &notFound)
+
+	in := []byte(strings.Repeat("7,", 200) + "7")
+	_, err = ParseEarley(in, g, "list", WithMaxForestNodes(1000))
+	assert.IsType(t, &ErrForestTooLarge{}, err)
+
+	_, err = ParseEarley(in, g, "list", WithMaxSlots(2))
+	assert.IsType(t, &ErrGrammarTooLarge{}, err)
+
+	f, err := ParseEarley(in, g, "list")
+	require.NoError(t, err)
+	assert.True(t, f.Valid())
+}
@@ -3,7 +3,10 @@ package goabnf
 import (
 	"errors"
 	"fmt"
+	"reflect"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -56,6 +59,63 @@ type ParseError struct {
 	// Hint, when set, gives a plain-language diagnosis of a common mistake (e.g.
 	// bare LF line endings where ABNF requires CR LF).
 	Hint string
+	// Contexts groups Expected by the stack of rules being parsed when each
+	// terminal failed. It may be empty, e.g. for the streaming recognizer.
+	Contexts []ErrorContext
+}
+
+// ErrorContext is one way parsing could have continued at a ParseError: the
+// rules being parsed there and the terminals they expected.
+type ErrorContext struct {
+	// Rules is the stack of rules being parsed, innermost first, e.g.
+	// ["DIGIT", "port", "authority", "URI"].
+	Rules []string
+	// Entered is how many of the innermost Rules started at the error offset,
+	// i.e. had not consumed anything yet.
+	Entered int
+	// Expected lists the terminals that could have been consumed.
+	Expected []string
+}
+
+// String summarizes the context, naming the outermost rule that consumed
+// nothing yet rather than its terminals, e.g. "DIGIT in `port` inside
+// `authority` inside `URI`".
+func (c ErrorContext) String() string { return c.describe(-1) }
+
+// describe is String keeping at most depth enclosing rules, or all of them
+// when depth is negative.
+func (c ErrorContext) describe(depth int) string {
+	var b strings.Builder
+	outer := c.Rules
+	if c.Entered > 0 {
+		b.WriteString(c.Rules[c.Entered-1])
+		outer = c.Rules[c.Entered:]
+	} else {
+		b.WriteString(strings.Join(c.Expected, " or "))
+	}
+	if depth >= 0 && len(outer) > depth {
+		outer = outer[:depth]
+	}
+	for k, r := range outer {
+		if k == 0 {
+			fmt.Fprintf(&b, " in `%s`", r)
+		} else {
+			fmt.Fprintf(&b, " inside `%s`", r)
+		}
+	}
+	return b.String()
+}
+
+// ExpectedRules summarizes Contexts, one deduplicated line per context (see
+// ErrorContext.String). It is empty when no context was recorded.
+func (e *ParseError) ExpectedRules() []string {
+	var out []string
+	for _, c := range e.Contexts {
+		if s := c.String(); !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
 }
 
 var _ error = (*ParseError)(nil)
@@ -122,14 +182,30 @@ func newParseError(input []byte, offset int, expected []string) *ParseError {
 			col++
 		}
 	}
+	cur, prev := -1, -1
+	if offset < len(input) {
+		cur = int(input[offset])
+	}
+	if offset > 0 {
+		prev = int(input[offset-1])
+	}
+	return parseErrorAt(offset, line, col, expected, cur, prev)
+}
+
+// parseErrorAt builds a ParseError from an already-located offset, for engines
+// that no longer hold the whole input (e.g. the streaming recognizer). cur is
+// the byte at offset and prev the one before it, each -1 when absent (end of
+// input, start of input).
+func parseErrorAt(offset, line, col int, expected []string, cur, prev int) *ParseError {
 	exp := append([]string(nil), expected...)
 	sort.Strings(exp)
 	pe := &ParseError{Offset: offset, Line: line, Col: col, Expected: exp}
-	if offset < len(input) {
-		pe.Found = describeByte(input[offset])
-		// Bare LF where the previous byte is not CR: ABNF lines end with CR LF,
-		// so a lone LF is almost always the cause.
-		if input[offset] == '\n' && (offset == 0 || input[offset-1] != '\r') {
+	if cur >= 0 {
+		pe.Found = describeByte(byte(cur))
+		// Bare LF where the previous byte is not CR and a CR was expected: ABNF
+		// lines end with CR LF, so a lone LF is almost always the cause. Grammars
+		// accepting LF line endings (e.g. TOML) do not get the hint.
+		if cur == '\n' && prev != '\r' && expectsCR(exp) {
 			pe.Hint = `input uses bare LF line endings, but ABNF requires CR LF ("\r\n")`
 		}
 	} else {
@@ -138,6 +214,140 @@ func newParseError(input []byte, offset int, expected []string) *ParseError {
 	return pe
 }
 
+// furthest tracks the furthest-failure diagnostics shared by the parsing
+// engines: maxPos is the deepest input offset at which a terminal match was
+// attempted and failed, expected collects (deduplicated) the terminals that
+// could have been consumed there, and contexts groups them by the stack of
+// rules being parsed. They drive ParseError when an input has no solution.
+type furthest struct {
+	maxPos       int
+	expected     []string
+	expectedSeen map[string]bool
+	contexts     []ErrorContext
+	contextIdx   map[string]int
+}
+
+// at moves the furthest position forward to i if it is deeper, and reports
+// whether i is the furthest position.
+func (f *furthest) at(i int) bool {
+	if i > f.maxPos {
+		f.maxPos = i
+		f.expected = f.expected[:0]
+		f.expectedSeen = nil
+		f.contexts = f.contexts[:0]
+		f.contextIdx = nil
+	}
+	return i == f.maxPos
+}
+
+// note records that the terminal described by desc failed to match at i.
+func (f *furthest) note(i int, desc string) {
+	if !f.at(i) {
+		return
+	}
+	if f.expectedSeen == nil {
+		f.expectedSeen = map[string]bool{}
+	}
+	if !f.expectedSeen[desc] {
+		f.expectedSeen[desc] = true
+		f.expected = append(f.expected, desc)
+	}
+}
+
+// noteIn records that the terminal described by desc failed to match at the
+// furthest position i, while parsing the rules of frames (innermost first).
+func (f *furthest) noteIn(desc string, frames []ruleFrame, i int) {
+	rules, entered := ruleContext(frames, i)
+	key := strconv.Itoa(entered) + ":" + strings.Join(rules, "\x00")
+	k, ok := f.contextIdx[key]
+	if !ok {
+		if f.contextIdx == nil {
+			f.contextIdx = map[string]int{}
+		}
+		k = len(f.contexts)
+		f.contextIdx[key] = k
+		f.contexts = append(f.contexts, ErrorContext{Rules: rules, Entered: entered})
+	}
+	if !slices.Contains(f.contexts[k].Expected, desc) {
+		f.contexts[k].Expected = append(f.contexts[k].Expected, desc)
+	}
+}
+
+// parseError builds the ParseError of the furthest failure, shifted by base
+// when the engine ran over a slice of input starting at base.
+func (f *furthest) parseError(input []byte, base int) *ParseError {
+	return f.withContexts(newParseError(input, base+f.maxPos, f.expected))
+}
+
+// withContexts attaches the rule contexts of the furthest failure to pe.
+func (f *furthest) withContexts(pe *ParseError) *ParseError {
+	for _, c := range f.contexts {
+		c.Rules = slices.Clone(c.Rules)
+		c.Expected = slices.Clone(c.Expected)
+		slices.Sort(c.Expected)
+		pe.Contexts = append(pe.Contexts, c)
+	}
+	slices.SortFunc(pe.Contexts, func(a, b ErrorContext) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	return pe
+}
+
+// ruleFrame is an active rule and the input offset at which it started.
+type ruleFrame struct {
+	name  string
+	start int
+}
+
+// ruleContext turns frames, innermost first, into the rules of an ErrorContext
+// and how many of the innermost ones started at offset i. A directly recursive
+// run of one rule is reported once, starting where the outermost call did, as
+// engines unfold recursion to different depths.
+func ruleContext(frames []ruleFrame, i int) ([]string, int) {
+	var kept []ruleFrame
+	for _, fr := range frames {
+		if n := len(kept); n > 0 && kept[n-1].name == fr.name {
+			kept[n-1].start = fr.start
+			continue
+		}
+		kept = append(kept, fr)
+	}
+	rules := make([]string, len(kept))
+	entered := 0
+	for k, fr := range kept {
+		rules[k] = fr.name
+		if fr.start == i && entered == k {
+			entered++
+		}
+	}
+	return rules, entered
+}
+
+// termDesc describes a terminal the way it is written in ABNF.
+func termDesc(e ElemItf) string {
+	if str, ok := e.(fmt.Stringer); ok {
+		return str.String()
+	}
+	return "?"
+}
+
+// expectsCR reports whether one of the expected terminals starts with a CR.
+func expectsCR(expected []string) bool {
+	for _, x := range expected {
+		if len(x) < 3 || x[0] != '%' {
+			continue
+		}
+		first := x[2:]
+		if k := strings.IndexAny(first, ".-"); k >= 0 {
+			first = first[:k]
+		}
+		if numvalToRune(first, x[1:2]) == '\r' {
+			return true
+		}
+	}
+	return false
+}
+
 // ErrMultipleSolutionsFound is an error returned when a parser found
 // multiple solutions when none or one were expected.
 type ErrMultipleSolutionsFound struct{}
@@ -148,6 +358,16 @@ func (err ErrMultipleSolutionsFound) Error() string {
 	return "multiple solutions found, this should not happen. Please open an issue. This could eventually need an Erratum from IETF tracking"
 }
 
+// ErrClosed is returned by StreamRecognizer.Write once the recognizer was
+// closed.
+type ErrClosed struct{}
+
+var _ error = (*ErrClosed)(nil)
+
+func (err ErrClosed) Error() string {
+	return "write to closed StreamRecognizer"
+}
+
 // ErrRuleNotFound is an error returned when the rule was not found
 // as part of the grammar.
 type ErrRuleNotFound struct {
@@ -232,6 +452,18 @@ func (err ErrCyclicRule) Error() string {
 	return fmt.Sprintf("can't generate a content as the rule %s involves an unavoidable cycle", err.Rulename)
 }
 
+// ErrTooManySentences is returned by FindAmbiguity and DiffPEG when the rule has
+// more sentences within the length bound than the WithMaxSentences budget.
+type ErrTooManySentences struct {
+	Max int
+}
+
+var _ error = (*ErrTooManySentences)(nil)
+
+func (err ErrTooManySentences) Error() string {
+	return fmt.Sprintf("more than %d sentences to enumerate", err.Max)
+}
+
 // ErrMaxNodesExceeded is returned by TransitionGraph when construction would
 // allocate more nodes than the budget set with WithMaxNodes.
 type ErrMaxNodesExceeded struct {
@@ -243,3 +475,97 @@ var _ error = (*ErrMaxNodesExceeded)(nil)
 func (err ErrMaxNodesExceeded) Error() string {
 	return fmt.Sprintf("transition graph node budget of %d exceeded", err.Max)
 }
+
+// ErrInvalidEdit is returned by Document.Apply when an edit does not delimit
+// a span of the input.
+type ErrInvalidEdit struct {
+	Start, End int
+	Len        int
+}
+
+var _ error = (*ErrInvalidEdit)(nil)
+
+func (err ErrInvalidEdit) Error() string {
+	return fmt.Sprintf("edit of bytes %d to %d out of an input of %d bytes", err.Start, err.End, err.Len)
+}
+
+// ErrEvaluateAction is returned by Evaluate when an action fails.
+type ErrEvaluateAction struct {
+	Rule       string
+	Start, End int
+	Err        error
+}
+
+var _ error = (*ErrEvaluateAction)(nil)
+
+func (err ErrEvaluateAction) Error() string {
+	return fmt.Sprintf("action of rule %s failed on bytes %d to %d: %s", err.Rule, err.Start, err.End, err.Err)
+}
+
+func (err ErrEvaluateAction) Unwrap() error {
+	return err.Err
+}
+
+// ErrDuplicateAction is returned by Evaluate when two of its actions are
+// registered under names only differing by case, so for the same rule.
+type ErrDuplicateAction struct {
+	Rule, Other string
+}
+
+var _ error = (*ErrDuplicateAction)(nil)
+
+func (err ErrDuplicateAction) Error() string {
+	return fmt.Sprintf("actions %s and %s are registered for the same rule", err.Rule, err.Other)
+}
+
+// ErrEvaluateType is returned by Evaluate when the value of the root of the
+// tree is not of the expected type.
+type ErrEvaluateType struct {
+	Rule     string
+	Value    any
+	Expected string
+}
+
+var _ error = (*ErrEvaluateType)(nil)
+
+func (err ErrEvaluateType) Error() string {
+	return fmt.Sprintf("rule %s evaluates to a %T, expected a %s", err.Rule, err.Value, err.Expected)
+}
+
+// ErrInvalidUnmarshal is returned by Unmarshal when its target is not a
+// non-nil pointer.
+type ErrInvalidUnmarshal struct {
+	Type reflect.Type
+}
+
+var _ error = (*ErrInvalidUnmarshal)(nil)
+
+func (err ErrInvalidUnmarshal) Error() string {
+	if err.Type == nil {
+		return "can't unmarshal into nil"
+	}
+	return fmt.Sprintf("can't unmarshal into non-pointer or nil %s", err.Type)
+}
+
+// ErrUnmarshal is returned by Unmarshal when the text of a node can't be
+// stored in a value of the given type. Err is nil when the type is not
+// supported at all.
+type ErrUnmarshal struct {
+	Rule string
+	Text string
+	Type reflect.Type
+	Err  error
+}
+
+var _ error = (*ErrUnmarshal)(nil)
+
+func (err ErrUnmarshal) Error() string {
+	if err.Err == nil {
+		return fmt.Sprintf("can't unmarshal rule %s into unsupported type %s", err.Rule, err.Type)
+	}
+	return fmt.Sprintf("can't unmarshal %q of rule %s into %s: %s", err.Text, err.Rule, err.Type, err.Err)
+}
+
+func (err ErrUnmarshal) Unwrap() error {
+	return err.Err
+}
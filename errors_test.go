@@ -2,6 +2,7 @@ package goabnf
 
 import (
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -52,6 +53,91 @@ func Test_U_ParseError_BareLF(t *testing.T) {
 	assert.True(t, errors.As(err, &dep), "with CR LF, the real error is the missing rule b")
 }
 
+func Test_U_Check(t *testing.T) {
+	g := mustGrammar("a = \"x\" 1*DIGIT\r\n")
+
+	assert.NoError(t, g.Check("a", []byte("x12")))
+
+	err := g.Check("a", []byte("x1y"))
+	var pe *ParseError
+	require.True(t, errors.As(err, &pe))
+	assert.Equal(t, 2, pe.Offset)
+	assert.Equal(t, []string{"%x30-39"}, pe.Expected)
+	assert.Equal(t, `"y"`, pe.Found)
+
+	assert.IsType(t, &ErrRuleNotFound{}, g.Check("nope", nil))
+}
+
+// Test_U_ParseError_Contexts pins the rule-aware diagnostics: expected terminals
+// are grouped by the rules being parsed, naming a rule rather than its
+// terminals when it has not consumed anything yet.
+func Test_U_ParseError_Contexts(t *testing.T) {
+	g := mustGrammar("uri = scheme \"://\" authority\r\n" +
+		"scheme = 1*ALPHA\r\n" +
+		"authority = host [\":\" port]\r\n" +
+		"host = 1*ALPHA\r\n" +
+		"port = 1*DIGIT\r\n")
+
+	f, err := ParseForest([]byte("http://example:80x"), g, "uri")
+	require.NoError(t, err)
+	pe := f.ParseError()
+	require.NotNil(t, pe)
+	assert.Equal(t, 17, pe.Offset)
+	assert.Equal(t, []ErrorContext{{
+		Rules:    []string{"DIGIT", "port", "authority", "uri"},
+		Entered:  1,
+		Expected: []string{"%x30-39"},
+	}}, pe.Contexts)
+	assert.Equal(t, []string{"DIGIT in `port` inside `authority` inside `uri`"}, pe.ExpectedRules())
+
+	err = g.Check("uri", []byte("http://example:"))
+	require.True(t, errors.As(err, &pe))
+	assert.Equal(t, []string{"port in `authority` inside `uri`"}, pe.ExpectedRules())
+}
+
+// Test_I_ParseError_Engines pins that the recognizer, the SPPF, the BSR and
+// the streaming engines report the same diagnostic for every rejected input.
+func Test_I_ParseError_Engines(t *testing.T) {
+	for _, c := range invariantCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			g := mustGrammar(c.src + "\r\n")
+			for _, in := range enumerate(c.alpha+"-", 4) {
+				f, err := ParseForest([]byte(in), g, "a")
+				require.NoError(t, err)
+				bf, err := ParseBSR([]byte(in), g, "a")
+				require.NoError(t, err)
+				check := g.Check("a", []byte(in))
+				_, stream := g.IsValidReader("a", strings.NewReader(in))
+				if f.Valid() {
+					assert.NoError(t, check)
+					assert.Nil(t, bf.ParseError())
+					assert.NoError(t, stream)
+					continue
+				}
+				assert.Equalf(t, f.ParseError(), bf.ParseError(), "BSR on %q", in)
+				want := *f.ParseError()
+				want.Contexts = nil
+
+				// The recognizer walks recursive rules in another order than the
+				// GSS records them, so only the rule summaries must agree.
+				var pe *ParseError
+				require.True(t, errors.As(check, &pe))
+				assert.Equalf(t, f.ParseError().ExpectedRules(), pe.ExpectedRules(), "Check on %q", in)
+				got := *pe
+				got.Contexts = nil
+				assert.Equalf(t, want, got, "Check on %q", in)
+
+				// The streaming engine calls rules in tail position without a GSS
+				// frame, so its rule contexts are shallower.
+				require.Truef(t, errors.As(stream, &pe), "stream on %q", in)
+				got = *pe
+				got.Contexts = nil
+				assert.Equalf(t, want, got, "stream on %q", in)
+			}
+		})
+	}
+}
+
 // Test_U_Error_Sentinels pins that the promoted sentinels are matchable with
 // errors.Is rather than being opaque strings.
 func Test_U_Error_Sentinels(t *testing.T) {
@@ -0,0 +1,135 @@
+package goabnf
+
+import (
+	"reflect"
+	"slices"
+)
+
+// evaluate.go turns parse trees into values, bottom-up: the value of a rule
+// node is computed by the action registered for its rule from the values of
+// its children, as feval does by hand for the ABNF meta-grammar. Terminals are
+// worth the text they match.
+
+// ActionNode is what an Action is given to compute the value of a rule node.
+type ActionNode struct {
+	// Tree is the rule node.
+	Tree *ParseTree
+	// Text is the part of the input the node spans.
+	Text string
+	// Values holds the value of each child of the node, in order: the result
+	// of its action for a rule node, its text for a terminal.
+	Values []any
+}
+
+// Action computes the value of a rule node. An error aborts the evaluation.
+type Action func(n ActionNode) (any, error)
+
+// Actions maps rule names to the actions computing their values. Rule names
+// are case-insensitive, so two names differing only by case are an error.
+type Actions map[string]Action
+
+// DefaultAction is the action of the rules without one: the text of the node
+// if its children are all terminals or core rules, such as `1*ALPHA`, the
+// values of its children otherwise.
+func DefaultAction(n ActionNode) (any, error) {
+	for _, c := range n.Tree.Children {
+		if c.Rule != "" && !isCoreRule(c.Rule) {
+			return n.Values, nil
+		}
+	}
+	return n.Text, nil
+}
+
+// EvaluateOption configures Evaluate.
+type EvaluateOption interface {
+	apply(*evaluateConfig)
+}
+
+type evaluateConfig struct {
+	fallback Action
+}
+
+// WithDefaultAction replaces DefaultAction for the rules without an action.
+func WithDefaultAction(a Action) EvaluateOption {
+	return defaultActionOption(a)
+}
+
+type defaultActionOption Action
+
+var _ EvaluateOption = (*defaultActionOption)(nil)
+
+func (o defaultActionOption) apply(c *evaluateConfig) {
+	c.fallback = Action(o)
+}
+
+// Evaluate computes the value of tree, a parse tree of input, by running the
+// actions bottom-up. The value of the root must be a T, else it fails with
+// ErrEvaluateType; an error returned by an action is wrapped in an
+// ErrEvaluateAction locating the node.
+func Evaluate[T any](input []byte, tree *ParseTree, actions Actions, opts ...EvaluateOption) (T, error) {
+	var zero T
+	if tree == nil {
+		return zero, ErrNoSolutionFound
+	}
+	cfg := evaluateConfig{fallback: DefaultAction}
+	for _, o := range opts {
+		o.apply(&cfg)
+	}
+	actions, err := actions.canon()
+	if err != nil {
+		return zero, err
+	}
+	v, err := cfg.evaluate(input, tree, actions)
+	if err != nil {
+		return zero, err
+	}
+	out, ok := v.(T)
+	if !ok && v != nil {
+		return zero, &ErrEvaluateType{Rule: tree.Rule, Value: v, Expected: reflect.TypeFor[T]().String()}
+	}
+	return out, nil
+}
+
+// canon returns the actions keyed by canonical rule name, or an
+// ErrDuplicateAction if two names only differ by case.
+func (actions Actions) canon() (Actions, error) {
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	out := make(Actions, len(actions))
+	seen := make(map[string]string, len(actions))
+	for _, name := range names {
+		c := canon(name)
+		if prev, ok := seen[c]; ok {
+			return nil, &ErrDuplicateAction{Rule: prev, Other: name}
+		}
+		seen[c] = name
+		out[c] = actions[name]
+	}
+	return out, nil
+}
+
+func (cfg evaluateConfig) evaluate(input []byte, t *ParseTree, actions Actions) (any, error) {
+	if t.Rule == "" {
+		return t.Text(input), nil
+	}
+	n := ActionNode{Tree: t, Text: t.Text(input), Values: make([]any, len(t.Children))}
+	for k, c := range t.Children {
+		v, err := cfg.evaluate(input, c, actions)
+		if err != nil {
+			return nil, err
+		}
+		n.Values[k] = v
+	}
+	act, ok := actions[canon(t.Rule)]
+	if !ok {
+		act = cfg.fallback
+	}
+	v, err := act(n)
+	if err != nil {
+		return nil, &ErrEvaluateAction{Rule: t.Rule, Start: t.Start, End: t.End, Err: err}
+	}
+	return v, nil
+}
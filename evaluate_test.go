@@ -0,0 +1,160 @@
+package goabnf
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const calcAbnf = "expr = term *((\"+\" / \"-\") term)\r\n" +
+	"term = factor *(\"*\" factor)\r\n" +
+	"factor = number / \"(\" expr \")\"\r\n" +
+	"number = 1*DIGIT\r\n"
+
+var calcActions = Actions{
+	"expr": func(n ActionNode) (any, error) {
+		acc := n.Values[0].(int)
+		for k := 1; k < len(n.Values); k += 2 {
+			if n.Values[k] == "+" {
+				acc += n.Values[k+1].(int)
+			} else {
+				acc -= n.Values[k+1].(int)
+			}
+		}
+		return acc, nil
+	},
+	"term": func(n ActionNode) (any, error) {
+		acc := n.Values[0].(int)
+		for k := 2; k < len(n.Values); k += 2 {
+			acc *= n.Values[k].(int)
+		}
+		return acc, nil
+	},
+	"factor": func(n ActionNode) (any, error) {
+		if n.Tree.Alternative == 0 {
+			return n.Values[0], nil
+		}
+		return n.Values[1], nil
+	},
+	"number": func(n ActionNode) (any, error) {
+		return strconv.Atoi(n.Text)
+	},
+}
+
+func Test_U_Evaluate(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Input    string
+		Expected int
+	}{
+		"number": {
+			Input:    "42",
+			Expected: 42,
+		},
+		"precedence": {
+			Input:    "1+2*3",
+			Expected: 7,
+		},
+		"parentheses": {
+			Input:    "(1+2)*3-4",
+			Expected: 5,
+		},
+	}
+
+	g := mustGrammar(calcAbnf)
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := ParseForest([]byte(tt.Input), g, "expr")
+			require.NoError(t, err)
+
+			v, err := Evaluate[int]([]byte(tt.Input), f.Tree(), calcActions)
+			require.NoError(t, err)
+			assert.Equal(t, tt.Expected, v)
+		})
+	}
+}
+
+func Test_U_Evaluate_Default(t *testing.T) {
+	g := mustGrammar("pairs = pair *(\";\" pair)\r\npair = key \"=\" value\r\nkey = 1*ALPHA\r\nvalue = 1*DIGIT\r\n")
+	input := []byte("a=1;b=22")
+	f, err := ParseForest(input, g, "pairs")
+	require.NoError(t, err)
+
+	// Without actions, rule nodes are worth their children's values, down
+	// to the lexical ones worth their text.
+	v, err := Evaluate[[]any](input, f.Tree(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []any{
+		[]any{"a", "=", "1"},
+		";",
+		[]any{"b", "=", "22"},
+	}, v)
+
+	// Only pair has an action: the others fall back.
+	cfg, err := Evaluate[[]any](input, f.Tree(), Actions{
+		"pair": func(n ActionNode) (any, error) {
+			return [2]string{n.Values[0].(string), n.Values[2].(string)}, nil
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []any{[2]string{"a", "1"}, ";", [2]string{"b", "22"}}, cfg)
+
+	// Rule names are matched case-insensitively.
+	upper, err := Evaluate[[]any](input, f.Tree(), Actions{
+		"PAIR": func(n ActionNode) (any, error) {
+			return [2]string{n.Values[0].(string), n.Values[2].(string)}, nil
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, cfg, upper)
+
+	// A custom fallback replaces DefaultAction.
+	text, err := Evaluate[string](input, f.Tree(), nil, WithDefaultAction(func(n ActionNode) (any, error) {
+		return n.Text, nil
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "a=1;b=22", text)
+}
+
+func Test_U_Evaluate_Errors(t *testing.T) {
+	g := mustGrammar(calcAbnf)
+	input := []byte("1+99999999999999999999")
+	f, err := ParseForest(input, g, "expr")
+	require.NoError(t, err)
+
+	_, err = Evaluate[int](input, f.Tree(), calcActions)
+	var actErr *ErrEvaluateAction
+	require.ErrorAs(t, err, &actErr)
+	assert.Equal(t, "number", actErr.Rule)
+	assert.Equal(t, 2, actErr.Start)
+	assert.Equal(t, 22, actErr.End)
+	var numErr *strconv.NumError
+	assert.True(t, errors.As(err, &numErr))
+
+	input = []byte("1+2")
+	f, err = ParseForest(input, g, "expr")
+	require.NoError(t, err)
+	_, err = Evaluate[string](input, f.Tree(), calcActions)
+	var typeErr *ErrEvaluateType
+	require.ErrorAs(t, err, &typeErr)
+	assert.Equal(t, "expr", typeErr.Rule)
+	assert.Equal(t, 3, typeErr.Value)
+	assert.Equal(t, "string", typeErr.Expected)
+
+	_, err = Evaluate[int](input, nil, calcActions)
+	assert.ErrorIs(t, err, ErrNoSolutionFound)
+	_, err = Evaluate[int](input, f.Tree(), Actions{
+		"number": calcActions["number"],
+		"Number": calcActions["number"],
+	})
+	var dupErr *ErrDuplicateAction
+	require.ErrorAs(t, err, &dupErr)
+	assert.Equal(t, "Number", dupErr.Rule)
+	assert.Equal(t, "number", dupErr.Other)
+}
@@ -0,0 +1,354 @@
+package goabnf
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// export.go renders the internal representations of a parse: the nodes of a
+// forest reachable from its root, and the elements of a BSR set grouped by
+// slot. A slot is written as the alternate of a nonterminal with a dot before
+// the next symbol to match, e.g. `sum = sum · "+" term`.
+
+// symbolName returns the name of nt: its rule name, or the group, option or
+// repetition it stands for as written in the grammar.
+func (sg *slotGrammar) symbolName(nt int) string {
+	if info := sg.nts[nt]; info.isRule {
+		return info.ruleName
+	}
+	return sg.nts[nt].label
+}
+
+func (sg *slotGrammar) slotString(sl slot) string {
+	var b strings.Builder
+	b.WriteString(sg.symbolName(sl.nt))
+	b.WriteString(" =")
+	for k, s := range sg.nts[sl.nt].alts[sl.alt] {
+		if k == sl.dot {
+			b.WriteString(" ·")
+		}
+		b.WriteByte(' ')
+		switch s.kind {
+		case symNonterm:
+			b.WriteString(sg.symbolName(s.nt))
+		case symTerm:
+			b.WriteString(s.term.String())
+		case symEps:
+			b.WriteString(`""`)
+		case symErr:
+			b.WriteString("<error>")
+		}
+	}
+	if sl.dot == len(sg.nts[sl.nt].alts[sl.alt]) {
+		b.WriteString(" ·")
+	}
+	return b.String()
+}
+
+// exportNode is a node of a forest as exported: one of "symbol",
+// "intermediate", "terminal" or "error".
+type exportNode struct {
+	n     *gnode
+	id    int
+	kind  string
+	label string
+	packs []exportPack
+}
+
+type exportPack struct {
+	id       int
+	p        PackedNode
+	children []int
+}
+
+// exportNodes lists the nodes reachable from the root, depth first.
+func (f *Forest) exportNodes() []*exportNode {
+	if f.root == nil {
+		return nil
+	}
+	ids := map[*gnode]int{}
+	var nodes []*exportNode
+	var visit func(n *gnode) int
+	visit = func(n *gnode) int {
+		if id, ok := ids[n]; ok {
+			return id
+		}
+		e := &exportNode{n: n, id: len(nodes)}
+		ids[n] = e.id
+		nodes = append(nodes, e)
+		span := fmt.Sprintf("[%d,%d)", n.Start, n.End)
+		switch n.kind {
+		case gSymbol:
+			e.kind, e.label = "symbol", f.sg.symbolName(n.nt)+" "+span
+		case gInter:
+			e.kind, e.label = "intermediate", f.sg.slotString(n.L)+" "+span
+		case gTerm:
+			e.kind, e.label = "terminal", "ε "+span
+			if n.End > n.Start {
+				e.label = strconv.Quote(string(f.input[n.Start:n.End])) + " " + span
+			}
+		case gErr:
+			e.kind, e.label = "error", "error "+strconv.Quote(string(f.input[n.Start:n.End]))+" "+span
+		}
+		for k, pk := range n.packs {
+			p := exportPack{p: f.packed(n, pk, n.alts[k])}
+			for _, c := range pk {
+				p.children = append(p.children, visit(c))
+			}
+			e.packs = append(e.packs, p)
+		}
+		return e.id
+	}
+	visit(f.root)
+	id := 0
+	for _, e := range nodes {
+		for k := range e.packs {
+			e.packs[k].id = id
+			id++
+		}
+	}
+	return nodes
+}
+
+func (p exportPack) label() string {
+	if p.p.Alternative >= 0 {
+		return fmt.Sprintf("alt %d, pivot %d", p.p.Alternative, p.p.Pivot)
+	}
+	return fmt.Sprintf("pivot %d", p.p.Pivot)
+}
+
+// ToDOT produces a Graphviz representation of the forest: symbol nodes as
+// boxes, intermediate nodes as rounded boxes, terminals as plain text and
+// packed nodes as small circles, each labelled with its span. An invalid forest
+// yields an empty graph.
+func (f *Forest) ToDOT() string {
+	var b strings.Builder
+	b.WriteString("digraph forest {\n\tnode [shape=box];\n")
+	for _, e := range f.exportNodes() {
+		attrs := ""
+		switch e.kind {
+		case "intermediate":
+			attrs = ", style=rounded"
+		case "terminal":
+			attrs = ", shape=plaintext"
+		case "error":
+			attrs = ", shape=plaintext, fontcolor=red"
+		}
+		fmt.Fprintf(&b, "\tn%d [label=%s%s];\n", e.id, dotQuote(e.label), attrs)
+		for _, p := range e.packs {
+			fmt.Fprintf(&b, "\tp%d [label=%s, shape=circle, fontsize=8];\n", p.id, dotQuote(p.label()))
+			fmt.Fprintf(&b, "\tn%d -> p%d;\n", e.id, p.id)
+			for _, c := range p.children {
+				fmt.Fprintf(&b, "\tp%d -> n%d;\n", p.id, c)
+			}
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// ToMermaid produces a mermaid flowchart of the forest, with the same nodes as
+// ToDOT.
+func (f *Forest) ToMermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	for _, e := range f.exportNodes() {
+		open, close := "[", "]"
+		switch e.kind {
+		case "intermediate":
+			open, close = "(", ")"
+		case "terminal", "error":
+			open, close = "[/", "/]"
+		}
+		fmt.Fprintf(&b, "    n%d%s%s%s\n", e.id, open, mermaidQuote(e.label), close)
+		for _, p := range e.packs {
+			fmt.Fprintf(&b, "    p%d((%s))\n", p.id, mermaidQuote(p.label()))
+			fmt.Fprintf(&b, "    n%d --> p%d\n", e.id, p.id)
+			for _, c := range p.children {
+				fmt.Fprintf(&b, "    p%d --> n%d\n", p.id, c)
+			}
+		}
+	}
+	return b.String()
+}
+
+// dotQuote quotes s as a DOT string: backslashes would otherwise start DOT's
+// own escapes (\l, \N...), and a newline is kept as a centered line break.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// mermaidQuote quotes s as a mermaid label. Inside quotes, mermaid still reads
+// `#...;` as an entity code, HTML tags and backtick markdown strings, so those
+// characters are written as entity codes too.
+func mermaidQuote(s string) string {
+	return `"` + mermaidEscaper.Replace(s) + `"`
+}
+
+var mermaidEscaper = strings.NewReplacer(
+	`"`, "#quot;",
+	"#", "#35;",
+	"&", "#amp;",
+	"<", "#lt;",
+	">", "#gt;",
+	"`", "#96;",
+	"\n", "#10;",
+)
+
+type forestJSON struct {
+	Rule  string           `json:"rule"`
+	Input string           `json:"input"`
+	Valid bool             `json:"valid"`
+	Nodes []forestNodeJSON `json:"nodes"`
+}
+
+type forestNodeJSON struct {
+	ID     int              `json:"id"`
+	Kind   string           `json:"kind"`
+	Symbol string           `json:"symbol,omitempty"`
+	Slot   string           `json:"slot,omitempty"`
+	Start  int              `json:"start"`
+	End    int              `json:"end"`
+	Packs  []forestPackJSON `json:"packs,omitempty"`
+}
+
+type forestPackJSON struct {
+	Alternative int   `json:"alternative"`
+	Pivot       int   `json:"pivot"`
+	Children    []int `json:"children"`
+}
+
+// MarshalJSON encodes the forest as the list of its nodes, the root first.
+// Each node has an id, a kind ("symbol", "intermediate", "terminal" or
+// "error"), a span, the symbol or slot it derives, and its packed nodes which
+// refer to their children by id.
+func (f *Forest) MarshalJSON() ([]byte, error) {
+	out := forestJSON{Rule: f.rulename, Input: string(f.input), Valid: f.Valid(), Nodes: []forestNodeJSON{}}
+	for _, e := range f.exportNodes() {
+		n := forestNodeJSON{ID: e.id, Kind: e.kind, Start: e.n.Start, End: e.n.End}
+		switch e.n.kind {
+		case gSymbol:
+			n.Symbol = f.sg.symbolName(e.n.nt)
+		case gInter:
+			n.Slot = f.sg.slotString(e.n.L)
+		}
+		for _, p := range e.packs {
+			n.Packs = append(n.Packs, forestPackJSON{Alternative: p.p.Alternative, Pivot: p.p.Pivot, Children: p.children})
+		}
+		out.Nodes = append(out.Nodes, n)
+	}
+	return json.Marshal(out)
+}
+
+// bsrSlot groups the elements of a slot, and in a counted repetition of a
+// count of elements before the production.
+type bsrSlot struct {
+	slot slot
+	rc   int
+}
+
+// bsrSlots returns the elements of the BSR set grouped by slot, both in order.
+func (f *BSRForest) bsrSlots() ([]bsrSlot, map[bsrSlot][]bsrElem) {
+	per := map[bsrSlot][]bsrElem{}
+	for e := range f.set {
+		k := bsrSlot{e.slot, e.rc}
+		per[k] = append(per[k], e)
+	}
+	slots := make([]bsrSlot, 0, len(per))
+	for sl, elems := range per {
+		slots = append(slots, sl)
+		slices.SortFunc(elems, func(a, b bsrElem) int {
+			return cmp.Or(cmp.Compare(a.l, b.l), cmp.Compare(a.k, b.k), cmp.Compare(a.r, b.r))
+		})
+	}
+	slices.SortFunc(slots, func(a, b bsrSlot) int {
+		return cmp.Or(cmp.Compare(a.slot.nt, b.slot.nt), cmp.Compare(a.slot.alt, b.slot.alt),
+			cmp.Compare(a.slot.dot, b.slot.dot), cmp.Compare(a.rc, b.rc))
+	})
+	return slots, per
+}
+
+// bsrSlotString renders a slot as slotString does, followed by the count for
+// the slots of a counted repetition.
+func (f *BSRForest) bsrSlotString(sl bsrSlot) string {
+	if f.sg.nts[sl.slot.nt].isRep {
+		return fmt.Sprintf("%s (count %d)", f.sg.slotString(sl.slot), sl.rc)
+	}
+	return f.sg.slotString(sl.slot)
+}
+
+// ToDOT produces a Graphviz representation of the BSR set: one cluster per
+// slot, listing its elements as (left, pivot, right) extents. The slots of a
+// counted repetition have a cluster per count of elements before them.
+func (f *BSRForest) ToDOT() string {
+	var b strings.Builder
+	b.WriteString("digraph bsr {\n\tnode [shape=box];\n")
+	slots, per := f.bsrSlots()
+	id := 0
+	for k, sl := range slots {
+		fmt.Fprintf(&b, "\tsubgraph cluster_%d {\n\t\tlabel=%s;\n", k, dotQuote(f.bsrSlotString(sl)))
+		for _, e := range per[sl] {
+			fmt.Fprintf(&b, "\t\te%d [label=\"(%d, %d, %d)\"];\n", id, e.l, e.k, e.r)
+			id++
+		}
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// ToMermaid produces a mermaid flowchart of the BSR set, with one subgraph per
+// slot as ToDOT does.
+func (f *BSRForest) ToMermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	slots, per := f.bsrSlots()
+	id := 0
+	for k, sl := range slots {
+		fmt.Fprintf(&b, "    subgraph s%d [%s]\n", k, mermaidQuote(f.bsrSlotString(sl)))
+		for _, e := range per[sl] {
+			fmt.Fprintf(&b, "        e%d[\"(%d, %d, %d)\"]\n", id, e.l, e.k, e.r)
+			id++
+		}
+		b.WriteString("    end\n")
+	}
+	return b.String()
+}
+
+type bsrJSON struct {
+	Rule  string        `json:"rule"`
+	Input string        `json:"input"`
+	Valid bool          `json:"valid"`
+	Slots []bsrSlotJSON `json:"slots"`
+}
+
+type bsrSlotJSON struct {
+	Slot     string   `json:"slot"`
+	Count    *int     `json:"count,omitempty"`
+	Elements [][3]int `json:"elements"`
+}
+
+// MarshalJSON encodes the BSR set as the list of its slots, each with its
+// elements as [left, pivot, right] extents. The slots of a counted repetition
+// come once per count of elements before them, given as count.
+func (f *BSRForest) MarshalJSON() ([]byte, error) {
+	out := bsrJSON{Rule: f.rulename, Input: string(f.input), Valid: f.Valid(), Slots: []bsrSlotJSON{}}
+	slots, per := f.bsrSlots()
+	for _, sl := range slots {
+		s := bsrSlotJSON{Slot: f.sg.slotString(sl.slot)}
+		if f.sg.nts[sl.slot.nt].isRep {
+			s.Count = &sl.rc
+		}
+		for _, e := range per[sl] {
+			s.Elements = append(s.Elements, [3]int{e.l, e.k, e.r})
+		}
+		out.Slots = append(out.Slots, s)
+	}
+	return json.Marshal(out)
+}
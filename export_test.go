@@ -0,0 +1,199 @@
+package goabnf
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_ForestToDOT(t *testing.T) {
+	g := mustGrammar("a = a \"+\" a / \"x\"\r\n")
+	f, err := ParseForest([]byte("x+x"), g, "a")
+	require.NoError(t, err)
+
+	assert.Equal(t, `digraph forest {
+	node [shape=box];
+	n0 [label="a [0,3)"];
+	p0 [label="alt 0, pivot 2", shape=circle, fontsize=8];
+	n0 -> p0;
+	p0 -> n1;
+	p0 -> n5;
+	n1 [label="a = a \"+\" · a [0,2)", style=rounded];
+	p1 [label="alt 0, pivot 1", shape=circle, fontsize=8];
+	n1 -> p1;
+	p1 -> n2;
+	p1 -> n4;
+	n2 [label="a [0,1)"];
+	p2 [label="alt 1, pivot 0", shape=circle, fontsize=8];
+	n2 -> p2;
+	p2 -> n3;
+	n3 [label="\"x\" [0,1)", shape=plaintext];
+	n4 [label="\"+\" [1,2)", shape=plaintext];
+	n5 [label="a [2,3)"];
+	p3 [label="alt 1, pivot 2", shape=circle, fontsize=8];
+	n5 -> p3;
+	p3 -> n6;
+	n6 [label="\"x\" [2,3)", shape=plaintext];
+}
+`, f.ToDOT())
+
+	mmd := f.ToMermaid()
+	assert.True(t, strings.HasPrefix(mmd, "flowchart TD\n"))
+	assert.Contains(t, mmd, `    n1("a = a #quot;+#quot; · a [0,2)")`)
+	assert.Contains(t, mmd, `    p0(("alt 0, pivot 2"))`)
+	assert.Contains(t, mmd, "    p0 --> n5\n")
+
+	// An invalid forest has no node.
+	f, err = ParseForest([]byte("x+"), g, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "digraph forest {\n\tnode [shape=box];\n}\n", f.ToDOT())
+	assert.Equal(t, "flowchart TD\n", f.ToMermaid())
+}
+
+// Test_U_ForestExport_Escaping pins that labels are escaped for DOT and mermaid
+// rather than with Go's string escapes.
+func Test_U_ForestExport_Escaping(t *testing.T) {
+	g := mustGrammar("a = %x5C \"#<\"\r\n")
+	f, err := ParseForest([]byte(`\#<`), g, "a")
+	require.NoError(t, err)
+
+	dot := f.ToDOT()
+	assert.Contains(t, dot, `	n1 [label="\"\\\\\" [0,1)", shape=plaintext];`)
+	assert.Contains(t, dot, `	n2 [label="\"#<\" [1,3)", shape=plaintext];`)
+	assert.Equal(t, `"a\nb \\l é"`, dotQuote("a\nb \\l é"))
+
+	mmd := f.ToMermaid()
+	assert.Contains(t, mmd, `    n1[/"#quot;\\#quot; [0,1)"/]`)
+	assert.Contains(t, mmd, `    n2[/"#quot;#35;#lt;#quot; [1,3)"/]`)
+	assert.Equal(t, `"#96;a#96; #amp; b#gt;"`, mermaidQuote("`a` & b>"))
+}
+
+func Test_U_ForestMarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Grammar       string
+		Input         string
+		ExpectedValid bool
+		ExpectedKinds map[string]int
+		ExpectedPacks int
+	}{
+		"unambiguous": {
+			Grammar:       "a = a \"+\" a / \"x\"\r\n",
+			Input:         "x+x",
+			ExpectedValid: true,
+			ExpectedKinds: map[string]int{"symbol": 3, "intermediate": 1, "terminal": 3},
+			ExpectedPacks: 4,
+		},
+		"ambiguous": {
+			// The symbol node of the whole input has two packs.
+			Grammar:       "a = a \"+\" a / \"x\"\r\n",
+			Input:         "x+x+x",
+			ExpectedValid: true,
+			ExpectedKinds: map[string]int{"symbol": 6, "intermediate": 3, "terminal": 5},
+			ExpectedPacks: 10,
+		},
+		"invalid": {
+			Grammar:       "a = \"x\"\r\n",
+			Input:         "y",
+			ExpectedValid: false,
+			ExpectedKinds: map[string]int{},
+			ExpectedPacks: 0,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := ParseForest([]byte(tt.Input), mustGrammar(tt.Grammar), "a")
+			require.NoError(t, err)
+			b, err := json.Marshal(f)
+			require.NoError(t, err)
+
+			var out struct {
+				Rule  string
+				Input string
+				Valid bool
+				Nodes []struct {
+					ID         int
+					Kind       string
+					Symbol     string
+					Start, End int
+					Packs      []struct {
+						Alternative int
+						Pivot       int
+						Children    []int
+					}
+				}
+			}
+			require.NoError(t, json.Unmarshal(b, &out))
+			assert.Equal(t, "a", out.Rule)
+			assert.Equal(t, tt.Input, out.Input)
+			assert.Equal(t, tt.ExpectedValid, out.Valid)
+
+			kinds := map[string]int{}
+			packs := 0
+			for k, n := range out.Nodes {
+				assert.Equal(t, k, n.ID)
+				kinds[n.Kind]++
+				for _, p := range n.Packs {
+					packs++
+					for _, c := range p.Children {
+						assert.Less(t, c, len(out.Nodes))
+					}
+				}
+			}
+			assert.Equal(t, tt.ExpectedKinds, kinds)
+			assert.Equal(t, tt.ExpectedPacks, packs)
+			if tt.ExpectedValid {
+				assert.Equal(t, "a", out.Nodes[0].Symbol)
+				assert.Equal(t, len(tt.Input), out.Nodes[0].End)
+			}
+		})
+	}
+}
+
+func Test_U_BSRForestExport(t *testing.T) {
+	g := mustGrammar("a = a \"+\" a / \"x\"\r\n")
+	f, err := ParseBSR([]byte("x+x"), g, "a")
+	require.NoError(t, err)
+
+	b, err := json.Marshal(f)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"rule": "a",
+		"input": "x+x",
+		"valid": true,
+		"slots": [
+			{"slot": "a = a \"+\" · a", "elements": [[0, 1, 2]]},
+			{"slot": "a = a \"+\" a ·", "elements": [[0, 2, 3]]},
+			{"slot": "a = \"x\" ·", "elements": [[0, 0, 1], [2, 2, 3]]}
+		]
+	}`, string(b))
+
+	assert.Equal(t, `digraph bsr {
+	node [shape=box];
+	subgraph cluster_0 {
+		label="a = a \"+\" · a";
+		e0 [label="(0, 1, 2)"];
+	}
+	subgraph cluster_1 {
+		label="a = a \"+\" a ·";
+		e1 [label="(0, 2, 3)"];
+	}
+	subgraph cluster_2 {
+		label="a = \"x\" ·";
+		e2 [label="(0, 0, 1)"];
+		e3 [label="(2, 2, 3)"];
+	}
+}
+`, f.ToDOT())
+
+	mmd := f.ToMermaid()
+	assert.Contains(t, mmd, `    subgraph s2 ["a = #quot;x#quot; ·"]`)
+	assert.Contains(t, mmd, `        e3["(2, 2, 3)"]`)
+}
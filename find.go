@@ -0,0 +1,81 @@
+package goabnf
+
+// find.go searches a text for the spans a rule derives, grep-style. Every start
+// offset is a prefix-match query (see match.go) against a single recognizer, so
+// the position sets computed for one offset are reused by all the others instead
+// of re-parsing from scratch at each offset.
+
+// Span is a half-open byte range [Start,End) of an input.
+type Span struct {
+	Start, End int
+}
+
+// FindMode selects which spans FindAll reports.
+type FindMode int
+
+const (
+	// FindLeftmostLongest reports non-overlapping spans, scanning left to right
+	// and taking the longest match at each start, as regexp and grep do.
+	FindLeftmostLongest FindMode = iota
+	// FindOverlapping reports every span the rule derives, including spans
+	// nested in or overlapping others, ordered by start then end.
+	FindOverlapping
+)
+
+// FindOption configures FindAll.
+type FindOption interface {
+	apply(*findOptions)
+}
+
+type findOptions struct {
+	mode FindMode
+}
+
+type findModeOption FindMode
+
+var _ FindOption = (*findModeOption)(nil)
+
+func (o findModeOption) apply(opts *findOptions) {
+	opts.mode = FindMode(o)
+}
+
+// WithFindMode selects the spans FindAll reports.
+// Default is FindLeftmostLongest.
+func WithFindMode(mode FindMode) FindOption {
+	return findModeOption(mode)
+}
+
+// FindAll returns the spans of text derivable from rulename, e.g. every URI in a
+// log file under the RFC 3986 grammar. Empty matches are never reported, as they
+// would occur at every offset of a nullable rule.
+func (g *Grammar) FindAll(rulename string, text []byte, opts ...FindOption) ([]Span, error) {
+	if GetRule(rulename, g.Rulemap) == nil {
+		return nil, &ErrRuleNotFound{Rulename: rulename}
+	}
+	o := &findOptions{mode: FindLeftmostLongest}
+	for _, opt := range opts {
+		opt.apply(o)
+	}
+
+	r := newRecognizer(g, text)
+	var out []Span
+	for p := 0; p < len(text); {
+		ends := r.prefixes(rulename, p)
+		switch o.mode {
+		case FindOverlapping:
+			for _, e := range ends {
+				if e > 0 {
+					out = append(out, Span{Start: p, End: p + e})
+				}
+			}
+		default:
+			if n := len(ends); n > 0 && ends[n-1] > 0 {
+				out = append(out, Span{Start: p, End: p + ends[n-1]})
+				p += ends[n-1]
+				continue
+			}
+		}
+		p++
+	}
+	return out, nil
+}
@@ -0,0 +1,59 @@
+package goabnf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_FindAll(t *testing.T) {
+	g := mustGrammar("date = 4DIGIT \"-\" 2DIGIT \"-\" 2DIGIT\r\nnum = 1*DIGIT\r\n")
+	text := []byte("on 2024-01-31 and 1999-12-01, not 99-1-1")
+
+	spans, err := g.FindAll("date", text)
+	require.NoError(t, err)
+	assert.Equal(t, []Span{{3, 13}, {18, 28}}, spans)
+	assert.Equal(t, "1999-12-01", string(text[spans[1].Start:spans[1].End]))
+
+	// Leftmost-longest does not overlap; overlapping reports every span.
+	spans, err = g.FindAll("num", []byte("a 123"))
+	require.NoError(t, err)
+	assert.Equal(t, []Span{{2, 5}}, spans)
+
+	spans, err = g.FindAll("num", []byte("a 123"), WithFindMode(FindOverlapping))
+	require.NoError(t, err)
+	assert.Equal(t, []Span{{2, 3}, {2, 4}, {2, 5}, {3, 4}, {3, 5}, {4, 5}}, spans)
+
+	// Nullable rules never report empty spans.
+	spans, err = mustGrammar("a = *\"x\"\r\n").FindAll("a", []byte("yxxy"))
+	require.NoError(t, err)
+	assert.Equal(t, []Span{{1, 3}}, spans)
+
+	_, err = g.FindAll("nope", text)
+	assert.IsType(t, &ErrRuleNotFound{}, err)
+}
+
+// Test_I_FindAll_IsValid pins FindOverlapping against the recognizer: a span is
+// reported iff the rule derives exactly the bytes it covers.
+func Test_I_FindAll_IsValid(t *testing.T) {
+	for _, c := range invariantCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			g := mustGrammar(c.src + "\r\n")
+			for _, in := range enumerate(c.alpha+"-", 4) {
+				spans, err := g.FindAll("a", []byte(in), WithFindMode(FindOverlapping))
+				require.NoError(t, err)
+				got := map[Span]bool{}
+				for _, s := range spans {
+					got[s] = true
+				}
+				for i := 0; i < len(in); i++ {
+					for j := i + 1; j <= len(in); j++ {
+						want, _ := g.IsValid("a", []byte(in[i:j]))
+						assert.Equalf(t, want, got[Span{i, j}], "span [%d,%d) of %q", i, j, in)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package goabnf
+
+import (
+	"slices"
+	"unicode"
+)
+
+// CaseFolding selects which characters a case-insensitive char-val treats as
+// the same. RFC 7405 only folds the US-ASCII letters, so "k" matches "k" and
+// "K"; identifiers of internationalized formats want Unicode case folding, so
+// that "café" matches "CAFÉ" and "k" also matches the Kelvin sign U+212A.
+//
+// Case-sensitive char-vals (%s"...") match exactly in both cases.
+type CaseFolding int
+
+const (
+	// FoldASCII is the RFC 7405 behavior: only A-Z and a-z are folded. It is
+	// the default.
+	FoldASCII CaseFolding = iota
+	// FoldUnicode folds with Unicode simple case folding, as the (?i) flag of
+	// Go's regexp package does: two characters are the same when
+	// unicode.SimpleFold cycles from one to the other. It also lets char-vals
+	// hold any non-ASCII character, which RFC 5234 does not.
+	FoldUnicode
+)
+
+func (f CaseFolding) String() string {
+	if f == FoldUnicode {
+		return "unicode"
+	}
+	return "ascii"
+}
+
+// equal reports whether the characters a and b are the same once folded.
+func (f CaseFolding) equal(a, b rune) bool {
+	if a == b {
+		return true
+	}
+	if f == FoldUnicode {
+		return foldKey(a) == foldKey(b)
+	}
+	return runeMin(a) == runeMin(b)
+}
+
+// variants returns the characters r folds with, r included, in increasing
+// order.
+func (f CaseFolding) variants(r rune) []rune {
+	if f == FoldUnicode {
+		vs := []rune{r}
+		for s := unicode.SimpleFold(r); s != r; s = unicode.SimpleFold(s) {
+			vs = append(vs, s)
+		}
+		slices.Sort(vs)
+		return vs
+	}
+	if lo, up := runeMin(r), runeMax(r); lo != up {
+		return []rune{up, lo}
+	}
+	return []rune{r}
+}
+
+// foldKey returns the smallest character of the Unicode simple case folding
+// orbit of r, the same for all the characters that fold together.
+func foldKey(r rune) rune {
+	k := r
+	for s := unicode.SimpleFold(r); s != r; s = unicode.SimpleFold(s) {
+		k = min(k, s)
+	}
+	return k
+}
+
+// charVariants returns the characters a char-val accepts for r.
+func charVariants(v ElemCharVal, r rune, f CaseFolding) []rune {
+	if v.Sensitive {
+		return []rune{r}
+	}
+	return f.variants(r)
+}
+
+// foldsAsASCII reports whether f folds r as RFC 7405 does.
+func foldsAsASCII(r rune, f CaseFolding) bool {
+	return slices.Equal(f.variants(r), FoldASCII.variants(r))
+}
+
+// unicodeCharVals returns the ABNF meta-grammar with char-vals extended to any
+// non-ASCII character, for grammars using FoldUnicode.
+func unicodeCharVals() *Grammar {
+	qs := *abnfQuotedString
+	qs.Alternation.Concatenations = slices.Clone(qs.Alternation.Concatenations)
+	c := &qs.Alternation.Concatenations[0]
+	c.Repetitions = slices.Clone(c.Repetitions)
+	group := c.Repetitions[1].Element.(ElemGroup)
+	group.Alternation.Concatenations = append(slices.Clone(group.Alternation.Concatenations), Concatenation{
+		Repetitions: []Repetition{{
+			Min: 1,
+			Max: 1,
+			Element: ElemNumVal{
+				Base:   "x",
+				Status: StatRange,
+				Elems:  []string{"80", "10FFFF"},
+			},
+		}},
+	})
+	c.Repetitions[1].Element = group
+
+	g := &Grammar{Rulemap: make(map[string]*Rule, len(ABNF.Rulemap))}
+	for name, r := range ABNF.Rulemap {
+		g.Rulemap[name] = r
+	}
+	g.Rulemap[qs.Name] = &qs
+	return g
+}
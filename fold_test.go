@@ -0,0 +1,238 @@
+package goabnf
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// foldCases are grammars whose char-vals fold differently under FoldASCII and
+// FoldUnicode, with inputs telling the two apart.
+var foldCases = []struct {
+	name      string
+	src       string
+	inputs    []string
+	ascii     []bool // expected validity with FoldASCII, nil if the grammar is rejected
+	unicode   []bool // expected validity with FoldUnicode
+	alternate string // an alternate casing the generators must produce with FoldUnicode
+}{
+	{
+		name:      "kelvin",
+		src:       `a = "k"`,
+		inputs:    []string{"k", "K", "\u212A", "x"},
+		ascii:     []bool{true, true, false, false},
+		unicode:   []bool{true, true, true, false},
+		alternate: "\u212A",
+	},
+	{
+		name:      "long-s",
+		src:       `a = 1*"s"`,
+		inputs:    []string{"sS", "ſ", "sſS", "ß"},
+		ascii:     []bool{true, false, false, false},
+		unicode:   []bool{true, true, true, false},
+		alternate: "ſ",
+	},
+	{
+		name:      "cafe",
+		src:       `a = "café"`,
+		inputs:    []string{"café", "CAFÉ", "Café", "CAFE", "cafe\u0301"},
+		unicode:   []bool{true, true, true, false, false},
+		alternate: "É",
+	},
+	{
+		name:      "sigma",
+		src:       `a = "σ" / "ß"`,
+		inputs:    []string{"σ", "Σ", "ς", "s", "ß", "ẞ", "ss"},
+		unicode:   []bool{true, true, true, false, true, true, false},
+		alternate: "ς",
+	},
+	{
+		name:      "sensitive",
+		src:       `a = %s"é" "s"`,
+		inputs:    []string{"és", "éS", "éſ", "És"},
+		unicode:   []bool{true, true, true, false},
+		alternate: "ſ",
+	},
+}
+
+func foldGrammar(t *testing.T, src string, mode MatchMode, folding CaseFolding) *Grammar {
+	g, err := ParseABNF([]byte(src+"\r\n"), WithMatchMode(mode), WithCaseFolding(folding))
+	require.NoError(t, err)
+	require.Equal(t, folding, g.Folding)
+	return g
+}
+
+// Test_U_CaseFolding pins that every recognizing engine folds char-vals the
+// same way: the recognizer, the streaming recognizer, the SPPF, BSR and Earley
+// forests, the PEG parser and the regular expression, in both match modes.
+func Test_U_CaseFolding(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range foldCases {
+		for _, folding := range []CaseFolding{FoldASCII, FoldUnicode} {
+			expected := c.unicode
+			if folding == FoldASCII {
+				expected = c.ascii
+			}
+			if expected == nil {
+				continue
+			}
+			for _, mode := range []MatchMode{ModeUTF8, ModeBytes} {
+				t.Run(c.name+"/"+folding.String()+"/"+mode.String(), func(t *testing.T) {
+					t.Parallel()
+
+					g := foldGrammar(t, c.src, mode, folding)
+					pat, err := g.Regex("a")
+					require.NoError(t, err)
+					if folding == FoldUnicode && mode == ModeUTF8 {
+						assert.Contains(t, pat, "(?i:")
+					}
+					re := regexp.MustCompile("^(?:" + pat + ")$")
+
+					for k, in := range c.inputs {
+						b := []byte(in)
+						want := expected[k]
+
+						ok, err := g.IsValid("a", b)
+						require.NoError(t, err)
+						assert.Equalf(t, want, ok, "IsValid %q", in)
+						ok, err = g.IsValidReader("a", bytes.NewReader(b))
+						if want {
+							assert.NoErrorf(t, err, "IsValidReader %q", in)
+						}
+						assert.Equalf(t, want, ok, "IsValidReader %q", in)
+
+						sf, err := ParseForest(b, g, "a")
+						require.NoError(t, err)
+						assert.Equalf(t, want, sf.Valid(), "ParseForest %q", in)
+						bf, err := ParseBSR(b, g, "a")
+						require.NoError(t, err)
+						assert.Equalf(t, want, bf.Valid(), "ParseBSR %q", in)
+						ef, err := ParseEarley(b, g, "a")
+						require.NoError(t, err)
+						assert.Equalf(t, want, ef.Valid(), "ParseEarley %q", in)
+						tr, _ := g.ParsePEG("a", b)
+						assert.Equalf(t, want, tr != nil, "ParsePEG %q", in)
+
+						text := in
+						if mode == ModeBytes {
+							text = Latin1(b)
+						}
+						assert.Equalf(t, want, re.MatchString(text), "Regex %q", in)
+					}
+				})
+			}
+		}
+	}
+}
+
+// Test_U_CaseFolding_Generators pins that every generator emits the alternate
+// casings of FoldUnicode, and only accepted ones.
+func Test_U_CaseFolding_Generators(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range foldCases {
+		for _, mode := range []MatchMode{ModeUTF8, ModeBytes} {
+			t.Run(c.name+"/"+mode.String(), func(t *testing.T) {
+				t.Parallel()
+
+				g := foldGrammar(t, c.src, mode, FoldUnicode)
+				produced := map[string]map[string]bool{}
+				valid := func(what string, out []byte) {
+					ok, err := g.IsValid("a", out)
+					require.NoError(t, err)
+					assert.Truef(t, ok, "%s produced %q", what, out)
+					if produced[what] == nil {
+						produced[what] = map[string]bool{}
+					}
+					produced[what][string(out)] = true
+				}
+
+				r := rand.New(rand.NewSource(1))
+				ag, err := NewASTGenerator(g, "a")
+				require.NoError(t, err)
+				for seed := range int64(50) {
+					out, err := g.Generate(seed, "a", WithRepMax(3))
+					require.NoError(t, err)
+					valid("Generate", out)
+					valid("ASTGenerator", ag.GenerateRand(r))
+				}
+
+				for _, deflate := range []bool{false, true} {
+					tg, err := g.TransitionGraph("a", WithDeflateCharVals(deflate), WithRepetitionThreshold(3))
+					require.NoError(t, err)
+					gen, err := NewGenerator(tg)
+					require.NoError(t, err)
+					for range 50 {
+						valid("Generator", gen.GenerateRand(r))
+					}
+					rd := tg.Reader()
+					for n := 0; n < 100 && rd.Next(); n++ {
+						valid(fmt.Sprintf("TransitionGraphReader/%v", deflate), rd.Scan())
+					}
+				}
+
+				for what, outs := range produced {
+					found := false
+					for out := range outs {
+						found = found || bytes.Contains([]byte(out), []byte(c.alternate))
+					}
+					assert.Truef(t, found, "%s never produced %q", what, c.alternate)
+				}
+
+				// The sentences are enumerated over the folded characters.
+				d, err := g.DiffPEG("a", 5)
+				require.NoError(t, err)
+				assert.False(t, d.Differ())
+				assert.Positive(t, d.Checked)
+			})
+		}
+	}
+}
+
+func Test_U_CaseFolding_Validation(t *testing.T) {
+	src := []byte("a = \"é\"\r\n")
+
+	// RFC 5234 char-vals are US-ASCII only.
+	_, err := ParseABNF(src)
+	assert.Error(t, err)
+	g, err := ParseABNF(src, WithCaseFolding(FoldUnicode))
+	require.NoError(t, err)
+	assert.Equal(t, FoldUnicode, g.Folding)
+
+	// The default grammar keeps RFC 7405 folding.
+	g, err = ParseABNF([]byte("a = \"k\"\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, FoldASCII, g.Folding)
+}
+
+// Test_F_GeneratedParser_CaseFolding compiles the generated parsers of the fold
+// cases and checks they agree with the library.
+func Test_F_GeneratedParser_CaseFolding(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping functional codegen test in -short mode")
+	}
+
+	for _, c := range foldCases {
+		for _, folding := range []CaseFolding{FoldASCII, FoldUnicode} {
+			if folding == FoldASCII && c.ascii == nil {
+				continue
+			}
+			t.Run(c.name+"/"+folding.String(), func(t *testing.T) {
+				g := foldGrammar(t, c.src, ModeUTF8, folding)
+				var want []string
+				for _, in := range c.inputs {
+					ok, err := g.IsValid("a", []byte(in))
+					require.NoError(t, err)
+					want = append(want, fmt.Sprint(ok))
+				}
+				assert.Equal(t, want, runGeneratedParser(t, g, c.inputs))
+			})
+		}
+	}
+}
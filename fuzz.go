@@ -3,7 +3,7 @@ package goabnf
 import (
 	"fmt"
 	"math/rand"
-	"unicode/utf8"
+	"slices"
 )
 
 // fuzz.go turns a (fully expanded) transition graph into a total
@@ -288,7 +288,7 @@ func (g *Generator) walk(src source, trace *[]traceSeg) []byte {
 	steps := 0
 	for {
 		if node != emptyNode && producing(node) {
-			_, vt := nodeEmit(node, 0)
+			_, vt := g.tg.nodeEmit(node, 0)
 			if vt < 1 {
 				vt = 1
 			}
@@ -296,7 +296,7 @@ func (g *Generator) walk(src source, trace *[]traceSeg) []byte {
 			if vt > 1 {
 				idx = int32(src.intn(int(vt)))
 			}
-			b, _ := nodeEmit(node, idx)
+			b, _ := g.tg.nodeEmit(node, idx)
 			if trace != nil && len(b) > 0 {
 				*trace = append(*trace, traceSeg{off: len(out), length: len(b), elem: node.Elem})
 			}
@@ -433,7 +433,8 @@ func (g *Generator) nearMissCandidates(src source, base []byte, trace []traceSeg
 	// (1) Boundary violations: take a num-val range segment one step past its
 	// edge. These are the high-value off-by-one cases.
 	for _, seg := range trace {
-		nv, isRange := numvalRange(seg.elem)
+		mode := g.tg.grammar.Mode
+		nv, isRange := numvalRange(seg.elem, mode)
 		if !isRange {
 			continue
 		}
@@ -444,11 +445,11 @@ func (g *Generator) nearMissCandidates(src source, base []byte, trace []traceSeg
 			{rune(nv.min - 1), "below"},
 			{rune(nv.max + 1), "above"},
 		} {
-			if b.r < 0 || b.r > 0x10FFFF {
+			if b.r < 0 || b.r > mode.maxChar() {
 				continue
 			}
 			cand := cloneBytes(base)
-			repl := []byte(string(b.r))
+			repl := mode.appendChar(nil, b.r)
 			out := append(append(cloneBytes(cand[:seg.off]), repl...), cand[seg.off+seg.length:]...)
 			cs = append(cs, candidate{out, fmt.Sprintf("byte at offset %d is one %s num-val range %s", seg.off, b.what, nv.label)})
 		}
@@ -504,16 +505,16 @@ type numvalInfo struct {
 }
 
 // numvalRange reports whether elem is a num-val range and, if so, its bounds.
-func numvalRange(elem ElemItf) (numvalInfo, bool) {
+func numvalRange(elem ElemItf, mode MatchMode) (numvalInfo, bool) {
 	nv, ok := elem.(ElemNumVal)
 	if !ok || nv.Status != StatRange {
 		return numvalInfo{}, false
 	}
 	min, max := numvalToInt32(nv.Elems[0], nv.Base), numvalToInt32(nv.Elems[1], nv.Base)
-	// Clamp the upper bound to the Unicode range so the "just above" boundary
-	// probe (max+1) stays a meaningful rune rather than overflowing.
-	if max > utf8.MaxRune {
-		max = utf8.MaxRune
+	// Clamp the upper bound to the largest character so the "just above"
+	// boundary probe (max+1) stays meaningful rather than overflowing.
+	if mc := mode.maxChar(); max > mc {
+		max = mc
 	}
 	return numvalInfo{min: min, max: max, label: elem.String()}, true
 }
@@ -576,38 +577,69 @@ func (g *Generator) emissions(n *Node) ([]emission, bool) {
 	if e, seen := g.emitCache[id]; seen {
 		return e, g.emitOK[id]
 	}
-	e, ok := nodeEmissions(n)
+	e, ok := nodeEmissions(n, g.tg.grammar.Mode, g.tg.grammar.Folding)
 	g.emitCache[id] = e
 	g.emitOK[id] = ok
 	return e, ok
 }
 
-func nodeEmissions(n *Node) ([]emission, bool) {
+func nodeEmissions(n *Node, mode MatchMode, fold CaseFolding) ([]emission, bool) {
 	switch v := n.Elem.(type) {
 	case ElemCharVal:
-		e := emission{}
+		if v.Sensitive || fold == FoldASCII {
+			e := emission{}
+			for _, r := range v.Values {
+				if !v.Sensitive && ((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')) {
+					lo, up := r, r
+					if lo >= 'A' && lo <= 'Z' {
+						lo = lo - 'A' + 'a'
+					}
+					if up >= 'a' && up <= 'z' {
+						up = up - 'a' + 'A'
+					}
+					var s octetSet
+					s.add(byte(lo))
+					s.add(byte(up))
+					e.pos = append(e.pos, s)
+				} else {
+					for _, b := range []byte(string(r)) {
+						var s octetSet
+						s.add(b)
+						e.pos = append(e.pos, s)
+					}
+				}
+			}
+			return []emission{e}, true
+		}
+		// The variants of a character may be encoded in different lengths: as
+		// for a multi-byte range, one emission per length, each position
+		// holding the octets of the variants of that length.
+		out := []emission{{}}
 		for _, r := range v.Values {
-			if !v.Sensitive && ((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')) {
-				lo, up := r, r
-				if lo >= 'A' && lo <= 'Z' {
-					lo = lo - 'A' + 'a'
+			byLen := map[int][]octetSet{}
+			var lens []int
+			for _, c := range FoldUnicode.variants(r) {
+				bs := []byte(string(c))
+				if byLen[len(bs)] == nil {
+					byLen[len(bs)] = make([]octetSet, len(bs))
+					lens = append(lens, len(bs))
 				}
-				if up >= 'a' && up <= 'z' {
-					up = up - 'a' + 'A'
+				for j, b := range bs {
+					byLen[len(bs)][j].add(b)
 				}
-				var s octetSet
-				s.add(byte(lo))
-				s.add(byte(up))
-				e.pos = append(e.pos, s)
-			} else {
-				for _, b := range []byte(string(r)) {
-					var s octetSet
-					s.add(b)
-					e.pos = append(e.pos, s)
+			}
+			if len(out)*len(lens) > expectedNextRangeCap {
+				return nil, false
+			}
+			next := make([]emission, 0, len(out)*len(lens))
+			for _, e := range out {
+				for _, l := range lens {
+					next = append(next, emission{pos: append(slices.Clone(e.pos), byLen[l]...)})
 				}
 			}
+			out = next
 		}
-		return []emission{e}, true
+		return out, true
 
 	case ElemNumVal:
 		switch v.Status {
@@ -615,11 +647,11 @@ func nodeEmissions(n *Node) ([]emission, bool) {
 			e := emission{}
 			for _, el := range v.Elems {
 				r := numvalToRune(el, v.Base)
-				if !utf8.ValidRune(r) {
-					// An out-of-range element can never appear in UTF-8 input.
+				if !mode.validChar(r) {
+					// An out-of-range element can never appear in input.
 					return nil, false
 				}
-				for _, b := range []byte(string(r)) {
+				for _, b := range mode.appendChar(nil, r) {
 					var s octetSet
 					s.add(b)
 					e.pos = append(e.pos, s)
@@ -628,19 +660,20 @@ func nodeEmissions(n *Node) ([]emission, bool) {
 			return []emission{e}, true
 		case StatRange:
 			min, max := numvalToInt32(v.Elems[0], v.Base), numvalToInt32(v.Elems[1], v.Base)
-			// Clamp to the Unicode range first: otherwise an out-of-range bound
-			// near MaxInt32 overflows the int32 count below, bypasses the cap
-			// guard, and turns the enumeration loop into a DoS. A range wholly
-			// above U+10FFFF emits nothing.
-			if max > utf8.MaxRune {
-				max = utf8.MaxRune
+			// Clamp to the largest character first: otherwise an out-of-range
+			// bound near MaxInt32 overflows the int32 count below, bypasses the
+			// cap guard, and turns the enumeration loop into a DoS. A range
+			// wholly above it emits nothing.
+			mc := mode.maxChar()
+			if max > mc {
+				max = mc
 			}
-			if min > utf8.MaxRune || min > max {
+			if min > mc || min > max {
 				return nil, false
 			}
-			if max <= 0x7f { // ASCII: a single one-octet emission
+			if max <= 0x7f || mode == ModeBytes { // ASCII or octets: a single one-octet emission
 				var s octetSet
-				for b := min; b <= max && b <= 0x7f; b++ { // explicit ASCII bound for the conversion
+				for b := min; b <= max && b <= 0xff; b++ { // explicit octet bound for the conversion
 					s.add(byte(b))
 				}
 				return []emission{{pos: []octetSet{s}}}, true
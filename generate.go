@@ -5,7 +5,6 @@ import (
 	"maps"
 	"math/rand"
 	"strings"
-	"unicode/utf8"
 )
 
 // Generate is an experimental feature that consumes a seed for
@@ -71,31 +70,35 @@ func generateAlt(rand rand.Source, g *Grammar, out *[]byte, alt Alternation, opt
 				switch elem.Status {
 				case StatRange:
 					min, max := numvalToRune(elem.Elems[0], elem.Base), numvalToRune(elem.Elems[1], elem.Base)
-					// Generation emits UTF-8 text, so clamp to the representable
-					// (Unicode) subset; a range wholly above U+10FFFF (or with
-					// max < min) yields no character.
-					if max > utf8.MaxRune {
-						max = utf8.MaxRune
+					// Clamp to the representable subset (up to U+10FFFF, or %xFF
+					// in ModeBytes); a range wholly above it (or with max < min)
+					// yields no character.
+					if mc := g.Mode.maxChar(); max > mc {
+						max = mc
 					}
-					if min <= utf8.MaxRune && min <= max {
+					if min <= g.Mode.maxChar() && min <= max {
 						r := min + (rune(rand.Int63()&0x0F) % (max - min + 1)) // mask 0x0F to keep the 32-bit part (rune size)
-						appendPtr(out, r)
+						*out = g.Mode.appendChar(*out, r)
 					}
 
 				case StatSeries:
 					for _, v := range elem.Elems {
 						r := numvalToRune(v, elem.Base)
 						// Skip values that are not real characters; they cannot be
-						// emitted as UTF-8 text.
-						if utf8.ValidRune(r) {
-							appendPtr(out, r)
+						// emitted.
+						if g.Mode.validChar(r) {
+							*out = g.Mode.appendChar(*out, r)
 						}
 					}
 				}
 
 			case ElemCharVal:
 				for _, val := range elem.Values {
-					if !elem.Sensitive && (int(rand.Int63())%2) == 0 {
+					switch {
+					case !elem.Sensitive && g.Folding == FoldUnicode:
+						vs := g.Folding.variants(val)
+						val = vs[int(rand.Int63())%len(vs)]
+					case !elem.Sensitive && (int(rand.Int63())%2) == 0:
 						val = runeMax(val)
 					}
 					appendPtr(out, val)
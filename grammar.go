@@ -2,7 +2,6 @@ package goabnf
 
 import (
 	"fmt"
-	"slices"
 	"strconv"
 	"strings"
 )
@@ -11,6 +10,13 @@ import (
 // It is constituted of a set of rules with a unique name.
 type Grammar struct {
 	Rulemap map[string]*Rule
+
+	// Mode is what the values of num-vals denote, Unicode code points by
+	// default (see MatchMode).
+	Mode MatchMode
+	// Folding is how case-insensitive char-vals fold, as RFC 7405 by default
+	// (see CaseFolding).
+	Folding CaseFolding
 }
 
 // IsValid checks there exist at least a path that completly consumes
@@ -27,18 +33,25 @@ func (g *Grammar) IsValid(rulename string, input []byte) (bool, error) {
 	// (element, index) instead of enumerating paths, which keeps this
 	// polynomial. Left-recursive rules are resolved by seed-growing rather than
 	// refused; see recognize.go and leftrec.go.
-	r := &recognizer{
-		g:          g,
-		input:      input,
-		memo:       map[string]map[int]bool{},
-		inProgress: map[string]bool{},
-		leftRec:    g.leftRecursiveSCCs(),
-		growing:    map[string]map[int]bool{},
-	}
+	r := newRecognizer(g, input)
 	ends := r.reachElem(ElemRulename{Name: rulename}, 0)
 	return ends[len(input)], nil
 }
 
+// Check is IsValid with diagnostics: it returns nil when input is derivable
+// from rulename, and otherwise a [*ParseError] locating the furthest failure,
+// identical to the one ParseForest and ParseBSR report.
+func (g *Grammar) Check(rulename string, input []byte) error {
+	if GetRule(rulename, g.Rulemap) == nil {
+		return &ErrRuleNotFound{Rulename: rulename}
+	}
+	r := newRecognizer(g, input)
+	if r.reachElem(ElemRulename{Name: rulename}, 0)[len(input)] {
+		return nil
+	}
+	return r.parseError(input, 0)
+}
+
 // String returns the representation of the grammar that is valid
 // according to the ABNF specifications/RFCs.
 // This notably imply the use of CRLF instead of LF, and does not
@@ -81,7 +94,11 @@ func ParseABNF(input []byte, opts ...ABNFOption) (*Grammar, error) {
 	o := process(opts...)
 
 	// Parse the ABNF source using the ABNF meta-grammar with the GLL engine.
-	f, err := Parse(input, ABNF, "rulelist")
+	meta := ABNF
+	if o.folding == FoldUnicode {
+		meta = unicodeCharVals()
+	}
+	f, err := Parse(input, meta, "rulelist")
 	if err != nil {
 		return nil, err
 	}
@@ -138,30 +155,11 @@ type feval struct {
 	o     *abnfOptions
 }
 
-func ptChildren(t *ParseTree, name string) []*ParseTree {
-	var out []*ParseTree
-	for _, c := range t.Children {
-		if c.Rule == name {
-			out = append(out, c)
-		}
-	}
-	return out
-}
-
-func ptFirst(t *ParseTree, names ...string) *ParseTree {
-	for _, c := range t.Children {
-		if slices.Contains(names, c.Rule) {
-			return c
-		}
-	}
-	return nil
-}
-
-func (e *feval) span(t *ParseTree) string { return string(e.input[t.Start:t.End]) }
+func (e *feval) span(t *ParseTree) string { return t.Text(e.input) }
 
 func (e *feval) rulelist(t *ParseTree) (*Grammar, error) {
 	mp := map[string]*Rule{}
-	for _, rc := range ptChildren(t, "rule") {
+	for _, rc := range t.ChildrenOf("rule") {
 		rl, definedAs, err := e.rule(rc)
 		if err != nil {
 			return nil, err
@@ -190,13 +188,13 @@ func (e *feval) rulelist(t *ParseTree) (*Grammar, error) {
 			mp[rule.Name] = rule
 		}
 	}
-	return &Grammar{Rulemap: mp}, nil
+	return &Grammar{Rulemap: mp, Mode: e.o.mode, Folding: e.o.folding}, nil
 }
 
 func (e *feval) rule(t *ParseTree) (*Rule, string, error) {
-	nameNode := ptFirst(t, "rulename")
-	defNode := ptFirst(t, "defined-as")
-	elemsNode := ptFirst(t, "elements")
+	nameNode := t.Child("rulename")
+	defNode := t.Child("defined-as")
+	elemsNode := t.Child("elements")
 	if nameNode == nil || defNode == nil || elemsNode == nil {
 		return nil, "", ErrNoSolutionFound
 	}
@@ -204,7 +202,7 @@ func (e *feval) rule(t *ParseTree) (*Rule, string, error) {
 	if strings.Contains(e.span(defNode), "=/") {
 		definedAs = "=/"
 	}
-	altNode := ptFirst(elemsNode, "alternation")
+	altNode := elemsNode.Child("alternation")
 	if altNode == nil {
 		return nil, "", ErrNoSolutionFound
 	}
@@ -217,7 +215,7 @@ func (e *feval) rule(t *ParseTree) (*Rule, string, error) {
 
 func (e *feval) alternation(t *ParseTree) (Alternation, error) {
 	var cs []Concatenation
-	for _, cc := range ptChildren(t, "concatenation") {
+	for _, cc := range t.ChildrenOf("concatenation") {
 		c, err := e.concatenation(cc)
 		if err != nil {
 			return Alternation{}, err
@@ -229,7 +227,7 @@ func (e *feval) alternation(t *ParseTree) (Alternation, error) {
 
 func (e *feval) concatenation(t *ParseTree) (Concatenation, error) {
 	var rs []Repetition
-	for _, rc := range ptChildren(t, "repetition") {
+	for _, rc := range t.ChildrenOf("repetition") {
 		r, err := e.repetition(rc)
 		if err != nil {
 			return Concatenation{}, err
@@ -241,10 +239,10 @@ func (e *feval) concatenation(t *ParseTree) (Concatenation, error) {
 
 func (e *feval) repetition(t *ParseTree) (Repetition, error) {
 	min, max := 1, 1
-	if rep := ptFirst(t, "repeat"); rep != nil {
+	if rep := t.Child("repeat"); rep != nil {
 		min, max = e.parseRepeat(rep)
 	}
-	elNode := ptFirst(t, "element")
+	elNode := t.Child("element")
 	if elNode == nil {
 		return Repetition{}, ErrNoSolutionFound
 	}
@@ -273,7 +271,7 @@ func (e *feval) parseRepeat(t *ParseTree) (int, int) {
 }
 
 func (e *feval) element(t *ParseTree) (ElemItf, error) {
-	c := ptFirst(t, "rulename", "group", "option", "char-val", "num-val", "prose-val")
+	c := t.Child("rulename", "group", "option", "char-val", "num-val", "prose-val")
 	if c == nil {
 		return nil, ErrNoSolutionFound
 	}
@@ -281,13 +279,13 @@ func (e *feval) element(t *ParseTree) (ElemItf, error) {
 	case "rulename":
 		return ElemRulename{Name: e.span(c)}, nil
 	case "group":
-		alt, err := e.alternation(ptFirst(c, "alternation"))
+		alt, err := e.alternation(c.Child("alternation"))
 		if err != nil {
 			return nil, err
 		}
 		return ElemGroup{Alternation: alt}, nil
 	case "option":
-		alt, err := e.alternation(ptFirst(c, "alternation"))
+		alt, err := e.alternation(c.Child("alternation"))
 		if err != nil {
 			return nil, err
 		}
@@ -359,9 +357,9 @@ func (e *feval) proseVal(t *ParseTree) ElemProseVal {
 	return ElemProseVal{values: values}
 }
 
-func sensequal(target, actual rune, sensitive bool) bool {
+func sensequal(target, actual rune, sensitive bool, f CaseFolding) bool {
 	if !sensitive {
-		target, actual = runeMin(target), runeMin(actual)
+		return f.equal(target, actual)
 	}
 	return target == actual
 }
@@ -384,7 +382,7 @@ func runeMax(r rune) rune {
 // It currently support the following checks:
 // - for all rules, its dependencies (rules) exist
 // - for repetition, min <= max
-// - for num-val, that the value fits in 7-bits (US-ASCII encoded)
+// - for num-val, that the value is a character of the grammar's Mode
 // To update this list, please open an issue.
 func SemvalABNF(g *Grammar) error {
 	// Check all dependencies exist
@@ -401,14 +399,14 @@ func SemvalABNF(g *Grammar) error {
 	}
 
 	for _, rule := range g.Rulemap {
-		if err := semvalAlternation(rule.Alternation); err != nil {
+		if err := semvalAlternation(rule.Alternation, g.Mode); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func semvalAlternation(alt Alternation) error {
+func semvalAlternation(alt Alternation, mode MatchMode) error {
 	for _, concat := range alt.Concatenations {
 		for _, rep := range concat.Repetitions {
 			// min <= max
@@ -424,16 +422,22 @@ func semvalAlternation(alt Alternation) error {
 					if err := checkBounds(val, elem.Base); err != nil {
 						return err
 					}
+					if v, _ := numvalToUint64(val, elem.Base); mode == ModeBytes && v > 0xFF {
+						return &ErrTooLargeNumeral{
+							Base:  elem.Base,
+							Value: val,
+						}
+					}
 				}
 
 			// propagate recursion
 			case ElemGroup:
-				if err := semvalAlternation(elem.Alternation); err != nil {
+				if err := semvalAlternation(elem.Alternation, mode); err != nil {
 					return err
 				}
 
 			case ElemOption:
-				if err := semvalAlternation(elem.Alternation); err != nil {
+				if err := semvalAlternation(elem.Alternation, mode); err != nil {
 					return err
 				}
 			}
@@ -1,7 +1,10 @@
 package goabnf
 
 import (
+	"math/big"
 	"regexp"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -18,6 +21,26 @@ import (
 //     (ParseBSR) agree on validity for every grammar/input;
 //   - ParseForest and ParseBSR agree on NumTrees and Ambiguous (forest shape,
 //     not just acceptance);
+//   - on unambiguous inputs, ParseForest and ParseBSR extract the same tree,
+//     alternatives included;
+//   - the Earley parser (ParseEarley), which shares neither the slot lowering
+//     nor the GLL machinery, agrees with them on validity, NumTrees, Ambiguous
+//     and unambiguous trees;
+//   - Forest.Trees enumerates exactly NumTrees trees, in TreeAt's order;
+//   - Forest.AmbiguityReport finds a witness exactly when Ambiguous holds;
+//   - FindAmbiguity checks the same sentences as brute force over the
+//     alphabet, and stops at the same first ambiguous one;
+//   - the priority and longest-match filters keep the input valid, and keep
+//     only trees of the unfiltered forest;
+//   - ParsePEG only accepts inputs the grammar derives, with the SPPF tree
+//     when it is the only one, and DiffPEG finds a disagreement exactly when
+//     brute force over the alphabet does;
+//   - unparsing any parse tree of an input gives back the input;
+//   - DiffTrees finds no change between two parse trees exactly when they are
+//     the same derivation of the same input;
+//   - after any one-character edit, a Document agrees with a fresh ParseForest
+//     on validity and diagnostic, with its tree when it is the only one and
+//     one of its trees otherwise;
 //   - for regular grammars, Regex compiled and anchored matches IsValid;
 //   - every input produced by Generate is accepted by IsValid (generation is
 //     sound w.r.t. recognition);
@@ -40,7 +63,11 @@ var invariantCorpus = []invariantCase{
 	{"nested", `a = 1*2(2*3"a")`, "a", 8, false},
 	{"catalan", `a = a a / "a"`, "a", 6, false}, // Catalan ambiguity
 	{"leftrec", `a = a "a" / "a"`, "a", 6, false},
-	{"infamb", `a = a / "a"`, "a", 3, false}, // infinitely ambiguous (-1)
+	{"infamb", `a = a / "a"`, "a", 3, false},  // infinitely ambiguous (-1)
+	{"dupalt", `a = "a" / "a"`, "a", 2, true}, // same children, two alternates
+	{"rightrec", `a = "a" a / "a" / "b" a "b"`, "ab", 6, false},
+	{"nullopt", `a = *["a"] "b"`, "ab", 4, false}, // infinitely ambiguous (-1)
+	{"nullchain", `a = ["a" a] / "b" ["b"] a`, "ab", 5, false},
 }
 
 // enumerate returns every string over alpha up to length max (inclusive).
@@ -60,8 +87,9 @@ func enumerate(alpha string, max int) []string {
 	return out
 }
 
-// Test_I_Engines_Agree pins recognizer == SPPF == BSR on validity, and SPPF ==
-// BSR on tree count and ambiguity, plus Regex == IsValid for regular grammars.
+// Test_I_Engines_Agree pins recognizer == SPPF == BSR == Earley on validity, and
+// SPPF == BSR == Earley on tree count, ambiguity and unambiguous trees, plus
+// Regex == IsValid for regular grammars.
 func Test_I_Engines_Agree(t *testing.T) {
 	for _, c := range invariantCorpus {
 		c := c
@@ -82,12 +110,22 @@ func Test_I_Engines_Agree(t *testing.T) {
 				require.NoErrorf(t, err, "ParseForest %q", in)
 				bf, err := ParseBSR([]byte(in), g, "a")
 				require.NoErrorf(t, err, "ParseBSR %q", in)
+				ef, err := ParseEarley([]byte(in), g, "a")
+				require.NoErrorf(t, err, "ParseEarley %q", in)
 
 				assert.Equalf(t, rec, sf.Valid(), "recognizer vs SPPF on %q", in)
 				assert.Equalf(t, rec, bf.Valid(), "recognizer vs BSR on %q", in)
+				assert.Equalf(t, rec, ef.Valid(), "recognizer vs Earley on %q", in)
 				assert.Equalf(t, sf.NumTrees().String(), bf.NumTrees().String(),
 					"SPPF vs BSR NumTrees on %q", in)
+				assert.Equalf(t, sf.NumTrees().String(), ef.NumTrees().String(),
+					"SPPF vs Earley NumTrees on %q", in)
 				assert.Equalf(t, sf.Ambiguous(), bf.Ambiguous(), "SPPF vs BSR Ambiguous on %q", in)
+				assert.Equalf(t, sf.Ambiguous(), ef.Ambiguous(), "SPPF vs Earley Ambiguous on %q", in)
+				if rec && !sf.Ambiguous() {
+					assert.Equalf(t, sf.Tree(), bf.Tree(), "SPPF vs BSR Tree on %q", in)
+					assert.Equalf(t, sf.Tree(), ef.Tree(), "SPPF vs Earley Tree on %q", in)
+				}
 				if re != nil {
 					assert.Equalf(t, rec, re.MatchString(in), "Regex vs IsValid on %q", in)
 				}
@@ -96,6 +134,257 @@ func Test_I_Engines_Agree(t *testing.T) {
 	}
 }
 
+// Test_I_Trees_NumTrees pins tree enumeration against tree counting: a finite
+// forest yields the distinct trees of TreeAt over [0, NumTrees), in the order
+// of their first rank, and the order does not change from one parse to the
+// next.
+func Test_I_Trees_NumTrees(t *testing.T) {
+	for _, c := range invariantCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			g := mustGrammar(c.src + "\r\n")
+			for _, in := range enumerate(c.alpha, c.maxn) {
+				f, err := ParseForest([]byte(in), g, "a")
+				require.NoError(t, err)
+				trees := slices.Collect(f.Trees(0))
+				again, err := ParseForest([]byte(in), g, "a")
+				require.NoError(t, err)
+				assert.Equalf(t, trees, slices.Collect(again.Trees(0)), "order on %q", in)
+
+				n := f.NumTrees()
+				if n.Sign() < 0 {
+					assert.NotEmptyf(t, trees, "acyclic trees on %q", in)
+					continue
+				}
+				var ranked []*ParseTree
+				for i := int64(0); i < n.Int64(); i++ {
+					tr := f.TreeAt(big.NewInt(i))
+					if !slices.ContainsFunc(ranked, func(r *ParseTree) bool { return assert.ObjectsAreEqual(r, tr) }) {
+						ranked = append(ranked, tr)
+					}
+				}
+				assert.Equalf(t, ranked, trees, "Trees vs TreeAt on %q", in)
+			}
+		})
+	}
+}
+
+// Test_I_AmbiguityReport_Ambiguous pins that an ambiguity witness exists
+// exactly for ambiguous inputs.
+func Test_I_AmbiguityReport_Ambiguous(t *testing.T) {
+	for _, c := range invariantCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			g := mustGrammar(c.src + "\r\n")
+			for _, in := range enumerate(c.alpha, c.maxn) {
+				f, err := ParseForest([]byte(in), g, "a")
+				require.NoError(t, err)
+				r := f.AmbiguityReport()
+				if !f.Ambiguous() {
+					assert.Nilf(t, r, "report on %q", in)
+					continue
+				}
+				if assert.NotNilf(t, r, "report on %q", in) {
+					assert.Equalf(t, "a", r.Rule, "report on %q", in)
+				}
+			}
+		})
+	}
+}
+
+// Test_I_FindAmbiguity_BruteForce pins the sentence enumeration against the
+// brute-force one: every string over the alphabet up to the bound, kept when
+// valid, in the same shortest-first order.
+func Test_I_FindAmbiguity_BruteForce(t *testing.T) {
+	for _, c := range invariantCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			g := mustGrammar(c.src + "\r\n")
+			var first string
+			checked := 0
+			for _, in := range enumerate(c.alpha, c.maxn) {
+				f, err := ParseForest([]byte(in), g, "a")
+				require.NoError(t, err)
+				if !f.Valid() {
+					continue
+				}
+				checked++
+				if f.Ambiguous() {
+					first = in
+					break
+				}
+			}
+
+			s, err := g.FindAmbiguity("a", c.maxn)
+			require.NoError(t, err)
+			assert.Equal(t, checked, s.Checked)
+			assert.True(t, strings.EqualFold(first, string(s.Input)), "first ambiguous sentence")
+			if s.Ambiguous() {
+				assert.Equal(t, -1, s.UnambiguousUpTo)
+			} else {
+				assert.Equal(t, c.maxn, s.UnambiguousUpTo)
+			}
+		})
+	}
+}
+
+// Test_I_Disambiguate_Subset pins that the priority and longest-match filters
+// only select among the trees of a forest: validity is unchanged, and every tree
+// left is one of the unfiltered forest.
+func Test_I_Disambiguate_Subset(t *testing.T) {
+	for _, c := range invariantCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			g := mustGrammar(c.src + "\r\n")
+			for _, in := range enumerate(c.alpha, c.maxn) {
+				f, err := ParseForest([]byte(in), g, "a")
+				require.NoError(t, err)
+				filtered, err := ParseForest([]byte(in), g, "a", WithAlternativePriority(), WithLongestMatch())
+				require.NoError(t, err)
+
+				require.Equalf(t, f.Valid(), filtered.Valid(), "validity on %q", in)
+				all := slices.Collect(f.Trees(0))
+				for tr := range filtered.Trees(0) {
+					assert.Containsf(t, all, tr, "filtered tree on %q", in)
+				}
+				if n := filtered.NumTrees(); n.Sign() > 0 && f.NumTrees().Sign() > 0 {
+					assert.LessOrEqualf(t, n.Cmp(f.NumTrees()), 0, "NumTrees on %q", in)
+				}
+			}
+		})
+	}
+}
+
+// Test_I_PEG_Subset pins that the PEG reading accepts a subset of the grammar,
+// each time by one of its derivations, and that DiffPEG misses no disagreement.
+func Test_I_PEG_Subset(t *testing.T) {
+	for _, c := range invariantCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			g := mustGrammar(c.src + "\r\n")
+			differ := false
+			for _, in := range enumerate(c.alpha, c.maxn) {
+				f, err := ParseForest([]byte(in), g, "a")
+				require.NoError(t, err)
+				tr, err := g.ParsePEG("a", []byte(in))
+				if err != nil {
+					var pe *ParseError
+					require.ErrorAsf(t, err, &pe, "ParsePEG %q", in)
+					differ = differ || f.Valid()
+					continue
+				}
+				require.Truef(t, f.Valid(), "PEG accepts %q", in)
+				if !f.Ambiguous() {
+					assert.Equalf(t, f.Tree(), tr, "SPPF vs PEG Tree on %q", in)
+				} else {
+					assert.Containsf(t, slices.Collect(f.Trees(0)), tr, "PEG tree on %q", in)
+				}
+			}
+
+			d, err := g.DiffPEG("a", c.maxn)
+			require.NoError(t, err)
+			assert.Equal(t, differ, d.Differ())
+		})
+	}
+}
+
+// Test_I_Unparse_Identity pins that the children of a parse tree tile the span
+// of their parent: unparsed unedited, every tree gives back its input.
+func Test_I_Unparse_Identity(t *testing.T) {
+	for _, c := range invariantCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			g := mustGrammar(c.src + "\r\n")
+			for _, in := range enumerate(c.alpha, c.maxn) {
+				f, err := ParseForest([]byte(in), g, "a")
+				require.NoError(t, err)
+				if !f.Valid() {
+					continue
+				}
+				for tr := range f.Trees(50) {
+					assert.Equalf(t, in, string(tr.Unparse([]byte(in))), "Unparse on %q", in)
+				}
+			}
+		})
+	}
+}
+
+func Test_I_DiffTrees_Equal(t *testing.T) {
+	type parsed struct {
+		in   string
+		tree *ParseTree
+	}
+	for _, c := range invariantCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			g := mustGrammar(c.src + "\r\n")
+			var all []parsed
+			for _, in := range enumerate(c.alpha, c.maxn) {
+				f, err := ParseForest([]byte(in), g, "a")
+				require.NoError(t, err)
+				if !f.Valid() {
+					continue
+				}
+				for tr := range f.Trees(5) {
+					all = append(all, parsed{in, tr})
+				}
+				if len(all) >= 40 {
+					break
+				}
+			}
+			for _, x := range all {
+				for _, y := range all {
+					d := DiffTrees(x.tree, []byte(x.in), y.tree, []byte(y.in))
+					same := x.in == y.in && assert.ObjectsAreEqual(x.tree, y.tree)
+					assert.Equalf(t, same, d.Equal(), "DiffTrees on %q and %q:\n%s", x.in, y.in, d)
+				}
+			}
+		})
+	}
+}
+
+// oneCharEdits returns every insertion, deletion and replacement of a single
+// character of alpha in in.
+func oneCharEdits(in, alpha string) []Edit {
+	var out []Edit
+	for i := 0; i <= len(in); i++ {
+		for _, c := range alpha {
+			out = append(out, Edit{Start: i, End: i, NewText: []byte(string(c))})
+			if i < len(in) {
+				out = append(out, Edit{Start: i, End: i + 1, NewText: []byte(string(c))})
+			}
+		}
+		if i < len(in) {
+			out = append(out, Edit{Start: i, End: i + 1})
+		}
+	}
+	return out
+}
+
+// Test_I_Document_ParseForest pins incremental reparsing against parsing from
+// scratch, for every one-character edit of every short input.
+func Test_I_Document_ParseForest(t *testing.T) {
+	for _, c := range invariantCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			g := mustGrammar(c.src + "\r\n")
+			for _, in := range enumerate(c.alpha, c.maxn-1) {
+				for _, e := range oneCharEdits(in, c.alpha) {
+					d, err := NewDocument(g, "a", []byte(in))
+					require.NoError(t, err)
+					_, err = d.Apply(e)
+					require.NoError(t, err)
+					out := d.Input()
+					f, err := ParseForest(out, g, "a")
+					require.NoError(t, err)
+
+					assert.Equalf(t, f.Valid(), d.Valid(), "%q edited to %q", in, out)
+					assert.Equalf(t, f.ParseError(), d.ParseError(), "%q edited to %q", in, out)
+					switch {
+					case !f.Valid():
+					case !f.Ambiguous():
+						assert.Equalf(t, f.Tree(), d.Tree(), "%q edited to %q", in, out)
+					case f.NumTrees().Sign() > 0:
+						assert.Containsf(t, slices.Collect(f.Trees(0)), d.Tree(), "%q edited to %q", in, out)
+					}
+				}
+			}
+		})
+	}
+}
+
 // Test_I_Generate_IsValid pins that generation is sound: every input Generate
 // produces from a grammar is accepted by that grammar's recognizer.
 func Test_I_Generate_IsValid(t *testing.T) {
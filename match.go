@@ -0,0 +1,119 @@
+package goabnf
+
+import (
+	"slices"
+)
+
+// match.go answers "how much of the input can this rule consume?". The
+// recognizer already computes, for a rule at a start index, the set of every
+// reachable end index; IsValid only checks whether len(input) is among them.
+// Exposing the whole set gives prefix matching for free, and because the
+// recognizer memoizes per (element, index), a Scanner reusing one recognizer
+// across offsets shares all the work two tokens have in common.
+
+// MatchPrefix returns, in increasing order, the length of every prefix of input
+// derivable from rulename. It is empty when no prefix matches; a length of 0
+// means the rule derives the empty string.
+func (g *Grammar) MatchPrefix(rulename string, input []byte) ([]int, error) {
+	if GetRule(rulename, g.Rulemap) == nil {
+		return nil, &ErrRuleNotFound{Rulename: rulename}
+	}
+	return newRecognizer(g, input).prefixes(rulename, 0), nil
+}
+
+// LongestPrefix returns the length of the longest prefix of input derivable from
+// rulename, or -1 when no prefix matches.
+func (g *Grammar) LongestPrefix(rulename string, input []byte) (int, error) {
+	ends, err := g.MatchPrefix(rulename, input)
+	if err != nil {
+		return -1, err
+	}
+	if len(ends) == 0 {
+		return -1, nil
+	}
+	return ends[len(ends)-1], nil
+}
+
+// prefixes returns the sorted match lengths of rulename starting at index.
+func (r *recognizer) prefixes(rulename string, index int) []int {
+	ends := r.reachElem(ElemRulename{Name: rulename}, index)
+	out := make([]int, 0, len(ends))
+	for e := range ends {
+		out = append(out, e-index)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// Token is a span of input matched by one of a Scanner's rules.
+type Token struct {
+	// Rule is the name of the rule that matched, as given to NewScanner.
+	Rule       string
+	Start, End int
+}
+
+// Scanner splits an input into consecutive tokens, each the longest non-empty
+// prefix of the remaining input matched by one of a set of rules, in the style
+// of bufio.Scanner. When several rules match the same longest prefix, the one
+// listed first wins.
+//
+//	s, _ := goabnf.NewScanner(g, input, "header-field", "CRLF")
+//	for s.Scan() {
+//		tok := s.Token()
+//		...
+//	}
+//	if err := s.Err(); err != nil { ... }
+type Scanner struct {
+	r     *recognizer
+	rules []string
+	pos   int
+	tok   Token
+	err   error
+}
+
+// NewScanner returns a Scanner tokenizing input with rules, tried in order.
+func NewScanner(g *Grammar, input []byte, rules ...string) (*Scanner, error) {
+	for _, rl := range rules {
+		if GetRule(rl, g.Rulemap) == nil {
+			return nil, &ErrRuleNotFound{Rulename: rl}
+		}
+	}
+	return &Scanner{r: newRecognizer(g, input), rules: rules}, nil
+}
+
+// Scan advances to the next token, which is then available through Token and
+// Bytes. It returns false at the end of input, or when no rule matches the
+// remaining input; Err tells the two apart.
+func (s *Scanner) Scan() bool {
+	if s.err != nil || s.pos >= len(s.r.input) {
+		return false
+	}
+	best := Token{Start: s.pos, End: s.pos}
+	for _, rl := range s.rules {
+		ends := s.r.prefixes(rl, s.pos)
+		if len(ends) == 0 {
+			continue
+		}
+		if end := s.pos + ends[len(ends)-1]; end > best.End {
+			best = Token{Rule: rl, Start: s.pos, End: end}
+		}
+	}
+	if best.End == s.pos {
+		// Only empty matches (or none): scanning could not progress.
+		s.err = newParseError(s.r.input, s.pos, s.rules)
+		return false
+	}
+	s.tok = best
+	s.pos = best.End
+	return true
+}
+
+// Token returns the most recent token produced by Scan.
+func (s *Scanner) Token() Token { return s.tok }
+
+// Bytes returns the input bytes of the most recent token produced by Scan.
+func (s *Scanner) Bytes() []byte { return s.r.input[s.tok.Start:s.tok.End] }
+
+// Err returns the [*ParseError] that stopped scanning, or nil if the whole input
+// was tokenized.
+func (s *Scanner) Err() error { return s.err }
@@ -0,0 +1,95 @@
+package goabnf
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_MatchPrefix(t *testing.T) {
+	g := mustGrammar("num = 1*DIGIT\r\nopt = *\"a\"\r\n")
+
+	ends, err := g.MatchPrefix("num", []byte("123x"))
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, ends)
+
+	n, err := g.LongestPrefix("num", []byte("123x"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	n, err = g.LongestPrefix("num", []byte("x123"))
+	require.NoError(t, err)
+	assert.Equal(t, -1, n, "no prefix matches")
+
+	ends, err = g.MatchPrefix("opt", []byte("aab"))
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, ends, "a nullable rule matches the empty prefix")
+
+	_, err = g.MatchPrefix("nope", nil)
+	assert.IsType(t, &ErrRuleNotFound{}, err)
+}
+
+// Test_I_MatchPrefix_IsValid pins MatchPrefix against the recognizer: a length
+// is reported iff the prefix of that length is valid.
+func Test_I_MatchPrefix_IsValid(t *testing.T) {
+	for _, c := range invariantCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			g := mustGrammar(c.src + "\r\n")
+			for _, in := range enumerate(c.alpha, c.maxn) {
+				ends, err := g.MatchPrefix("a", []byte(in))
+				require.NoError(t, err)
+				got := map[int]bool{}
+				for _, e := range ends {
+					got[e] = true
+				}
+				for k := 0; k <= len(in); k++ {
+					want, _ := g.IsValid("a", []byte(in[:k]))
+					assert.Equalf(t, want, got[k], "prefix %q of %q", in[:k], in)
+				}
+			}
+		})
+	}
+}
+
+func Test_U_Scanner(t *testing.T) {
+	g := mustGrammar("word = 1*ALPHA\r\nnum = 1*DIGIT\r\nhex = 1*HEXDIG\r\nblank = 1*SP\r\n")
+
+	s, err := NewScanner(g, []byte("GET 200 beef"), "word", "num", "hex", "blank")
+	require.NoError(t, err)
+	var toks []Token
+	var texts []string
+	for s.Scan() {
+		toks = append(toks, s.Token())
+		texts = append(texts, string(s.Bytes()))
+	}
+	require.NoError(t, s.Err())
+	assert.Equal(t, []string{"GET", " ", "200", " ", "beef"}, texts)
+	// "200" is matched by num and hex alike: the rule listed first wins.
+	// "beef" is matched by word and hex alike: same.
+	assert.Equal(t, "num", toks[2].Rule)
+	assert.Equal(t, "word", toks[4].Rule)
+	assert.Equal(t, Token{Rule: "word", Start: 8, End: 12}, toks[4])
+
+	// Longest match wins over rule order: "2a" is one hex token, not num+word.
+	s, err = NewScanner(g, []byte("2a"), "num", "word", "hex")
+	require.NoError(t, err)
+	require.True(t, s.Scan())
+	assert.Equal(t, Token{Rule: "hex", Start: 0, End: 2}, s.Token())
+	assert.False(t, s.Scan())
+	assert.NoError(t, s.Err())
+
+	// Unmatched input stops scanning with a located error.
+	s, err = NewScanner(g, []byte("ab!"), "word")
+	require.NoError(t, err)
+	require.True(t, s.Scan())
+	assert.False(t, s.Scan())
+	var pe *ParseError
+	require.True(t, errors.As(s.Err(), &pe))
+	assert.Equal(t, 2, pe.Offset)
+	assert.Equal(t, []string{"word"}, pe.Expected)
+
+	_, err = NewScanner(g, nil, "word", "nope")
+	assert.IsType(t, &ErrRuleNotFound{}, err)
+}
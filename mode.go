@@ -0,0 +1,95 @@
+package goabnf
+
+import "unicode/utf8"
+
+// MatchMode selects what the values of a grammar's num-vals denote, hence how
+// input is read. RFC 5234 leaves it to the specification using ABNF: a binary
+// protocol counts octets, while a text format such as TOML counts Unicode code
+// points, so %x80-FF matches one byte in the former and the two-byte UTF-8
+// encodings of U+0080 to U+00FF in the latter.
+//
+// Char-vals are text in both modes: they match the UTF-8 encoding of their
+// characters, which for the US-ASCII ones RFC 5234 allows is a single byte.
+//
+// Every engine honors the mode of the grammar it is given: the recognizer, the
+// GLL, Earley and PEG parsers, Regex, the transition graph, the generators and
+// the generated parsers.
+type MatchMode int
+
+const (
+	// ModeUTF8 reads input as UTF-8 text: a num-val denotes a Unicode code
+	// point, and input that is not valid UTF-8 matches no num-val. It is the
+	// default.
+	ModeUTF8 MatchMode = iota
+	// ModeBytes reads input as octets: a num-val denotes a byte, and values
+	// above %xFF match nothing.
+	ModeBytes
+)
+
+func (m MatchMode) String() string {
+	if m == ModeBytes {
+		return "bytes"
+	}
+	return "utf-8"
+}
+
+// maxChar returns the largest num-val value that denotes a character.
+func (m MatchMode) maxChar() rune {
+	if m == ModeBytes {
+		return 0xFF
+	}
+	return utf8.MaxRune
+}
+
+// validChar reports whether the num-val value r denotes a character. A value
+// that does not can never be matched, nor generated.
+func (m MatchMode) validChar(r rune) bool {
+	if m == ModeBytes {
+		return r >= 0 && r <= 0xFF
+	}
+	return utf8.ValidRune(r)
+}
+
+// decode returns the character input starts with and its width in bytes. The
+// width is 0 when input is empty or, in ModeUTF8, does not start with a valid
+// UTF-8 sequence.
+func (m MatchMode) decode(input []byte) (rune, int) {
+	if len(input) == 0 {
+		return 0, 0
+	}
+	if m == ModeBytes {
+		return rune(input[0]), 1
+	}
+	r, size := utf8.DecodeRune(input)
+	if r == utf8.RuneError && size == 1 {
+		return 0, 0
+	}
+	return r, size
+}
+
+// appendChar appends the encoding of the character r to b.
+func (m MatchMode) appendChar(b []byte, r rune) []byte {
+	if m == ModeBytes {
+		return append(b, byte(r))
+	}
+	return utf8.AppendRune(b, r)
+}
+
+// charLen returns the width in bytes of the encoding of the character r.
+func (m MatchMode) charLen(r rune) int {
+	if m == ModeBytes {
+		return 1
+	}
+	return utf8.RuneLen(r)
+}
+
+// Latin1 maps each byte of input to the code point of the same value. Go's
+// regexp package only reads UTF-8, so the pattern Regex returns for a ModeBytes
+// grammar is written against that text: match Latin1(input) rather than input.
+func Latin1(input []byte) string {
+	rs := make([]rune, len(input))
+	for i, c := range input {
+		rs[i] = rune(c)
+	}
+	return string(rs)
+}
@@ -0,0 +1,275 @@
+package goabnf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// modeCases are grammars whose num-vals read differently as bytes and as code
+// points, with inputs telling the two apart.
+var modeCases = []struct {
+	name   string
+	src    string
+	inputs []string
+	bytes  []bool // expected validity in ModeBytes, per input
+	utf8   []bool // expected validity in ModeUTF8, per input
+}{
+	{
+		name:   "high-range",
+		src:    `a = %x80-FF`,
+		inputs: []string{"\x80", "\xff", "\u0080", "ÿ", "a"},
+		bytes:  []bool{true, true, false, false, false},
+		utf8:   []bool{false, false, true, true, false},
+	},
+	{
+		name:   "series",
+		src:    `a = %xE9.62 / %x63.E9`,
+		inputs: []string{"\xe9b", "éb", "c\xe9", "cé"},
+		bytes:  []bool{true, false, true, false},
+		utf8:   []bool{false, true, false, true},
+	},
+	{
+		name:   "octets",
+		src:    `a = 2*OCTET`,
+		inputs: []string{"\xff\xfe", "ÿ", "ÿÿ", "\xff"},
+		bytes:  []bool{true, true, true, false},
+		utf8:   []bool{false, false, true, false},
+	},
+	{
+		name:   "mixed",
+		src:    `a = "x" *(%x00-2F / %xC0-FF) "y"`,
+		inputs: []string{"xy", "x\xc0\xffy", "xéy", "xÀy", "x\x80y"},
+		bytes:  []bool{true, true, false, false, false},
+		utf8:   []bool{true, false, true, true, false},
+	},
+}
+
+func modeGrammar(t *testing.T, src string, mode MatchMode) *Grammar {
+	g, err := ParseABNF([]byte(src+"\r\n"), WithMatchMode(mode))
+	require.NoError(t, err)
+	require.Equal(t, mode, g.Mode)
+	return g
+}
+
+// Test_U_MatchMode pins that every recognizing engine reads num-vals the same
+// way in each mode: the recognizer, the streaming recognizer, the SPPF, BSR and
+// Earley forests, the PEG parser and the regular expression.
+func Test_U_MatchMode(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range modeCases {
+		for _, mode := range []MatchMode{ModeBytes, ModeUTF8} {
+			t.Run(c.name+"/"+mode.String(), func(t *testing.T) {
+				t.Parallel()
+
+				g := modeGrammar(t, c.src, mode)
+				expected := c.utf8
+				if mode == ModeBytes {
+					expected = c.bytes
+				}
+				pat, err := g.Regex("a")
+				require.NoError(t, err)
+				re := regexp.MustCompile("^(?:" + pat + ")$")
+
+				for k, in := range c.inputs {
+					b := []byte(in)
+					want := expected[k]
+
+					ok, err := g.IsValid("a", b)
+					require.NoError(t, err)
+					assert.Equalf(t, want, ok, "IsValid %q", in)
+					ok, err = g.IsValidReader("a", bytes.NewReader(b))
+					if want {
+						assert.NoErrorf(t, err, "IsValidReader %q", in)
+					}
+					assert.Equalf(t, want, ok, "IsValidReader %q", in)
+
+					sf, err := ParseForest(b, g, "a")
+					require.NoError(t, err)
+					assert.Equalf(t, want, sf.Valid(), "ParseForest %q", in)
+					bf, err := ParseBSR(b, g, "a")
+					require.NoError(t, err)
+					assert.Equalf(t, want, bf.Valid(), "ParseBSR %q", in)
+					ef, err := ParseEarley(b, g, "a")
+					require.NoError(t, err)
+					assert.Equalf(t, want, ef.Valid(), "ParseEarley %q", in)
+					tr, _ := g.ParsePEG("a", b)
+					assert.Equalf(t, want, tr != nil, "ParsePEG %q", in)
+
+					text := in
+					if mode == ModeBytes {
+						text = Latin1(b)
+					}
+					assert.Equalf(t, want, re.MatchString(text), "Regex %q", in)
+				}
+			})
+		}
+	}
+}
+
+// Test_U_MatchMode_Generators pins that every generator emits characters in the
+// mode of the grammar: whatever they produce is accepted.
+func Test_U_MatchMode_Generators(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range modeCases {
+		for _, mode := range []MatchMode{ModeBytes, ModeUTF8} {
+			t.Run(c.name+"/"+mode.String(), func(t *testing.T) {
+				t.Parallel()
+
+				g := modeGrammar(t, c.src, mode)
+				valid := func(what string, out []byte) {
+					ok, err := g.IsValid("a", out)
+					require.NoError(t, err)
+					assert.Truef(t, ok, "%s produced %q", what, out)
+				}
+
+				r := rand.New(rand.NewSource(1))
+				ag, err := NewASTGenerator(g, "a")
+				require.NoError(t, err)
+				for seed := range int64(30) {
+					out, err := g.Generate(seed, "a", WithRepMax(4))
+					require.NoError(t, err)
+					valid("Generate", out)
+					valid("ASTGenerator", ag.GenerateRand(r))
+				}
+
+				for _, deflate := range []bool{false, true} {
+					tg, err := g.TransitionGraph("a", WithDeflateRules(true), WithDeflateNumVals(deflate), WithRepetitionThreshold(4))
+					require.NoError(t, err)
+					gen, err := NewGenerator(tg)
+					require.NoError(t, err)
+					for range 30 {
+						valid("Generator", gen.GenerateRand(r))
+					}
+					rd := tg.Reader()
+					for n := 0; n < 50 && rd.Next(); n++ {
+						valid("TransitionGraphReader", rd.Scan())
+					}
+				}
+
+				// The sentences are enumerated over the characters of the mode.
+				d, err := g.DiffPEG("a", 3)
+				require.NoError(t, err)
+				assert.False(t, d.Differ())
+				assert.Positive(t, d.Checked)
+			})
+		}
+	}
+}
+
+func Test_U_MatchMode_Validation(t *testing.T) {
+	src := []byte("a = %x100\r\n")
+
+	_, err := ParseABNF(src)
+	require.NoError(t, err)
+	_, err = ParseABNF(src, WithMatchMode(ModeBytes))
+	assert.IsType(t, &ErrTooLargeNumeral{}, err)
+
+	// Without validation, a value above %xFF matches no byte.
+	g, err := ParseABNF(src, WithMatchMode(ModeBytes), WithValidation(false))
+	require.NoError(t, err)
+	for _, in := range []string{"\x01\x00", "\x00", "Ā"} {
+		ok, err := g.IsValid("a", []byte(in))
+		require.NoError(t, err)
+		assert.Falsef(t, ok, "%q", in)
+	}
+	pat, err := g.Regex("a")
+	require.NoError(t, err)
+	assert.False(t, regexp.MustCompile("^(?:"+pat+")$").MatchString(Latin1([]byte("Ā"))))
+}
+
+// modeMain is a driver compiled alongside a generated parser. It prints the
+// validity of each file named on the command line, one per line.
+const modeMain = `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	for _, name := range os.Args[1:] {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Println("READERR", err)
+			os.Exit(1)
+		}
+		r, err := Parse(b)
+		if err != nil {
+			fmt.Println("PARSEERR", err)
+			os.Exit(1)
+		}
+		fmt.Println(r.Valid())
+	}
+}
+`
+
+// runGeneratedParser compiles the parser generated for rule "a" of g with the
+// modeMain driver and returns the validity it reports for each input.
+func runGeneratedParser(t *testing.T, g *Grammar, inputs []string) []string {
+	t.Helper()
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not found in PATH; skipping functional codegen test")
+	}
+	src, err := GenerateGoParser(g, "a", "main")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	mustWrite := func(name string, content []byte) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), content, 0o644))
+	}
+	mustWrite("parser.go", src)
+	mustWrite("main.go", []byte(modeMain))
+	mustWrite("go.mod", []byte("module genmode\n\ngo 1.18\n"))
+	args := []string{"run", "."}
+	for k, in := range inputs {
+		name := fmt.Sprintf("in%d", k)
+		mustWrite(name, []byte(in))
+		args = append(args, name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, goBin, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoErrorf(t, err, "go run failed:\n%s", out)
+	return strings.Fields(string(out))
+}
+
+// Test_F_GeneratedParser_MatchMode compiles the generated parsers of the mode
+// cases and checks they agree with the library in each mode.
+func Test_F_GeneratedParser_MatchMode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping functional codegen test in -short mode")
+	}
+
+	for _, c := range modeCases {
+		for _, mode := range []MatchMode{ModeBytes, ModeUTF8} {
+			t.Run(c.name+"/"+mode.String(), func(t *testing.T) {
+				g := modeGrammar(t, c.src, mode)
+				var want []string
+				for _, in := range c.inputs {
+					ok, err := g.IsValid("a", []byte(in))
+					require.NoError(t, err)
+					want = append(want, fmt.Sprint(ok))
+				}
+				assert.Equal(t, want, runGeneratedParser(t, g, c.inputs))
+			})
+		}
+	}
+}
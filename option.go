@@ -24,6 +24,8 @@ type ABNFOption interface {
 type abnfOptions struct {
 	validate     bool
 	redefineCore bool
+	mode         MatchMode
+	folding      CaseFolding
 }
 
 // Defines if proceed to semantic validation.
@@ -62,3 +64,37 @@ func (o redefineCoreOption) apply(opts *abnfOptions) {
 func WithRedefineCoreRules(redefine bool) ABNFOption {
 	return redefineCoreOption(redefine)
 }
+
+// Defines what the num-vals of the grammar denote.
+type matchModeOption MatchMode
+
+var _ ABNFOption = (*matchModeOption)(nil)
+
+func (o matchModeOption) apply(opts *abnfOptions) {
+	opts.mode = MatchMode(o)
+}
+
+// WithMatchMode returns a functional option to set the Mode of the
+// grammar, i.e. whether its num-vals match code points or bytes.
+// Default is ModeUTF8.
+func WithMatchMode(mode MatchMode) ABNFOption {
+	return matchModeOption(mode)
+}
+
+// Defines how the case-insensitive char-vals of the grammar fold.
+type caseFoldingOption CaseFolding
+
+var _ ABNFOption = (*caseFoldingOption)(nil)
+
+func (o caseFoldingOption) apply(opts *abnfOptions) {
+	opts.folding = CaseFolding(o)
+}
+
+// WithCaseFolding returns a functional option to set the Folding of
+// the grammar, i.e. whether its case-insensitive char-vals fold
+// US-ASCII letters only or use Unicode simple case folding.
+// FoldUnicode also accepts non-ASCII characters in char-vals.
+// Default is FoldASCII.
+func WithCaseFolding(folding CaseFolding) ABNFOption {
+	return caseFoldingOption(folding)
+}
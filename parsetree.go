@@ -0,0 +1,99 @@
+package goabnf
+
+import "strings"
+
+// parsetree.go navigates parse trees. Searches go down through the nodes of
+// other rules, but stop at the first node of the rule searched for: a match
+// nested in another one is left to a search from that one, so that
+// FindAll("concatenation") on an alternation returns its own concatenations and
+// not those of its groups. Rule names compare case-insensitively, as in ABNF.
+
+// Text returns the part of input the node spans. input must be the parsed
+// input the tree was extracted from.
+func (t *ParseTree) Text(input []byte) string {
+	return string(input[t.Start:t.End])
+}
+
+// Walk calls fn on t then on its descendants, depth first in input order. The
+// children of a node are skipped when fn returns false on it.
+func (t *ParseTree) Walk(fn func(n *ParseTree) bool) {
+	if !fn(t) {
+		return
+	}
+	for _, c := range t.Children {
+		c.Walk(fn)
+	}
+}
+
+// Find returns the first descendant of t of the given rule, in input order, or
+// nil if there is none.
+func (t *ParseTree) Find(rule string) *ParseTree {
+	rule = canon(rule)
+	for _, c := range t.Children {
+		if canon(c.Rule) == rule {
+			return c
+		}
+		if n := c.Find(rule); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// Child returns the first child of t of one of the given rules, or nil if
+// there is none. Unlike Find, it does not look below the children.
+func (t *ParseTree) Child(rules ...string) *ParseTree {
+	for _, c := range t.Children {
+		for _, rule := range rules {
+			if canon(c.Rule) == canon(rule) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// ChildrenOf returns the children of t of the given rule, in input order.
+// Unlike FindAll, it does not look below the children.
+func (t *ParseTree) ChildrenOf(rule string) []*ParseTree {
+	rule = canon(rule)
+	var out []*ParseTree
+	for _, c := range t.Children {
+		if canon(c.Rule) == rule {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// FindAll returns the descendants of t of the given rule that are not
+// themselves below another one, in input order.
+func (t *ParseTree) FindAll(rule string) []*ParseTree {
+	rule = canon(rule)
+	var out []*ParseTree
+	for _, c := range t.Children {
+		c.Walk(func(n *ParseTree) bool {
+			if canon(n.Rule) == rule {
+				out = append(out, n)
+				return false
+			}
+			return true
+		})
+	}
+	return out
+}
+
+// Query returns the nodes a slash-separated path of rule names leads to, in
+// input order: for "authority/host", the host nodes found by FindAll in every
+// authority node FindAll finds in t.
+func (t *ParseTree) Query(path string) []*ParseTree {
+	nodes := []*ParseTree{t}
+	for _, rule := range strings.Split(path, "/") {
+		var next []*ParseTree
+		for _, n := range nodes {
+			next = append(next, n.FindAll(rule)...)
+		}
+		nodes = next
+	}
+	return nodes
+}
@@ -0,0 +1,158 @@
+package goabnf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const uriLikeAbnf = "uri = scheme \":\" hier\r\n" +
+	"scheme = 1*ALPHA\r\n" +
+	"hier = \"//\" authority path / path\r\n" +
+	"authority = [userinfo \"@\"] host\r\n" +
+	"userinfo = 1*ALPHA\r\n" +
+	"host = 1*(ALPHA / \".\")\r\n" +
+	"path = *(\"/\" segment)\r\n" +
+	"segment = *ALPHA\r\n"
+
+func Test_U_ParseTreeAlternative(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Grammar      string
+		Input        string
+		Expected     []int
+		ExpectedRule string
+	}{
+		"first": {
+			Grammar:      "a = \"x\" / \"y\" / \"z\"\r\n",
+			Input:        "x",
+			Expected:     []int{0},
+			ExpectedRule: "a",
+		},
+		"last": {
+			Grammar:      "a = \"x\" / \"y\" / \"z\"\r\n",
+			Input:        "z",
+			Expected:     []int{2},
+			ExpectedRule: "a",
+		},
+		"nested": {
+			Grammar:      "a = b / \"(\" a \")\"\r\nb = \"x\" / \"y\"\r\n",
+			Input:        "((y))",
+			Expected:     []int{1, 1, 0, 1},
+			ExpectedRule: "",
+		},
+		"group": {
+			// Groups are not rules: only the alternative of a counts.
+			Grammar:      "a = (\"x\" / \"y\") \"z\" / \"w\"\r\n",
+			Input:        "yz",
+			Expected:     []int{0},
+			ExpectedRule: "a",
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			g := mustGrammar(tt.Grammar)
+			f, err := ParseForest([]byte(tt.Input), g, "a")
+			require.NoError(t, err)
+			bf, err := ParseBSR([]byte(tt.Input), g, "a")
+			require.NoError(t, err)
+
+			for _, tree := range []*ParseTree{f.Tree(), bf.Tree()} {
+				var alts []int
+				tree.Walk(func(n *ParseTree) bool {
+					if n.Rule != "" {
+						alts = append(alts, n.Alternative)
+					}
+					return true
+				})
+				assert.Equal(t, tt.Expected, alts)
+			}
+		})
+	}
+}
+
+func Test_U_ParseTreeAlternative_Recovered(t *testing.T) {
+	g := mustGrammar("a = 1*b\r\nb = \"x\" \";\"\r\n")
+	f, err := ParseForest([]byte("x;?;x;"), g, "a", WithRecovery("b"))
+	require.NoError(t, err)
+	tree := f.Tree()
+	require.NotNil(t, tree)
+
+	bs := tree.FindAll("b")
+	require.Len(t, bs, 3)
+	assert.Equal(t, []int{0, -1, 0}, []int{bs[0].Alternative, bs[1].Alternative, bs[2].Alternative})
+}
+
+func Test_U_ParseTreeNavigation(t *testing.T) {
+	g := mustGrammar(uriLikeAbnf)
+	input := []byte("http://me@example.org/a/b")
+	f, err := ParseForest(input, g, "uri")
+	require.NoError(t, err)
+	tree := f.Tree()
+	require.NotNil(t, tree)
+
+	assert.Equal(t, "http://me@example.org/a/b", tree.Text(input))
+	assert.Equal(t, 0, tree.Find("hier").Alternative)
+	assert.Equal(t, "example.org", tree.Find("host").Text(input))
+	assert.Nil(t, tree.Find("uri"))
+	assert.Nil(t, tree.Find("unknown"))
+
+	var segments []string
+	for _, s := range tree.FindAll("segment") {
+		segments = append(segments, s.Text(input))
+	}
+	assert.Equal(t, []string{"a", "b"}, segments)
+
+	hosts := tree.Query("authority/host")
+	require.Len(t, hosts, 1)
+	assert.Equal(t, "example.org", hosts[0].Text(input))
+	assert.Equal(t, "me", tree.Query("hier/authority/userinfo")[0].Text(input))
+	assert.Empty(t, tree.Query("path/host"))
+
+	// Rule names are case-insensitive.
+	assert.Same(t, tree.Find("host"), tree.Find("Host"))
+	assert.Len(t, tree.FindAll("SEGMENT"), 2)
+	assert.Equal(t, hosts, tree.Query("Authority/HOST"))
+
+	// Child and ChildrenOf only look at the direct children.
+	hier := tree.Child("hier")
+	require.NotNil(t, hier)
+	assert.Same(t, tree.Find("hier"), hier)
+	assert.Nil(t, tree.Child("host"))
+	assert.Same(t, hier.Child("Authority"), tree.Find("authority"))
+	assert.Same(t, hier.Child("path", "authority"), tree.Find("authority"))
+	assert.Len(t, tree.Find("path").ChildrenOf("segment"), 2)
+	assert.Empty(t, tree.ChildrenOf("segment"))
+
+	// Walk skips the children of a node it returns false on.
+	var rules []string
+	tree.Walk(func(n *ParseTree) bool {
+		if n.Rule != "" && n.Rule != "ALPHA" {
+			rules = append(rules, n.Rule)
+		}
+		return n.Rule != "authority"
+	})
+	assert.Equal(t, []string{"uri", "scheme", "hier", "authority", "path", "segment", "segment"}, rules)
+}
+
+func Test_U_ParseTreeFindAll_Topmost(t *testing.T) {
+	// Nested matches are left to a search from the outer one.
+	g := mustGrammar("a = \"(\" *a \")\"\r\n")
+	input := []byte("(()(()))")
+	f, err := ParseForest(input, g, "a")
+	require.NoError(t, err)
+	tree := f.Tree()
+	require.NotNil(t, tree)
+
+	var texts []string
+	for _, n := range tree.FindAll("a") {
+		texts = append(texts, n.Text(input))
+	}
+	assert.Equal(t, []string{"()", "(())"}, texts)
+	assert.Len(t, tree.Query("a/a"), 1)
+}
@@ -0,0 +1,289 @@
+package goabnf
+
+import (
+	"fmt"
+	"iter"
+	"strings"
+)
+
+// peg.go reads a grammar as a parsing expression grammar, the way most
+// hand-written and generated parsers of ABNF-specified formats actually behave:
+// an alternation commits to its first alternative that matches, a repetition or
+// option matches as much as it can and never gives back, and a rule matches at
+// most one way at each offset, so its outcome is memoized (packrat parsing).
+//
+// A successful PEG parse is one derivation of the grammar, so the PEG reading
+// accepts a subset of the inputs the grammar derives. DiffPEG searches the
+// difference: the inputs a greedy parser of the grammar wrongly rejects.
+
+type pegKey struct {
+	rule string
+	pos  int
+}
+
+type pegResult struct {
+	end  int // -1 when the rule does not match
+	tree *ParseTree
+	busy bool // being parsed: a left-recursive call fails
+}
+
+type pegParser struct {
+	g      *Grammar
+	input  []byte
+	memo   map[pegKey]*pegResult
+	frames []ruleFrame // outermost first
+
+	furthest
+}
+
+// ParsePEG parses input as rulename with PEG semantics: ordered choice, greedy
+// repetition and options, and memoization of rules. The whole input must be
+// matched. The tree is shaped as ParseForest's, with the alternative each rule
+// committed to; an input the grammar derives only through another choice is
+// rejected, with a ParseError at the furthest offset reached.
+//
+// A left-recursive rule fails where it recurses, as it would loop forever. A
+// repetition of an element matching the empty string stops there, as the next
+// attempts would match the same.
+func (g *Grammar) ParsePEG(rulename string, input []byte) (*ParseTree, error) {
+	if GetRule(rulename, g.Rulemap) == nil {
+		return nil, &ErrRuleNotFound{Rulename: rulename}
+	}
+	p := &pegParser{g: g, input: input, memo: map[pegKey]*pegResult{}}
+	end, t := p.rule(rulename, 0)
+	if end == len(input) {
+		return t, nil
+	}
+	if end >= 0 && p.at(end) {
+		p.note(end, "end of input")
+	}
+	return nil, p.parseError(input, 0)
+}
+
+func (p *pegParser) rule(name string, i int) (int, *ParseTree) {
+	r := GetRule(name, p.g.Rulemap)
+	if r == nil {
+		return -1, nil
+	}
+	key := pegKey{canon(name), i}
+	if res, ok := p.memo[key]; ok {
+		if res.busy {
+			return -1, nil
+		}
+		return res.end, res.tree
+	}
+	res := &pegResult{end: -1, busy: true}
+	p.memo[key] = res
+	p.frames = append(p.frames, ruleFrame{r.Name, i})
+	for k, c := range r.Alternation.Concatenations {
+		if end, kids := p.concat(c, i); end >= 0 {
+			res.end = end
+			res.tree = &ParseTree{Rule: r.Name, Alternative: k, Start: i, End: end, Children: kids}
+			break
+		}
+	}
+	p.frames = p.frames[:len(p.frames)-1]
+	res.busy = false
+	return res.end, res.tree
+}
+
+func (p *pegParser) alt(a Alternation, i int) (int, []*ParseTree) {
+	for _, c := range a.Concatenations {
+		if end, kids := p.concat(c, i); end >= 0 {
+			return end, kids
+		}
+	}
+	return -1, nil
+}
+
+func (p *pegParser) concat(c Concatenation, i int) (int, []*ParseTree) {
+	var kids []*ParseTree
+	for _, r := range c.Repetitions {
+		end, k := p.rep(r, i)
+		if end < 0 {
+			return -1, nil
+		}
+		kids = append(kids, k...)
+		i = end
+	}
+	return i, kids
+}
+
+func (p *pegParser) rep(r Repetition, i int) (int, []*ParseTree) {
+	if r.Max != inf && r.Max < r.Min {
+		return -1, nil
+	}
+	var kids []*ParseTree
+	for count := 0; r.Max == inf || count < r.Max; count++ {
+		end, k := p.elem(r.Element, i)
+		if end < 0 {
+			if count < r.Min {
+				return -1, nil
+			}
+			break
+		}
+		kids = append(kids, k...)
+		if end == i {
+			// Matching nothing, the next elements would match nothing too:
+			// only those needed to reach the minimum are kept.
+			for ; count+1 < r.Min; count++ {
+				kids = append(kids, k...)
+			}
+			break
+		}
+		i = end
+	}
+	return i, kids
+}
+
+func (p *pegParser) elem(e ElemItf, i int) (int, []*ParseTree) {
+	switch v := e.(type) {
+	case ElemRulename:
+		end, t := p.rule(v.Name, i)
+		if end < 0 {
+			return -1, nil
+		}
+		return end, []*ParseTree{t}
+	case ElemGroup:
+		return p.alt(v.Alternation, i)
+	case ElemOption:
+		if end, kids := p.alt(v.Alternation, i); end >= 0 {
+			return end, kids
+		}
+		return i, nil
+	}
+	end := termEnd(p.g.Mode, p.g.Folding, p.input, ssym{kind: symTerm, term: e}, i)
+	if end < 0 {
+		p.noteFail(e, i)
+		return -1, nil
+	}
+	if end == i {
+		return i, nil
+	}
+	return end, []*ParseTree{{Start: i, End: end}}
+}
+
+// noteFail records that terminal e failed to match at i, for the ParseError.
+func (p *pegParser) noteFail(e ElemItf, i int) {
+	if !p.at(i) {
+		return
+	}
+	desc := termDesc(e)
+	p.note(i, desc)
+	frames := make([]ruleFrame, len(p.frames))
+	for k, fr := range p.frames {
+		frames[len(frames)-1-k] = fr
+	}
+	p.noteIn(desc, frames, i)
+}
+
+// PEGDiff is the outcome of a search for an input the grammar and its PEG
+// reading disagree on (see DiffPEG).
+type PEGDiff struct {
+	// Input is the first input the readings disagree on, or nil if none.
+	Input []byte
+	// CFG is a parse tree of Input by the grammar, nil if it rejects Input.
+	CFG *ParseTree
+	// PEG is the parse tree of Input by ParsePEG, nil if it rejects Input.
+	PEG *ParseTree
+	// PEGError is the error of ParsePEG on Input, if it rejects it.
+	PEGError *ParseError
+	// Checked is the number of inputs parsed both ways.
+	Checked int
+	// AgreeUpTo certifies, when no disagreement was found by an exhaustive
+	// search, that the readings agree on every input of at most this many
+	// characters. It is -1 otherwise.
+	AgreeUpTo int
+}
+
+// Differ reports whether an input the readings disagree on was found.
+func (d *PEGDiff) Differ() bool { return d.Input != nil }
+
+// String summarizes the search: the input and how each reading parses it, or
+// the certificate.
+func (d *PEGDiff) String() string {
+	switch {
+	case d.Input != nil:
+		var b strings.Builder
+		fmt.Fprintf(&b, "%q: grammar ", d.Input)
+		b.WriteString(verdict(d.CFG != nil))
+		b.WriteString(", PEG ")
+		b.WriteString(verdict(d.PEG != nil))
+		b.WriteByte('\n')
+		if d.CFG != nil {
+			b.WriteString(strings.Join(treeLines(d.CFG, d.Input, "  "), "\n"))
+			b.WriteByte('\n')
+		}
+		if d.PEGError != nil {
+			fmt.Fprintf(&b, "PEG: %v\n", d.PEGError)
+		}
+		return b.String()
+	case d.AgreeUpTo >= 0:
+		return fmt.Sprintf("PEG agrees up to %d characters (%d inputs)\n", d.AgreeUpTo, d.Checked)
+	}
+	return fmt.Sprintf("PEG agrees on %d inputs\n", d.Checked)
+}
+
+func verdict(ok bool) string {
+	if ok {
+		return "accepts"
+	}
+	return "rejects"
+}
+
+// DiffPEG searches the sentences of rule of at most maxLen characters, shortest
+// first, for one ParsePEG rejects: an input a greedy, ordered-choice parser of
+// the grammar would wrongly refuse. As FindAmbiguity, characters no terminal
+// tells apart are only tried once, so when it finds nothing, the result
+// certifies the readings agree up to maxLen. WithMaxSentences bounds the
+// enumeration.
+func (g *Grammar) DiffPEG(rule string, maxLen int, opts ...AmbiguityOption) (*PEGDiff, error) {
+	cfg := ambiguityConfig{maxSentences: 100000}
+	for _, o := range opts {
+		o.apply(&cfg)
+	}
+	if GetRule(rule, g.Rulemap) == nil {
+		return nil, &ErrRuleNotFound{Rulename: rule}
+	}
+	sentences, ok := newSentenceEnumerator(g, rule, maxLen, cfg.maxSentences).enumerate(rule)
+	if !ok {
+		return nil, &ErrTooManySentences{Max: cfg.maxSentences}
+	}
+	d, err := g.DiffPEGIn(rule, func(yield func([]byte) bool) {
+		for _, sn := range sentences {
+			if !yield([]byte(sn)) {
+				return
+			}
+		}
+	})
+	if err != nil || d.Input != nil {
+		return d, err
+	}
+	d.AgreeUpTo = maxLen
+	return d, nil
+}
+
+// DiffPEGIn parses the given inputs as rule both ways, with ParseForest and
+// ParsePEG, and returns the first one they disagree on. Unlike DiffPEG, finding
+// nothing certifies nothing beyond those inputs.
+func (g *Grammar) DiffPEGIn(rule string, inputs iter.Seq[[]byte]) (*PEGDiff, error) {
+	d := &PEGDiff{AgreeUpTo: -1}
+	for in := range inputs {
+		f, err := ParseForest(in, g, rule)
+		if err != nil {
+			return nil, err
+		}
+		d.Checked++
+		t, err := g.ParsePEG(rule, in)
+		pe, _ := err.(*ParseError)
+		if err != nil && pe == nil {
+			return nil, err
+		}
+		if f.Valid() != (t != nil) {
+			d.Input = append([]byte{}, in...)
+			d.CFG, d.PEG, d.PEGError = f.Tree(), t, pe
+			return d, nil
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,182 @@
+package goabnf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_ParsePEG(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Grammar        string
+		Input          string
+		ExpectedValid  bool
+		ExpectedOffset int
+	}{
+		"ordered-choice": {
+			Grammar:       "a = (\"a\" / \"ab\") \"c\"\r\n",
+			Input:         "ac",
+			ExpectedValid: true,
+		},
+		"ordered-choice-commits": {
+			// "a" matches first, then "c" fails on "b": "ab" is never tried.
+			Grammar:        "a = (\"a\" / \"ab\") \"c\"\r\n",
+			Input:          "abc",
+			ExpectedValid:  false,
+			ExpectedOffset: 1,
+		},
+		"greedy-repetition": {
+			// *"a" leaves nothing to the last "a".
+			Grammar:        "a = *\"a\" \"a\"\r\n",
+			Input:          "aa",
+			ExpectedValid:  false,
+			ExpectedOffset: 2,
+		},
+		"greedy-option": {
+			Grammar:        "a = [\"a\"] \"a\"\r\n",
+			Input:          "a",
+			ExpectedValid:  false,
+			ExpectedOffset: 1,
+		},
+		"bounded-repetition": {
+			Grammar:       "a = 2*3\"a\" \"b\"\r\n",
+			Input:         "aaab",
+			ExpectedValid: true,
+		},
+		"below-minimum": {
+			Grammar:        "a = 2*3\"a\"\r\n",
+			Input:          "a",
+			ExpectedValid:  false,
+			ExpectedOffset: 1,
+		},
+		"trailing-input": {
+			Grammar:        "a = \"a\" / \"ab\"\r\n",
+			Input:          "ab",
+			ExpectedValid:  false,
+			ExpectedOffset: 1,
+		},
+		"nullable-element": {
+			Grammar:       "a = 2*3(\"b\" / \"\") \"a\"\r\n",
+			Input:         "a",
+			ExpectedValid: true,
+		},
+		"left-recursion": {
+			// The recursive call fails, so only "a" is left.
+			Grammar:        "a = a \"+\" \"a\" / \"a\"\r\n",
+			Input:          "a+a",
+			ExpectedValid:  false,
+			ExpectedOffset: 1,
+		},
+		"right-recursion": {
+			Grammar:       "a = \"a\" \"+\" a / \"a\"\r\n",
+			Input:         "a+a+a",
+			ExpectedValid: true,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			g := mustGrammar(tt.Grammar)
+			tree, err := g.ParsePEG("a", []byte(tt.Input))
+			if !tt.ExpectedValid {
+				assert.Nil(t, tree)
+				var pe *ParseError
+				require.ErrorAs(t, err, &pe)
+				assert.Equal(t, tt.ExpectedOffset, pe.Offset)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, tree)
+			assert.Equal(t, len(tt.Input), tree.End)
+
+			// A PEG parse is a derivation of the grammar.
+			f, err := ParseForest([]byte(tt.Input), g, "a")
+			require.NoError(t, err)
+			assert.True(t, f.Valid())
+			if !f.Ambiguous() {
+				assert.Equal(t, f.Tree(), tree)
+			}
+		})
+	}
+}
+
+func Test_U_ParsePEG_Tree(t *testing.T) {
+	g := mustGrammar(uriLikeAbnf)
+	input := []byte("http://me@example.org/a/b")
+
+	tree, err := g.ParsePEG("uri", input)
+	require.NoError(t, err)
+	f, err := ParseForest(input, g, "uri")
+	require.NoError(t, err)
+	assert.Equal(t, f.Tree(), tree)
+
+	_, err = g.ParsePEG("unknown", input)
+	var notFound *ErrRuleNotFound
+	assert.ErrorAs(t, err, &notFound)
+}
+
+func Test_U_DiffPEG(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Grammar           string
+		MaxLen            int
+		ExpectedInput     string
+		ExpectedAgreeUpTo int
+	}{
+		"greedy": {
+			Grammar:           "a = *\"a\" \"a\"\r\n",
+			MaxLen:            4,
+			ExpectedInput:     "a",
+			ExpectedAgreeUpTo: -1,
+		},
+		"ordered-choice": {
+			Grammar:           "a = (\"a\" / \"ab\") \"c\"\r\n",
+			MaxLen:            4,
+			ExpectedInput:     "abc",
+			ExpectedAgreeUpTo: -1,
+		},
+		"agree": {
+			Grammar:           "a = (\"ab\" / \"a\") \"c\"\r\n",
+			MaxLen:            4,
+			ExpectedAgreeUpTo: 4,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			d, err := mustGrammar(tt.Grammar).DiffPEG("a", tt.MaxLen)
+			require.NoError(t, err)
+			assert.Equal(t, tt.ExpectedInput != "", d.Differ())
+			assert.Equal(t, tt.ExpectedAgreeUpTo, d.AgreeUpTo)
+			if tt.ExpectedInput != "" {
+				// Char-vals are case-insensitive: any casing may be tried.
+				assert.True(t, strings.EqualFold(tt.ExpectedInput, string(d.Input)))
+				assert.NotNil(t, d.CFG)
+				assert.Nil(t, d.PEG)
+				assert.NotNil(t, d.PEGError)
+				assert.Contains(t, d.String(), "grammar accepts, PEG rejects")
+			}
+		})
+	}
+}
+
+func Test_U_DiffPEG_Errors(t *testing.T) {
+	g := mustGrammar("a = *(\"a\" / \"b\")\r\n")
+
+	_, err := g.DiffPEG("unknown", 3)
+	var notFound *ErrRuleNotFound
+	assert.ErrorAs(t, err, &notFound)
+
+	_, err = g.DiffPEG("a", 20, WithMaxSentences(10))
+	var tooMany *ErrTooManySentences
+	assert.ErrorAs(t, err, &tooMany)
+}
@@ -3,7 +3,6 @@ package goabnf
 import (
 	"strconv"
 	"strings"
-	"unicode/utf8"
 )
 
 // recognize.go holds the engine behind (*Grammar).IsValid.
@@ -31,6 +30,25 @@ type recognizer struct {
 	// returns the seed instead of recursing.
 	leftRec map[string][]string
 	growing map[string]map[int]bool
+
+	// stack holds the rules being computed, outermost first, for the
+	// furthest-failure diagnostics.
+	stack []ruleFrame
+	furthest
+}
+
+// newRecognizer prepares a recognizer of input. Its memo is keyed by (element,
+// index), so one recognizer answers queries at any number of start offsets while
+// sharing the position sets they have in common.
+func newRecognizer(g *Grammar, input []byte) *recognizer {
+	return &recognizer{
+		g:          g,
+		input:      input,
+		memo:       map[string]map[int]bool{},
+		inProgress: map[string]bool{},
+		leftRec:    g.leftRecursiveSCCs(),
+		growing:    map[string]map[int]bool{},
+	}
 }
 
 func cloneSet(s map[int]bool) map[int]bool {
@@ -132,6 +150,9 @@ func (r *recognizer) reachElem(elem ElemItf, index int) map[int]bool {
 
 	key := elem.String() + "@" + strconv.Itoa(index)
 	if cached, ok := r.memo[key]; ok {
+		if len(cached) == 0 {
+			r.noteFail(elem, index)
+		}
 		return cached
 	}
 	if r.inProgress[key] {
@@ -143,6 +164,9 @@ func (r *recognizer) reachElem(elem ElemItf, index int) map[int]bool {
 	r.inProgress[key] = true
 	out := r.computeElem(elem, index)
 	delete(r.inProgress, key)
+	if len(out) == 0 {
+		r.noteFail(elem, index)
+	}
 	// Safe to cache: a rule reached here either is independent of any actively
 	// growing seed, or is itself a growing SCC member (handled by reachLeftRec
 	// via r.growing, not this path). A lower left-corner SCC cannot reference
@@ -152,6 +176,28 @@ func (r *recognizer) reachElem(elem ElemItf, index int) map[int]bool {
 	return out
 }
 
+// noteFail records that elem matched nothing at index when elem is a terminal,
+// within the rules currently on the stack. A memoized failure is recorded again
+// so every rule attempting the terminal shows up in the diagnostics, as with
+// the GLL engines.
+func (r *recognizer) noteFail(elem ElemItf, index int) {
+	switch elem.(type) {
+	case ElemCharVal, ElemNumVal, ElemProseVal:
+	default:
+		return
+	}
+	if !r.at(index) {
+		return
+	}
+	desc := termDesc(elem)
+	r.note(index, desc)
+	frames := make([]ruleFrame, len(r.stack))
+	for k, fr := range r.stack {
+		frames[len(r.stack)-1-k] = fr
+	}
+	r.noteIn(desc, frames, index)
+}
+
 // reachLeftRec computes the reachable end-set of a left-recursive rule at index
 // by growing the whole left-corner SCC from the empty seed to its least
 // fixpoint. Each growth step adds end-positions (bounded by len(input)), so it
@@ -180,7 +226,9 @@ func (r *recognizer) reachLeftRec(name string, scc []string, index int) map[int]
 			if rule == nil {
 				continue
 			}
+			r.stack = append(r.stack, ruleFrame{rule.Name, index})
 			ns := r.reachAlt(rule.Alternation, index)
+			r.stack = r.stack[:len(r.stack)-1]
 			cur := r.growing[keys[i]]
 			for e := range ns {
 				if !cur[e] {
@@ -215,7 +263,10 @@ func (r *recognizer) computeElem(elem ElemItf, index int) map[int]bool {
 		if rule == nil {
 			return out
 		}
-		return r.reachAlt(rule.Alternation, index)
+		r.stack = append(r.stack, ruleFrame{rule.Name, index})
+		out = r.reachAlt(rule.Alternation, index)
+		r.stack = r.stack[:len(r.stack)-1]
+		return out
 
 	case ElemGroup:
 		return r.reachAlt(v.Alternation, index)
@@ -231,61 +282,10 @@ func (r *recognizer) computeElem(elem ElemItf, index int) map[int]bool {
 		// prose-val can't be matched (same as the original parser)
 		return out
 
-	case ElemNumVal:
-		switch v.Status {
-		case StatRange:
-			if index >= len(r.input) {
-				return out
-			}
-			// Bounds are numeric: a range straddling the Unicode ceiling still
-			// matches its representable (rune) subset, one wholly above it matches
-			// nothing. numvalToRune never panics (saturates out-of-int32 bounds).
-			min := numvalToRune(v.Elems[0], v.Base)
-			max := numvalToRune(v.Elems[1], v.Base)
-			ru, size := utf8.DecodeRune(r.input[index:])
-			if ru == utf8.RuneError && size == 1 {
-				return out
-			}
-			if min <= ru && ru <= max {
-				out[index+size] = true
-			}
-		case StatSeries:
-			idx := index
-			for i := 0; i < len(v.Elems); i++ {
-				ru := numvalToRune(v.Elems[i], v.Base)
-				// A series element must denote a real character; an out-of-range
-				// value can never appear in UTF-8 input, so the series matches
-				// nothing (and we avoid a spurious U+FFFD coincidence).
-				if !utf8.ValidRune(ru) {
-					return out
-				}
-				s := string(ru)
-				sz := len([]byte(s))
-				if idx+sz > len(r.input) || s != string(r.input[idx:idx+sz]) {
-					return out
-				}
-				idx += sz
-			}
-			out[idx] = true
-		}
-		return out
-
-	case ElemCharVal:
-		idx := index
-		for i := 0; i < len(v.Values); i++ {
-			if idx >= len(r.input) {
-				return out
-			}
-			ru, size := utf8.DecodeRune(r.input[idx:])
-			if ru == utf8.RuneError && size == 1 {
-				return out
-			}
-			if !sensequal(v.Values[i], ru, v.Sensitive) {
-				return out
-			}
-			idx += size
+	case ElemNumVal, ElemCharVal:
+		if end := termEnd(r.g.Mode, r.g.Folding, r.input, ssym{kind: symTerm, term: v}, index); end >= 0 {
+			out[end] = true
 		}
-		out[idx] = true
 		return out
 	}
 	return out
@@ -0,0 +1,325 @@
+package goabnf
+
+import (
+	"fmt"
+	"math"
+)
+
+// recover.go implements opt-in error recovery for ParseForest, in the style of
+// panic-mode recovery adapted to GLL. Each synchronization rule R gets one extra
+// alternate, an error symbol skipping input up to the next position where
+// something that may follow R starts (a terminal of FOLLOW(R), or end of input).
+// For a non-nullable R it may also skip nothing, i.e. insert a missing R.
+// GLL explores this alternate alongside the real ones, so error-free derivations
+// are kept whenever they exist; the forest is then pruned to the derivations
+// using the fewest error spans (before any disambiguation filter applies), and
+// each span of the extracted tree is diagnosed by parsing R alone over it.
+
+// WithRecovery makes ParseForest recover from syntax errors by resynchronizing
+// on the given rules, typically the element of a top-level repetition such as
+// `expression` in `toml = expression *( newline expression )`. On an invalid
+// input, the forest then holds a partial tree in which erroneous spans are
+// skipped, and Errors reports one ParseError per span. It is ignored by
+// ParseBSR.
+func WithRecovery(rules ...string) ForestOption {
+	return func(c *forestConfig) { c.recover = append(c.recover, rules...) }
+}
+
+// addRecovery appends the error alternate to the nonterminals of rules.
+// A rule of the grammar unreachable from the root is ignored.
+func (sg *slotGrammar) addRecovery(g *Grammar, rules []string) error {
+	var ids []int
+	for _, name := range rules {
+		if GetRule(name, g.Rulemap) == nil {
+			return &ErrRuleNotFound{Rulename: name}
+		}
+		if id, ok := sg.index["rule:"+canon(name)]; ok && !sg.nts[id].recover {
+			sg.nts[id].recover = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	nullable, follow, eof := sg.followSets()
+	for _, id := range ids {
+		nt := sg.nts[id]
+		nt.nullable = nullable[id]
+		nt.sync = follow[id].list
+		nt.syncEOF = eof[id]
+		nt.alts = append(nt.alts, []ssym{{kind: symErr, nt: id}})
+	}
+	return nil
+}
+
+// termSet is an insertion-ordered set of terminals.
+type termSet struct {
+	list []ssym
+	seen map[string]bool
+}
+
+func (t *termSet) add(s ssym) bool {
+	k := fmt.Sprintf("%#v", s.term)
+	if t.seen[k] {
+		return false
+	}
+	if t.seen == nil {
+		t.seen = map[string]bool{}
+	}
+	t.seen[k] = true
+	t.list = append(t.list, s)
+	return true
+}
+
+// followSets computes, for every nonterminal, whether it is nullable, the
+// terminals that may follow it, and whether end of input may follow it. The
+// repetition bounds are ignored, which only over-approximates the sets.
+func (sg *slotGrammar) followSets() ([]bool, []*termSet, []bool) {
+	n := len(sg.nts)
+	nullable := make([]bool, n)
+	symNullable := func(s ssym) bool {
+		switch s.kind {
+		case symEps:
+			return true
+		case symNonterm:
+			return nullable[s.nt]
+		}
+		cv, ok := s.term.(ElemCharVal)
+		return ok && len(cv.Values) == 0
+	}
+	for changed := true; changed; {
+		changed = false
+		for id, nt := range sg.nts {
+			if nullable[id] {
+				continue
+			}
+			for _, alt := range nt.alts {
+				all := true
+				for _, s := range alt {
+					if !symNullable(s) {
+						all = false
+						break
+					}
+				}
+				if all {
+					nullable[id] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	first := make([]*termSet, n)
+	follow := make([]*termSet, n)
+	for id := range first {
+		first[id], follow[id] = &termSet{}, &termSet{}
+	}
+	// addFirst adds FIRST(seq) to set, and reports whether set changed and
+	// whether seq is nullable.
+	addFirst := func(set *termSet, seq []ssym) (bool, bool) {
+		changed := false
+		for _, s := range seq {
+			switch s.kind {
+			case symTerm:
+				if !symNullable(s) && set.add(s) {
+					changed = true
+				}
+			case symNonterm:
+				for _, t := range first[s.nt].list {
+					if set.add(t) {
+						changed = true
+					}
+				}
+			}
+			if !symNullable(s) {
+				return changed, false
+			}
+		}
+		return changed, true
+	}
+	for changed := true; changed; {
+		changed = false
+		for id, nt := range sg.nts {
+			for _, alt := range nt.alts {
+				if c, _ := addFirst(first[id], alt); c {
+					changed = true
+				}
+			}
+		}
+	}
+
+	eof := make([]bool, n)
+	eof[sg.start] = true
+	for changed := true; changed; {
+		changed = false
+		for id, nt := range sg.nts {
+			for _, alt := range nt.alts {
+				for k, s := range alt {
+					if s.kind != symNonterm {
+						continue
+					}
+					c, restNullable := addFirst(follow[s.nt], alt[k+1:])
+					if c {
+						changed = true
+					}
+					if !restNullable {
+						continue
+					}
+					for _, t := range follow[id].list {
+						if follow[s.nt].add(t) {
+							changed = true
+						}
+					}
+					if eof[id] && !eof[s.nt] {
+						eof[s.nt] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	return nullable, follow, eof
+}
+
+// syncAt reports whether the input at j may follow the synchronization rule nt.
+func (p *gllParser) syncAt(nt *sgNT, j int) bool {
+	if j == len(p.input) {
+		return nt.syncEOF
+	}
+	for _, t := range nt.sync {
+		if termEnd(p.sg.mode, p.sg.fold, p.input, t, j) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// recoverAt processes the error alternate L of a synchronization rule at i: it
+// skips to the next synchronization point after i, and for a non-nullable rule
+// also inserts an empty span when i already is one.
+func (p *gllParser) recoverAt(L slot, u *gssNode, i, rc int) {
+	nt := p.sg.nts[L.nt]
+	var ends []int
+	if !nt.nullable && p.syncAt(nt, i) {
+		ends = append(ends, i)
+	}
+	for j := i + 1; j <= len(p.input); j++ {
+		if p.syncAt(nt, j) {
+			ends = append(ends, j)
+			break
+		}
+	}
+	next := slot{L.nt, L.alt, L.dot + 1}
+	for _, j := range ends {
+		e := p.findNode(nodeKey{kind: gErr, nt: L.nt, start: i, end: j})
+		p.add(next, u, j, p.getNodeP(next, nil, e, rc), rc)
+	}
+}
+
+// pruneRecovered prunes the forest to its derivations with the fewest error
+// spans.
+func (f *Forest) pruneRecovered() {
+	// Post-order the reachable nodes so that a single pass settles acyclic
+	// forests; cycles are handled by iterating to a fixpoint.
+	order := postOrder(f.root)
+	cost := make(map[*gnode]int, len(order))
+	for _, n := range order {
+		switch {
+		case n.kind == gErr:
+			cost[n] = 1
+		case len(n.packs) == 0:
+			cost[n] = 0
+		default:
+			cost[n] = math.MaxInt
+		}
+	}
+	packCost := func(pk []*gnode) int {
+		sum := 0
+		for _, c := range pk {
+			if cost[c] == math.MaxInt {
+				return math.MaxInt
+			}
+			sum += cost[c]
+		}
+		return sum
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, n := range order {
+			for _, pk := range n.packs {
+				if c := packCost(pk); c < cost[n] {
+					cost[n] = c
+					changed = true
+				}
+			}
+		}
+	}
+	for _, n := range order {
+		if len(n.packs) < 2 || cost[n] == math.MaxInt {
+			continue
+		}
+		best := cost[n]
+		n.retainPacks(func(pk []*gnode, _ int) bool { return packCost(pk) == best })
+	}
+}
+
+// recovered diagnoses the error spans of the tree Tree extracts.
+func (f *Forest) recovered(g *Grammar, cfg forestConfig) []*ParseError {
+	// Collect the error spans along the first packing, as Tree does.
+	var spans []*gnode
+	onStack := map[*gnode]bool{}
+	var pick func(n *gnode)
+	pick = func(n *gnode) {
+		if n.kind == gErr {
+			spans = append(spans, n)
+			return
+		}
+		if onStack[n] || len(n.packs) == 0 {
+			return
+		}
+		onStack[n] = true
+		for _, c := range n.packs[0] {
+			pick(c)
+		}
+		delete(onStack, n)
+	}
+	pick(f.root)
+
+	var errs []*ParseError
+	for _, e := range spans {
+		errs = append(errs, f.diagnose(g, e, cfg))
+	}
+	return errs
+}
+
+// postOrder returns the nodes reachable from root, children first.
+func postOrder(root *gnode) []*gnode {
+	var order []*gnode
+	seen := map[*gnode]bool{}
+	var walk func(n *gnode)
+	walk = func(n *gnode) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		for _, pk := range n.packs {
+			for _, c := range pk {
+				walk(c)
+			}
+		}
+		order = append(order, n)
+	}
+	walk(root)
+	return order
+}
+
+// diagnose locates the error in a skipped span by parsing its rule alone over
+// it, so the offset and expected terminals are those of the span's own failure.
+func (f *Forest) diagnose(g *Grammar, e *gnode, cfg forestConfig) *ParseError {
+	sub, err := ParseForest(f.input[e.Start:e.End], g, f.sg.nts[e.nt].ruleName, WithMaxForestNodes(cfg.maxNodes), WithMaxSlots(cfg.maxSlots))
+	if err != nil || sub.Valid() {
+		return newParseError(f.input, e.Start, nil)
+	}
+	return sub.diag.parseError(f.input, e.Start)
+}
@@ -0,0 +1,73 @@
+package goabnf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_ParseForestRecovery(t *testing.T) {
+	g := mustGrammar("list = item *( \";\" item )\r\nitem = 1*DIGIT\r\n")
+
+	f, err := ParseForest([]byte("1;x;3;4y;5"), g, "list", WithRecovery("item"))
+	require.NoError(t, err)
+	assert.False(t, f.Valid())
+	errs := f.Errors()
+	require.Len(t, errs, 2)
+	assert.Equal(t, 2, errs[0].Offset)
+	assert.Equal(t, `"x"`, errs[0].Found)
+	assert.Equal(t, 7, errs[1].Offset)
+	assert.Equal(t, `"y"`, errs[1].Found)
+	assert.Equal(t, errs[0], f.ParseError())
+
+	// The partial tree keeps every item, the skipped ones as leaves.
+	tree := f.Tree()
+	require.NotNil(t, tree)
+	var items []string
+	for _, c := range tree.Children {
+		if c.Rule == "item" {
+			items = append(items, "1;x;3;4y;5"[c.Start:c.End])
+		}
+	}
+	assert.Equal(t, []string{"1", "x", "3", "4y", "5"}, items)
+
+	// A missing item is inserted as an empty span.
+	f, err = ParseForest([]byte("1;;3"), g, "list", WithRecovery("item"))
+	require.NoError(t, err)
+	errs = f.Errors()
+	require.Len(t, errs, 1)
+	assert.Equal(t, 2, errs[0].Offset)
+	assert.Equal(t, `";"`, errs[0].Found)
+
+	// Valid input is unaffected.
+	f, err = ParseForest([]byte("1;2;3"), g, "list", WithRecovery("item"))
+	require.NoError(t, err)
+	assert.True(t, f.Valid())
+	assert.Empty(t, f.Errors())
+	assert.Equal(t, int64(1), f.NumTrees().Int64())
+
+	_, err = ParseForest([]byte("1"), g, "list", WithRecovery("nope"))
+	assert.IsType(t, &ErrRuleNotFound{}, err)
+}
+
+func Test_U_ParseForestRecovery_TOML(t *testing.T) {
+	g, err := ParseABNF(tomlAbnf, WithRedefineCoreRules(true))
+	require.NoError(t, err)
+
+	input := []byte("title = \"ok\"\nport = 80x\n[server]\nname = = 1\nenabled = true\n")
+	f, err := ParseForest(input, g, "toml", WithRecovery("expression"))
+	require.NoError(t, err)
+	assert.False(t, f.Valid())
+	errs := f.Errors()
+	require.Len(t, errs, 2)
+	assert.Equal(t, 2, errs[0].Line)
+	assert.Equal(t, 4, errs[1].Line)
+	assert.Equal(t, 8, errs[1].Col)
+
+	// Without recovery, only the first error is reported.
+	f, err = ParseForest(input, g, "toml")
+	require.NoError(t, err)
+	require.Len(t, f.Errors(), 1)
+	assert.Equal(t, 2, f.Errors()[0].Line)
+}
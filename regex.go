@@ -19,6 +19,9 @@ import (
 // *ErrRegexTooLarge instead of exhausting memory. The output is simplified
 // (character classes merged, redundant groups and {1} quantifiers dropped) but
 // is not guaranteed minimal.
+//
+// For a ModeBytes grammar, the pattern is written against the Latin1 text of
+// the input, as Go's regexp package only reads UTF-8.
 func (g *Grammar) Regex(rulename string, opts ...RegexOption) (string, error) {
 	o := &regexOptions{}
 	for _, op := range opts {
@@ -102,6 +105,9 @@ type reRepeat struct {
 }
 type reEmpty struct{}
 
+// reFold matches text with Unicode simple case folding, as a (?i) segment.
+type reFold struct{ text string }
+
 // reNever matches nothing. It represents a num-val a RE2/Unicode pattern cannot
 // express (a value entirely above U+10FFFF), keeping Regex consistent with the
 // recognizer, which also matches nothing in that case.
@@ -112,6 +118,7 @@ func (*reConcat) prec() int { return precConcat }
 func (*reAlt) prec() int    { return precAlt }
 func (*reRepeat) prec() int { return precRepeat }
 func (*reEmpty) prec() int  { return precAtom }
+func (*reFold) prec() int   { return precAtom }
 func (*reNever) prec() int  { return precAtom }
 
 var reEmptyV reNode = &reEmpty{}
@@ -170,11 +177,11 @@ func (b *reBuilder) elem(e ElemItf) reNode {
 		case StatRange:
 			lo := numvalToRune(v.Elems[0], v.Base)
 			hi := min(
-				// RE2 patterns are Unicode (max U+10FFFF). A range straddling that
-				// ceiling is clamped to its representable subset; one wholly above it
-				// matches nothing -- mirroring the recognizer.
-				numvalToRune(v.Elems[1], v.Base), utf8.MaxRune)
-			if lo > utf8.MaxRune || lo > hi {
+				// A range straddling the largest character (U+10FFFF, or %xFF in
+				// ModeBytes) is clamped to its representable subset; one wholly
+				// above it matches nothing -- mirroring the recognizer.
+				numvalToRune(v.Elems[1], v.Base), b.g.Mode.maxChar())
+			if lo > b.g.Mode.maxChar() || lo > hi {
 				return reNeverV
 			}
 			return &reClass{ranges: []reRange{{lo, hi}}}
@@ -183,8 +190,8 @@ func (b *reBuilder) elem(e ElemItf) reNode {
 			for _, s := range v.Elems {
 				c := numvalToRune(s, v.Base)
 				// A series element must be a real character; if it is not, the
-				// whole sequence can never match any (Unicode) input.
-				if !utf8.ValidRune(c) {
+				// whole sequence can never match any input.
+				if !b.g.Mode.validChar(c) {
 					return reNeverV
 				}
 				parts = append(parts, &reClass{ranges: []reRange{{c, c}}})
@@ -193,10 +200,18 @@ func (b *reBuilder) elem(e ElemItf) reNode {
 		}
 	case ElemCharVal:
 		// A char-val is case-insensitive unless Sensitive (RFC 7405); the
-		// recognizer folds ASCII A-Z only (see runeMin/sensequal), so we match
-		// that exactly here.
+		// recognizer folds ASCII A-Z only by default (see sensequal), so we
+		// match that exactly here. With FoldUnicode, it is a (?i) segment, as
+		// RE2 folds the way unicode.SimpleFold does.
+		if !v.Sensitive && b.g.Folding == FoldUnicode && b.g.Mode == ModeUTF8 && len(v.Values) > 0 {
+			return &reFold{text: string(v.Values)}
+		}
 		parts := make([]reNode, 0, len(v.Values))
 		for _, c := range v.Values {
+			if b.g.Mode == ModeBytes {
+				parts = append(parts, latin1Char(charVariants(v, c, b.g.Folding)))
+				continue
+			}
 			parts = append(parts, charClass(c, v.Sensitive))
 		}
 		return &reConcat{parts: parts}
@@ -209,6 +224,34 @@ func (b *reBuilder) elem(e ElemItf) reNode {
 	return reEmptyV
 }
 
+// latin1Char matches any of the characters vs in the Latin1 text of the input:
+// a non-ASCII character is the sequence of its UTF-8 bytes.
+func latin1Char(vs []rune) reNode {
+	ascii := &reClass{}
+	var opts []reNode
+	for _, c := range vs {
+		if c < utf8.RuneSelf {
+			ascii.ranges = append(ascii.ranges, reRange{c, c})
+			continue
+		}
+		var seq []reNode
+		for _, o := range []byte(string(c)) {
+			seq = append(seq, &reClass{ranges: []reRange{{rune(o), rune(o)}}})
+		}
+		opts = append(opts, &reConcat{parts: seq})
+	}
+	if len(ascii.ranges) != 0 {
+		if len(opts) == 0 {
+			return ascii
+		}
+		opts = append([]reNode{ascii}, opts...)
+	}
+	if len(opts) == 1 {
+		return opts[0]
+	}
+	return &reAlt{opts: opts}
+}
+
 func charClass(c rune, sensitive bool) reNode {
 	if !sensitive {
 		switch {
@@ -396,6 +439,8 @@ func (rr *reRenderer) render(sb *strings.Builder, n reNode, ctx int) {
 		rr.write(sb, "[^\\x00-\\x{10ffff}]")
 	case *reClass:
 		rr.write(sb, renderClass(v))
+	case *reFold:
+		rr.write(sb, "(?i:"+regexp.QuoteMeta(v.text)+")")
 	case *reConcat:
 		for _, p := range v.parts {
 			rr.render(sb, p, precConcat)
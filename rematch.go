@@ -0,0 +1,162 @@
+package goabnf
+
+// rematch.go recovers how a rule node derives its children. A parse tree keeps
+// the alternative each rule node took and its children, rule nodes and terminal
+// spans, but not the repetitions, options and terminals of the alternative they
+// come from: the alternative is matched again against the children, and the
+// match is reported as a sequence of events, the way the canonicalizer and the
+// coverage report walk it.
+//
+// Where several splits of the children between repetitions fit, as in
+// `*"x" *"x"`, the tree does not record the one the parse took: every split
+// fitting yields the same tree. The re-match commits to the greedy one, each
+// repetition taking as many children as it can, so the counts reported are
+// those of that split and not necessarily those of the derivation the forest
+// picked. Both derive the same children, so the canonical form of the tree
+// does not depend on it.
+
+// rematchKind is what a rematchEvent reports.
+type rematchKind int
+
+const (
+	// rematchRule is the rule child kid, matched by a rulename.
+	rematchRule rematchKind = iota
+	// rematchTerm is the terminal child kid, matched by the element of rep.
+	rematchTerm
+	// rematchEnter and rematchLeave enclose the events of occurrence n
+	// (0-based) of rep.
+	rematchEnter
+	rematchLeave
+	// rematchStop is rep stopping after n occurrences.
+	rematchStop
+	// rematchOption is the option element of rep taken (n is 1) or absent
+	// (n is 0). A taken option matching nothing is reported absent.
+	rematchOption
+)
+
+// rematchEvent is a step of the match of an alternative against the children
+// of a node.
+type rematchEvent struct {
+	kind rematchKind
+	rep  *Repetition
+	n    int
+	kid  int
+}
+
+// rematch matches the alternative the rule node t took against its children,
+// repetitions greedily, and returns the events of the match. It reports false
+// when there is none: the rule or alternative is unknown, or the node was cut
+// short on a cycle and has no children to match.
+func rematch(g *Grammar, input []byte, t *ParseTree) ([]rematchEvent, bool) {
+	r := GetRule(t.Rule, g.Rulemap)
+	if r == nil || t.Alternative < 0 || t.Alternative >= len(r.Alternation.Concatenations) {
+		return nil, false
+	}
+	m := &rematcher{g: g, input: input, kids: t.Children, end: t.End}
+	ok := m.concat(r.Alternation.Concatenations[t.Alternative].Repetitions, 0, func(k int) bool {
+		return k == len(m.kids)
+	})
+	return m.events, ok
+}
+
+type rematcher struct {
+	g      *Grammar
+	input  []byte
+	kids   []*ParseTree
+	end    int // end of the node, where a terminal past the last child is
+	events []rematchEvent
+}
+
+// emit appends e and calls cont, dropping the events from e on if cont fails.
+func (m *rematcher) emit(e rematchEvent, cont func() bool) bool {
+	mark := len(m.events)
+	m.events = append(m.events, e)
+	if cont() {
+		return true
+	}
+	m.events = m.events[:mark]
+	return false
+}
+
+// concat matches reps against the children from k on, and calls cont with the
+// index of the first child left.
+func (m *rematcher) concat(reps []Repetition, k int, cont func(int) bool) bool {
+	if len(reps) == 0 {
+		return cont(k)
+	}
+	return m.rep(&reps[0], 0, k, func(k int) bool {
+		return m.concat(reps[1:], k, cont)
+	})
+}
+
+// rep matches the occurrences of r after the first count ones, greedily.
+func (m *rematcher) rep(r *Repetition, count, k int, cont func(int) bool) bool {
+	if r.Max == inf || count < r.Max {
+		if m.emit(rematchEvent{kind: rematchEnter, rep: r, n: count}, func() bool {
+			return m.elem(r, k, func(next int) bool {
+				if next == k && count >= r.Min {
+					// An empty occurrence beyond the minimum changes nothing.
+					return false
+				}
+				return m.emit(rematchEvent{kind: rematchLeave, rep: r, n: count}, func() bool {
+					return m.rep(r, count+1, next, cont)
+				})
+			})
+		}) {
+			return true
+		}
+	}
+	if count < r.Min {
+		return false
+	}
+	return m.emit(rematchEvent{kind: rematchStop, rep: r, n: count}, func() bool { return cont(k) })
+}
+
+func (m *rematcher) alt(a Alternation, k int, cont func(int) bool) bool {
+	for _, cc := range a.Concatenations {
+		if m.concat(cc.Repetitions, k, cont) {
+			return true
+		}
+	}
+	return false
+}
+
+// elem matches an occurrence of the element of r.
+func (m *rematcher) elem(r *Repetition, k int, cont func(int) bool) bool {
+	switch v := r.Element.(type) {
+	case ElemRulename:
+		if k == len(m.kids) || m.kids[k].Rule == "" || canon(m.kids[k].Rule) != canon(v.Name) {
+			return false
+		}
+		return m.emit(rematchEvent{kind: rematchRule, kid: k}, func() bool { return cont(k + 1) })
+	case ElemGroup:
+		return m.alt(v.Alternation, k, cont)
+	case ElemOption:
+		mark := len(m.events)
+		m.events = append(m.events, rematchEvent{kind: rematchOption, rep: r, n: 1})
+		if m.alt(v.Alternation, k, func(next int) bool {
+			// Taken empty, it is as good as absent.
+			return next != k && cont(next)
+		}) {
+			return true
+		}
+		m.events = m.events[:mark]
+		return m.emit(rematchEvent{kind: rematchOption, rep: r, n: 0}, func() bool { return cont(k) })
+	case ElemProseVal:
+		return false
+	default:
+		// A terminal is a leaf, unless it matches the empty string.
+		at := m.end
+		if k < len(m.kids) {
+			at = m.kids[k].Start
+		}
+		end := termEnd(m.g.Mode, m.g.Folding, m.input, ssym{kind: symTerm, term: v}, at)
+		if end == at {
+			return cont(k)
+		}
+		if k == len(m.kids) || m.kids[k].Rule != "" || end != m.kids[k].End {
+			return false
+		}
+		return m.emit(rematchEvent{kind: rematchTerm, rep: r, kid: k}, func() bool { return cont(k + 1) })
+	}
+}
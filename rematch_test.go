@@ -0,0 +1,40 @@
+package goabnf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_Rematch(t *testing.T) {
+	g := mustGrammar("a = 1*2\"x\" [\"y\"] b\r\nb = \"z\"\r\n")
+	input := []byte("xxz")
+	tree := mustTree(t, g, "a", input)
+
+	events, ok := rematch(g, input, tree)
+	require.True(t, ok)
+	reps := g.Rulemap["a"].Alternation.Concatenations[0].Repetitions
+	x, y, b := &reps[0], &reps[1], &reps[2]
+	assert.Equal(t, []rematchEvent{
+		{kind: rematchEnter, rep: x, n: 0},
+		{kind: rematchTerm, rep: x, kid: 0},
+		{kind: rematchLeave, rep: x, n: 0},
+		{kind: rematchEnter, rep: x, n: 1},
+		{kind: rematchTerm, rep: x, kid: 1},
+		{kind: rematchLeave, rep: x, n: 1},
+		{kind: rematchStop, rep: x, n: 2},
+		{kind: rematchEnter, rep: y, n: 0},
+		{kind: rematchOption, rep: y, n: 0},
+		{kind: rematchLeave, rep: y, n: 0},
+		{kind: rematchStop, rep: y, n: 1},
+		{kind: rematchEnter, rep: b, n: 0},
+		{kind: rematchRule, kid: 2},
+		{kind: rematchLeave, rep: b, n: 0},
+		{kind: rematchStop, rep: b, n: 1},
+	}, events)
+
+	// A node cut short on a cycle has no children to match.
+	_, ok = rematch(g, input, &ParseTree{Rule: "a", Start: 0, End: 3})
+	assert.False(t, ok)
+}
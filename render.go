@@ -0,0 +1,196 @@
+package goabnf
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// render.go renders located errors the way compilers do: a headline, the
+// location, the offending source line with a caret under the failing column,
+// then notes and hints. Any error able to locate itself implements Diagnoser,
+// so ParseError and future positioned grammar errors share one renderer.
+
+// Diagnostic is an error located in a source text, ready to be rendered.
+type Diagnostic struct {
+	// Name names the source in the location line, e.g. a file path. It may be
+	// empty.
+	Name string
+	// Source is the text the error is located in. When nil, the snippet is
+	// omitted.
+	Source []byte
+	// Offset is the byte offset of the error into Source, and Line and Col its
+	// 1-based line and column.
+	Offset, Line, Col int
+	// Message is the headline of the diagnostic.
+	Message string
+	// Notes are rendered after the snippet, one per line.
+	Notes []string
+	// Hint, when set, gives a plain-language diagnosis of the error.
+	Hint string
+}
+
+// Diagnoser is implemented by errors that can be located in their source, such
+// as [*ParseError].
+type Diagnoser interface {
+	Diagnostic(name string, source []byte) *Diagnostic
+}
+
+var _ Diagnoser = (*ParseError)(nil)
+
+// maxRenderedNotes caps the expected-set summary of a rendered ParseError, as
+// large grammars may expect dozens of terminals at once, and
+// maxRenderedDepth the enclosing rules named by each entry.
+const (
+	maxRenderedNotes = 8
+	maxRenderedDepth = 2
+)
+
+// Diagnostic locates the error in source, the input that was parsed, for
+// rendering. name names the source, e.g. a file path, and may be empty.
+func (e *ParseError) Diagnostic(name string, source []byte) *Diagnostic {
+	d := &Diagnostic{
+		Name:   name,
+		Source: source,
+		Offset: e.Offset,
+		Line:   e.Line,
+		Col:    e.Col,
+		Hint:   e.Hint,
+	}
+	switch {
+	case e.Found != "":
+		d.Message = "unexpected " + e.Found
+	default:
+		d.Message = ErrNoSolutionFound.Error()
+	}
+	var rules []string
+	for _, c := range e.Contexts {
+		if r := c.describe(maxRenderedDepth); !slices.Contains(rules, r) {
+			rules = append(rules, r)
+		}
+	}
+	if len(rules) > 0 {
+		for k, r := range rules {
+			if k == maxRenderedNotes {
+				d.Notes = append(d.Notes, fmt.Sprintf("... and %d more", len(rules)-k))
+				break
+			}
+			d.Notes = append(d.Notes, "expected "+r)
+		}
+	} else if len(e.Expected) > 0 {
+		d.Notes = append(d.Notes, "expected "+strings.Join(e.Expected, ", "))
+	}
+	return d
+}
+
+// ANSI escape sequences used by Render.
+const (
+	ansiReset = "\x1b[0m"
+	ansiError = "\x1b[1;31m"
+	ansiFrame = "\x1b[1;34m"
+	ansiHint  = "\x1b[1;36m"
+)
+
+// Render returns the diagnostic as multi-line text, with ANSI colors when
+// color is set:
+//
+//	error: unexpected "x"
+//	 --> uri.txt:1:18
+//	  |
+//	1 | http://example:80x
+//	  |                  ^
+//	  = expected DIGIT in `port` inside `authority`
+func (d *Diagnostic) Render(color bool) string {
+	paint := func(style, s string) string {
+		if !color {
+			return s
+		}
+		return style + s + ansiReset
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\n", paint(ansiError, "error:"), d.Message)
+	if d.Line == 0 {
+		// Not located: the headline is all there is.
+		for _, n := range d.Notes {
+			fmt.Fprintf(&b, "%s %s\n", paint(ansiFrame, "="), n)
+		}
+		if d.Hint != "" {
+			fmt.Fprintf(&b, "%s %s\n", paint(ansiHint, "hint:"), d.Hint)
+		}
+		return b.String()
+	}
+
+	gutter := strings.Repeat(" ", len(strconv.Itoa(d.Line)))
+	loc := fmt.Sprintf("line %d, column %d", d.Line, d.Col)
+	if d.Name != "" {
+		loc = fmt.Sprintf("%s:%d:%d", d.Name, d.Line, d.Col)
+	}
+	fmt.Fprintf(&b, "%s%s %s\n", gutter, paint(ansiFrame, "-->"), loc)
+	if d.Source != nil && d.Offset >= 0 && d.Offset <= len(d.Source) {
+		line, caret := snippet(d.Source, d.Offset)
+		fmt.Fprintf(&b, "%s %s\n", gutter, paint(ansiFrame, "|"))
+		fmt.Fprintf(&b, "%s %s %s\n", paint(ansiFrame, strconv.Itoa(d.Line)), paint(ansiFrame, "|"), line)
+		fmt.Fprintf(&b, "%s %s %s%s\n", gutter, paint(ansiFrame, "|"), caret, paint(ansiError, "^"))
+	}
+	for _, n := range d.Notes {
+		fmt.Fprintf(&b, "%s %s %s\n", gutter, paint(ansiFrame, "="), n)
+	}
+	if d.Hint != "" {
+		fmt.Fprintf(&b, "%s %s %s %s\n", gutter, paint(ansiFrame, "="), paint(ansiHint, "hint:"), d.Hint)
+	}
+	return b.String()
+}
+
+// String renders the diagnostic without colors.
+func (d *Diagnostic) String() string { return d.Render(false) }
+
+// snippet returns the source line holding offset, without its line ending, and
+// the padding that puts a caret under offset. Tabs are kept in the padding so
+// the caret lines up whatever the tab width, and control characters are shown
+// as '?' so they cannot garble the terminal.
+func snippet(source []byte, offset int) (string, string) {
+	start := offset
+	for start > 0 && source[start-1] != '\n' {
+		start--
+	}
+	end := offset
+	for end < len(source) && source[end] != '\n' {
+		end++
+	}
+	line := strings.Map(func(r rune) rune {
+		if r != '\t' && (r < 0x20 || r == 0x7f) {
+			return '?'
+		}
+		return r
+	}, strings.TrimSuffix(string(source[start:end]), "\r"))
+	var pad strings.Builder
+	for _, r := range string(source[start:offset]) {
+		if r == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+	return line, pad.String()
+}
+
+// RenderError renders err as a diagnostic located in source (see Diagnostic).
+// Errors joined with errors.Join are rendered one after the other, and errors
+// that cannot locate themselves are rendered as a headline only.
+func RenderError(err error, name string, source []byte, color bool) string {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		parts := []string{}
+		for _, e := range j.Unwrap() {
+			parts = append(parts, RenderError(e, name, source, color))
+		}
+		return strings.Join(parts, "\n")
+	}
+	var dg Diagnoser
+	if errors.As(err, &dg) {
+		return dg.Diagnostic(name, source).Render(color)
+	}
+	return (&Diagnostic{Message: err.Error()}).Render(color)
+}
@@ -0,0 +1,58 @@
+package goabnf
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_Diagnostic_Render(t *testing.T) {
+	g := mustGrammar("uri = scheme \"://\" authority\r\n" +
+		"scheme = 1*ALPHA\r\n" +
+		"authority = host [\":\" port]\r\n" +
+		"host = 1*ALPHA\r\n" +
+		"port = 1*DIGIT\r\n")
+	input := []byte("http://example:80x")
+
+	err := g.Check("uri", input)
+	var pe *ParseError
+	require.True(t, errors.As(err, &pe))
+	assert.Equal(t, "error: unexpected \"x\"\n"+
+		" --> uri.txt:1:18\n"+
+		"  |\n"+
+		"1 | http://example:80x\n"+
+		"  |                  ^\n"+
+		"  = expected DIGIT in `port` inside `authority`\n",
+		pe.Diagnostic("uri.txt", input).Render(false))
+
+	colored := pe.Diagnostic("", input).Render(true)
+	assert.Contains(t, colored, ansiError+"error:"+ansiReset)
+	assert.Contains(t, colored, "line 1, column 18")
+}
+
+func Test_U_Diagnostic_BareLF(t *testing.T) {
+	src := []byte("a = \"x\"\r\nb = c\n")
+	_, err := ParseABNF(src)
+	require.Error(t, err)
+
+	out := RenderError(err, "", src, false)
+	assert.Contains(t, out, "2 | b = c\n  |      ^\n")
+	assert.Contains(t, out, "= hint: input uses bare LF")
+
+	// LF is a legitimate line ending in other grammars: no hint there.
+	g := mustGrammar("doc = *(line LF)\r\nline = *ALPHA\r\n")
+	err = g.Check("doc", []byte("ab\ncd!\n"))
+	var pe *ParseError
+	require.True(t, errors.As(err, &pe))
+	assert.Empty(t, pe.Hint)
+}
+
+func Test_U_RenderError(t *testing.T) {
+	assert.Equal(t, "error: boom\n", RenderError(errors.New("boom"), "", nil, false))
+
+	joined := errors.Join(&ErrDependencyNotFound{Rulename: "b"}, &ErrDependencyNotFound{Rulename: "c"})
+	assert.Equal(t, "error: unsatisfied dependency (rule) b\n\nerror: unsatisfied dependency (rule) c\n",
+		RenderError(joined, "", nil, false))
+}
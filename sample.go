@@ -0,0 +1,590 @@
+package goabnf
+
+import (
+	"math/big"
+	"math/rand"
+	"slices"
+	"strconv"
+)
+
+// sample.go draws parse trees uniformly at random. Several derivations of a
+// forest may flatten to the same ParseTree, as groups, options and repetitions
+// leave no node and the splits between them are lost, so the derivation counts
+// NumTrees computes would favour such trees. The children of a rule node are
+// rather read as a sequence of items, rule nodes and terminal leaves: a
+// deterministic automaton over those sequences, built by subset construction
+// from the derivations, has one path per distinct sequence, so that counting
+// its paths counts distinct trees. The draw is then a weighted descent of the
+// automata, every distinct tree being equally likely. On an infinitely
+// ambiguous forest there is no uniform distribution; cyclic derivations are
+// then skipped and the descent picks uniformly among the remaining packings,
+// backtracking out of the ones that only lead to cycles.
+
+// SampleTree returns a parse tree drawn uniformly at random among the distinct
+// trees of the forest, as Trees lists them, or nil if the input is invalid. On
+// an infinitely ambiguous forest it returns a random acyclic tree, not
+// uniformly.
+func (f *Forest) SampleTree(r *rand.Rand) *ParseTree {
+	if f.root == nil {
+		return nil
+	}
+	if f.NumTrees().Sign() > 0 {
+		return newFlatSampler(r, f.flatNode).tree(f.root)
+	}
+	var kids []*ParseTree
+	if !f.sampleAcyclic(f.root, r, nil, &kids) {
+		return nil
+	}
+	return kids[0]
+}
+
+// flatNode returns what n flattens to.
+func (f *Forest) flatNode(n *gnode) flatNode[*gnode] {
+	switch {
+	case n.kind == gSymbol && n.nt >= 0 && f.sg.nts[n.nt].isRule:
+		alts := make([]int, len(n.packs))
+		for k := range n.packs {
+			alts[k] = f.ruleAlternative(n, k)
+		}
+		return flatNode[*gnode]{kind: flatRule, rule: f.sg.nts[n.nt].ruleName, start: n.Start, end: n.End, packs: n.packs, alts: alts}
+	case len(n.packs) > 0:
+		return flatNode[*gnode]{kind: flatInner, packs: n.packs}
+	case (n.kind == gTerm || n.kind == gErr) && n.End > n.Start:
+		return flatNode[*gnode]{kind: flatLeaf, start: n.Start, end: n.End}
+	}
+	return flatNode[*gnode]{kind: flatEmpty}
+}
+
+// sampleAcyclic appends to into a random expansion of n (see variants) among
+// the packings not going back to one of anc. A packing failing deeper down, its
+// children all going back to anc, is withdrawn and another one drawn; it
+// reports false when none is left.
+func (f *Forest) sampleAcyclic(n *gnode, r *rand.Rand, anc *ancestors, into *[]*ParseTree) bool {
+	if len(n.packs) == 0 {
+		if (n.kind == gTerm || n.kind == gErr) && n.End > n.Start {
+			*into = append(*into, &ParseTree{Start: n.Start, End: n.End})
+		}
+		return true
+	}
+	anc = &ancestors{n: n, parent: anc}
+	var ok []int
+	for k, pk := range n.packs {
+		if !slices.ContainsFunc(pk, anc.has) {
+			ok = append(ok, k)
+		}
+	}
+	isRule := n.kind == gSymbol && n.nt >= 0 && f.sg.nts[n.nt].isRule
+	mark := len(*into)
+	for len(ok) > 0 {
+		i := r.Intn(len(ok))
+		k := ok[i]
+		kids := into
+		if isRule {
+			kids = new([]*ParseTree)
+		}
+		if f.samplePack(n.packs[k], r, anc, kids) {
+			if isRule {
+				*into = append(*into, &ParseTree{Rule: f.sg.nts[n.nt].ruleName, Alternative: f.ruleAlternative(n, k), Start: n.Start, End: n.End, Children: *kids})
+			}
+			return true
+		}
+		*into = (*into)[:mark]
+		ok = slices.Delete(ok, i, i+1)
+	}
+	return false
+}
+
+func (f *Forest) samplePack(pk []*gnode, r *rand.Rand, anc *ancestors, into *[]*ParseTree) bool {
+	for _, c := range pk {
+		if !f.sampleAcyclic(c, r, anc, into) {
+			return false
+		}
+	}
+	return true
+}
+
+// SampleTree returns a parse tree drawn uniformly at random among the distinct
+// trees of the forest, as Forest.SampleTree does, or nil if the input is
+// invalid.
+func (f *BSRForest) SampleTree(r *rand.Rand) *ParseTree {
+	if !f.Valid() {
+		return nil
+	}
+	if f.NumTrees().Sign() > 0 {
+		return newFlatSampler(r, f.flatNode).tree(bsrNodeID{nt: f.start, r: f.n})
+	}
+	s := &bsrSampler{f: f, c: f.newCounter(), r: r, visited: map[bsrNodeID]bool{}}
+	var kids []*ParseTree
+	if !s.sym(f.start, 0, f.n, 0, &kids) {
+		return nil
+	}
+	return kids[0]
+}
+
+// flatNode returns what the symbol or intermediate node id flattens to. A
+// terminal is a node of nt -1 spanning it.
+func (f *BSRForest) flatNode(id bsrNodeID) flatNode[bsrNodeID] {
+	if !id.inter && id.nt < 0 {
+		return flatNode[bsrNodeID]{kind: flatLeaf, start: id.l, end: id.r}
+	}
+	var packs [][]bsrNodeID
+	if id.inter {
+		for _, k := range f.index[bsrKey{id.slot, id.l, id.r, id.rc}] {
+			packs = append(packs, f.flatElem(id.slot, id.l, k, id.r, id.rc))
+		}
+		return flatNode[bsrNodeID]{kind: flatInner, packs: packs}
+	}
+	var alts []int
+	for ai, prod := range f.sg.nts[id.nt].alts {
+		sl := slot{id.nt, ai, len(prod)}
+		for _, k := range f.index[bsrKey{sl, id.l, id.r, id.rc}] {
+			packs = append(packs, f.flatElem(sl, id.l, k, id.r, id.rc))
+			alts = append(alts, ai)
+		}
+	}
+	if info := f.sg.nts[id.nt]; info.isRule {
+		return flatNode[bsrNodeID]{kind: flatRule, rule: info.ruleName, start: id.l, end: id.r, packs: packs, alts: alts}
+	}
+	return flatNode[bsrNodeID]{kind: flatInner, packs: packs}
+}
+
+// flatElem returns the children of element (sl,l,k,r): its prefix, as a node,
+// and its last symbol. Epsilon is left out.
+func (f *BSRForest) flatElem(sl slot, l, k, r, rc int) []bsrNodeID {
+	prod := f.sg.nts[sl.nt].alts[sl.alt]
+	var out []bsrNodeID
+	child := func(sym ssym, a, b int) {
+		switch sym.kind {
+		case symNonterm:
+			out = append(out, bsrNodeID{nt: sym.nt, l: a, r: b, rc: f.sg.childRC(sl, rc, sym)})
+		case symEps:
+		default:
+			out = append(out, bsrNodeID{nt: -1, l: a, r: b})
+		}
+	}
+	switch sl.dot {
+	case 1:
+		// empty prefix
+	case 2:
+		child(prod[0], l, k)
+	default:
+		out = append(out, bsrNodeID{inter: true, slot: slot{sl.nt, sl.alt, sl.dot - 1}, l: l, r: k, rc: rc})
+	}
+	child(prod[sl.dot-1], k, r)
+	return out
+}
+
+// bsrSampler is a weighted descent of a BSR set. Its weights are the tree
+// counts of bsrCounter, which counts a cycle as one tree: on an infinitely
+// ambiguous set, the choices going back to a symbol being sampled (visited) are
+// excluded before drawing, and a choice failing deeper down is withdrawn and
+// another one drawn.
+type bsrSampler struct {
+	f       *BSRForest
+	c       *bsrCounter
+	r       *rand.Rand
+	visited map[bsrNodeID]bool
+}
+
+// draw calls try on indexes drawn from weights until it succeeds, withdrawing
+// each failed one and truncating out back to its length, and reports whether
+// one did.
+func (s *bsrSampler) draw(weights []*big.Int, out *[]*ParseTree, try func(i int) bool) bool {
+	mark := len(*out)
+	for {
+		i := pickWeighted(s.r, weights)
+		if i < 0 {
+			return false
+		}
+		if try(i) {
+			return true
+		}
+		*out = (*out)[:mark]
+		weights[i] = new(big.Int)
+	}
+}
+
+// sym appends to out a random derivation of nt over [l,r]: a rule node, or the
+// spliced children of a synthetic nonterminal. It reports false when every
+// derivation goes back to a symbol being sampled.
+func (s *bsrSampler) sym(nt, l, r, rc int, out *[]*ParseTree) bool {
+	id := bsrNodeID{nt: nt, l: l, r: r, rc: rc}
+	if s.visited[id] {
+		return false
+	}
+	s.visited[id] = true
+	defer delete(s.visited, id)
+
+	type choice struct {
+		sl slot
+		k  int
+	}
+	var choices []choice
+	var weights []*big.Int
+	for ai, prod := range s.f.sg.nts[nt].alts {
+		sl := slot{nt, ai, len(prod)}
+		for _, k := range s.f.index[bsrKey{sl, l, r, rc}] {
+			if s.leadsBack(sl, l, k, r, rc) {
+				continue
+			}
+			choices = append(choices, choice{sl, k})
+			weights = append(weights, s.c.elem(sl, l, k, r, rc))
+		}
+	}
+	info := s.f.sg.nts[nt]
+	return s.draw(weights, out, func(i int) bool {
+		ch := choices[i]
+		if !info.isRule {
+			return s.elem(ch.sl, l, ch.k, r, rc, out)
+		}
+		var kids []*ParseTree
+		if !s.elem(ch.sl, l, ch.k, r, rc, &kids) {
+			return false
+		}
+		*out = append(*out, &ParseTree{Rule: info.ruleName, Alternative: ch.sl.alt, Start: l, End: r, Children: kids})
+		return true
+	})
+}
+
+// leadsBack reports whether element (sl,l,k,r) has a symbol being sampled as
+// its last child, or as its first one when there is no intermediate prefix.
+func (s *bsrSampler) leadsBack(sl slot, l, k, r, rc int) bool {
+	prod := s.f.sg.nts[sl.nt].alts[sl.alt]
+	back := func(sym ssym, a, b int) bool {
+		return sym.kind == symNonterm && s.visited[bsrNodeID{nt: sym.nt, l: a, r: b, rc: s.f.sg.childRC(sl, rc, sym)}]
+	}
+	return back(prod[sl.dot-1], k, r) || sl.dot == 2 && back(prod[0], l, k)
+}
+
+// elem appends to out a random derivation of element (sl,l,k,r), reporting
+// false when there is none (see sym).
+func (s *bsrSampler) elem(sl slot, l, k, r, rc int, out *[]*ParseTree) bool {
+	prod := s.f.sg.nts[sl.nt].alts[sl.alt]
+	switch sl.dot {
+	case 1:
+		// empty prefix
+	case 2:
+		if !s.child(prod[0], l, k, s.f.sg.childRC(sl, rc, prod[0]), out) {
+			return false
+		}
+	default:
+		isl := slot{sl.nt, sl.alt, sl.dot - 1}
+		ks := s.f.index[bsrKey{isl, l, k, rc}]
+		weights := make([]*big.Int, len(ks))
+		for i, kk := range ks {
+			weights[i] = s.c.elem(isl, l, kk, k, rc)
+		}
+		if !s.draw(weights, out, func(i int) bool { return s.elem(isl, l, ks[i], k, rc, out) }) {
+			return false
+		}
+	}
+	return s.child(prod[sl.dot-1], k, r, s.f.sg.childRC(sl, rc, prod[sl.dot-1]), out)
+}
+
+func (s *bsrSampler) child(sym ssym, a, b, rc int, out *[]*ParseTree) bool {
+	switch sym.kind {
+	case symNonterm:
+		return s.sym(sym.nt, a, b, rc, out)
+	case symEps:
+		// epsilon contributes no leaf
+	default:
+		*out = append(*out, &ParseTree{Start: a, End: b})
+	}
+	return true
+}
+
+// pickWeighted returns an index drawn with probability proportional to
+// weights, or -1 when they are all zero.
+func pickWeighted(r *rand.Rand, weights []*big.Int) int {
+	total := big.NewInt(0)
+	for _, w := range weights {
+		total.Add(total, w)
+	}
+	if total.Sign() <= 0 {
+		return -1
+	}
+	x := new(big.Int).Rand(r, total)
+	for i, w := range weights {
+		if x.Cmp(w) < 0 {
+			return i
+		}
+		x.Sub(x, w)
+	}
+	return -1
+}
+
+// flatKind is what a node of a derivation graph contributes to the children
+// of the rule node above it.
+type flatKind int
+
+const (
+	// flatEmpty contributes nothing.
+	flatEmpty flatKind = iota
+	// flatLeaf is a terminal leaf.
+	flatLeaf
+	// flatRule is a rule node.
+	flatRule
+	// flatInner is spliced: its children, by one of its packs, are.
+	flatInner
+)
+
+// flatNode is a node of a derivation graph as it flattens into a ParseTree.
+type flatNode[N comparable] struct {
+	kind       flatKind
+	rule       string // of a rule node
+	start, end int    // of a rule node or leaf
+	packs      [][]N  // of a rule or inner node
+	alts       []int  // the alternative of its rule each pack of a rule node takes
+}
+
+// flatFrame is a position in a pack of node n, below the frame parent (-1 for
+// the packs of the rule node being read).
+type flatFrame[N comparable] struct {
+	n         N
+	pack, dot int
+	parent    int
+}
+
+// flatItem is a child of a rule node: the rule node n, or a leaf spanning
+// [start,end) whatever the node deriving it.
+type flatItem[N comparable] struct {
+	n          N
+	leaf       bool
+	start, end int
+}
+
+// flatState is a state of the automaton reading the children of a rule node:
+// the frames at an item, and whether the children may end there.
+type flatState[N comparable] struct {
+	accept bool
+	shifts []int // frames at an item, increasing
+	items  []flatItem[N]
+	next   []*flatState[N]
+	count  *big.Int // the number of trees the children from there on form
+}
+
+// flatSampler draws distinct trees uniformly from a derivation graph.
+type flatSampler[N comparable] struct {
+	r      *rand.Rand
+	node   func(N) flatNode[N]
+	nodes  map[N]flatNode[N]
+	frames []flatFrame[N]
+	ids    map[flatFrame[N]]int
+	states map[string]*flatState[N]
+	trees  map[N]*big.Int
+	starts map[N][]*flatState[N]
+}
+
+func newFlatSampler[N comparable](r *rand.Rand, node func(N) flatNode[N]) *flatSampler[N] {
+	return &flatSampler[N]{
+		r:      r,
+		node:   node,
+		nodes:  map[N]flatNode[N]{},
+		ids:    map[flatFrame[N]]int{},
+		states: map[string]*flatState[N]{},
+		trees:  map[N]*big.Int{},
+		starts: map[N][]*flatState[N]{},
+	}
+}
+
+func (s *flatSampler[N]) flat(n N) flatNode[N] {
+	fn, ok := s.nodes[n]
+	if !ok {
+		fn = s.node(n)
+		s.nodes[n] = fn
+	}
+	return fn
+}
+
+func (s *flatSampler[N]) frame(fr flatFrame[N]) int {
+	id, ok := s.ids[fr]
+	if !ok {
+		id = len(s.frames)
+		s.frames = append(s.frames, fr)
+		s.ids[fr] = id
+	}
+	return id
+}
+
+// state returns the state of the frames seeds, once closed: inner nodes are
+// entered, empty ones and completed packs are stepped over.
+func (s *flatSampler[N]) state(seeds []int) *flatState[N] {
+	seen := map[int]bool{}
+	accept := false
+	var shifts []int
+	var visit func(id int)
+	visit = func(id int) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		fr := s.frames[id]
+		pk := s.flat(fr.n).packs[fr.pack]
+		if fr.dot == len(pk) {
+			if fr.parent < 0 {
+				accept = true
+				return
+			}
+			p := s.frames[fr.parent]
+			visit(s.frame(flatFrame[N]{n: p.n, pack: p.pack, dot: p.dot + 1, parent: p.parent}))
+			return
+		}
+		switch c := s.flat(pk[fr.dot]); c.kind {
+		case flatLeaf, flatRule:
+			shifts = append(shifts, id)
+		case flatInner:
+			for k := range c.packs {
+				visit(s.frame(flatFrame[N]{n: pk[fr.dot], pack: k, parent: id}))
+			}
+		default:
+			visit(s.frame(flatFrame[N]{n: fr.n, pack: fr.pack, dot: fr.dot + 1, parent: fr.parent}))
+		}
+	}
+	for _, id := range seeds {
+		visit(id)
+	}
+	slices.Sort(shifts)
+
+	key := strconv.AppendBool(nil, accept)
+	for _, id := range shifts {
+		key = strconv.AppendInt(append(key, ' '), int64(id), 10)
+	}
+	if st, ok := s.states[string(key)]; ok {
+		return st
+	}
+	st := &flatState[N]{accept: accept, shifts: shifts}
+	s.states[string(key)] = st
+	return st
+}
+
+// transitions fills the items st reads and the states they lead to, in the
+// order of the frames reading them.
+func (s *flatSampler[N]) transitions(st *flatState[N]) {
+	if st.next != nil || len(st.shifts) == 0 {
+		return
+	}
+	var seeds [][]int
+	for _, id := range st.shifts {
+		fr := s.frames[id]
+		c := s.flat(fr.n).packs[fr.pack][fr.dot]
+		it := flatItem[N]{n: c}
+		if fc := s.flat(c); fc.kind == flatLeaf {
+			var zero N
+			it = flatItem[N]{n: zero, leaf: true, start: fc.start, end: fc.end}
+		}
+		adv := s.frame(flatFrame[N]{n: fr.n, pack: fr.pack, dot: fr.dot + 1, parent: fr.parent})
+		if i := slices.Index(st.items, it); i >= 0 {
+			seeds[i] = append(seeds[i], adv)
+			continue
+		}
+		st.items = append(st.items, it)
+		seeds = append(seeds, []int{adv})
+	}
+	st.next = make([]*flatState[N], len(seeds))
+	for i, seed := range seeds {
+		st.next[i] = s.state(seed)
+	}
+}
+
+// count returns the number of distinct children sequences from st on, each
+// weighted by the number of trees of its rule nodes.
+func (s *flatSampler[N]) count(st *flatState[N]) *big.Int {
+	if st.count != nil {
+		return st.count
+	}
+	s.transitions(st)
+	total := big.NewInt(0)
+	if st.accept {
+		total.SetInt64(1)
+	}
+	for i, it := range st.items {
+		total.Add(total, new(big.Int).Mul(s.weight(it), s.count(st.next[i])))
+	}
+	st.count = total
+	return total
+}
+
+func (s *flatSampler[N]) weight(it flatItem[N]) *big.Int {
+	if it.leaf {
+		return big.NewInt(1)
+	}
+	return s.numTrees(it.n)
+}
+
+// numTrees returns the number of distinct trees of the rule node n.
+func (s *flatSampler[N]) numTrees(n N) *big.Int {
+	if v, ok := s.trees[n]; ok {
+		return v
+	}
+	total := big.NewInt(0)
+	sts := s.alternatives(n)
+	if len(sts) == 0 {
+		total.SetInt64(1)
+	}
+	for _, st := range sts {
+		total.Add(total, s.count(st))
+	}
+	s.trees[n] = total
+	return total
+}
+
+// alternatives returns, per alternative the rule node n takes, the initial
+// state of the automaton reading its children.
+func (s *flatSampler[N]) alternatives(n N) []*flatState[N] {
+	if sts, ok := s.starts[n]; ok {
+		return sts
+	}
+	fn := s.flat(n)
+	var seeds [][]int
+	for _, alt := range slices.Compact(slices.Sorted(slices.Values(fn.alts))) {
+		var seed []int
+		for k := range fn.packs {
+			if fn.alts[k] == alt {
+				seed = append(seed, s.frame(flatFrame[N]{n: n, pack: k, parent: -1}))
+			}
+		}
+		seeds = append(seeds, seed)
+	}
+	sts := make([]*flatState[N], len(seeds))
+	for i, seed := range seeds {
+		sts[i] = s.state(seed)
+	}
+	s.starts[n] = sts
+	return sts
+}
+
+// tree draws one of the distinct trees of the rule node n.
+func (s *flatSampler[N]) tree(n N) *ParseTree {
+	fn := s.flat(n)
+	t := &ParseTree{Rule: fn.rule, Start: fn.start, End: fn.end}
+	sts := s.alternatives(n)
+	if len(sts) == 0 {
+		return t
+	}
+	weights := make([]*big.Int, len(sts))
+	for i, st := range sts {
+		weights[i] = s.count(st)
+	}
+	i := pickWeighted(s.r, weights)
+	alts := slices.Compact(slices.Sorted(slices.Values(fn.alts)))
+	t.Alternative = alts[i]
+	for st := sts[i]; ; {
+		weights := []*big.Int{big.NewInt(0)}
+		if st.accept {
+			weights[0].SetInt64(1)
+		}
+		for j, it := range st.items {
+			weights = append(weights, new(big.Int).Mul(s.weight(it), s.count(st.next[j])))
+		}
+		j := pickWeighted(s.r, weights) - 1
+		if j < 0 {
+			return t
+		}
+		if it := st.items[j]; it.leaf {
+			t.Children = append(t.Children, &ParseTree{Start: it.start, End: it.end})
+		} else {
+			t.Children = append(t.Children, s.tree(it.n))
+		}
+		st = st.next[j]
+	}
+}
@@ -0,0 +1,199 @@
+package goabnf
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_U_SampleTree pins that both engines sample the trees of an ambiguous
+// forest uniformly: each of the 5 trees of "xxxx" is drawn about 1/5 of times.
+func Test_U_SampleTree(t *testing.T) {
+	g := mustGrammar("a = a a / \"x\"\r\n")
+	input := []byte("xxxx")
+	f, err := ParseForest(input, g, "a")
+	require.NoError(t, err)
+	bf, err := ParseBSR(input, g, "a")
+	require.NoError(t, err)
+	trees := slices.Collect(f.Trees(0))
+	require.Len(t, trees, 5)
+
+	const draws = 2500
+	samplers := map[string]func(*rand.Rand) *ParseTree{
+		"sppf": f.SampleTree,
+		"bsr":  bf.SampleTree,
+	}
+	for name, sample := range samplers {
+		t.Run(name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			hits := make([]int, len(trees))
+			for range draws {
+				tr := sample(r)
+				i := slices.IndexFunc(trees, func(x *ParseTree) bool { return assert.ObjectsAreEqual(x, tr) })
+				require.GreaterOrEqual(t, i, 0, "sampled tree is a tree of the forest")
+				hits[i]++
+			}
+			for i, h := range hits {
+				assert.InDeltaf(t, draws/len(trees), h, 100, "tree %d drawn %d times", i, h)
+			}
+		})
+	}
+}
+
+// Test_U_SampleTree_Distinct pins that sampling is uniform over distinct trees,
+// not over derivations: the 3 derivations of the first alternative on "xx",
+// splitting it between the repetitions, all flatten to one tree.
+func Test_U_SampleTree_Distinct(t *testing.T) {
+	g := mustGrammar("a = *\"x\" *\"x\" / 2\"x\"\r\n")
+	input := []byte("xx")
+	f, err := ParseForest(input, g, "a")
+	require.NoError(t, err)
+	bf, err := ParseBSR(input, g, "a")
+	require.NoError(t, err)
+	trees := slices.Collect(f.Trees(0))
+	require.Len(t, trees, 2)
+
+	const draws = 4000
+	samplers := map[string]func(*rand.Rand) *ParseTree{
+		"sppf": f.SampleTree,
+		"bsr":  bf.SampleTree,
+	}
+	for name, sample := range samplers {
+		t.Run(name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			hits := make([]int, len(trees))
+			for range draws {
+				tr := sample(r)
+				i := slices.IndexFunc(trees, func(x *ParseTree) bool { return assert.ObjectsAreEqual(x, tr) })
+				require.GreaterOrEqual(t, i, 0, "sampled tree is a tree of the forest")
+				hits[i]++
+			}
+			for i, h := range hits {
+				assert.InDeltaf(t, draws/len(trees), h, 150, "tree %d drawn %d times", i, h)
+			}
+		})
+	}
+}
+
+// Test_U_SampleTree_DistinctCount pins that the sampler counts the trees Trees
+// lists, however many derivations flatten to each.
+func Test_U_SampleTree_DistinctCount(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Grammar string
+		Input   string
+	}{
+		"splits":      {Grammar: "a = *\"x\" *\"x\" / 2\"x\"\r\n", Input: "xxxx"},
+		"overlapping": {Grammar: "a = *(\"x\" / \"xx\") *(\"x\" / \"xx\")\r\n", Input: "xxxx"},
+		"group":       {Grammar: "a = *(\"x\" / \"x\")\r\n", Input: "xxx"},
+		"rules":       {Grammar: "a = *b *b\r\nb = \"x\" / \"x\" / c\r\nc = \"x\"\r\n", Input: "xxx"},
+		"empty":       {Grammar: "a = [b] [b] \"x\"\r\nb = \"\"\r\n", Input: "x"},
+		"binary":      {Grammar: "a = a a / \"x\"\r\n", Input: "xxxxx"},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			g := mustGrammar(tt.Grammar)
+			input := []byte(tt.Input)
+			f, err := ParseForest(input, g, "a")
+			require.NoError(t, err)
+			bf, err := ParseBSR(input, g, "a")
+			require.NoError(t, err)
+			n := int64(len(slices.Collect(f.Trees(0))))
+
+			fs := newFlatSampler(rand.New(rand.NewSource(1)), f.flatNode)
+			assert.Equal(t, n, fs.numTrees(f.root).Int64())
+			bs := newFlatSampler(rand.New(rand.NewSource(1)), bf.flatNode)
+			assert.Equal(t, n, bs.numTrees(bsrNodeID{nt: bf.start, r: bf.n}).Int64())
+		})
+	}
+}
+
+func Test_U_SampleTree_Edges(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	// Infinitely ambiguous: the acyclic tree is returned.
+	g := mustGrammar("a = a / \"x\"\r\n")
+	f, err := ParseForest([]byte("x"), g, "a")
+	require.NoError(t, err)
+	bf, err := ParseBSR([]byte("x"), g, "a")
+	require.NoError(t, err)
+	want := &ParseTree{Rule: "a", Alternative: 1, Start: 0, End: 1, Children: []*ParseTree{{Start: 0, End: 1}}}
+	assert.Equal(t, want, f.SampleTree(r))
+	assert.Equal(t, want, bf.SampleTree(r))
+
+	// Invalid input.
+	f, err = ParseForest([]byte("y"), g, "a")
+	require.NoError(t, err)
+	assert.Nil(t, f.SampleTree(r))
+	bf, err = ParseBSR([]byte("y"), g, "a")
+	require.NoError(t, err)
+	assert.Nil(t, bf.SampleTree(r))
+}
+
+// Test_U_SampleTree_Cyclic pins that sampling an infinitely ambiguous forest
+// always completes a tree: whatever the seed, every rule node spans its
+// children, and the tree is one of the acyclic trees of the forest.
+func Test_U_SampleTree_Cyclic(t *testing.T) {
+	var tests = map[string]struct {
+		Grammar string
+		Input   string
+	}{
+		"self":     {Grammar: "a = a / \"x\"\r\n", Input: "x"},
+		"mutual":   {Grammar: "a = b / \"x\"\r\nb = a / \"y\"\r\n", Input: "x"},
+		"mutual-b": {Grammar: "a = b / \"x\"\r\nb = a / \"y\"\r\n", Input: "y"},
+		"nullopt":  {Grammar: "a = *[\"a\"] \"b\"\r\n", Input: "aab"},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			g := mustGrammar(tt.Grammar)
+			input := []byte(tt.Input)
+			f, err := ParseForest(input, g, "a")
+			require.NoError(t, err)
+			bf, err := ParseBSR(input, g, "a")
+			require.NoError(t, err)
+			require.Equal(t, int64(-1), f.NumTrees().Int64())
+			trees := slices.Collect(f.Trees(0))
+
+			samplers := map[string]func(*rand.Rand) *ParseTree{
+				"sppf": f.SampleTree,
+				"bsr":  bf.SampleTree,
+			}
+			for name, sample := range samplers {
+				for seed := range int64(200) {
+					tr := sample(rand.New(rand.NewSource(seed)))
+					require.NotNilf(t, tr, "%s seed %d", name, seed)
+					assert.Truef(t, treeComplete(tr), "%s seed %d: %+v", name, seed, tr)
+					assert.Truef(t, slices.ContainsFunc(trees, func(x *ParseTree) bool { return assert.ObjectsAreEqual(x, tr) }),
+						"%s seed %d: not a tree of the forest", name, seed)
+					ok, err := g.IsValid("a", tr.Unparse(input))
+					require.NoError(t, err)
+					assert.Truef(t, ok, "%s seed %d: does not re-validate", name, seed)
+				}
+			}
+		})
+	}
+}
+
+// treeComplete reports whether the children of every rule node of t cover its
+// span, one after the other.
+func treeComplete(t *ParseTree) bool {
+	if t.Rule == "" {
+		return true
+	}
+	at := t.Start
+	for _, c := range t.Children {
+		if c.Start != at || !treeComplete(c) {
+			return false
+		}
+		at = c.End
+	}
+	return at == t.End
+}
@@ -0,0 +1,493 @@
+package goabnf
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// sentences.go enumerates the sentences of a rule up to a length bound. Letting
+// every character of the alphabet through would be hopeless (a single
+// %x00-10FFFF range has over a million), but the parsers can only tell two
+// characters apart if some terminal accepts one and not the other. The
+// alphabet is therefore split into classes of characters accepted by exactly
+// the same terminal positions, and a single representative of each class is
+// used: any sentence is parsed like the one obtained by replacing each of its
+// characters by the representative of its class, with the same trees.
+
+// runeRange is the closed interval of characters [lo,hi].
+type runeRange struct{ lo, hi rune }
+
+// charSet is the set of characters a terminal accepts at one position.
+type charSet []runeRange
+
+// charAlts is one character of a terminal: the alternative sequences of
+// positions it may be written as. Most characters have a single one-position
+// alternative; in ModeBytes, a character folding with non-ASCII characters has
+// one alternative per encoding, of as many positions as it has bytes.
+type charAlts [][]charSet
+
+// termChars returns the characters of a terminal in mode m with folding f, or
+// false when it matches nothing.
+func termChars(e ElemItf, m MatchMode, f CaseFolding) ([]charAlts, bool) {
+	switch v := e.(type) {
+	case ElemCharVal:
+		chars := make([]charAlts, 0, len(v.Values))
+		for _, r := range v.Values {
+			var set charSet
+			var alts charAlts
+			for _, c := range charVariants(v, r, f) {
+				if m == ModeBytes && c >= utf8.RuneSelf {
+					// Char-vals are text: one position per byte of its encoding.
+					var seq []charSet
+					for _, b := range []byte(string(c)) {
+						seq = append(seq, charSet{{rune(b), rune(b)}})
+					}
+					alts = append(alts, seq)
+					continue
+				}
+				set = append(set, runeRange{c, c})
+			}
+			if set != nil {
+				alts = append(charAlts{{set}}, alts...)
+			}
+			chars = append(chars, alts)
+		}
+		return chars, true
+	case ElemNumVal:
+		switch v.Status {
+		case StatRange:
+			lo, hi := numvalToRune(v.Elems[0], v.Base), numvalToRune(v.Elems[1], v.Base)
+			hi = min(hi, m.maxChar())
+			if lo > hi {
+				return nil, false
+			}
+			return []charAlts{{{{{lo, hi}}}}}, true
+		case StatSeries:
+			chars := make([]charAlts, 0, len(v.Elems))
+			for _, s := range v.Elems {
+				r := numvalToRune(s, v.Base)
+				if !m.validChar(r) {
+					return nil, false
+				}
+				chars = append(chars, charAlts{{{{r, r}}}})
+			}
+			return chars, true
+		}
+	}
+	return nil, false
+}
+
+// termPart is one character of a terminal as charAlts, with its position sets
+// replaced by their indexes in the enumerator's sets.
+type termPart [][]int
+
+// sentenceEnumerator computes, for every rule, the set of its sentences written
+// with class representatives. Each rule is only enumerated up to the longest
+// sentence it can contribute to one of the root of at most max characters, as
+// given by the shortest sentences of what surrounds it.
+type sentenceEnumerator struct {
+	g      *Grammar
+	max    int
+	budget int // max sentences per set; 0 == unbounded
+	over   bool
+
+	sets  []charSet
+	reps  [][]rune              // reps[k]: the representatives accepted by sets[k]
+	terms map[string][]termPart // terminal -> its characters
+
+	minLen map[string]int            // rule name -> length of its shortest sentence
+	limit  map[string]int            // rule name -> length of its longest useful sentence
+	rules  map[string]map[string]int // rule name -> sentence -> length
+}
+
+func newSentenceEnumerator(g *Grammar, rule string, max, budget int) *sentenceEnumerator {
+	e := &sentenceEnumerator{
+		g:      g,
+		max:    max,
+		budget: budget,
+		terms:  map[string][]termPart{},
+		minLen: map[string]int{},
+		limit:  map[string]int{},
+		rules:  map[string]map[string]int{},
+	}
+	root := GetRule(rule, g.Rulemap)
+	if root == nil {
+		return e
+	}
+	// Collect the rules and terminals reachable from rule.
+	var visitAlt func(a Alternation)
+	visitAlt = func(a Alternation) {
+		for _, c := range a.Concatenations {
+			for _, rep := range c.Repetitions {
+				switch v := rep.Element.(type) {
+				case ElemRulename:
+					r := GetRule(v.Name, g.Rulemap)
+					if r == nil {
+						continue
+					}
+					if _, ok := e.minLen[r.Name]; !ok {
+						e.minLen[r.Name] = astUnbounded
+						visitAlt(r.Alternation)
+					}
+				case ElemGroup:
+					visitAlt(v.Alternation)
+				case ElemOption:
+					visitAlt(v.Alternation)
+				default:
+					k := v.String()
+					if _, ok := e.terms[k]; ok {
+						continue
+					}
+					chars, ok := termChars(v, g.Mode, g.Folding)
+					if !ok {
+						e.terms[k] = nil
+						continue
+					}
+					parts := make([]termPart, len(chars))
+					for i, alts := range chars {
+						parts[i] = make(termPart, len(alts))
+						for j, seq := range alts {
+							for _, s := range seq {
+								parts[i][j] = append(parts[i][j], len(e.sets))
+								e.sets = append(e.sets, s)
+							}
+						}
+					}
+					e.terms[k] = parts
+				}
+			}
+		}
+	}
+	e.minLen[root.Name] = astUnbounded
+	visitAlt(root.Alternation)
+	e.classes()
+
+	for changed := true; changed; {
+		changed = false
+		for name := range e.minLen {
+			if m := e.minAlt(GetRule(name, g.Rulemap).Alternation); m < e.minLen[name] {
+				e.minLen[name] = m
+				changed = true
+			}
+		}
+	}
+	e.limit[root.Name] = max
+	for changed := true; changed; {
+		changed = false
+		for name, l := range e.limit {
+			if e.bound(GetRule(name, g.Rulemap).Alternation, l) {
+				changed = true
+			}
+		}
+	}
+	for name := range e.limit {
+		e.rules[name] = map[string]int{}
+	}
+	return e
+}
+
+// classes splits the characters into the segments between the bounds of the
+// sets, groups the segments belonging to the same sets into a class, and
+// records the smallest character of each class as its representative.
+func (e *sentenceEnumerator) classes() {
+	// Surrogates are not characters: keep them in a segment of their own.
+	bounds := []rune{0, 0xD800, 0xE000, utf8.MaxRune + 1}
+	if e.g.Mode == ModeBytes {
+		bounds = []rune{0, 0x100}
+	}
+	for _, s := range e.sets {
+		for _, r := range s {
+			bounds = append(bounds, r.lo, r.hi+1)
+		}
+	}
+	slices.Sort(bounds)
+	bounds = slices.Compact(bounds)
+
+	e.reps = make([][]rune, len(e.sets))
+	seen := map[string]bool{}
+	sig := make([]byte, len(e.sets))
+	for k := 0; k+1 < len(bounds); k++ {
+		lo := bounds[k]
+		if (lo == 0xD800 && e.g.Mode == ModeUTF8) || lo > e.g.Mode.maxChar() {
+			continue
+		}
+		in := false
+		for j, s := range e.sets {
+			sig[j] = '0'
+			for _, r := range s {
+				if r.lo <= lo && lo <= r.hi {
+					sig[j] = '1'
+					in = true
+					break
+				}
+			}
+		}
+		if !in || seen[string(sig)] {
+			continue
+		}
+		seen[string(sig)] = true
+		for j := range e.sets {
+			if sig[j] == '1' {
+				e.reps[j] = append(e.reps[j], lo)
+			}
+		}
+	}
+}
+
+// minAlt returns the length of the shortest sentence of a, or astUnbounded if
+// it has none; minConcat, minRep and minElem do the same for the other nodes.
+func (e *sentenceEnumerator) minAlt(a Alternation) int {
+	m := astUnbounded
+	for _, c := range a.Concatenations {
+		m = min(m, e.minConcat(c))
+	}
+	return m
+}
+
+func (e *sentenceEnumerator) minConcat(c Concatenation) int {
+	sum := 0
+	for _, rep := range c.Repetitions {
+		sum = min(sum+e.minRep(rep), astUnbounded)
+	}
+	return sum
+}
+
+func (e *sentenceEnumerator) minRep(rep Repetition) int {
+	if rep.Max != inf && rep.Max < rep.Min {
+		return astUnbounded
+	}
+	if rep.Min == 0 {
+		return 0
+	}
+	m := e.minElem(rep.Element)
+	if m > 0 && rep.Min > astUnbounded/m {
+		return astUnbounded
+	}
+	return min(rep.Min*m, astUnbounded)
+}
+
+func (e *sentenceEnumerator) minElem(el ElemItf) int {
+	switch v := el.(type) {
+	case ElemRulename:
+		if r := GetRule(v.Name, e.g.Rulemap); r != nil {
+			return e.minLen[r.Name]
+		}
+		return astUnbounded
+	case ElemGroup:
+		return e.minAlt(v.Alternation)
+	case ElemOption:
+		return 0
+	}
+	parts := e.terms[el.String()]
+	if parts == nil {
+		return astUnbounded
+	}
+	total := 0
+	for _, p := range parts {
+		shortest := astUnbounded
+		for _, seq := range p {
+			shortest = min(shortest, len(seq))
+		}
+		total += shortest
+	}
+	return total
+}
+
+// bound raises the limits of the rules occurring in a, for sentences of a of
+// at most b characters, and reports whether one changed.
+func (e *sentenceEnumerator) bound(a Alternation, b int) bool {
+	changed := false
+	for _, c := range a.Concatenations {
+		total := e.minConcat(c)
+		if total > b {
+			continue
+		}
+		for _, rep := range c.Repetitions {
+			eb := e.repBound(rep, b-(total-e.minRep(rep)))
+			switch v := rep.Element.(type) {
+			case ElemRulename:
+				r := GetRule(v.Name, e.g.Rulemap)
+				if r == nil {
+					continue
+				}
+				if l, ok := e.limit[r.Name]; !ok || l < eb {
+					e.limit[r.Name] = eb
+					changed = true
+				}
+			case ElemGroup:
+				changed = e.bound(v.Alternation, eb) || changed
+			case ElemOption:
+				changed = e.bound(v.Alternation, eb) || changed
+			}
+		}
+	}
+	return changed
+}
+
+// repBound returns the bound of each element of rep, for b characters of it.
+func (e *sentenceEnumerator) repBound(rep Repetition, b int) int {
+	if rep.Min <= 1 {
+		return b
+	}
+	return b - (e.minRep(rep) - e.minElem(rep.Element))
+}
+
+// enumerate returns the sentences of rule, shortest first then in byte order.
+// It reports false when a set exceeds the budget.
+func (e *sentenceEnumerator) enumerate(rule string) ([]string, bool) {
+	r := GetRule(rule, e.g.Rulemap)
+	if r == nil {
+		return nil, true
+	}
+	// Least fixpoint: recursive rules only see the sentences of their
+	// references found so far, so iterate until no set grows.
+	for changed := true; changed && !e.over; {
+		changed = false
+		for name, l := range e.limit {
+			next := e.alt(GetRule(name, e.g.Rulemap).Alternation, l)
+			if len(next) != len(e.rules[name]) {
+				e.rules[name] = next
+				changed = true
+			}
+		}
+	}
+	if e.over {
+		return nil, false
+	}
+	set := e.rules[r.Name]
+	out := make([]string, 0, len(set))
+	for s := range set {
+		out = append(out, s)
+	}
+	slices.SortFunc(out, func(a, b string) int {
+		return cmp.Or(cmp.Compare(set[a], set[b]), strings.Compare(a, b))
+	})
+	return out, true
+}
+
+// alt returns the sentences of a of at most b characters; rep and elem do the
+// same for the other nodes.
+func (e *sentenceEnumerator) alt(a Alternation, b int) map[string]int {
+	out := map[string]int{}
+	for _, c := range a.Concatenations {
+		total := e.minConcat(c)
+		if total > b {
+			continue
+		}
+		acc := map[string]int{"": 0}
+		for _, rep := range c.Repetitions {
+			acc = e.concat(acc, e.rep(rep, b-(total-e.minRep(rep))), b)
+		}
+		for s, n := range acc {
+			out[s] = n
+		}
+	}
+	e.check(out)
+	return out
+}
+
+func (e *sentenceEnumerator) rep(rep Repetition, b int) map[string]int {
+	out := map[string]int{}
+	if rep.Max != inf && rep.Max < rep.Min {
+		return out
+	}
+	// With E' the non-empty sentences of the element, take the powers of E'
+	// from Min up to Max, or from 0 when the element is nullable (the empty
+	// sentence fills the missing occurrences). Every power is longer than the
+	// previous one, so the bound stops them.
+	elem := e.elem(rep.Element, e.repBound(rep, b))
+	_, nullable := elem[""]
+	nonEmpty := make(map[string]int, len(elem))
+	for s, n := range elem {
+		if n > 0 {
+			nonEmpty[s] = n
+		}
+	}
+	from := rep.Min
+	if nullable {
+		from = 0
+	}
+	pow := map[string]int{"": 0}
+	for k := 0; (rep.Max == inf || k <= rep.Max) && len(pow) > 0 && !e.over; k++ {
+		if k > 0 {
+			pow = e.concat(pow, nonEmpty, b)
+		}
+		if k >= from {
+			for s, n := range pow {
+				out[s] = n
+			}
+		}
+	}
+	e.check(out)
+	return out
+}
+
+func (e *sentenceEnumerator) elem(el ElemItf, b int) map[string]int {
+	switch v := el.(type) {
+	case ElemRulename:
+		if r := GetRule(v.Name, e.g.Rulemap); r != nil {
+			return e.rules[r.Name]
+		}
+		return nil
+	case ElemGroup:
+		return e.alt(v.Alternation, b)
+	case ElemOption:
+		out := e.alt(v.Alternation, b)
+		out[""] = 0
+		return out
+	}
+	parts := e.terms[el.String()]
+	if parts == nil {
+		return nil
+	}
+	out := map[string]int{"": 0}
+	for _, p := range parts {
+		chars := map[string]int{}
+		for _, seq := range p {
+			alt := map[string]int{"": 0}
+			for _, k := range seq {
+				reps := map[string]int{}
+				for _, r := range e.reps[k] {
+					reps[string(e.g.Mode.appendChar(nil, r))] = 1
+				}
+				alt = e.concat(alt, reps, b)
+			}
+			for sn, n := range alt {
+				chars[sn] = n
+			}
+		}
+		out = e.concat(out, chars, b)
+	}
+	return out
+}
+
+// concat returns the concatenations of the sentences of a and b of at most
+// bound characters.
+func (e *sentenceEnumerator) concat(a, b map[string]int, bound int) map[string]int {
+	byLen := map[int][]string{}
+	for y, m := range b {
+		byLen[m] = append(byLen[m], y)
+	}
+	out := map[string]int{}
+	for x, n := range a {
+		for m := 0; n+m <= bound; m++ {
+			for _, y := range byLen[m] {
+				out[x+y] = n + m
+			}
+		}
+		if e.check(out) {
+			break
+		}
+	}
+	return out
+}
+
+// check records whether set exceeds the budget, and reports it.
+func (e *sentenceEnumerator) check(set map[string]int) bool {
+	if e.budget > 0 && len(set) > e.budget {
+		e.over = true
+	}
+	return e.over
+}
@@ -1,7 +1,6 @@
 package goabnf
 
 import (
-	"fmt"
 	"math/big"
 	"strconv"
 	"unicode/utf8"
@@ -32,6 +31,7 @@ const (
 	symNonterm symKind = iota
 	symTerm            // a char-val / num-val / prose-val matcher
 	symEps             // matches the empty string
+	symErr             // skips erroneous input, for WithRecovery (see recover.go)
 )
 
 type ssym struct {
@@ -54,12 +54,26 @@ type sgNT struct {
 	isRep  bool
 	repMin int
 	repMax int // inf (== -1) means unbounded
+
+	// isOpt marks an ABNF option: alternate 0 is the empty one, and alternate
+	// k > 0 is alternative k-1 of the bracketed alternation.
+	isOpt bool
+
+	// Error recovery (see recover.go). When recover is set, the last alternate
+	// is [symErr], which skips input up to the next position where a terminal
+	// of sync can start (or end of input when syncEOF).
+	recover  bool
+	nullable bool
+	sync     []ssym
+	syncEOF  bool
 }
 
 type slotGrammar struct {
 	nts   []*sgNT
 	index map[string]int // dedup key -> nt id
 	start int
+	mode  MatchMode
+	fold  CaseFolding
 }
 
 func (sg *slotGrammar) reserve(key, label string) (int, bool) {
@@ -79,7 +93,7 @@ func compileSlots(g *Grammar, rootRulename string, maxSlots int) (*slotGrammar,
 	if GetRule(rootRulename, g.Rulemap) == nil {
 		return nil, &ErrRuleNotFound{Rulename: rootRulename}
 	}
-	sg := &slotGrammar{index: map[string]int{}}
+	sg := &slotGrammar{index: map[string]int{}, mode: g.Mode, fold: g.Folding}
 	c := &lowerer{g: g, sg: sg, max: maxSlots}
 	sg.start = c.rule(rootRulename)
 	if c.err != nil {
@@ -217,6 +231,7 @@ func (c *lowerer) option(v ElemOption) int {
 	alts := [][]ssym{{{kind: symEps}}}
 	alts = append(alts, c.alts(v.Alternation)...)
 	c.sg.nts[id].alts = alts
+	c.sg.nts[id].isOpt = true
 	return id
 }
 
@@ -240,18 +255,24 @@ const (
 	gSymbol gnodeKind = iota // a nonterminal node (Start..End)
 	gInter                   // a binarisation intermediate node
 	gTerm                    // a terminal / epsilon node
+	gErr                     // a skipped input span, for WithRecovery
 )
 
 type gnode struct {
 	kind       gnodeKind
 	nt         int // symbol nonterminal id, for gSymbol
 	Start, End int
+	L          slot       // binarisation slot, for gInter
 	packs      [][]*gnode // each pack has 1 or 2 children; >1 pack == ambiguity
+	alts       []int      // alts[k] is the alternate of the nonterminal packs[k] derives
 }
 
-func (n *gnode) addPack(children []*gnode) {
-	for _, p := range n.packs {
-		if len(p) != len(children) {
+// addPack packs children under n as a derivation by alternate alt. Packs are
+// labelled with their alternate, as two alternates may derive the same span
+// with the same children (e.g. a = "x" / "x").
+func (n *gnode) addPack(children []*gnode, alt int) {
+	for k, p := range n.packs {
+		if len(p) != len(children) || n.alts[k] != alt {
 			continue
 		}
 		same := true
@@ -266,6 +287,19 @@ func (n *gnode) addPack(children []*gnode) {
 		}
 	}
 	n.packs = append(n.packs, children)
+	n.alts = append(n.alts, alt)
+}
+
+// retainPacks keeps the packs of n for which keep reports true, in order.
+func (n *gnode) retainPacks(keep func(pk []*gnode, alt int) bool) {
+	packs, alts := n.packs[:0], n.alts[:0]
+	for k, pk := range n.packs {
+		if keep(pk, n.alts[k]) {
+			packs = append(packs, pk)
+			alts = append(alts, n.alts[k])
+		}
+	}
+	n.packs, n.alts = packs, alts
 }
 
 // ---------------------------------------------------------------------------
@@ -309,23 +343,20 @@ type gllParser struct {
 	maxNodes int
 	aborted  bool
 
-	// Furthest-failure diagnostics: maxPos is the deepest input offset at which
-	// a terminal match was attempted and failed; expected collects the terminals
-	// that could have been consumed there (deduplicated via expectedSeen). They
-	// drive ParseError when the parse has no solution.
-	maxPos       int
-	expected     []string
-	expectedSeen map[string]bool
+	furthest
 }
 
 // nodeKey interns SPPF nodes without allocating strings. Distinct kinds never
 // collide: symbol nodes key on (kind,nt,start,end); intermediate nodes on
-// (kind,L,start,end); terminal nodes on (kind,start,end).
+// (kind,L,start,end); terminal nodes on (kind,start,end). The symbol nodes of a
+// counted repetition also key on the count rc of elements before them, as the
+// same span is derived differently depending on how many are left to match.
 type nodeKey struct {
 	kind       gnodeKind
 	nt         int
 	L          slot
 	start, end int
+	rc         int
 }
 
 type gssKey struct {
@@ -360,7 +391,7 @@ func (p *gllParser) create(L slot, u *gssNode, i int, w *gnode, rc int) *gssNode
 		v.edgeSet[e] = true
 		v.edges = append(v.edges, e)
 		for z := range p.popped[v] {
-			y := p.getNodeP(L, w, z)
+			y := p.getNodeP(L, w, z, rc)
 			p.add(L, u, z.End, y, rc)
 		}
 	}
@@ -376,7 +407,7 @@ func (p *gllParser) pop(u *gssNode, i int, z *gnode) {
 	}
 	p.popped[u][z] = true
 	for _, e := range u.edges {
-		y := p.getNodeP(u.ret, e.w, z)
+		y := p.getNodeP(u.ret, e.w, z, u.rc)
 		p.add(u.ret, e.to, i, y, u.rc)
 	}
 }
@@ -388,7 +419,7 @@ func (p *gllParser) findNode(k nodeKey) *gnode {
 	if p.maxNodes > 0 && len(p.nodes) >= p.maxNodes {
 		p.aborted = true
 	}
-	v := &gnode{kind: k.kind, nt: k.nt, Start: k.start, End: k.end}
+	v := &gnode{kind: k.kind, nt: k.nt, L: k.L, Start: k.start, End: k.end}
 	p.nodes[k] = v
 	return v
 }
@@ -397,8 +428,9 @@ func (p *gllParser) getNodeT(start, end int) *gnode {
 	return p.findNode(nodeKey{kind: gTerm, nt: -1, start: start, end: end})
 }
 
-// getNodeP combines left node w (may be nil == $) and right node z under slot L.
-func (p *gllParser) getNodeP(L slot, w, z *gnode) *gnode {
+// getNodeP combines left node w (may be nil == $) and right node z under slot L,
+// rc being the repetition count of the frame of L.
+func (p *gllParser) getNodeP(L slot, w, z *gnode, rc int) *gnode {
 	prod := p.sg.nts[L.nt].alts[L.alt]
 	betaEmpty := L.dot == len(prod)
 	if L.dot == 1 && !betaEmpty {
@@ -411,35 +443,44 @@ func (p *gllParser) getNodeP(L slot, w, z *gnode) *gnode {
 	var k nodeKey
 	if betaEmpty {
 		k = nodeKey{kind: gSymbol, nt: L.nt, start: start, end: z.End}
+		if p.sg.nts[L.nt].isRep {
+			k.rc = rc
+		}
 	} else {
 		k = nodeKey{kind: gInter, nt: -1, L: L, start: start, end: z.End}
 	}
 	y := p.findNode(k)
 	if w != nil {
-		y.addPack([]*gnode{w, z})
+		y.addPack([]*gnode{w, z}, L.alt)
 	} else {
-		y.addPack([]*gnode{z})
+		y.addPack([]*gnode{z}, L.alt)
 	}
 	return y
 }
 
 // matchTerm returns the end index after matching the terminal at i, or -1.
-func (p *gllParser) matchTerm(s ssym, i int) int {
+func (p *gllParser) matchTerm(s ssym, i int) int { return termEnd(p.sg.mode, p.sg.fold, p.input, s, i) }
+
+// termEnd returns the end index after matching the terminal s against input at
+// i in mode m with folding f, or -1. It is the single terminal matcher shared by
+// the engines, so they cannot drift apart on what a char-val or num-val accepts.
+func termEnd(m MatchMode, f CaseFolding, input []byte, s ssym, i int) int {
 	if s.kind == symEps {
 		return i
 	}
 	switch v := s.term.(type) {
 	case ElemCharVal:
+		// Char-vals are text whatever the mode.
 		idx := i
 		for k := 0; k < len(v.Values); k++ {
-			if idx >= len(p.input) {
+			if idx >= len(input) {
 				return -1
 			}
-			r, size := utf8.DecodeRune(p.input[idx:])
+			r, size := utf8.DecodeRune(input[idx:])
 			if r == utf8.RuneError && size == 1 {
 				return -1
 			}
-			if !sensequal(v.Values[k], r, v.Sensitive) {
+			if !sensequal(v.Values[k], r, v.Sensitive, f) {
 				return -1
 			}
 			idx += size
@@ -448,15 +489,13 @@ func (p *gllParser) matchTerm(s ssym, i int) int {
 	case ElemNumVal:
 		switch v.Status {
 		case StatRange:
-			if i >= len(p.input) {
-				return -1
-			}
-			// Numeric comparison: a range straddling U+10FFFF matches its rune
-			// subset; numvalToRune never panics (saturates out-of-int32 bounds).
+			// Numeric comparison: a range straddling the largest character
+			// matches its character subset; numvalToRune never panics
+			// (saturates out-of-int32 bounds).
 			min := numvalToRune(v.Elems[0], v.Base)
 			max := numvalToRune(v.Elems[1], v.Base)
-			r, size := utf8.DecodeRune(p.input[i:])
-			if r == utf8.RuneError && size == 1 {
+			r, size := m.decode(input[i:])
+			if size == 0 {
 				return -1
 			}
 			if min <= r && r <= max {
@@ -465,19 +504,19 @@ func (p *gllParser) matchTerm(s ssym, i int) int {
 			return -1
 		case StatSeries:
 			idx := i
+			var enc []byte
 			for k := 0; k < len(v.Elems); k++ {
 				ru := numvalToRune(v.Elems[k], v.Base)
 				// Series elements must be real characters: an out-of-range value
-				// matches no UTF-8 input (and avoids a spurious U+FFFD match).
-				if !utf8.ValidRune(ru) {
+				// matches no input (and avoids a spurious U+FFFD match).
+				if !m.validChar(ru) {
 					return -1
 				}
-				str := string(ru)
-				sz := len(str)
-				if idx+sz > len(p.input) || str != string(p.input[idx:idx+sz]) {
+				enc = m.appendChar(enc[:0], ru)
+				if idx+len(enc) > len(input) || string(enc) != string(input[idx:idx+len(enc)]) {
 					return -1
 				}
-				idx += sz
+				idx += len(enc)
 			}
 			return idx
 		}
@@ -517,6 +556,10 @@ func (p *gllParser) process(d descriptor) {
 			return
 		}
 		s := prod[L.dot]
+		if s.kind == symErr {
+			p.recoverAt(L, u, i, rc)
+			return
+		}
 		if s.kind == symNonterm {
 			ret := slot{L.nt, L.alt, L.dot + 1}
 			// Preserve the current repetition frame count across the sub-parse so
@@ -526,11 +569,7 @@ func (p *gllParser) process(d descriptor) {
 				// Native counted repetition: a self-reference inside the rep's own
 				// loop alternate continues the chain (count+1); any other reference
 				// starts a fresh chain at count 0.
-				childRC := 0
-				if s.nt == L.nt {
-					childRC = capRC(rc, p.sg.nts[s.nt])
-				}
-				p.addRepAlts(s.nt, v, i, childRC)
+				p.addRepAlts(s.nt, v, i, p.sg.childRC(L, rc, s))
 			} else {
 				for ai := range p.sg.nts[s.nt].alts {
 					p.add(slot{s.nt, ai, 0}, v, i, nil, 0)
@@ -540,41 +579,40 @@ func (p *gllParser) process(d descriptor) {
 		}
 		j := p.matchTerm(s, i)
 		if j < 0 {
-			p.noteFail(i, s)
+			p.noteFail(L, u, i, s)
 			return
 		}
 		cR := p.getNodeT(i, j)
 		next := slot{L.nt, L.alt, L.dot + 1}
-		w = p.getNodeP(next, w, cR)
+		w = p.getNodeP(next, w, cR, rc)
 		i = j
 		L = next
 	}
 }
 
-// noteFail records that a terminal match failed at input offset i. It maintains
-// the furthest such offset and, for that offset only, the set of terminals that
-// could have been consumed -- the classic "furthest-failure" position used to
-// report where parsing got stuck.
-func (p *gllParser) noteFail(i int, s ssym) {
-	if i > p.maxPos {
-		p.maxPos = i
-		p.expected = p.expected[:0]
-		p.expectedSeen = nil
-	}
-	if i != p.maxPos {
+// noteFail records that a terminal match failed at input offset i, for the
+// furthest-failure position used to report where parsing got stuck. The rules
+// being parsed are read off the GSS, following the first edge of each node.
+func (p *gllParser) noteFail(L slot, u *gssNode, i int, s ssym) {
+	if !p.at(i) {
 		return
 	}
-	desc := "?"
-	if str, ok := s.term.(fmt.Stringer); ok {
-		desc = str.String()
-	}
-	if p.expectedSeen == nil {
-		p.expectedSeen = map[string]bool{}
-	}
-	if !p.expectedSeen[desc] {
-		p.expectedSeen[desc] = true
-		p.expected = append(p.expected, desc)
+	desc := termDesc(s.term)
+	p.note(i, desc)
+	var frames []ruleFrame
+	seen := map[*gssNode]bool{}
+	for nt, start := L.nt, u.pos; ; {
+		if info := p.sg.nts[nt]; info.isRule {
+			frames = append(frames, ruleFrame{info.ruleName, start})
+		}
+		if u == p.u0 || len(u.edges) == 0 || seen[u] {
+			break
+		}
+		seen[u] = true
+		nt, u = u.ret.nt, u.edges[0].to
+		start = u.pos
 	}
+	p.noteIn(desc, frames, i)
 }
 
 // capRC returns the count after consuming one more element of a repetition R
@@ -590,6 +628,16 @@ func capRC(rc int, nt *sgNT) int {
 	return next
 }
 
+// childRC returns the repetition count of the frame entered when calling s from
+// slot L at count rc: a self-reference inside a repetition's own loop alternate
+// continues the chain, any other call starts a fresh one at 0.
+func (sg *slotGrammar) childRC(L slot, rc int, s ssym) int {
+	if s.kind == symNonterm && s.nt == L.nt && sg.nts[s.nt].isRep {
+		return capRC(rc, sg.nts[s.nt])
+	}
+	return 0
+}
+
 // addRepAlts seeds the two alternates of a repetition nonterminal, gated by the
 // current count rc: it may stop (alt 0) only once the minimum is met, and may
 // consume another element (alt 1) only while under the maximum. This enforces
@@ -619,10 +667,13 @@ type Forest struct {
 	root     *gnode
 	built    int
 
-	// maxPos / expected carry the furthest-failure diagnostics from the parse,
-	// surfaced through ParseError when the forest is invalid.
-	maxPos   int
-	expected []string
+	// diag carries the furthest-failure diagnostics from the parse, surfaced
+	// through ParseError when the forest is invalid.
+	diag furthest
+
+	// errs holds the diagnostics of the error spans skipped in the extracted
+	// tree, when WithRecovery is set (see recover.go).
+	errs []*ParseError
 }
 
 // ForestOption configures ParseForest.
@@ -631,6 +682,12 @@ type ForestOption func(*forestConfig)
 type forestConfig struct {
 	maxNodes int
 	maxSlots int
+	recover  []string
+
+	// Disambiguation filters (see disambiguate.go).
+	keep     []func(PackedNode, []byte) bool
+	priority bool
+	longest  bool
 }
 
 // WithMaxForestNodes bounds the number of SPPF nodes the forest may allocate.
@@ -674,6 +731,11 @@ func ParseForest(input []byte, grammar *Grammar, rootRulename string, opts ...Fo
 	if err != nil {
 		return nil, err
 	}
+	if len(cfg.recover) > 0 {
+		if err := sg.addRecovery(grammar, cfg.recover); err != nil {
+			return nil, err
+		}
+	}
 	p := &gllParser{
 		sg:       sg,
 		input:    input,
@@ -687,21 +749,51 @@ func ParseForest(input []byte, grammar *Grammar, rootRulename string, opts ...Fo
 	if p.aborted {
 		return nil, &ErrForestTooLarge{Max: cfg.maxNodes}
 	}
-	return &Forest{sg: sg, input: input, rulename: rootRulename, root: root, built: len(p.nodes), maxPos: p.maxPos, expected: p.expected}, nil
+	f := &Forest{sg: sg, input: input, rulename: rootRulename, root: root, built: len(p.nodes), diag: p.furthest}
+	if root != nil {
+		sortPacks(root)
+		if len(cfg.recover) > 0 {
+			f.pruneRecovered()
+		}
+		f.disambiguate(cfg)
+	}
+	if len(cfg.recover) > 0 && f.root != nil {
+		f.errs = f.recovered(grammar, cfg)
+	}
+	return f, nil
 }
 
-// Valid reports whether the whole input is derivable by the root rule.
-func (f *Forest) Valid() bool { return f.root != nil }
+// Valid reports whether the whole input is derivable by the root rule. A forest
+// recovered from syntax errors (see WithRecovery) is not valid.
+func (f *Forest) Valid() bool { return f.root != nil && len(f.errs) == 0 }
 
 // ParseError returns the furthest-failure diagnostic for an invalid forest:
 // where parsing got stuck and which terminals were expected there. It returns
 // nil when the forest is valid. The returned error unwraps to
 // [ErrNoSolutionFound].
+//
+// For a forest recovered from syntax errors, it returns the first of Errors.
 func (f *Forest) ParseError() *ParseError {
 	if f.Valid() {
 		return nil
 	}
-	return newParseError(f.input, f.maxPos, f.expected)
+	if len(f.errs) > 0 {
+		return f.errs[0]
+	}
+	return f.diag.parseError(f.input, 0)
+}
+
+// Errors returns every syntax error of the input, in input order. Without
+// WithRecovery, or when recovery could not resynchronize, it holds at most the
+// single ParseError. It is empty when the forest is valid.
+func (f *Forest) Errors() []*ParseError {
+	if f.Valid() {
+		return nil
+	}
+	if len(f.errs) > 0 {
+		return f.errs
+	}
+	return []*ParseError{f.ParseError()}
 }
 
 // Nodes returns the number of forest nodes reachable from the root.
@@ -798,61 +890,82 @@ func (f *Forest) NumTrees() *big.Int {
 // ParseTree is a concrete syntax tree keyed by grammar rules: rule nodes carry
 // the rule name, terminals are leaves with empty Rule.
 type ParseTree struct {
-	Rule       string
-	Start, End int
-	Children   []*ParseTree
-}
-
-// Tree extracts a single parse tree (first packing at each node), or nil if the
-// input is invalid. A visited guard keeps extraction finite on cyclic forests.
+	Rule string
+	// Alternative is the 0-based index of the alternative of Rule the node
+	// derives, or -1 for a span skipped by WithRecovery. It is 0 for terminals.
+	Alternative int
+	Start, End  int
+	Children    []*ParseTree
+	// Literal is the text a node inserted by an edit stands for, nil for the
+	// nodes of a parse. The node and its descendants span it rather than the
+	// input (see Unparse).
+	Literal []byte
+}
+
+// Tree extracts a single parse tree (first packing at each node, see Trees), or
+// nil if the input is invalid. A visited guard keeps extraction finite on cyclic forests.
+// A forest recovered from syntax errors yields a partial tree, in which each
+// skipped span is a leaf of the synchronization rule it replaces.
 func (f *Forest) Tree() *ParseTree {
 	if f.root == nil {
 		return nil
 	}
-	return f.emitSymbol(f.root, map[*gnode]bool{})
+	return f.emitSymbol(f.root, nil, map[*gnode]bool{})
 }
 
-func (f *Forest) emitSymbol(n *gnode, onStack map[*gnode]bool) *ParseTree {
+// emitSymbol extracts the tree of n, taking at every node the packing pick
+// maps it to, or the first one.
+func (f *Forest) emitSymbol(n *gnode, pick map[*gnode]int, onStack map[*gnode]bool) *ParseTree {
 	t := &ParseTree{Start: n.Start, End: n.End}
 	if n.kind == gSymbol && n.nt >= 0 && f.sg.nts[n.nt].isRule {
 		t.Rule = f.sg.nts[n.nt].ruleName
+		t.Alternative = f.ruleAlternative(n, pick[n])
 	}
 	onStack[n] = true
-	f.collect(n, &t.Children, onStack)
+	f.collect(n, &t.Children, pick, onStack)
 	delete(onStack, n)
 	return t
 }
 
-func (f *Forest) collect(n *gnode, into *[]*ParseTree, onStack map[*gnode]bool) {
+// ruleAlternative returns the alternative of its rule the rule node n derives
+// by its k-th pack.
+func (f *Forest) ruleAlternative(n *gnode, k int) int {
+	if k >= len(n.alts) {
+		return 0
+	}
+	return f.sg.alternative(n.nt, n.alts[k])
+}
+
+func (f *Forest) collect(n *gnode, into *[]*ParseTree, pick map[*gnode]int, onStack map[*gnode]bool) {
 	if len(n.packs) == 0 {
-		if n.kind == gTerm && n.End > n.Start {
+		if (n.kind == gTerm || n.kind == gErr) && n.End > n.Start {
 			*into = append(*into, &ParseTree{Start: n.Start, End: n.End})
 		}
 		return
 	}
-	for _, c := range n.packs[0] {
-		f.collectChild(c, into, onStack)
+	for _, c := range n.packs[pick[n]] {
+		f.collectChild(c, into, pick, onStack)
 	}
 }
 
-func (f *Forest) collectChild(c *gnode, into *[]*ParseTree, onStack map[*gnode]bool) {
+func (f *Forest) collectChild(c *gnode, into *[]*ParseTree, pick map[*gnode]int, onStack map[*gnode]bool) {
 	switch {
-	case c.kind == gTerm:
+	case c.kind == gTerm || c.kind == gErr:
 		if c.End > c.Start {
 			*into = append(*into, &ParseTree{Start: c.Start, End: c.End})
 		}
 	case c.kind == gSymbol && c.nt >= 0 && f.sg.nts[c.nt].isRule:
 		if onStack[c] {
-			*into = append(*into, &ParseTree{Rule: f.sg.nts[c.nt].ruleName, Start: c.Start, End: c.End})
+			*into = append(*into, &ParseTree{Rule: f.sg.nts[c.nt].ruleName, Alternative: f.ruleAlternative(c, pick[c]), Start: c.Start, End: c.End})
 			return
 		}
-		*into = append(*into, f.emitSymbol(c, onStack))
+		*into = append(*into, f.emitSymbol(c, pick, onStack))
 	default:
 		if onStack[c] {
 			return
 		}
 		onStack[c] = true
-		f.collect(c, into, onStack)
+		f.collect(c, into, pick, onStack)
 		delete(onStack, c)
 	}
 }
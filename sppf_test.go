@@ -2,7 +2,9 @@ package goabnf
 
 import (
 	"math"
+	"math/big"
 	"regexp"
+	"slices"
 	"strings"
 	"testing"
 	"unicode/utf8"
@@ -82,6 +84,40 @@ func Test_U_ParseForest_AmbiguityCount_Catalan(t *testing.T) {
 	}
 }
 
+// Alternates deriving a span with the same children are distinct derivations:
+// a = "x" / "x" has two trees over "x". Packs used to be merged by children
+// alone, which counted one; merged is that count, of the root's packs told
+// apart by their children only.
+func Test_U_ParseForest_DuplicateAlternatives(t *testing.T) {
+	for _, tc := range []struct {
+		src, in      string
+		merged, want int64
+	}{
+		{"a = \"x\"\r\n", "x", 1, 1},
+		{"a = \"x\" / \"y\"\r\n", "x", 1, 1},
+		{"a = \"x\" / \"x\"\r\n", "x", 1, 2},
+		{"a = \"x\" / \"x\" / \"x\"\r\n", "x", 1, 3},
+		{"a = b / b\r\nb = \"x\" / \"x\"\r\n", "x", 1, 4},
+	} {
+		g := mustGrammar(tc.src)
+		f, err := ParseForest([]byte(tc.in), g, "a")
+		require.NoError(t, err)
+		assert.Equalf(t, tc.want, f.NumTrees().Int64(), "%s", tc.src)
+		assert.Equalf(t, tc.want > 1, f.Ambiguous(), "%s", tc.src)
+		bf, err := ParseBSR([]byte(tc.in), g, "a")
+		require.NoError(t, err)
+		assert.Equalf(t, tc.want, bf.NumTrees().Int64(), "BSR %s", tc.src)
+
+		var byChildren [][]*gnode
+		for _, pk := range f.root.packs {
+			if !slices.ContainsFunc(byChildren, func(o []*gnode) bool { return slices.Equal(o, pk) }) {
+				byChildren = append(byChildren, pk)
+			}
+		}
+		assert.Equalf(t, tc.merged, int64(len(byChildren)), "%s", tc.src)
+	}
+}
+
 // An infinitely-ambiguous grammar yields a cyclic forest; NumTrees reports -1.
 func Test_U_ParseForest_InfiniteAmbiguity(t *testing.T) {
 	g := mustGrammar("a = a / \"x\"\r\n")
@@ -196,6 +232,27 @@ func Test_U_NativeRepetition(t *testing.T) {
 	require.True(t, f2.Valid())
 	assert.Equal(t, "1", f2.NumTrees().String(), "exact 2 of a|aa over aaaa")
 
+	// A span is derived differently depending on the count before it: over
+	// "aaaa", "a"+"a" ends a repetition started by "aa" but not one started by
+	// "a"+"a", which would make 4 elements. Nullable elements likewise leave
+	// finitely many trees, each count being bounded.
+	for _, tc := range []struct {
+		src, in, want string
+	}{
+		{"a = 2*3(\"a\" / \"aa\")\r\n", "aaaa", "4"},
+		{"a = 2*3(\"a\" / \"\")\r\n", "", "2"},
+		{"a = 2*3(\"a\" / \"\")\r\n", "a", "5"},
+		{"a = 2*3[\"a\"]\r\n", "aa", "4"},
+	} {
+		gg := mustGrammar(tc.src)
+		f, err := ParseForest([]byte(tc.in), gg, "a")
+		require.NoErrorf(t, err, "%s on %q", tc.src, tc.in)
+		assert.Equalf(t, tc.want, f.NumTrees().String(), "SPPF %s on %q", tc.src, tc.in)
+		bf, err := ParseBSR([]byte(tc.in), gg, "a")
+		require.NoErrorf(t, err, "%s on %q", tc.src, tc.in)
+		assert.Equalf(t, tc.want, bf.NumTrees().String(), "BSR %s on %q", tc.src, tc.in)
+	}
+
 	// Native repetition must agree with the recognizer across the bound edges.
 	for _, tc := range []struct {
 		src string
@@ -413,3 +470,63 @@ func Test_U_NumVal_Consumers_NoPanic(t *testing.T) {
 		}, "TransitionGraph src %q", c.src)
 	}
 }
+
+func Test_U_Forest_Trees(t *testing.T) {
+	g := mustGrammar("a = a a / \"x\"\r\n")
+	f, err := ParseForest([]byte("xxxx"), g, "a")
+	require.NoError(t, err)
+
+	var trees []*ParseTree
+	for tr := range f.Trees(0) {
+		trees = append(trees, tr)
+	}
+	require.Len(t, trees, 5) // Catalan(3)
+	assert.Equal(t, f.Tree(), trees[0])
+	for i, tr := range trees {
+		assert.Equal(t, tr, f.TreeAt(big.NewInt(int64(i))))
+		for _, other := range trees[:i] {
+			assert.NotEqual(t, other, tr)
+		}
+	}
+	assert.Nil(t, f.TreeAt(big.NewInt(5)))
+	assert.Nil(t, f.TreeAt(big.NewInt(-1)))
+
+	n := 0
+	for range f.Trees(2) {
+		n++
+	}
+	assert.Equal(t, 2, n)
+
+	// Infinitely ambiguous: only the acyclic derivation is enumerated.
+	f, err = ParseForest([]byte("x"), mustGrammar("a = a / \"x\"\r\n"), "a")
+	require.NoError(t, err)
+	trees = slices.Collect(f.Trees(0))
+	assert.Equal(t, []*ParseTree{{Rule: "a", Alternative: 1, Start: 0, End: 1, Children: []*ParseTree{{Start: 0, End: 1}}}}, trees)
+	assert.Nil(t, f.TreeAt(big.NewInt(0)))
+
+	// Splitting "xx" between the repetitions builds the same tree three ways:
+	// it is counted three times but yielded once.
+	f, err = ParseForest([]byte("xx"), mustGrammar("a = *\"x\" *\"x\"\r\n"), "a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), f.NumTrees().Int64())
+	trees = slices.Collect(f.Trees(0))
+	require.Len(t, trees, 1)
+	for i := range 3 {
+		assert.Equal(t, trees[0], f.TreeAt(big.NewInt(int64(i))))
+	}
+
+	// However the grammar packs them, no two yielded trees are equal.
+	for _, c := range invariantCorpus {
+		g := mustGrammar(c.src + "\r\n")
+		for _, in := range enumerate(c.alpha, c.maxn) {
+			f, err := ParseForest([]byte(in), g, "a")
+			require.NoError(t, err)
+			trees := slices.Collect(f.Trees(0))
+			for i, tr := range trees {
+				for _, other := range trees[:i] {
+					assert.NotEqualf(t, other, tr, "%s on %q", c.name, in)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package goabnf
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// stream.go holds an incremental recognizer that validates input delivered in
+// chunks (an io.Reader, or successive Write calls) without buffering all of it.
+//
+// It runs the same GLL control as sppf.go/bsr.go over the same slot grammar, but
+// records nothing and processes descriptors strictly in input-position order.
+// That ordering is what makes the engine streamable:
+//
+//   - a descriptor only inspects input at and after its own position, so every
+//     byte before the lowest pending position is dead and is released;
+//   - GSS nodes are only ever looked up (and popped-replayed) at the current
+//     position, so the GSS index and the descriptor "seen" set are kept for the
+//     current position only; older nodes stay alive solely through the edges of
+//     live ones;
+//   - a nonterminal in tail position is called without a new GSS frame (its
+//     completion would only pop to the caller anyway), so the self-recursive
+//     repetition encoding R ::= eps | E R of a line-oriented `*line` rule keeps
+//     a constant-size stack instead of one frame per line.
+//
+// A terminal never needs more than a bounded number of bytes (see termLook), so
+// a position is processed as soon as that many bytes are buffered past it, or
+// the input is known to have ended.
+
+// streamDesc is a descriptor of the streaming recognizer. Its input position is
+// implicit: descriptors are bucketed by position.
+type streamDesc struct {
+	L  slot
+	u  *streamGNode
+	rc int
+}
+
+// streamGNode is a GSS node of the streaming recognizer. Unlike the SPPF and BSR
+// engines, it only remembers whether it popped at its own position: a pop at a
+// later position can never be replayed, since no edge is added to a node once
+// the recognizer has moved past it.
+type streamGNode struct {
+	ret     slot
+	pos     int
+	rc      int
+	edges   []*streamGNode
+	edgeSet map[*streamGNode]bool // only allocated once edges grows large
+	nulled  bool
+}
+
+// link adds the edge v -> u, reporting whether it is new. Most nodes have one or
+// two callers, so edges are scanned linearly until a set pays off.
+func (v *streamGNode) link(u *streamGNode) bool {
+	if v.edgeSet != nil {
+		if v.edgeSet[u] {
+			return false
+		}
+		v.edgeSet[u] = true
+	} else {
+		for _, e := range v.edges {
+			if e == u {
+				return false
+			}
+		}
+		if len(v.edges) == 8 {
+			v.edgeSet = make(map[*streamGNode]bool, 16)
+			for _, e := range v.edges {
+				v.edgeSet[e] = true
+			}
+			v.edgeSet[u] = true
+		}
+	}
+	v.edges = append(v.edges, u)
+	return true
+}
+
+// StreamRecognizer is an incremental counterpart of [Grammar.IsValid]: input is
+// fed through Write (it is an io.Writer, so io.Copy works) and the verdict is
+// read with Close. Only the live GLL frontier and the few bytes it can still
+// look at are kept, so arbitrarily large inputs are recognized in memory bounded
+// by the grammar's nesting depth rather than by the input length.
+//
+// Once the input read so far cannot be the prefix of any valid input, Write
+// returns a [*ParseError] locating the first failure, and keeps returning it.
+type StreamRecognizer struct {
+	sg   *slotGrammar
+	look int // maximum number of bytes a terminal may inspect
+
+	buf  []byte // input from offset base on
+	base int
+	eof  bool
+
+	pos     int // position currently being processed (the frontier)
+	buckets map[int][]streamDesc
+	seen    map[int]map[streamDesc]bool
+	spare   []map[streamDesc]bool   // recycled seen sets
+	gss     map[gssKey]*streamGNode // nodes created at pos
+	u0      *streamGNode
+	accept  int // last position at which the root rule completed, or -1
+
+	// Diagnostics, shared with the other engines. line/col/prev track the
+	// frontier position as it advances, and failLine/failCol/failPrev/failCur
+	// locate the furthest failure, so that it can be reported after its bytes
+	// have been released.
+	furthest
+	line, col int
+	prev      int
+	failLine  int
+	failCol   int
+	failPrev  int
+	failCur   int
+
+	err error
+}
+
+// NewStreamRecognizer prepares an incremental recognizer of rulename.
+func NewStreamRecognizer(g *Grammar, rulename string) (*StreamRecognizer, error) {
+	sg, err := compileSlots(g, rulename, defaultMaxSlots)
+	if err != nil {
+		return nil, err
+	}
+	s := &StreamRecognizer{
+		sg:       sg,
+		buckets:  map[int][]streamDesc{},
+		seen:     map[int]map[streamDesc]bool{},
+		gss:      map[gssKey]*streamGNode{},
+		accept:   -1,
+		line:     1,
+		col:      1,
+		prev:     -1,
+		failLine: 1,
+		failCol:  1,
+		failPrev: -1,
+		failCur:  -1,
+	}
+	for _, nt := range sg.nts {
+		for _, prod := range nt.alts {
+			for _, sym := range prod {
+				if l := termLook(sym, sg.mode); l > s.look {
+					s.look = l
+				}
+			}
+		}
+	}
+	s.u0 = &streamGNode{ret: slot{-1, -1, -1}}
+	for ai := range sg.nts[sg.start].alts {
+		s.add(slot{sg.start, ai, 0}, s.u0, 0, 0)
+	}
+	return s, nil
+}
+
+// termLook bounds the number of input bytes the terminal s may inspect in mode m.
+func termLook(s ssym, m MatchMode) int {
+	if s.kind != symTerm {
+		return 0
+	}
+	switch v := s.term.(type) {
+	case ElemCharVal:
+		return len(v.Values) * utf8.UTFMax
+	case ElemNumVal:
+		if v.Status == StatRange {
+			return m.charLen(m.maxChar())
+		}
+		n := 0
+		for _, e := range v.Elems {
+			if ru := numvalToRune(e, v.Base); m.validChar(ru) {
+				n += m.charLen(ru)
+			}
+		}
+		return n
+	}
+	return 0
+}
+
+// Write feeds the next chunk of input. It returns a [*ParseError] as soon as
+// the input seen so far can no longer be completed into a valid one.
+func (s *StreamRecognizer) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.eof {
+		return 0, &ErrClosed{}
+	}
+	s.buf = append(s.buf, p...)
+	s.run()
+	return len(p), s.err
+}
+
+// Close signals the end of input and reports the verdict: nil when the whole
+// input is derivable by the rule, a [*ParseError] otherwise.
+func (s *StreamRecognizer) Close() error {
+	if s.err != nil || s.eof {
+		return s.err
+	}
+	s.eof = true
+	s.run()
+	if s.err == nil && s.accept != s.end() {
+		s.fail()
+	}
+	return s.err
+}
+
+// Offset returns the number of input bytes fed so far.
+func (s *StreamRecognizer) Offset() int { return s.end() }
+
+func (s *StreamRecognizer) end() int { return s.base + len(s.buf) }
+
+// run processes every position whose lookahead is available, then releases the
+// input no descriptor can reference anymore.
+func (s *StreamRecognizer) run() {
+	for len(s.buckets) > 0 {
+		for len(s.buckets[s.pos]) == 0 {
+			s.step()
+		}
+		if !s.eof && s.pos+s.look > s.end() {
+			break
+		}
+		for len(s.buckets[s.pos]) > 0 {
+			b := s.buckets[s.pos]
+			d := b[len(b)-1]
+			s.buckets[s.pos] = b[:len(b)-1]
+			s.process(d)
+		}
+		delete(s.buckets, s.pos)
+	}
+	// The frontier died: the input is only valid if it ends exactly where the
+	// root rule last completed.
+	if len(s.buckets) == 0 && s.end() > s.accept {
+		s.fail()
+	}
+	if n := s.pos - s.base; n > 0 {
+		if n > len(s.buf) {
+			n = len(s.buf)
+		}
+		if i := s.maxPos - s.base; i >= 0 && i < n {
+			s.failCur = int(s.buf[i])
+		}
+		s.buf = append(s.buf[:0], s.buf[n:]...)
+		s.base += n
+	}
+}
+
+// step advances the frontier by one byte, dropping the per-position indexes.
+func (s *StreamRecognizer) step() {
+	if i := s.pos - s.base; i < len(s.buf) {
+		c := s.buf[i]
+		if c == '\n' {
+			s.line++
+			s.col = 1
+		} else {
+			s.col++
+		}
+		s.prev = int(c)
+	}
+	if m, ok := s.seen[s.pos]; ok {
+		delete(s.seen, s.pos)
+		clear(m)
+		s.spare = append(s.spare, m)
+	}
+	s.pos++
+	clear(s.gss)
+}
+
+// fail records the furthest failure as the verdict. Its byte is read from the
+// buffer, or from failCur once it has been released.
+func (s *StreamRecognizer) fail() {
+	cur := s.failCur
+	if i := s.maxPos - s.base; i >= 0 && i < len(s.buf) {
+		cur = int(s.buf[i])
+	}
+	pe := parseErrorAt(s.maxPos, s.failLine, s.failCol, s.expected, cur, s.failPrev)
+	s.err = s.withContexts(pe)
+}
+
+func (s *StreamRecognizer) add(L slot, u *streamGNode, i, rc int) {
+	d := streamDesc{L, u, rc}
+	seen := s.seen[i]
+	if seen == nil {
+		if n := len(s.spare); n > 0 {
+			seen = s.spare[n-1]
+			s.spare = s.spare[:n-1]
+		} else {
+			seen = map[streamDesc]bool{}
+		}
+		s.seen[i] = seen
+	}
+	if seen[d] {
+		return
+	}
+	seen[d] = true
+	s.buckets[i] = append(s.buckets[i], d)
+}
+
+func (s *StreamRecognizer) create(ret slot, u *streamGNode, rc int) *streamGNode {
+	k := gssKey{ret, s.pos, rc}
+	v, ok := s.gss[k]
+	if !ok {
+		v = &streamGNode{ret: ret, pos: s.pos, rc: rc}
+		s.gss[k] = v
+	}
+	if v.link(u) && v.nulled {
+		s.add(ret, u, s.pos, rc)
+	}
+	return v
+}
+
+func (s *StreamRecognizer) pop(u *streamGNode) {
+	if u == s.u0 {
+		s.accept = s.pos
+		return
+	}
+	if u.pos == s.pos {
+		u.nulled = true
+	}
+	for _, v := range u.edges {
+		s.add(u.ret, v, s.pos, u.rc)
+	}
+}
+
+func (s *StreamRecognizer) addRepAlts(ntID int, v *streamGNode, rc int) {
+	nt := s.sg.nts[ntID]
+	if rc >= nt.repMin {
+		s.add(slot{ntID, 0, 0}, v, s.pos, rc)
+	}
+	if nt.repMax == inf || rc < nt.repMax {
+		s.add(slot{ntID, 1, 0}, v, s.pos, rc)
+	}
+}
+
+// process runs one step of descriptor d at the current position. A terminal
+// match schedules its continuation at the end position rather than continuing
+// inline, which keeps processing in position order.
+func (s *StreamRecognizer) process(d streamDesc) {
+	L, u, rc := d.L, d.u, d.rc
+	prod := s.sg.nts[L.nt].alts[L.alt]
+	if L.dot == len(prod) {
+		s.pop(u)
+		return
+	}
+	sym := prod[L.dot]
+	next := slot{L.nt, L.alt, L.dot + 1}
+	if sym.kind == symNonterm {
+		// Tail call: the continuation would complete and pop to u straight
+		// away, so return there directly instead of growing the stack.
+		v := u
+		if next.dot != len(prod) {
+			v = s.create(next, u, rc)
+		}
+		if s.sg.nts[sym.nt].isRep {
+			s.addRepAlts(sym.nt, v, s.sg.childRC(L, rc, sym))
+		} else {
+			for ai := range s.sg.nts[sym.nt].alts {
+				s.add(slot{sym.nt, ai, 0}, v, s.pos, 0)
+			}
+		}
+		return
+	}
+	j := termEnd(s.sg.mode, s.sg.fold, s.buf, sym, s.pos-s.base)
+	if j < 0 {
+		s.noteFail(L, u, sym)
+		return
+	}
+	s.add(next, u, s.base+j, rc)
+}
+
+// noteFail is gllParser.noteFail over the streaming GSS. Descriptors run in
+// position order, so the furthest position only moves to the frontier, where
+// its location is still known.
+func (s *StreamRecognizer) noteFail(L slot, u *streamGNode, sym ssym) {
+	if s.pos > s.maxPos {
+		s.failLine, s.failCol, s.failPrev, s.failCur = s.line, s.col, s.prev, -1
+	}
+	if !s.at(s.pos) {
+		return
+	}
+	desc := termDesc(sym.term)
+	s.note(s.pos, desc)
+	var frames []ruleFrame
+	seen := map[*streamGNode]bool{}
+	for nt, start := L.nt, u.pos; ; {
+		if info := s.sg.nts[nt]; info.isRule {
+			frames = append(frames, ruleFrame{info.ruleName, start})
+		}
+		if u == s.u0 || len(u.edges) == 0 || seen[u] {
+			break
+		}
+		seen[u] = true
+		nt, u = u.ret.nt, u.edges[0]
+		start = u.pos
+	}
+	s.noteIn(desc, frames, s.pos)
+}
+
+// IsValidReader is the streaming counterpart of [Grammar.IsValid]: it reads r
+// to the end (or to the first failure) in chunks, never holding more than the
+// recognizer's live frontier in memory. When the input is rejected it returns
+// false and a [*ParseError] locating the first failure; read errors are
+// returned as-is.
+func (g *Grammar) IsValidReader(rulename string, r io.Reader) (bool, error) {
+	s, err := NewStreamRecognizer(g, rulename)
+	if err != nil {
+		return false, err
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if _, err := s.Write(buf[:n]); err != nil {
+				return false, err
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return false, rerr
+		}
+	}
+	if err := s.Close(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,127 @@
+package goabnf
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_U_StreamRecognizer_AgreesWithIsValid pins the streaming recognizer to the
+// reference verdict, whether the input arrives whole or one byte at a time.
+func Test_U_StreamRecognizer_AgreesWithIsValid(t *testing.T) {
+	corpus := append([]invariantCase{
+		{"lines", `a = *(1*"x" %x0D.0A)`, "x\r\n", 5, false},
+		{"multibyte", `a = *(%x3C0 / "b")`, "π\xcfb", 4, false},
+		{"rightrec", `a = "x" a / ""`, "x", 5, false},
+	}, invariantCorpus...)
+	for _, c := range corpus {
+		t.Run(c.name, func(t *testing.T) {
+			g := mustGrammar(c.src + "\r\n")
+			for _, in := range enumerate(c.alpha, c.maxn) {
+				want, err := g.IsValid("a", []byte(in))
+				require.NoError(t, err)
+
+				got, err := g.IsValidReader("a", strings.NewReader(in))
+				assert.Equalf(t, want, got, "whole input %q", in)
+				if !want {
+					var pe *ParseError
+					assert.Truef(t, errors.As(err, &pe), "rejection of %q must carry a *ParseError", in)
+				}
+
+				got, _ = g.IsValidReader("a", iotest.OneByteReader(strings.NewReader(in)))
+				assert.Equalf(t, want, got, "byte-by-byte input %q", in)
+			}
+		})
+	}
+}
+
+// Test_U_StreamRecognizer_FirstFailure pins that the failure is reported where
+// the frontier died, with its line and column, as soon as the offending bytes
+// are written rather than at Close.
+func Test_U_StreamRecognizer_FirstFailure(t *testing.T) {
+	g := mustGrammar("log = *line\r\nline = 1*DIGIT CRLF\r\n")
+	s, err := NewStreamRecognizer(g, "log")
+	require.NoError(t, err)
+
+	_, err = s.Write([]byte("12\r\n34\r\n"))
+	require.NoError(t, err)
+	_, err = s.Write([]byte("5x6\r\n"))
+	var pe *ParseError
+	require.True(t, errors.As(err, &pe), "failure must surface from Write")
+	assert.Equal(t, 9, pe.Offset)
+	assert.Equal(t, 3, pe.Line)
+	assert.Equal(t, 2, pe.Col)
+	assert.Equal(t, `"x"`, pe.Found)
+	assert.NotEmpty(t, pe.Expected)
+	assert.True(t, errors.Is(s.Close(), ErrNoSolutionFound))
+
+	// Bytes past the last completion of the root rule are rejected too, located
+	// like the other engines do.
+	ab := mustGrammar("a = \"ab\"\r\n")
+	ok, err := ab.IsValidReader("a", strings.NewReader("abc"))
+	assert.False(t, ok)
+	require.True(t, errors.As(err, &pe))
+	f, err := ParseForest([]byte("abc"), ab, "a")
+	require.NoError(t, err)
+	assert.Equal(t, f.ParseError(), pe)
+
+	// A truncated input fails at EOF.
+	ok, err = g.IsValidReader("log", strings.NewReader("12\r"))
+	assert.False(t, ok)
+	require.True(t, errors.As(err, &pe))
+	assert.Equal(t, "EOF", pe.Found)
+}
+
+// Test_U_StreamRecognizer_BoundedMemory pins that consumed input is released and
+// that a line-oriented grammar keeps a constant-size frontier however many lines
+// are fed: the repetition's tail self-call does not stack a frame per line.
+func Test_U_StreamRecognizer_BoundedMemory(t *testing.T) {
+	g := mustGrammar("log = *line\r\nline = 1*(ALPHA / DIGIT / SP) CRLF\r\n")
+	s, err := NewStreamRecognizer(g, "log")
+	require.NoError(t, err)
+
+	chunk := bytes.Repeat([]byte("GET 200 ok\r\n"), 512)
+	maxDepth := 0
+	for i := 0; i < 64; i++ {
+		_, err := s.Write(chunk)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(s.buf), s.look, "consumed input must be released")
+		if d := streamDepth(s); d > maxDepth {
+			maxDepth = d
+		}
+	}
+	assert.Less(t, maxDepth, 16, "frontier must not grow with the number of lines")
+	require.NoError(t, s.Close())
+	assert.Equal(t, 64*len(chunk), s.Offset())
+
+	_, err = s.Write(chunk)
+	var closed *ErrClosed
+	assert.True(t, errors.As(err, &closed))
+}
+
+// streamDepth returns the number of GSS nodes reachable from the pending
+// descriptors, i.e. the size of the live frontier.
+func streamDepth(s *StreamRecognizer) int {
+	seen := map[*streamGNode]bool{}
+	var walk func(n *streamGNode)
+	walk = func(n *streamGNode) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		for _, e := range n.edges {
+			walk(e)
+		}
+	}
+	for _, b := range s.buckets {
+		for _, d := range b {
+			walk(d.u)
+		}
+	}
+	return len(seen)
+}
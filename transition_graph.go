@@ -5,7 +5,6 @@ import (
 	"slices"
 	"sort"
 	"strings"
-	"unicode/utf8"
 
 	uuid "github.com/hashicorp/go-uuid"
 )
@@ -563,7 +562,7 @@ func (tgr *TransitionGraphReader) ensureStaged() {
 		tgr.varTot = tgr.varTot[:0]
 		tgr.varIdx = tgr.varIdx[:0]
 		for i := range tgr.stack {
-			_, vt := nodeEmit(tgr.stack[i].node, 0)
+			_, vt := tgr.tg.nodeEmit(tgr.stack[i].node, 0)
 			if vt < 1 {
 				vt = 1
 			}
@@ -573,7 +572,7 @@ func (tgr *TransitionGraphReader) ensureStaged() {
 	}
 	tgr.staged = tgr.staged[:0]
 	for i := range tgr.stack {
-		b, _ := nodeEmit(tgr.stack[i].node, tgr.varIdx[i])
+		b, _ := tgr.tg.nodeEmit(tgr.stack[i].node, tgr.varIdx[i])
 		tgr.staged = append(tgr.staged, b...)
 	}
 	tgr.hasStaged = true
@@ -780,7 +779,7 @@ func (tgr *TransitionGraphReader) buildCompact() {
 		newCount := 0
 		for p, n := range w {
 			id := tgNodeID(n)
-			_, vt := nodeEmit(n, 0)
+			_, vt := tgr.tg.nodeEmit(n, 0)
 			if vt < 1 {
 				vt = 1
 			}
@@ -801,7 +800,7 @@ func (tgr *TransitionGraphReader) buildCompact() {
 		}
 		var bs []byte
 		for p, n := range w {
-			b, _ := nodeEmit(n, va[p])
+			b, _ := tgr.tg.nodeEmit(n, va[p])
 			bs = append(bs, b...)
 		}
 		tgr.prods = append(tgr.prods, bs)
@@ -859,9 +858,10 @@ func (tgr *TransitionGraphReader) buildCompact() {
 
 // nodeEmit returns the bytes a node emits for variation index tpos, and the
 // node's total number of variations. For a non-sensitive char-val the variations
-// are the 2^k casings of its k cased letters; for a num-val range, one byte per
-// value in the range.
-func nodeEmit(node *Node, tpos int32) (prod []byte, vtotal int32) {
+// are the 2^k casings of its k cased letters, or with FoldUnicode the
+// combinations of the variants of its characters; for a num-val range, one byte per
+// value in the range, encoded in the grammar's Mode.
+func (tg *TransitionGraph) nodeEmit(node *Node, tpos int32) (prod []byte, vtotal int32) {
 	if node == emptyNode {
 		return nil, 1
 	}
@@ -873,6 +873,23 @@ func nodeEmit(node *Node, tpos int32) (prod []byte, vtotal int32) {
 			}
 			return prod, 1
 		}
+		if tg.grammar.Folding == FoldUnicode {
+			// The variations are the products of the variants of each
+			// character, read as a mixed-radix number. Characters beyond the
+			// same cap as below are emitted as written.
+			vtotal = 1
+			for _, r := range v.Values {
+				vs := FoldUnicode.variants(r)
+				n := int32(len(vs))
+				if vtotal > (1<<30)/n {
+					prod = append(prod, string(r)...)
+					continue
+				}
+				prod = append(prod, string(vs[tpos/vtotal%n])...)
+				vtotal *= n
+			}
+			return prod, vtotal
+		}
 		var numVar int
 		for _, r := range v.Values {
 			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
@@ -916,22 +933,23 @@ func nodeEmit(node *Node, tpos int32) (prod []byte, vtotal int32) {
 		switch v.Status {
 		case StatSeries:
 			for _, elem := range v.Elems {
-				r := numvalToRune(elem, v.Base)
-				prod = append(prod, []byte(string(r))...)
+				// A value that is no character cannot be emitted.
+				if r := numvalToRune(elem, v.Base); tg.grammar.Mode.validChar(r) {
+					prod = tg.grammar.Mode.appendChar(prod, r)
+				}
 			}
 			return prod, 1
 		case StatRange:
 			min, max := numvalToInt32(v.Elems[0], v.Base), numvalToInt32(v.Elems[1], v.Base)
-			// The graph operates over runes; clamp to the Unicode range so an
+			// The graph operates over characters; clamp to the largest one so an
 			// out-of-range bound cannot overflow the int32 count below.
-			if max > utf8.MaxRune {
-				max = utf8.MaxRune
+			if mc := tg.grammar.Mode.maxChar(); max > mc {
+				max = mc
 			}
-			if min > utf8.MaxRune || min > max {
+			if min > tg.grammar.Mode.maxChar() || min > max {
 				return nil, 1 // no representable character in this range
 			}
-			r := rune(min + tpos)
-			return []byte(string(r)), max - min + 1
+			return tg.grammar.Mode.appendChar(nil, min+tpos), max - min + 1
 		}
 	}
 	return nil, 1
@@ -1285,59 +1303,44 @@ func (m *tgmachine) elemGraph(elem ElemItf) (entrypoints []*Node, endpoints []*N
 			return
 		}
 
-		if err := m.reserve(2 * len(v.Values)); err != nil {
+		// One node per character the value folds with.
+		variants := make([][]rune, len(v.Values))
+		total := 0
+		for i, r := range v.Values {
+			switch {
+			case v.Sensitive:
+				variants[i] = []rune{r}
+			case m.grammar.Folding == FoldUnicode:
+				variants[i] = FoldUnicode.variants(r)
+			case runeMin(r) != runeMax(r):
+				variants[i] = []rune{runeMin(r), runeMax(r)}
+			default:
+				variants[i] = []rune{r}
+			}
+			total += len(variants[i])
+		}
+		if err := m.reserve(total); err != nil {
 			return nil, nil, err
 		}
 
 		var prevs []*Node = nil
 		var curr []*Node = nil
-		for _, r := range v.Values {
-			isLower := r >= 'a' && r <= 'z'
-			isUpper := r >= 'A' && r <= 'Z'
-			requireBoth := (isLower || isUpper) && !v.Sensitive
-
-			if !requireBoth {
-				n := newNode(ElemCharVal{
+		for _, vs := range variants {
+			curr = make([]*Node, len(vs))
+			for i, r := range vs {
+				curr[i] = newNode(ElemCharVal{
 					Sensitive: true,
 					Values:    []rune{r},
 				})
-				curr = []*Node{n}
-
-				if prevs == nil {
-					entrypoints = append(entrypoints, n)
-				}
-				for _, v := range prevs {
-					v.Nexts = append(v.Nexts, curr...)
-				}
-				prevs = []*Node{n}
-			} else {
-				nlv := r
-				if isUpper {
-					nlv = runeMin(nlv)
-				}
-				nuv := r
-				if isLower {
-					nuv = runeMax(nuv)
-				}
-
-				nl := newNode(ElemCharVal{
-					Sensitive: true,
-					Values:    []rune{nlv},
-				})
-				nu := newNode(ElemCharVal{
-					Sensitive: true,
-					Values:    []rune{nuv},
-				})
-				curr = []*Node{nl, nu}
+			}
 
-				if prevs == nil {
-					entrypoints = append(entrypoints, nl, nu)
-				}
-				for _, v := range prevs {
-					v.Nexts = append(v.Nexts, curr...)
-				}
-				prevs = []*Node{nl, nu}
+			if prevs == nil {
+				entrypoints = append(entrypoints, curr...)
 			}
+			for _, v := range prevs {
+				v.Nexts = append(v.Nexts, curr...)
+			}
+			prevs = curr
 		}
 		endpoints = append(endpoints, curr...)
 		return
@@ -1355,14 +1358,14 @@ func (m *tgmachine) elemGraph(elem ElemItf) (entrypoints []*Node, endpoints []*N
 		switch v.Status {
 		case StatRange:
 			min, max := numvalToInt32(v.Elems[0], v.Base), numvalToInt32(v.Elems[1], v.Base)
-			// One node is enumerated per rune; clamp to the Unicode range so an
+			// One node is enumerated per character; clamp to the largest one so an
 			// out-of-range bound cannot overflow the count (int32) and bypass the
 			// node budget, which would turn this loop into a DoS. A range wholly
-			// above U+10FFFF contributes no node (it matches nothing).
-			if max > utf8.MaxRune {
-				max = utf8.MaxRune
+			// above it contributes no node (it matches nothing).
+			if mc := m.grammar.Mode.maxChar(); max > mc {
+				max = mc
 			}
-			if min > utf8.MaxRune || min > max {
+			if min > m.grammar.Mode.maxChar() || min > max {
 				return
 			}
 			if err := m.reserve(int(max - min + 1)); err != nil {
@@ -1382,15 +1385,22 @@ func (m *tgmachine) elemGraph(elem ElemItf) (entrypoints []*Node, endpoints []*N
 			if err := m.reserve(len(v.Elems)); err != nil {
 				return nil, nil, err
 			}
+			// A series is a sequence: chain its values.
+			var prev *Node
 			for _, s := range v.Elems {
 				n := newNode(ElemNumVal{
 					Base:   v.Base,
 					Status: StatSeries,
 					Elems:  []string{s},
 				})
-				entrypoints = append(entrypoints, n)
-				endpoints = append(endpoints, n)
+				if prev == nil {
+					entrypoints = append(entrypoints, n)
+				} else {
+					prev.Nexts = append(prev.Nexts, n)
+				}
+				prev = n
 			}
+			endpoints = append(endpoints, prev)
 		}
 		return
 
@@ -0,0 +1,270 @@
+package goabnf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// treediff.go compares two parse trees of the same rule by their rule nodes
+// rather than by lines. The rule children of two matching nodes are aligned by
+// rule name, preferring identical subtrees, as a longest common subsequence:
+// aligned children are compared in turn, the others were removed or inserted.
+// A node deriving its text from terminals and core rules only, such as a
+// `host = 1*(ALPHA / ".")`, is lexical: it is compared as a whole by its text,
+// so that a changed token is one change and not one per character.
+
+// NodeChangeKind is the way a rule node differs between two trees.
+type NodeChangeKind int
+
+const (
+	// NodeChanged is a node of both trees whose text differs, not accounted
+	// for by the changes of its rule children: a lexical node, or one taking
+	// another alternative or holding other terminals.
+	NodeChanged NodeChangeKind = iota
+	// NodeInserted is a node of the second tree only.
+	NodeInserted
+	// NodeRemoved is a node of the first tree only.
+	NodeRemoved
+)
+
+func (k NodeChangeKind) String() string {
+	switch k {
+	case NodeInserted:
+		return "inserted"
+	case NodeRemoved:
+		return "removed"
+	}
+	return "changed"
+}
+
+// NodeChange is a rule node differing between two trees.
+type NodeChange struct {
+	Kind NodeChangeKind
+	// Path is the rule names from the root down to the node, e.g.
+	// ["uri", "hier", "authority", "host"].
+	Path []string
+	// A and B are the node in the first and second tree, A nil when it was
+	// inserted and B nil when it was removed.
+	A, B *ParseTree
+}
+
+// TreeDiff is the structural difference between two parsed inputs.
+type TreeDiff struct {
+	// A and B are the inputs compared.
+	A, B []byte
+	// Changes are the differing nodes, in input order.
+	Changes []NodeChange
+}
+
+// Equal reports whether the trees are the same.
+func (d *TreeDiff) Equal() bool { return len(d.Changes) == 0 }
+
+// String lists the changes, one per line, with the texts of the nodes.
+func (d *TreeDiff) String() string {
+	if d.Equal() {
+		return "no change\n"
+	}
+	var b strings.Builder
+	for _, c := range d.Changes {
+		path := strings.Join(c.Path, "/")
+		switch c.Kind {
+		case NodeChanged:
+			fmt.Fprintf(&b, "changed %s: %q -> %q\n", path, c.A.Text(d.A), c.B.Text(d.B))
+		case NodeInserted:
+			fmt.Fprintf(&b, "inserted %s at %d: %q\n", path, c.B.Start, c.B.Text(d.B))
+		case NodeRemoved:
+			fmt.Fprintf(&b, "removed %s at %d: %q\n", path, c.A.Start, c.A.Text(d.A))
+		}
+	}
+	return b.String()
+}
+
+// DiffInputs parses a and b as rulename and returns the structural difference
+// of their trees (see DiffTrees). An invalid input is reported with its
+// *ParseError.
+func (g *Grammar) DiffInputs(rulename string, a, b []byte) (*TreeDiff, error) {
+	var trees [2]*ParseTree
+	for k, in := range [][]byte{a, b} {
+		f, err := ParseForest(in, g, rulename)
+		if err != nil {
+			return nil, err
+		}
+		if pe := f.ParseError(); pe != nil {
+			return nil, pe
+		}
+		trees[k] = f.Tree()
+	}
+	return DiffTrees(trees[0], a, trees[1], b), nil
+}
+
+// DiffTrees returns the rule nodes that differ between the tree ta of input a
+// and the tree tb of input b. Two trees of different rules differ as a whole.
+// A nil tree is no tree: against a nil ta, tb was inserted, and against a nil
+// tb, ta was removed.
+func DiffTrees(ta *ParseTree, a []byte, tb *ParseTree, b []byte) *TreeDiff {
+	d := &treeDiffer{
+		d:   &TreeDiff{A: a, B: b},
+		ids: map[string]int{},
+		fps: [2]map[*ParseTree]int{{}, {}},
+	}
+	switch {
+	case ta == nil && tb == nil:
+	case ta == nil:
+		d.change(NodeInserted, []string{tb.Rule}, nil, tb)
+	case tb == nil:
+		d.change(NodeRemoved, []string{ta.Rule}, ta, nil)
+	default:
+		d.node(ta, tb, []string{ta.Rule})
+	}
+	return d.d
+}
+
+type treeDiffer struct {
+	d   *TreeDiff
+	ids map[string]int        // fingerprint of each distinct node shape
+	fps [2]map[*ParseTree]int // fingerprints of the nodes of each tree
+}
+
+// node compares the nodes ta and tb of the same rule, at path.
+func (d *treeDiffer) node(ta, tb *ParseTree, path []string) {
+	if d.fingerprint(0, ta) == d.fingerprint(1, tb) {
+		return
+	}
+	if canon(ta.Rule) != canon(tb.Rule) || ta.Alternative != tb.Alternative || lexical(ta) || lexical(tb) {
+		d.change(NodeChanged, path, ta, tb)
+		return
+	}
+
+	// The terminals of the node itself.
+	var la, lb []string
+	for _, c := range ta.Children {
+		if c.Rule == "" {
+			la = append(la, c.Text(d.d.A))
+		}
+	}
+	for _, c := range tb.Children {
+		if c.Rule == "" {
+			lb = append(lb, c.Text(d.d.B))
+		}
+	}
+	if strings.Join(la, "\x00") != strings.Join(lb, "\x00") || len(la) != len(lb) {
+		d.change(NodeChanged, path, ta, tb)
+	}
+
+	ka, kb := ruleChildren(ta), ruleChildren(tb)
+	i, j := 0, 0
+	for _, p := range d.align(ka, kb) {
+		for ; i < p[0]; i++ {
+			d.change(NodeRemoved, append(path, ka[i].Rule), ka[i], nil)
+		}
+		for ; j < p[1]; j++ {
+			d.change(NodeInserted, append(path, kb[j].Rule), nil, kb[j])
+		}
+		d.node(ka[i], kb[j], append(path, ka[i].Rule))
+		i, j = i+1, j+1
+	}
+	for ; i < len(ka); i++ {
+		d.change(NodeRemoved, append(path, ka[i].Rule), ka[i], nil)
+	}
+	for ; j < len(kb); j++ {
+		d.change(NodeInserted, append(path, kb[j].Rule), nil, kb[j])
+	}
+}
+
+func (d *treeDiffer) change(kind NodeChangeKind, path []string, ta, tb *ParseTree) {
+	d.d.Changes = append(d.d.Changes, NodeChange{Kind: kind, Path: append([]string{}, path...), A: ta, B: tb})
+}
+
+// align returns the index pairs of the aligned children of ka and kb, in
+// order: a longest common subsequence by rule name, among which the one
+// aligning the most identical subtrees.
+func (d *treeDiffer) align(ka, kb []*ParseTree) [][2]int {
+	// score[i][j] is the best score aligning ka[i:] and kb[j:]; an aligned
+	// pair is worth 2, 3 if the subtrees are identical, so that a longer
+	// alignment always wins.
+	score := make([][]int, len(ka)+1)
+	for i := range score {
+		score[i] = make([]int, len(kb)+1)
+	}
+	pair := func(i, j int) int {
+		switch {
+		case d.fingerprint(0, ka[i]) == d.fingerprint(1, kb[j]):
+			return 3
+		case canon(ka[i].Rule) == canon(kb[j].Rule):
+			return 2
+		}
+		return 0
+	}
+	for i := len(ka) - 1; i >= 0; i-- {
+		for j := len(kb) - 1; j >= 0; j-- {
+			score[i][j] = max(score[i+1][j], score[i][j+1])
+			if p := pair(i, j); p > 0 {
+				score[i][j] = max(score[i][j], score[i+1][j+1]+p)
+			}
+		}
+	}
+	var out [][2]int
+	for i, j := 0, 0; i < len(ka) && j < len(kb); {
+		switch p := pair(i, j); {
+		case p > 0 && score[i][j] == score[i+1][j+1]+p:
+			out = append(out, [2]int{i, j})
+			i, j = i+1, j+1
+		case score[i][j] == score[i+1][j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return out
+}
+
+// fingerprint returns an id equal for two nodes exactly when they are of the
+// same rule, take the same alternatives and derive the same text. A node is
+// keyed by its rule, alternative and the ids of its children, so that keys
+// stay as short as the nodes are wide.
+func (d *treeDiffer) fingerprint(side int, t *ParseTree) int {
+	if fp, ok := d.fps[side][t]; ok {
+		return fp
+	}
+	input := d.d.A
+	if side == 1 {
+		input = d.d.B
+	}
+	var b strings.Builder
+	if t.Rule == "" {
+		fmt.Fprintf(&b, "%q", t.Text(input))
+	} else {
+		fmt.Fprintf(&b, "%s/%d(", canon(t.Rule), t.Alternative)
+		for _, c := range t.Children {
+			fmt.Fprintf(&b, "%d ", d.fingerprint(side, c))
+		}
+		b.WriteByte(')')
+	}
+	fp, ok := d.ids[b.String()]
+	if !ok {
+		fp = len(d.ids)
+		d.ids[b.String()] = fp
+	}
+	d.fps[side][t] = fp
+	return fp
+}
+
+// lexical reports whether t only has terminals and core rules as children.
+func lexical(t *ParseTree) bool {
+	for _, c := range t.Children {
+		if c.Rule != "" && !isCoreRule(c.Rule) {
+			return false
+		}
+	}
+	return true
+}
+
+func ruleChildren(t *ParseTree) []*ParseTree {
+	var out []*ParseTree
+	for _, c := range t.Children {
+		if c.Rule != "" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,140 @@
+package goabnf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_DiffInputs(t *testing.T) {
+	t.Parallel()
+
+	uri := mustGrammar(uriLikeAbnf)
+	message := mustGrammar(messageAbnf)
+
+	var tests = map[string]struct {
+		Grammar        *Grammar
+		Rule           string
+		A, B           string
+		ExpectedString string
+	}{
+		"equal": {
+			Grammar:        uri,
+			Rule:           "uri",
+			A:              "http://me@example.org/a/b",
+			B:              "http://me@example.org/a/b",
+			ExpectedString: "no change\n",
+		},
+		"lexical": {
+			// The host is one change, not one per letter.
+			Grammar:        uri,
+			Rule:           "uri",
+			A:              "http://me@example.org/a/b",
+			B:              "http://me@other.net/a/b",
+			ExpectedString: "changed uri/hier/authority/host: \"example.org\" -> \"other.net\"\n",
+		},
+		"segment-inserted": {
+			Grammar:        uri,
+			Rule:           "uri",
+			A:              "http://me@example.org/a/b",
+			B:              "http://me@example.org/a/x/b",
+			ExpectedString: "changed uri/hier/path: \"/a/b\" -> \"/a/x/b\"\ninserted uri/hier/path/segment at 24: \"x\"\n",
+		},
+		"segment-removed": {
+			Grammar:        uri,
+			Rule:           "uri",
+			A:              "http://me@example.org/a/x/b",
+			B:              "http://me@example.org/a/b",
+			ExpectedString: "changed uri/hier/path: \"/a/x/b\" -> \"/a/b\"\nremoved uri/hier/path/segment at 24: \"x\"\n",
+		},
+		"option-removed": {
+			Grammar:        uri,
+			Rule:           "uri",
+			A:              "http://me@example.org/a",
+			B:              "http://example.org/a",
+			ExpectedString: "changed uri/hier/authority: \"me@example.org\" -> \"example.org\"\nremoved uri/hier/authority/userinfo at 7: \"me\"\n",
+		},
+		"alternative": {
+			Grammar:        uri,
+			Rule:           "uri",
+			A:              "http://example.org/a",
+			B:              "file:/a",
+			ExpectedString: "changed uri/scheme: \"http\" -> \"file\"\nchanged uri/hier: \"//example.org/a\" -> \"/a\"\n",
+		},
+		"header-removed": {
+			// Headers align by identity: only the dropped one and its CRLF are
+			// reported.
+			Grammar:        message,
+			Rule:           "message",
+			A:              "Host:a\r\nAccept:b\r\nDate:c\r\n\r\nhello",
+			B:              "Host:a\r\nDate:c\r\n\r\nhello",
+			ExpectedString: "removed message/header at 8: \"Accept:b\"\nremoved message/CRLF at 16: \"\\r\\n\"\n",
+		},
+		"header-changed": {
+			Grammar:        message,
+			Rule:           "message",
+			A:              "Host:a\r\nAccept:b\r\n\r\nhello",
+			B:              "Host:a\r\nAccept:c\r\n\r\nbye",
+			ExpectedString: "changed message/header/value: \"b\" -> \"c\"\nchanged message/body: \"hello\" -> \"bye\"\n",
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			d, err := tt.Grammar.DiffInputs(tt.Rule, []byte(tt.A), []byte(tt.B))
+			require.NoError(t, err)
+			assert.Equal(t, tt.ExpectedString, d.String())
+			assert.Equal(t, tt.A == tt.B, d.Equal())
+		})
+	}
+}
+
+func Test_U_DiffTrees_Nodes(t *testing.T) {
+	g := mustGrammar(uriLikeAbnf)
+	a, b := []byte("http://me@example.org/a"), []byte("http://example.org/a")
+	ta, tb := mustTree(t, g, "uri", a), mustTree(t, g, "uri", b)
+
+	d := DiffTrees(ta, a, tb, b)
+	require.Len(t, d.Changes, 2)
+	assert.Equal(t, NodeChanged, d.Changes[0].Kind)
+	assert.Same(t, ta.Find("authority"), d.Changes[0].A)
+	assert.Same(t, tb.Find("authority"), d.Changes[0].B)
+	assert.Equal(t, NodeRemoved, d.Changes[1].Kind)
+	assert.Equal(t, []string{"uri", "hier", "authority", "userinfo"}, d.Changes[1].Path)
+	assert.Same(t, ta.Find("userinfo"), d.Changes[1].A)
+	assert.Nil(t, d.Changes[1].B)
+}
+
+func Test_U_DiffTrees_Nil(t *testing.T) {
+	g := mustGrammar(uriLikeAbnf)
+	a := []byte("http://example.org/a")
+	ta := mustTree(t, g, "uri", a)
+
+	d := DiffTrees(nil, nil, ta, a)
+	require.Len(t, d.Changes, 1)
+	assert.Equal(t, NodeInserted, d.Changes[0].Kind)
+	assert.Equal(t, []string{"uri"}, d.Changes[0].Path)
+	assert.Same(t, ta, d.Changes[0].B)
+
+	d = DiffTrees(ta, a, nil, nil)
+	require.Len(t, d.Changes, 1)
+	assert.Equal(t, NodeRemoved, d.Changes[0].Kind)
+	assert.Same(t, ta, d.Changes[0].A)
+
+	assert.True(t, DiffTrees(nil, nil, nil, nil).Equal())
+}
+
+func Test_U_DiffInputs_Errors(t *testing.T) {
+	g := mustGrammar(uriLikeAbnf)
+
+	_, err := g.DiffInputs("uri", []byte("http://example.org"), []byte("http//example.org"))
+	var pe *ParseError
+	assert.ErrorAs(t, err, &pe)
+
+	_, err = g.DiffInputs("unknown", []byte("a"), []byte("b"))
+	var notFound *ErrRuleNotFound
+	assert.ErrorAs(t, err, &notFound)
+}
@@ -0,0 +1,275 @@
+package goabnf
+
+import (
+	"cmp"
+	"fmt"
+	"iter"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// trees.go enumerates the parse trees a Forest packs. The GLL loop packs
+// alternatives in an order that depends on map iteration, so the packs of every
+// node are first put in a canonical order (sortPacks); the n-th tree is then the
+// n-th choice of packs in depth-first, first-child-major order, the one TreeAt
+// ranks. Tree() is tree 0. A ParseTree keeps rule nodes and terminals but not
+// the repetitions and groups between them, so two choices may build the same
+// tree, e.g. `a = *"x" *"x"` splitting "xx" three ways: Trees yields it once.
+
+// sortPacks orders the packs of every node reachable from root canonically, by
+// the extents and identities of their children, then by alternate, so that tree
+// extraction is deterministic across runs.
+func sortPacks(root *gnode) {
+	seen := map[*gnode]bool{}
+	var walk func(n *gnode)
+	walk = func(n *gnode) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		if len(n.packs) > 1 {
+			order := make([]int, len(n.packs))
+			for k := range order {
+				order[k] = k
+			}
+			slices.SortStableFunc(order, func(a, b int) int {
+				return cmp.Or(comparePacks(n.packs[a], n.packs[b]), cmp.Compare(n.alts[a], n.alts[b]))
+			})
+			packs, alts := make([][]*gnode, len(order)), make([]int, len(order))
+			for k, o := range order {
+				packs[k], alts[k] = n.packs[o], n.alts[o]
+			}
+			n.packs, n.alts = packs, alts
+		}
+		for _, pk := range n.packs {
+			for _, c := range pk {
+				walk(c)
+			}
+		}
+	}
+	walk(root)
+}
+
+func comparePacks(a, b []*gnode) int {
+	if c := cmp.Compare(len(a), len(b)); c != 0 {
+		return c
+	}
+	for k := range a {
+		x, y := a[k], b[k]
+		if c := cmp.Or(
+			cmp.Compare(x.Start, y.Start),
+			cmp.Compare(x.End, y.End),
+			cmp.Compare(x.kind, y.kind),
+			cmp.Compare(x.nt, y.nt),
+		); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// ancestors is the path from a node up to the root of the tree being built,
+// used to reject cyclic derivations.
+type ancestors struct {
+	n      *gnode
+	parent *ancestors
+}
+
+func (a *ancestors) has(n *gnode) bool {
+	for ; a != nil; a = a.parent {
+		if a.n == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Trees returns an iterator over the distinct parse trees of the forest, in a
+// deterministic order starting with Tree(), and yielding at most limit trees
+// when limit > 0. Trees are built lazily, one per iteration, and a tree already
+// yielded for another choice of packs is skipped, so there may be fewer than
+// NumTrees. On an infinitely ambiguous forest, derivations going through a
+// cycle (e.g. A => A) are skipped, so the iteration covers the finitely many
+// acyclic trees.
+func (f *Forest) Trees(limit int) iter.Seq[*ParseTree] {
+	return func(yield func(*ParseTree) bool) {
+		if f.root == nil {
+			return
+		}
+		n := 0
+		seen := map[string]bool{}
+		for kids := range f.variants(f.root, nil) {
+			var b strings.Builder
+			treeKey(&b, kids[0])
+			if seen[b.String()] {
+				continue
+			}
+			seen[b.String()] = true
+			if !yield(cloneTree(kids[0])) {
+				return
+			}
+			if n++; limit > 0 && n >= limit {
+				return
+			}
+		}
+	}
+}
+
+// variants yields every expansion of n into the trees it contributes to its
+// parent: one rule node for a rule symbol, its flattened children otherwise.
+// Yielded slices and trees may be shared between iterations.
+func (f *Forest) variants(n *gnode, anc *ancestors) iter.Seq[[]*ParseTree] {
+	return func(yield func([]*ParseTree) bool) {
+		if anc.has(n) {
+			return
+		}
+		if len(n.packs) == 0 {
+			var leaf []*ParseTree
+			if (n.kind == gTerm || n.kind == gErr) && n.End > n.Start {
+				leaf = []*ParseTree{{Start: n.Start, End: n.End}}
+			}
+			yield(leaf)
+			return
+		}
+		isRule := n.kind == gSymbol && n.nt >= 0 && f.sg.nts[n.nt].isRule
+		anc = &ancestors{n: n, parent: anc}
+		for k, pk := range n.packs {
+			for kids := range f.packVariants(pk, 0, nil, anc) {
+				out := kids
+				if isRule {
+					out = []*ParseTree{{Rule: f.sg.nts[n.nt].ruleName, Alternative: f.ruleAlternative(n, k), Start: n.Start, End: n.End, Children: kids}}
+				}
+				if !yield(out) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// packVariants yields the concatenations of the variants of pk[k:], after acc.
+func (f *Forest) packVariants(pk []*gnode, k int, acc []*ParseTree, anc *ancestors) iter.Seq[[]*ParseTree] {
+	return func(yield func([]*ParseTree) bool) {
+		if k == len(pk) {
+			yield(acc)
+			return
+		}
+		for v := range f.variants(pk[k], anc) {
+			for kids := range f.packVariants(pk, k+1, slices.Concat(acc, v), anc) {
+				if !yield(kids) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// treeKey writes to b a string equal for two trees exactly when they have the
+// same nodes, alternatives and spans.
+func treeKey(b *strings.Builder, t *ParseTree) {
+	fmt.Fprintf(b, "%s/%d[%d,%d]", t.Rule, t.Alternative, t.Start, t.End)
+	if len(t.Children) > 0 {
+		b.WriteByte('(')
+		for _, c := range t.Children {
+			treeKey(b, c)
+			b.WriteByte(' ')
+		}
+		b.WriteByte(')')
+	}
+}
+
+func cloneTree(t *ParseTree) *ParseTree {
+	c := &ParseTree{Rule: t.Rule, Alternative: t.Alternative, Start: t.Start, End: t.End, Literal: t.Literal}
+	if t.Children != nil {
+		c.Children = make([]*ParseTree, len(t.Children))
+		for k, ch := range t.Children {
+			c.Children[k] = cloneTree(ch)
+		}
+	}
+	return c
+}
+
+// TreeAt returns the i-th parse tree of the forest, without building the ones
+// before it. Trees are ranked by choice of packs, as NumTrees counts them, so
+// TreeAt may return the same tree for several i; Trees yields them in the order
+// of their first rank. It returns nil when i is out of [0, NumTrees()), in
+// particular for an infinitely ambiguous forest.
+func (f *Forest) TreeAt(i *big.Int) *ParseTree {
+	total := f.NumTrees()
+	if i.Sign() < 0 || total.Sign() <= 0 || i.Cmp(total) >= 0 {
+		return nil
+	}
+	counts := f.treeCounts()
+	var kids []*ParseTree
+	f.buildAt(f.root, new(big.Int).Set(i), counts, &kids)
+	return kids[0]
+}
+
+// treeCounts returns the number of trees below every node of a finite forest.
+func (f *Forest) treeCounts() map[*gnode]*big.Int {
+	memo := map[*gnode]*big.Int{}
+	var count func(n *gnode) *big.Int
+	count = func(n *gnode) *big.Int {
+		if v, ok := memo[n]; ok {
+			return v
+		}
+		total := big.NewInt(0)
+		if len(n.packs) == 0 {
+			total.SetInt64(1)
+		}
+		for _, pk := range n.packs {
+			total.Add(total, packCount(pk, count))
+		}
+		memo[n] = total
+		return total
+	}
+	count(f.root)
+	return memo
+}
+
+func packCount(pk []*gnode, count func(*gnode) *big.Int) *big.Int {
+	prod := big.NewInt(1)
+	for _, c := range pk {
+		prod.Mul(prod, count(c))
+	}
+	return prod
+}
+
+// buildAt appends to into the expansion of n ranked i (see variants).
+func (f *Forest) buildAt(n *gnode, i *big.Int, counts map[*gnode]*big.Int, into *[]*ParseTree) {
+	if len(n.packs) == 0 {
+		if (n.kind == gTerm || n.kind == gErr) && n.End > n.Start {
+			*into = append(*into, &ParseTree{Start: n.Start, End: n.End})
+		}
+		return
+	}
+	dst := into
+	var t *ParseTree
+	if n.kind == gSymbol && n.nt >= 0 && f.sg.nts[n.nt].isRule {
+		t = &ParseTree{Rule: f.sg.nts[n.nt].ruleName, Start: n.Start, End: n.End}
+		*into = append(*into, t)
+		dst = &t.Children
+	}
+	get := func(c *gnode) *big.Int { return counts[c] }
+	for p, pk := range n.packs {
+		c := packCount(pk, get)
+		if i.Cmp(c) >= 0 {
+			i.Sub(i, c)
+			continue
+		}
+		if t != nil {
+			t.Alternative = f.ruleAlternative(n, p)
+		}
+		// Mixed radix, first child most significant.
+		idx := make([]*big.Int, len(pk))
+		for k := len(pk) - 1; k >= 0; k-- {
+			q, r := new(big.Int).QuoRem(i, counts[pk[k]], new(big.Int))
+			idx[k], i = r, q
+		}
+		for k, ch := range pk {
+			f.buildAt(ch, idx[k], counts, dst)
+		}
+		return
+	}
+}
@@ -0,0 +1,151 @@
+package goabnf
+
+import (
+	"encoding"
+	"reflect"
+	"strconv"
+)
+
+// unmarshal.go fills Go values from a parse tree, the way encoding/json does
+// from a JSON document: struct fields name, with an `abnf` tag, the rule (or
+// path of rules, see ParseTree.Query) whose nodes hold their value, and leaves
+// are converted from the text of their node.
+
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+// Unmarshal parses input as rule of g with ParseForest, then stores the result
+// in the value pointed to by v. The options are passed to ParseForest; an
+// ambiguous input is read from its first tree (see Forest.Tree).
+//
+// A struct is filled field by field: a field tagged `abnf:"host"` takes its
+// value from the first host node below the node of the struct, a path such as
+// `abnf:"authority/host"` reaches further. A slice takes one element per node,
+// e.g. per repetition of the rule. A field no node matches keeps its value, so
+// a nil slice or pointer stays nil, as for an absent option. Untagged fields are
+// ignored, but for embedded structs which are filled from the same node.
+//
+// Other values are set from the text of their node: by its UnmarshalText
+// method when they implement encoding.TextUnmarshaler, else as a string,
+// []byte, or bool, integer or float parsed with strconv.
+func Unmarshal(g *Grammar, rule string, input []byte, v any, opts ...ForestOption) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return &ErrInvalidUnmarshal{Type: reflect.TypeOf(v)}
+	}
+	f, err := ParseForest(input, g, rule, opts...)
+	if err != nil {
+		return err
+	}
+	if errs := f.Errors(); len(errs) > 0 {
+		return errs[0]
+	}
+	tree := f.Tree()
+	if tree == nil {
+		return ErrNoSolutionFound
+	}
+	return unmarshalValue(input, tree, rv.Elem())
+}
+
+func unmarshalValue(input []byte, t *ParseTree, rv reflect.Value) error {
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return unmarshalValue(input, t, rv.Elem())
+	}
+	text := t.Text(input)
+	fail := func(err error) error {
+		return &ErrUnmarshal{Rule: t.Rule, Text: text, Type: rv.Type(), Err: err}
+	}
+	if rv.CanAddr() {
+		if u, ok := rv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			if err := u.UnmarshalText([]byte(text)); err != nil {
+				return fail(err)
+			}
+			return nil
+		}
+	}
+	switch rv.Kind() {
+	case reflect.Struct:
+		return unmarshalStruct(input, t, rv)
+	case reflect.String:
+		rv.SetString(text)
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() != reflect.Uint8 {
+			return fail(nil)
+		}
+		rv.SetBytes([]byte(text))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fail(err)
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(text, 10, rv.Type().Bits())
+		if err != nil {
+			return fail(err)
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(text, 10, rv.Type().Bits())
+		if err != nil {
+			return fail(err)
+		}
+		rv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(text, rv.Type().Bits())
+		if err != nil {
+			return fail(err)
+		}
+		rv.SetFloat(x)
+	case reflect.Interface:
+		if rv.NumMethod() != 0 {
+			return fail(nil)
+		}
+		rv.Set(reflect.ValueOf(text))
+	default:
+		return fail(nil)
+	}
+	return nil
+}
+
+func unmarshalStruct(input []byte, t *ParseTree, rv reflect.Value) error {
+	for i := range rv.NumField() {
+		sf := rv.Type().Field(i)
+		fv := rv.Field(i)
+		path, ok := sf.Tag.Lookup("abnf")
+		if !ok || path == "" {
+			// The exported fields of an embedded struct are settable even
+			// when it is not.
+			if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+				if err := unmarshalStruct(input, t, fv); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if path == "-" || !fv.CanSet() {
+			continue
+		}
+		nodes := t.Query(path)
+		if len(nodes) == 0 {
+			continue
+		}
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 &&
+			!reflect.PointerTo(fv.Type()).Implements(textUnmarshalerType) {
+			s := reflect.MakeSlice(fv.Type(), len(nodes), len(nodes))
+			for k, n := range nodes {
+				if err := unmarshalValue(input, n, s.Index(k)); err != nil {
+					return err
+				}
+			}
+			fv.Set(s)
+			continue
+		}
+		if err := unmarshalValue(input, nodes[0], fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package goabnf
+
+import (
+	"errors"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const hostHeaderAbnf = "host-header = host [\":\" port]\r\n" +
+	"host = ipv4 / reg-name\r\n" +
+	"ipv4 = 1*3DIGIT 3(\".\" 1*3DIGIT)\r\n" +
+	"reg-name = 1*(ALPHA / \".\")\r\n" +
+	"port = 1*DIGIT\r\n"
+
+type hostHeader struct {
+	Host string `abnf:"host"`
+	Port *int   `abnf:"port"`
+}
+
+type upper string
+
+func (u *upper) UnmarshalText(text []byte) error {
+	*u = upper(strings.ToUpper(string(text)))
+	return nil
+}
+
+type authority struct {
+	User *string `abnf:"userinfo"`
+	Host string  `abnf:"host"`
+}
+
+type uriLike struct {
+	Scheme    upper     `abnf:"scheme"`
+	Authority authority `abnf:"authority"`
+	Host      string    `abnf:"authority/host"`
+	Segments  []string  `abnf:"segment"`
+	Path      []byte    `abnf:"path"`
+	ignored   string    `abnf:"scheme"`
+	Skipped   string    `abnf:"-"`
+}
+
+func Test_U_Unmarshal(t *testing.T) {
+	t.Parallel()
+
+	port := 8080
+	user := "me"
+	var tests = map[string]struct {
+		Grammar  string
+		Rule     string
+		Input    string
+		Target   func() any
+		Expected any
+	}{
+		"host-port": {
+			Grammar:  hostHeaderAbnf,
+			Rule:     "host-header",
+			Input:    "example.org:8080",
+			Target:   func() any { return &hostHeader{} },
+			Expected: &hostHeader{Host: "example.org", Port: &port},
+		},
+		"host-absent-port": {
+			Grammar:  hostHeaderAbnf,
+			Rule:     "host-header",
+			Input:    "example.org",
+			Target:   func() any { return &hostHeader{} },
+			Expected: &hostHeader{Host: "example.org"},
+		},
+		"text-unmarshaler": {
+			Grammar: hostHeaderAbnf,
+			Rule:    "host-header",
+			Input:   "10.0.0.1:80",
+			Target: func() any {
+				return &struct {
+					Addr netip.Addr `abnf:"host/ipv4"`
+					Port uint16     `abnf:"port"`
+				}{}
+			},
+			Expected: &struct {
+				Addr netip.Addr `abnf:"host/ipv4"`
+				Port uint16     `abnf:"port"`
+			}{Addr: netip.MustParseAddr("10.0.0.1"), Port: 80},
+		},
+		"nested": {
+			Grammar:  uriLikeAbnf,
+			Rule:     "uri",
+			Input:    "http://me@example.org/a/b",
+			Target:   func() any { return &uriLike{} },
+			Expected: &uriLike{Scheme: "HTTP", Authority: authority{User: &user, Host: "example.org"}, Host: "example.org", Segments: []string{"a", "b"}, Path: []byte("/a/b")},
+		},
+		"nil-slice": {
+			Grammar:  uriLikeAbnf,
+			Rule:     "uri",
+			Input:    "urn:",
+			Target:   func() any { return &uriLike{} },
+			Expected: &uriLike{Scheme: "URN", Path: []byte{}},
+		},
+		"case-insensitive": {
+			Grammar: hostHeaderAbnf,
+			Rule:    "host-header",
+			Input:   "example.org:8080",
+			Target: func() any {
+				return &struct {
+					Host string `abnf:"HOST"`
+					Port int    `abnf:"Port"`
+				}{}
+			},
+			Expected: &struct {
+				Host string `abnf:"HOST"`
+				Port int    `abnf:"Port"`
+			}{Host: "example.org", Port: 8080},
+		},
+		"unmatched-kept": {
+			Grammar:  uriLikeAbnf,
+			Rule:     "uri",
+			Input:    "urn:",
+			Target:   func() any { return &uriLike{Segments: []string{"kept"}} },
+			Expected: &uriLike{Scheme: "URN", Segments: []string{"kept"}, Path: []byte{}},
+		},
+		"embedded": {
+			Grammar: hostHeaderAbnf,
+			Rule:    "host-header",
+			Input:   "example.org:8080",
+			Target: func() any {
+				return &struct {
+					hostHeader
+					Any any `abnf:"port"`
+				}{}
+			},
+			Expected: &struct {
+				hostHeader
+				Any any `abnf:"port"`
+			}{hostHeader: hostHeader{Host: "example.org", Port: &port}, Any: "8080"},
+		},
+		"scalar": {
+			Grammar: hostHeaderAbnf,
+			Rule:    "port",
+			Input:   "443",
+			Target:  func() any { return new(float64) },
+			Expected: func() *float64 {
+				x := 443.0
+				return &x
+			}(),
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			v := tt.Target()
+			err := Unmarshal(mustGrammar(tt.Grammar), tt.Rule, []byte(tt.Input), v)
+			require.NoError(t, err)
+			assert.Equal(t, tt.Expected, v)
+		})
+	}
+}
+
+func Test_U_Unmarshal_Errors(t *testing.T) {
+	g := mustGrammar(hostHeaderAbnf)
+
+	var h hostHeader
+	var invErr *ErrInvalidUnmarshal
+	assert.ErrorAs(t, Unmarshal(g, "host-header", []byte("example.org"), h), &invErr)
+	assert.ErrorAs(t, Unmarshal(g, "host-header", []byte("example.org"), nil), &invErr)
+	assert.ErrorAs(t, Unmarshal(g, "host-header", []byte("example.org"), (*hostHeader)(nil)), &invErr)
+
+	var pe *ParseError
+	assert.ErrorAs(t, Unmarshal(g, "host-header", []byte("example.org:"), &h), &pe)
+
+	var notFound *ErrRuleNotFound
+	assert.ErrorAs(t, Unmarshal(g, "unknown", []byte("x"), &h), &notFound)
+
+	var small struct {
+		Port int8 `abnf:"port"`
+	}
+	err := Unmarshal(g, "host-header", []byte("example.org:8080"), &small)
+	var umErr *ErrUnmarshal
+	require.ErrorAs(t, err, &umErr)
+	assert.Equal(t, "port", umErr.Rule)
+	assert.Equal(t, "8080", umErr.Text)
+	assert.True(t, errors.Is(err, strconv.ErrRange))
+
+	var unsupported struct {
+		Port chan int `abnf:"port"`
+	}
+	err = Unmarshal(g, "host-header", []byte("example.org:8080"), &unsupported)
+	require.ErrorAs(t, err, &umErr)
+	assert.Nil(t, umErr.Err)
+}
@@ -0,0 +1,121 @@
+package goabnf
+
+import "slices"
+
+// unparse.go edits parse trees and turns them back into bytes. A tree spans its
+// input: a leaf stands for input[Start:End], and the children of a node tile
+// its span. An edit replaces, inserts or deletes nodes; a node brought in from
+// elsewhere carries its text as Literal, and its descendants span that text
+// rather than the input. Unparse writes the leaves back in order, so the text
+// outside the edited nodes is left exactly as it was.
+//
+// An edited tree is no derivation of anything until checked: Revalidate parses
+// the result again as the rule of the root.
+
+// NewNode returns a leaf of rule standing for text, to replace or insert in a
+// tree.
+func NewNode(rule string, text []byte) *ParseTree {
+	return &ParseTree{Rule: rule, End: len(text), Literal: append([]byte{}, text...)}
+}
+
+// Detach returns a copy of t standing for its own text of input, which may be
+// inserted in a tree of another input. Its descendants span that text.
+func (t *ParseTree) Detach(input []byte) *ParseTree {
+	c := shiftTree(t.Clone(), -t.Start)
+	if t.Literal == nil {
+		c.Literal = append([]byte{}, input[t.Start:t.End]...)
+	}
+	return c
+}
+
+// Clone returns a deep copy of t, to edit while keeping t.
+func (t *ParseTree) Clone() *ParseTree {
+	return cloneTree(t)
+}
+
+// ReplaceChild replaces the i-th child of t with n.
+func (t *ParseTree) ReplaceChild(i int, n *ParseTree) {
+	t.Children[i] = n
+}
+
+// InsertChild inserts n as the i-th child of t, before the one at i, or last
+// if i is len(t.Children).
+func (t *ParseTree) InsertChild(i int, n *ParseTree) {
+	t.Children = slices.Insert(t.Children, i, n)
+}
+
+// DeleteChild removes the i-th child of t, and the text it stands for. A node
+// left without children stands for no text.
+func (t *ParseTree) DeleteChild(i int) {
+	t.Children = slices.Delete(t.Children, i, i+1)
+}
+
+// Replace replaces the descendant old of t with n, and reports whether old was
+// found. Descendants are compared by identity, as returned by Find or Query.
+func (t *ParseTree) Replace(old, n *ParseTree) bool {
+	p, i := t.parentOf(old)
+	if p == nil {
+		return false
+	}
+	p.ReplaceChild(i, n)
+	return true
+}
+
+// Delete removes the descendant n of t, and reports whether it was found.
+func (t *ParseTree) Delete(n *ParseTree) bool {
+	p, i := t.parentOf(n)
+	if p == nil {
+		return false
+	}
+	p.DeleteChild(i)
+	return true
+}
+
+// parentOf returns the node of t having n as its i-th child, or nil.
+func (t *ParseTree) parentOf(n *ParseTree) (*ParseTree, int) {
+	for i, c := range t.Children {
+		if c == n {
+			return t, i
+		}
+		if p, k := c.parentOf(n); p != nil {
+			return p, k
+		}
+	}
+	return nil, 0
+}
+
+// Unparse returns the text t stands for: input[t.Start:t.End] as edited. A
+// leaf is written as its span, of its Literal if it holds one or else of the
+// Literal of its closest ancestor holding one or else of input, and a node
+// with children as their texts in order.
+func (t *ParseTree) Unparse(input []byte) []byte {
+	return t.unparse(nil, input)
+}
+
+func (t *ParseTree) unparse(out, input []byte) []byte {
+	if t.Literal != nil {
+		input = t.Literal
+	}
+	if t.Children == nil {
+		return append(out, input[t.Start:t.End]...)
+	}
+	for _, c := range t.Children {
+		out = c.unparse(out, input)
+	}
+	return out
+}
+
+// Revalidate unparses t and parses the result again as the rule of t. It
+// returns the bytes and their parse tree, or a *ParseError locating where the
+// edits left them invalid.
+func (t *ParseTree) Revalidate(g *Grammar, input []byte) ([]byte, *ParseTree, error) {
+	out := t.Unparse(input)
+	f, err := ParseForest(out, g, t.Rule)
+	if err != nil {
+		return out, nil, err
+	}
+	if pe := f.ParseError(); pe != nil {
+		return out, nil, pe
+	}
+	return out, f.Tree(), nil
+}
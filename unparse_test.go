@@ -0,0 +1,180 @@
+package goabnf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const messageAbnf = "message = *(header CRLF) CRLF body\r\n" +
+	"header = name \":\" value\r\n" +
+	"name = 1*ALPHA\r\n" +
+	"value = *VCHAR\r\n" +
+	"body = *OCTET\r\n"
+
+func mustTree(t *testing.T, g *Grammar, rule string, input []byte) *ParseTree {
+	t.Helper()
+	f, err := ParseForest(input, g, rule)
+	require.NoError(t, err)
+	require.True(t, f.Valid())
+	return f.Tree()
+}
+
+func Test_U_Unparse(t *testing.T) {
+	t.Parallel()
+
+	uri := mustGrammar(uriLikeAbnf)
+	message := mustGrammar(messageAbnf)
+
+	var tests = map[string]struct {
+		Grammar       *Grammar
+		Rule          string
+		Input         string
+		Edit          func(t *testing.T, tree *ParseTree)
+		ExpectedText  string
+		ExpectedValid bool
+	}{
+		"unedited": {
+			Grammar:       uri,
+			Rule:          "uri",
+			Input:         "http://me@example.org/a/b",
+			Edit:          func(t *testing.T, tree *ParseTree) {},
+			ExpectedText:  "http://me@example.org/a/b",
+			ExpectedValid: true,
+		},
+		"replace-literal": {
+			Grammar: uri,
+			Rule:    "uri",
+			Input:   "http://me@example.org/a/b",
+			Edit: func(t *testing.T, tree *ParseTree) {
+				require.True(t, tree.Replace(tree.Find("host"), NewNode("host", []byte("other.net"))))
+			},
+			ExpectedText:  "http://me@other.net/a/b",
+			ExpectedValid: true,
+		},
+		"replace-detached": {
+			// A subtree of another input keeps its structure.
+			Grammar: uri,
+			Rule:    "uri",
+			Input:   "http://me@example.org/a/b",
+			Edit: func(t *testing.T, tree *ParseTree) {
+				other := []byte("ftp://you@files.example/c")
+				host := mustTree(t, uri, "uri", other).Find("host").Detach(other)
+				assert.Len(t, host.Children, 13)
+				require.True(t, tree.Replace(tree.Find("host"), host))
+			},
+			ExpectedText:  "http://me@files.example/a/b",
+			ExpectedValid: true,
+		},
+		"delete-leaves-invalid": {
+			Grammar: uri,
+			Rule:    "uri",
+			Input:   "http://me@example.org/a/b",
+			Edit: func(t *testing.T, tree *ParseTree) {
+				require.True(t, tree.Delete(tree.Find("userinfo")))
+			},
+			ExpectedText:  "http://@example.org/a/b",
+			ExpectedValid: false,
+		},
+		"delete-children": {
+			Grammar: uri,
+			Rule:    "uri",
+			Input:   "http://me@example.org/a/b",
+			Edit: func(t *testing.T, tree *ParseTree) {
+				auth := tree.Find("authority")
+				auth.DeleteChild(0) // userinfo
+				auth.DeleteChild(0) // "@"
+			},
+			ExpectedText:  "http://example.org/a/b",
+			ExpectedValid: true,
+		},
+		"insert": {
+			Grammar: uri,
+			Rule:    "uri",
+			Input:   "http://me@example.org/a/b",
+			Edit: func(t *testing.T, tree *ParseTree) {
+				path := tree.Find("path")
+				path.InsertChild(len(path.Children), NewNode("", []byte("/")))
+				path.InsertChild(len(path.Children), NewNode("segment", []byte("c")))
+				path.InsertChild(0, NewNode("", []byte("/")))
+			},
+			ExpectedText:  "http://me@example.org//a/b/c",
+			ExpectedValid: true,
+		},
+		"empty-path": {
+			Grammar: uri,
+			Rule:    "uri",
+			Input:   "http://me@example.org/a/b",
+			Edit: func(t *testing.T, tree *ParseTree) {
+				path := tree.Find("path")
+				for len(path.Children) > 0 {
+					path.DeleteChild(0)
+				}
+			},
+			ExpectedText:  "http://me@example.org",
+			ExpectedValid: true,
+		},
+		"drop-header": {
+			Grammar: message,
+			Rule:    "message",
+			Input:   "Host:a\r\nAccept:b\r\nDate:c\r\n\r\nhello",
+			Edit: func(t *testing.T, tree *ParseTree) {
+				// The header and its CRLF.
+				h := tree.FindAll("header")[1]
+				for i, c := range tree.Children {
+					if c == h {
+						tree.DeleteChild(i)
+						tree.DeleteChild(i)
+						break
+					}
+				}
+			},
+			ExpectedText:  "Host:a\r\nDate:c\r\n\r\nhello",
+			ExpectedValid: true,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			input := []byte(tt.Input)
+			tree := mustTree(t, tt.Grammar, tt.Rule, input)
+			orig := tree.Clone()
+			tt.Edit(t, tree)
+
+			assert.Equal(t, tt.ExpectedText, string(tree.Unparse(input)))
+			assert.Equal(t, tt.Input, string(input))
+
+			out, fresh, err := tree.Revalidate(tt.Grammar, input)
+			assert.Equal(t, tt.ExpectedText, string(out))
+			if !tt.ExpectedValid {
+				var pe *ParseError
+				assert.ErrorAs(t, err, &pe)
+				assert.Nil(t, fresh)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, mustTree(t, tt.Grammar, tt.Rule, out), fresh)
+
+			// Editing a clone leaves the tree it was cloned from untouched.
+			if testname != "unedited" {
+				assert.NotEqual(t, orig, tree)
+			}
+			assert.Equal(t, tt.Input, string(orig.Unparse(input)))
+		})
+	}
+}
+
+func Test_U_Unparse_NotFound(t *testing.T) {
+	g := mustGrammar(uriLikeAbnf)
+	input := []byte("http://me@example.org/a/b")
+	tree := mustTree(t, g, "uri", input)
+
+	stranger := NewNode("host", []byte("x"))
+	assert.False(t, tree.Replace(stranger, NewNode("host", []byte("y"))))
+	assert.False(t, tree.Delete(stranger))
+	assert.False(t, tree.Delete(tree))
+	assert.Equal(t, string(input), string(tree.Unparse(input)))
+}
@@ -142,6 +142,12 @@ func GetRule(rulename string, rulemap map[string]*Rule) *Rule {
 	return getRuleIn(rulename, coreRules)
 }
 
+// isCoreRule reports whether rulename is one of the core rules.
+func isCoreRule(rulename string) bool {
+	_, ok := coreRules[strings.ToUpper(rulename)]
+	return ok
+}
+
 func getRuleIn(rulename string, rulemap map[string]*Rule) *Rule {
 	for _, rule := range rulemap {
 		if strings.EqualFold(rulename, rule.Name) {